@@ -1947,7 +1947,7 @@ func (w *Writer) unlockFn() {
 	}
 }
 
-func (w *Writer) branchFn(prefix []byte) []byte {
+func (w *Writer) branchFn(prefix []byte) ([]byte, error) {
 	for lockFType := FirstType; lockFType < NumberOfStateTypes; lockFType++ {
 		w.a.fileLocks[lockFType].RLock()
 		defer w.a.fileLocks[lockFType].RUnlock()
@@ -1962,30 +1962,30 @@ func (w *Writer) branchFn(prefix []byte) []byte {
 	var startBlock uint64 = w.blockNum + 1
 	for mergedVal == nil || !commitment.IsComplete(mergedVal) {
 		if startBlock == 0 {
-			panic(fmt.Sprintf("Incomplete branch data prefix [%x], mergeVal=[%x], startBlock=%d\n", commitment.CompactToHex(prefix), mergedVal, startBlock))
+			return nil, fmt.Errorf("incomplete branch data prefix [%x], mergeVal=[%x], startBlock=%d", commitment.CompactToHex(prefix), mergedVal, startBlock)
 		}
 		var val []byte
 		val, startBlock = w.a.readFromFiles(Commitment, false /* lock */, startBlock-1, prefix, false /* trace */)
 		if val == nil {
 			if mergedVal == nil {
-				return nil
+				return nil, nil
 			}
-			panic(fmt.Sprintf("Incomplete branch data prefix [%x], mergeVal=[%x], startBlock=%d\n", commitment.CompactToHex(prefix), mergedVal, startBlock))
+			return nil, fmt.Errorf("incomplete branch data prefix [%x], mergeVal=[%x], startBlock=%d", commitment.CompactToHex(prefix), mergedVal, startBlock)
 		}
 		var err error
 		//fmt.Printf("Pre-merge prefix [%x] [%x]+[%x], startBlock %d\n", commitment.CompactToHex(prefix), val, mergedVal, startBlock)
 		if mergedVal == nil {
 			mergedVal = val
 		} else if mergedVal, err = commitment.MergeBranches(val, mergedVal, nil); err != nil {
-			panic(err)
+			return nil, fmt.Errorf("merge branch data prefix [%x]: %w", commitment.CompactToHex(prefix), err)
 		}
 		//fmt.Printf("Post-merge prefix [%x] [%x], startBlock %d\n", commitment.CompactToHex(prefix), mergedVal, startBlock)
 	}
 	if mergedVal == nil {
-		return nil
+		return nil, nil
 	}
 	//fmt.Printf("Returning branch data prefix [%x], mergeVal=[%x], startBlock=%d\n", commitment.CompactToHex(prefix), mergedVal, startBlock)
-	return mergedVal[2:] // Skip touchMap but keep afterMap
+	return mergedVal[2:], nil // Skip touchMap but keep afterMap
 }
 
 func bytesToUint64(buf []byte) (x uint64) {
@@ -1998,7 +1998,7 @@ func bytesToUint64(buf []byte) (x uint64) {
 	return
 }
 
-func (w *Writer) accountFn(plainKey []byte, cell *commitment.Cell) []byte {
+func (w *Writer) accountFn(plainKey []byte, cell *commitment.Cell) ([]byte, error) {
 	var enc []byte
 	// Look in the summary table first
 	w.search.k = plainKey
@@ -2038,10 +2038,10 @@ func (w *Writer) accountFn(plainKey []byte, cell *commitment.Cell) []byte {
 		w.a.keccak.Write(enc)
 		w.a.keccak.(io.Reader).Read(cell.CodeHash[:])
 	}
-	return plainKey
+	return plainKey, nil
 }
 
-func (w *Writer) storageFn(plainKey []byte, cell *commitment.Cell) []byte {
+func (w *Writer) storageFn(plainKey []byte, cell *commitment.Cell) ([]byte, error) {
 	var enc []byte
 	// Look in the summary table first
 	w.search.k = plainKey
@@ -2053,7 +2053,7 @@ func (w *Writer) storageFn(plainKey []byte, cell *commitment.Cell) []byte {
 	}
 	cell.StorageLen = len(enc)
 	copy(cell.Storage[:], enc)
-	return plainKey
+	return plainKey, nil
 }
 
 func (w *Writer) captureCommitmentType(fType FileType, trace bool, f func(commTree *btree.BTree, h hash.Hash, key, val []byte)) {
@@ -266,3 +266,51 @@ func TestChangeCode(t *testing.T) {
 		}
 	}
 }
+
+func TestHistoryReadWithSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	a, err := NewAggregator(tmpDir, 16, 4, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var account1 = accountWithBalance(1)
+	w := a.MakeStateWriter(true /* beforeOn */)
+	for blockNum := uint64(0); blockNum < 100; blockNum++ {
+		if err = w.Reset(blockNum); err != nil {
+			t.Fatal(err)
+		}
+		w.UpdateAccountData(int160(blockNum/10+1), account1, false /* trace */)
+		if err = w.FinishTx(blockNum, false /* trace */); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Aggregate(false /* trace */); err != nil {
+			t.Fatal(err)
+		}
+		account1 = accountWithBalance(blockNum + 2)
+	}
+	w.Close()
+	a.Close()
+	h, err := NewHistory(tmpDir, 100, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	r := h.MakeHistoryReader()
+	r.SetNums(100, 100, false)
+	acc, src, err := r.ReadWithSource(Account, int160(1), false /* trace */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := accountWithBalance(10); !bytes.Equal(acc, expected) {
+		t.Errorf("read account %x, expected account %x", acc, expected)
+	}
+	if src.FromHistory || src.FileType != Account || src.StartBlock > 9 || src.EndBlock < 9 || src.FileName() == "" {
+		t.Errorf("unexpected source %+v", src)
+	}
+	if src.StartStep != src.StartBlock/4 || src.EndStep != (src.EndBlock+1)/4 {
+		t.Errorf("unexpected steps of source %+v", src)
+	}
+	if _, _, err = r.ReadWithSource(Commitment, int160(1), false /* trace */); err == nil {
+		t.Errorf("expected error for commitment history")
+	}
+}
@@ -186,7 +186,42 @@ func (hr *HistoryReader) SetNums(blockNum uint64, txNum uint64, lastTx bool) {
 	hr.lastTx = lastTx
 }
 
+// HistorySource - where value returned by HistoryReader came from. Either from history file: value before change made by
+// transaction TxNum (first change of key after requested txNum), or from state file: key was not changed after requested txNum
+type HistorySource struct {
+	FromHistory bool
+	TxNum       uint64 // Transaction which changed the value, only if FromHistory
+	FileType    FileType
+	StartBlock  uint64 // Block range of the file
+	EndBlock    uint64
+	StartStep   uint64 // Aggregation steps [StartStep, EndStep) covered by the file
+	EndStep     uint64
+}
+
+// FileName - name of .dat file of the source (file may be not persisted yet), empty if value was not found
+func (s HistorySource) FileName() string {
+	if s.StartBlock == 0 && s.EndBlock == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s.%d-%d.dat", s.FileType.String(), s.StartBlock, s.EndBlock)
+}
+
+func (h *History) source(fType FileType, item *byEndBlockItem) HistorySource {
+	return HistorySource{
+		FileType:   fType,
+		StartBlock: item.startBlock,
+		EndBlock:   item.endBlock,
+		StartStep:  item.startBlock / h.aggregationStep,
+		EndStep:    (item.endBlock + 1) / h.aggregationStep,
+	}
+}
+
 func (hr *HistoryReader) searchInHistory(bitmapType, historyType FileType, key []byte, trace bool) (bool, []byte, error) {
+	found, v, _, err := hr.searchInHistoryWithSource(bitmapType, historyType, key, trace)
+	return found, v, err
+}
+
+func (hr *HistoryReader) searchInHistoryWithSource(bitmapType, historyType FileType, key []byte, trace bool) (bool, []byte, HistorySource, error) {
 	if trace {
 		fmt.Printf("searchInHistory %s %s [%x] blockNum %d, txNum %d\n", bitmapType.String(), historyType.String(), key, hr.blockNum, hr.txNum)
 	}
@@ -253,10 +288,10 @@ func (hr *HistoryReader) searchInHistory(bitmapType, historyType FileType, key [
 		return true
 	})
 	if err != nil {
-		return false, nil, err
+		return false, nil, HistorySource{}, err
 	}
 	if !found {
-		return false, nil, nil
+		return false, nil, HistorySource{}, nil
 	}
 	if trace {
 		fmt.Printf("found in tx %d, endBlock %d\n", foundTxNum, foundEndBlock)
@@ -269,7 +304,7 @@ func (hr *HistoryReader) searchInHistory(bitmapType, historyType FileType, key [
 	if i := hr.h.files[historyType].Get(&hr.search); i != nil {
 		historyItem = i.(*byEndBlockItem)
 	} else {
-		return false, nil, fmt.Errorf("no %s file found for %d", historyType.String(), foundEndBlock)
+		return false, nil, HistorySource{}, fmt.Errorf("no %s file found for %d", historyType.String(), foundEndBlock)
 	}
 	offset := historyItem.indexReader.Lookup(lookupKey)
 	if trace {
@@ -277,7 +312,9 @@ func (hr *HistoryReader) searchInHistory(bitmapType, historyType FileType, key [
 	}
 	historyItem.getter.Reset(offset)
 	v, _ := historyItem.getter.Next(nil)
-	return true, v, nil
+	src := hr.h.source(historyType, historyItem)
+	src.FromHistory, src.TxNum = true, foundTxNum
+	return true, v, src, nil
 }
 
 func (hr *HistoryReader) ReadAccountData(addr []byte, trace bool) ([]byte, error) {
@@ -345,8 +382,39 @@ func (hr *HistoryReader) ReadAccountCodeSize(addr []byte, trace bool) (int, erro
 	return len(hr.h.readFromFiles(Code, addr, trace)), nil
 }
 
+// ReadWithSource - value of key in state of type stateType (Account, Storage or Code) as of txNum set by SetNums,
+// together with file it was read from. For Storage key is address+location. Value is nil if key didn't exist.
+func (hr *HistoryReader) ReadWithSource(stateType FileType, key []byte, trace bool) ([]byte, HistorySource, error) {
+	var bitmapType, historyType FileType
+	switch stateType {
+	case Account:
+		bitmapType, historyType = AccountBitmap, AccountHistory
+	case Storage:
+		bitmapType, historyType = StorageBitmap, StorageHistory
+	case Code:
+		bitmapType, historyType = CodeBitmap, CodeHistory
+	default:
+		return nil, HistorySource{}, fmt.Errorf("history of %s is not tracked", stateType.String())
+	}
+	hOk, v, src, err := hr.searchInHistoryWithSource(bitmapType, historyType, key, trace)
+	if err != nil {
+		return nil, HistorySource{}, err
+	}
+	if hOk {
+		return v, src, nil
+	}
+	v, src = hr.h.readFromFilesWithSource(stateType, key, trace)
+	return v, src, nil
+}
+
 func (h *History) readFromFiles(fType FileType, filekey []byte, trace bool) []byte {
+	val, _ := h.readFromFilesWithSource(fType, filekey, trace)
+	return val
+}
+
+func (h *History) readFromFilesWithSource(fType FileType, filekey []byte, trace bool) ([]byte, HistorySource) {
 	var val []byte
+	var src HistorySource
 	h.files[fType].Descend(func(i btree.Item) bool {
 		item := i.(*byEndBlockItem)
 		if trace {
@@ -358,6 +426,7 @@ func (h *History) readFromFiles(fType FileType, filekey []byte, trace bool) []by
 				return true
 			}
 			val = ai.(*AggregateItem).v
+			src = h.source(fType, item)
 			return false
 		}
 		if item.index.Empty() {
@@ -369,6 +438,7 @@ func (h *History) readFromFiles(fType FileType, filekey []byte, trace bool) []by
 		if g.HasNext() {
 			if keyMatch, _ := g.Match(filekey); keyMatch {
 				val, _ = g.Next(nil)
+				src = h.source(fType, item)
 				if trace {
 					fmt.Printf("read %s %x: found [%x] in file [%d-%d]\n", fType.String(), filekey, val, item.startBlock, item.endBlock)
 				}
@@ -377,5 +447,5 @@ func (h *History) readFromFiles(fType FileType, filekey []byte, trace bool) []by
 		}
 		return true
 	})
-	return val
+	return val, src
 }
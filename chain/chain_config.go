@@ -48,6 +48,13 @@ type Config struct {
 	BerlinBlock         *big.Int `json:"berlinBlock,omitempty"`         // Berlin switch block (nil = no fork, 0 = already on berlin)
 	LondonBlock         *big.Int `json:"londonBlock,omitempty"`         // London switch block (nil = no fork, 0 = already on london)
 	ArrowGlacierBlock   *big.Int `json:"arrowGlacierBlock,omitempty"`   // EIP-4345 (bomb delay) switch block (nil = no fork, 0 = already activated)
+
+	Bor *BorConfig `json:"bor,omitempty"` // Polygon-style chains, nil for others
+}
+
+// BorConfig - settings of Bor consensus which matter outside of execution
+type BorConfig struct {
+	SystemTxSenders []string `json:"systemTxSenders,omitempty"` // hex addresses which send fee-less system transactions
 }
 
 // Rules wraps Config and is merely syntactic sugar or can be used for functions
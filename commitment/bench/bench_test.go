@@ -0,0 +1,161 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bench
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"golang.org/x/crypto/sha3"
+)
+
+func smallConfig(kind Kind) Config {
+	cfg := DefaultConfig(kind)
+	cfg.Accounts = 500
+	cfg.SlotsPerAccount = 4
+	cfg.BatchSize = 200
+	return cfg
+}
+
+func TestGeneratorDeterministic(t *testing.T) {
+	for kind := Uniform; kind <= MassDelete; kind++ {
+		g1, err := NewGenerator(smallConfig(kind))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g2, err := NewGenerator(smallConfig(kind))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			b1, b2 := g1.Next(), g2.Next()
+			if b1.Len() != b2.Len() {
+				t.Fatalf("%s batch %d: len %d != %d", kind, i, b1.Len(), b2.Len())
+			}
+			for j := range b1.HashedKeys {
+				if !bytes.Equal(b1.PlainKeys[j], b2.PlainKeys[j]) || b1.Updates[j] != b2.Updates[j] {
+					t.Fatalf("%s batch %d: update %d differs", kind, i, j)
+				}
+				if j > 0 && bytes.Compare(b1.HashedKeys[j-1], b1.HashedKeys[j]) >= 0 {
+					t.Fatalf("%s batch %d: hashed keys are not sorted or not unique at %d", kind, i, j)
+				}
+			}
+		}
+	}
+}
+
+func TestNewGeneratorValidate(t *testing.T) {
+	cfg := smallConfig(Zipfian)
+	cfg.ZipfS = 1
+	if _, err := NewGenerator(cfg); err == nil {
+		t.Fatal("expected error for zipf skew 1")
+	}
+	if _, err := ParseKind("massdelete"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseKind("random"); err == nil {
+		t.Fatal("expected error for unknown kind")
+	}
+}
+
+// stateBatch - whole plain state as single batch, to compute the root from scratch
+func stateBatch(s *State) *Batch {
+	g := &Generator{keccak: sha3.NewLegacyKeccak256(), batch: &Batch{}, index: make(map[string]int)}
+	for addr, acc := range s.accounts {
+		u := g.add([]byte(addr), nil)
+		u.Flags = commitment.BALANCE_UPDATE | commitment.NONCE_UPDATE | commitment.CODE_UPDATE
+		u.Balance.Set(&acc.balance)
+		u.Nonce = acc.nonce
+		copy(u.CodeHashOrStorage[:], acc.codeHash[:])
+	}
+	for key, val := range s.storage {
+		u := g.add([]byte(key[:length.Addr]), []byte(key[length.Addr:]))
+		u.Flags = commitment.STORAGE_UPDATE
+		u.ValLength = copy(u.CodeHashOrStorage[:], val)
+	}
+	sort.Sort(g.batch)
+	return g.batch
+}
+
+func TestRunMatchesRootFromScratch(t *testing.T) {
+	for kind := Uniform; kind <= MassDelete; kind++ {
+		gen, err := NewGenerator(smallConfig(kind))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := NewState()
+		var batches int
+		res, err := Run(context.Background(), gen, state, 5, Hooks{AfterBatch: func(stats BatchStats) { batches++ }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Batches != 5 || batches != 5 {
+			t.Fatalf("%s: expected 5 batches, got %d, hooks called %d times", kind, res.Batches, batches)
+		}
+		if state.Accounts() != gen.LiveAccounts() {
+			t.Fatalf("%s: state has %d accounts, generator %d", kind, state.Accounts(), gen.LiveAccounts())
+		}
+		fresh := NewState()
+		b := stateBatch(state)
+		fresh.ApplyUpdates(b)
+		hph := fresh.NewTrie()
+		if _, err = hph.ProcessUpdates(b.PlainKeys, b.HashedKeys, b.Updates); err != nil {
+			t.Fatal(err)
+		}
+		rootHash, err := hph.RootHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rootHash, res.RootHash) {
+			t.Fatalf("%s: incremental root %x, from scratch %x", kind, res.RootHash, rootHash)
+		}
+		verified, err := commitment.NewVerifier(length.Addr, state.branchFn, state.accountFn, state.storageFn).RootHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(verified, res.RootHash) {
+			t.Fatalf("%s: incremental root %x, verified from persisted branches %x", kind, res.RootHash, verified)
+		}
+	}
+}
+
+func BenchmarkProcessUpdates(b *testing.B) {
+	for kind := Uniform; kind <= MassDelete; kind++ {
+		cfg := DefaultConfig(kind)
+		cfg.BatchSize = 1_000
+		b.Run(kind.String(), func(b *testing.B) {
+			gen, err := NewGenerator(cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.StopTimer()
+			res, err := Run(context.Background(), gen, NewState(), b.N, Hooks{
+				BeforeBatch: func(int) { b.StartTimer() },
+				AfterBatch:  func(BatchStats) { b.StopTimer() },
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(res.Took.Nanoseconds())/float64(res.Updates), "ns/update")
+		})
+	}
+}
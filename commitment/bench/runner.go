@@ -0,0 +1,133 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// BatchStats - measurements of single ProcessUpdates call
+type BatchStats struct {
+	Batch         int
+	Updates       int
+	BranchUpdates int
+	Took          time.Duration // ProcessUpdates and RootHash only, applying the batch to the State is not included
+}
+
+// Hooks - optional callbacks around every batch, to start/stop profilers or collect custom metrics
+type Hooks struct {
+	BeforeBatch func(batch int)
+	AfterBatch  func(stats BatchStats)
+}
+
+type Result struct {
+	Batches       int
+	Updates       int
+	BranchUpdates int
+	Took          time.Duration
+	RootHash      []byte
+}
+
+func (r *Result) String() string {
+	var perUpdate time.Duration
+	if r.Updates > 0 {
+		perUpdate = r.Took / time.Duration(r.Updates)
+	}
+	return fmt.Sprintf("batches=%d, updates=%d, branchUpdates=%d, took=%s (%s/update), root=%x",
+		r.Batches, r.Updates, r.BranchUpdates, r.Took, perUpdate, r.RootHash)
+}
+
+// Run - generates given number of batches and processes each of them end-to-end:
+// applies it to the state, runs ProcessUpdates, merges produced branch nodes back into the state
+func Run(ctx context.Context, gen *Generator, state *State, batches int, hooks Hooks) (*Result, error) {
+	hph := state.NewTrie()
+	res := &Result{}
+	for i := 0; i < batches; i++ {
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		default:
+		}
+		b := gen.Next()
+		state.ApplyUpdates(b)
+		if hooks.BeforeBatch != nil {
+			hooks.BeforeBatch(i)
+		}
+		start := time.Now()
+		hph.Reset()
+		branchNodeUpdates, err := hph.ProcessUpdates(b.PlainKeys, b.HashedKeys, b.Updates)
+		if err != nil {
+			return res, fmt.Errorf("batch %d: %w", i, err)
+		}
+		if res.RootHash, err = hph.RootHash(); err != nil {
+			return res, fmt.Errorf("batch %d root hash: %w", i, err)
+		}
+		stats := BatchStats{Batch: i, Updates: b.Len(), BranchUpdates: len(branchNodeUpdates), Took: time.Since(start)}
+		if hooks.AfterBatch != nil {
+			hooks.AfterBatch(stats)
+		}
+		if err = state.ApplyBranchUpdates(branchNodeUpdates); err != nil {
+			return res, fmt.Errorf("batch %d: %w", i, err)
+		}
+		res.Batches++
+		res.Updates += stats.Updates
+		res.BranchUpdates += stats.BranchUpdates
+		res.Took += stats.Took
+	}
+	return res, nil
+}
+
+// StartProfiling - writes CPU profile into cpuPath until stop is called, then heap profile into memPath.
+// Empty path disables corresponding profile
+func StartProfiling(cpuPath, memPath string) (stop func() error, err error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		if cpuFile, err = os.Create(cpuPath); err != nil {
+			return nil, fmt.Errorf("create cpu profile: %w", err)
+		}
+		if err = pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("start cpu profile: %w", err)
+		}
+	}
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				return fmt.Errorf("close cpu profile: %w", err)
+			}
+		}
+		if memPath == "" {
+			return nil
+		}
+		f, err := os.Create(memPath)
+		if err != nil {
+			return fmt.Errorf("create heap profile: %w", err)
+		}
+		defer f.Close()
+		runtime.GC()
+		if err = pprof.WriteHeapProfile(f); err != nil {
+			return fmt.Errorf("write heap profile: %w", err)
+		}
+		return nil
+	}, nil
+}
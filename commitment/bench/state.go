@@ -0,0 +1,139 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bench
+
+import (
+	"fmt"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+)
+
+type account struct {
+	nonce    uint64
+	balance  uint256.Int
+	codeHash [32]byte
+}
+
+// State - in memory plain state and branch nodes, backing the commitment during the benchmark
+type State struct {
+	accounts map[string]*account
+	storage  map[string][]byte
+	branches map[string][]byte
+}
+
+func NewState() *State {
+	return &State{
+		accounts: make(map[string]*account),
+		storage:  make(map[string][]byte),
+		branches: make(map[string][]byte),
+	}
+}
+
+// NewTrie - commitment reading the branches and the plain state from s
+func (s *State) NewTrie() *commitment.HexPatriciaHashed {
+	return commitment.NewHexPatriciaHashed(length.Addr, s.branchFn, s.accountFn, s.storageFn, s.lockFn, s.unlockFn)
+}
+
+// ApplyUpdates - applies batch to the plain state, must be called before the batch is processed by the trie
+func (s *State) ApplyUpdates(b *Batch) {
+	for i, plainKey := range b.PlainKeys {
+		u := &b.Updates[i]
+		if len(plainKey) > length.Addr {
+			if u.Flags == commitment.DELETE_UPDATE {
+				delete(s.storage, string(plainKey))
+			} else {
+				s.storage[string(plainKey)] = append([]byte(nil), u.CodeHashOrStorage[:u.ValLength]...)
+			}
+			continue
+		}
+		if u.Flags == commitment.DELETE_UPDATE {
+			delete(s.accounts, string(plainKey))
+			continue
+		}
+		acc, ok := s.accounts[string(plainKey)]
+		if !ok {
+			acc = &account{}
+			copy(acc.codeHash[:], commitment.EmptyCodeHash)
+			s.accounts[string(plainKey)] = acc
+		}
+		if u.Flags&commitment.BALANCE_UPDATE != 0 {
+			acc.balance.Set(&u.Balance)
+		}
+		if u.Flags&commitment.NONCE_UPDATE != 0 {
+			acc.nonce = u.Nonce
+		}
+		if u.Flags&commitment.CODE_UPDATE != 0 {
+			copy(acc.codeHash[:], u.CodeHashOrStorage[:])
+		}
+	}
+}
+
+// ApplyBranchUpdates - merges branch node updates produced by ProcessUpdates into the state
+func (s *State) ApplyBranchUpdates(updates map[string][]byte) error {
+	for prefix, update := range updates {
+		if update == nil {
+			continue
+		}
+		if pre, ok := s.branches[prefix]; ok {
+			merged, err := commitment.MergeBranches(pre, update, nil)
+			if err != nil {
+				return fmt.Errorf("merge branches [%x]: %w", commitment.CompactToHex([]byte(prefix)), err)
+			}
+			update = merged
+		}
+		s.branches[prefix] = update
+	}
+	return nil
+}
+
+func (s *State) Accounts() int { return len(s.accounts) }
+
+func (s *State) StorageSlots() int { return len(s.storage) }
+
+func (s *State) Branches() int { return len(s.branches) }
+
+func (s *State) lockFn() {}
+
+func (s *State) unlockFn() {}
+
+func (s *State) branchFn(prefix []byte) ([]byte, error) {
+	if branch, ok := s.branches[string(prefix)]; ok {
+		return branch[2:], nil // Skip touchMap, but keep afterMap
+	}
+	return nil, nil
+}
+
+func (s *State) accountFn(plainKey []byte, cell *commitment.Cell) ([]byte, error) {
+	cell.Nonce = 0
+	cell.Balance.Clear()
+	copy(cell.CodeHash[:], commitment.EmptyCodeHash)
+	if acc, ok := s.accounts[string(plainKey)]; ok {
+		cell.Nonce = acc.nonce
+		cell.Balance.Set(&acc.balance)
+		cell.CodeHash = acc.codeHash
+	}
+	return plainKey, nil
+}
+
+func (s *State) storageFn(plainKey []byte, cell *commitment.Cell) ([]byte, error) {
+	enc := s.storage[string(plainKey)]
+	cell.StorageLen = len(enc)
+	copy(cell.Storage[:], enc)
+	return plainKey, nil
+}
@@ -0,0 +1,278 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package bench generates reproducible synthetic update streams for the commitment
+// and runs them through HexPatriciaHashed.ProcessUpdates, to validate performance changes
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/rand"
+	"sort"
+
+	"github.com/ledgerwatch/erigon-lib/commitment"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"golang.org/x/crypto/sha3"
+)
+
+// Kind - shape of the synthetic workload
+type Kind uint8
+
+const (
+	Uniform    Kind = iota // accounts and storage slots are picked uniformly
+	Zipfian                // few hot accounts receive most of the updates
+	MassDelete             // batches populating the state alternate with batches deleting large part of it
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Uniform:
+		return "uniform"
+	case Zipfian:
+		return "zipfian"
+	case MassDelete:
+		return "massdelete"
+	default:
+		return fmt.Sprintf("unknown(%d)", k)
+	}
+}
+
+// ParseKind - inverse of Kind.String, to pick the workload from command line flags
+func ParseKind(s string) (Kind, error) {
+	for k := Uniform; k <= MassDelete; k++ {
+		if k.String() == s {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown workload kind %q", s)
+}
+
+type Config struct {
+	Kind            Kind
+	Seed            int64   // same seed and config produce the same update stream
+	Accounts        uint64  // size of the account key space
+	SlotsPerAccount uint64  // size of the storage key space of each account, 0 - no storage updates
+	StorageRatio    float64 // fraction of updates that modify storage rather than accounts
+	BatchSize       int     // number of updates generated per batch, before deduplication
+	ZipfS           float64 // Zipfian only: skew of the distribution, must be > 1
+	DeleteRatio     float64 // MassDelete only: fraction of live accounts deleted by every deleting batch
+}
+
+// DefaultConfig - moderate workload of given kind, which runs in reasonable time on a laptop
+func DefaultConfig(kind Kind) Config {
+	return Config{
+		Kind:            kind,
+		Seed:            1,
+		Accounts:        100_000,
+		SlotsPerAccount: 16,
+		StorageRatio:    0.5,
+		BatchSize:       10_000,
+		ZipfS:           1.1,
+		DeleteRatio:     0.5,
+	}
+}
+
+func (cfg Config) validate() error {
+	if cfg.Kind > MassDelete {
+		return fmt.Errorf("unknown workload kind %d", cfg.Kind)
+	}
+	if cfg.Accounts == 0 {
+		return fmt.Errorf("accounts must be positive")
+	}
+	if cfg.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", cfg.BatchSize)
+	}
+	if cfg.StorageRatio < 0 || cfg.StorageRatio > 1 {
+		return fmt.Errorf("storage ratio must be within [0, 1], got %f", cfg.StorageRatio)
+	}
+	if cfg.Kind == Zipfian && cfg.ZipfS <= 1 {
+		return fmt.Errorf("zipf skew must be > 1, got %f", cfg.ZipfS)
+	}
+	if cfg.Kind == MassDelete && (cfg.DeleteRatio <= 0 || cfg.DeleteRatio > 1) {
+		return fmt.Errorf("delete ratio must be within (0, 1], got %f", cfg.DeleteRatio)
+	}
+	return nil
+}
+
+// Batch - updates sorted by hashed key and free of duplicates, as ProcessUpdates expects them
+type Batch struct {
+	PlainKeys  [][]byte
+	HashedKeys [][]byte
+	Updates    []commitment.Update
+}
+
+func (b *Batch) Len() int { return len(b.Updates) }
+
+func (b *Batch) Less(i, j int) bool { return bytes.Compare(b.HashedKeys[i], b.HashedKeys[j]) < 0 }
+
+func (b *Batch) Swap(i, j int) {
+	b.PlainKeys[i], b.PlainKeys[j] = b.PlainKeys[j], b.PlainKeys[i]
+	b.HashedKeys[i], b.HashedKeys[j] = b.HashedKeys[j], b.HashedKeys[i]
+	b.Updates[i], b.Updates[j] = b.Updates[j], b.Updates[i]
+}
+
+// Generator - produces the stream of batches described by Config. Not thread-safe
+type Generator struct {
+	cfg     Config
+	rnd     *rand.Rand
+	zipf    *rand.Zipf
+	keccak  hash.Hash
+	live    map[uint64]map[uint64]struct{} // live accounts and their live storage slots
+	batches int
+	batch   *Batch
+	index   map[string]int // position of the plain key in the current batch
+}
+
+func NewGenerator(cfg Config) (*Generator, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("bench config: %w", err)
+	}
+	g := &Generator{
+		cfg:    cfg,
+		rnd:    rand.New(rand.NewSource(cfg.Seed)), //nolint:gosec
+		keccak: sha3.NewLegacyKeccak256(),
+		live:   make(map[uint64]map[uint64]struct{}),
+	}
+	if cfg.Kind == Zipfian {
+		g.zipf = rand.NewZipf(g.rnd, cfg.ZipfS, 1, cfg.Accounts-1)
+	}
+	return g, nil
+}
+
+func (g *Generator) Config() Config { return g.cfg }
+
+// LiveAccounts - number of accounts existing after all generated batches are applied
+func (g *Generator) LiveAccounts() int { return len(g.live) }
+
+// Next - generates next batch of updates
+func (g *Generator) Next() *Batch {
+	g.batch = &Batch{}
+	g.index = make(map[string]int, g.cfg.BatchSize)
+	if g.cfg.Kind == MassDelete && g.batches%2 == 1 {
+		g.deleteAccounts()
+	} else {
+		for i := 0; i < g.cfg.BatchSize; i++ {
+			acc := g.pickAccount()
+			if g.cfg.SlotsPerAccount > 0 && g.rnd.Float64() < g.cfg.StorageRatio {
+				g.updateStorage(acc, uint64(g.rnd.Int63n(int64(g.cfg.SlotsPerAccount))))
+			} else {
+				g.updateAccount(acc)
+			}
+		}
+	}
+	g.batches++
+	b := g.batch
+	g.batch, g.index = nil, nil
+	sort.Sort(b)
+	return b
+}
+
+func (g *Generator) pickAccount() uint64 {
+	if g.zipf != nil {
+		return g.zipf.Uint64()
+	}
+	return uint64(g.rnd.Int63n(int64(g.cfg.Accounts)))
+}
+
+func (g *Generator) updateAccount(acc uint64) {
+	u := g.add(accountKey(acc), nil)
+	u.Flags = commitment.BALANCE_UPDATE | commitment.NONCE_UPDATE
+	u.Balance.SetUint64(g.rnd.Uint64())
+	u.Nonce = uint64(g.rnd.Int63n(1 << 20))
+	if _, ok := g.live[acc]; !ok {
+		g.live[acc] = make(map[uint64]struct{})
+	}
+}
+
+func (g *Generator) updateStorage(acc, slot uint64) {
+	if _, ok := g.live[acc]; !ok {
+		// Storage can only exist under existing account
+		g.updateAccount(acc)
+	}
+	u := g.add(accountKey(acc), storageLoc(slot))
+	u.Flags = commitment.STORAGE_UPDATE
+	u.ValLength = 1 + g.rnd.Intn(length.Hash)
+	g.rnd.Read(u.CodeHashOrStorage[:u.ValLength])
+	g.live[acc][slot] = struct{}{}
+}
+
+// deleteAccounts - deletes DeleteRatio of the live accounts together with their storage
+func (g *Generator) deleteAccounts() {
+	accs := make([]uint64, 0, len(g.live))
+	for acc := range g.live {
+		accs = append(accs, acc)
+	}
+	// Map iteration order is random, sorting keeps the stream reproducible
+	sort.Slice(accs, func(i, j int) bool { return accs[i] < accs[j] })
+	g.rnd.Shuffle(len(accs), func(i, j int) { accs[i], accs[j] = accs[j], accs[i] })
+	for _, acc := range accs[:int(float64(len(accs))*g.cfg.DeleteRatio)] {
+		slots := make([]uint64, 0, len(g.live[acc]))
+		for slot := range g.live[acc] {
+			slots = append(slots, slot)
+		}
+		sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+		for _, slot := range slots {
+			g.add(accountKey(acc), storageLoc(slot)).Flags = commitment.DELETE_UPDATE
+		}
+		g.add(accountKey(acc), nil).Flags = commitment.DELETE_UPDATE
+		delete(g.live, acc)
+	}
+}
+
+// add - returns update for the key within the current batch, replacing previous update of the same key
+func (g *Generator) add(addr, loc []byte) *commitment.Update {
+	plainKey := append(addr, loc...)
+	if i, ok := g.index[string(plainKey)]; ok {
+		g.batch.Updates[i] = commitment.Update{}
+		return &g.batch.Updates[i]
+	}
+	g.index[string(plainKey)] = len(g.batch.Updates)
+	g.batch.PlainKeys = append(g.batch.PlainKeys, plainKey)
+	g.batch.HashedKeys = append(g.batch.HashedKeys, g.hashKey(addr, loc))
+	g.batch.Updates = append(g.batch.Updates, commitment.Update{})
+	return &g.batch.Updates[len(g.batch.Updates)-1]
+}
+
+// hashKey - nibbles of keccak(addr), followed by nibbles of keccak(loc) for storage keys
+func (g *Generator) hashKey(addr, loc []byte) []byte {
+	hashedKey := make([]byte, 0, 4*length.Hash)
+	for _, part := range [][]byte{addr, loc} {
+		if part == nil {
+			continue
+		}
+		g.keccak.Reset()
+		g.keccak.Write(part)
+		for _, b := range g.keccak.Sum(nil) {
+			hashedKey = append(hashedKey, (b>>4)&0xf, b&0xf)
+		}
+	}
+	return hashedKey
+}
+
+func accountKey(acc uint64) []byte {
+	addr := make([]byte, length.Addr)
+	binary.BigEndian.PutUint64(addr[length.Addr-8:], acc)
+	return addr
+}
+
+func storageLoc(slot uint64) []byte {
+	loc := make([]byte, length.Hash)
+	binary.BigEndian.PutUint64(loc[length.Hash-8:], slot)
+	return loc
+}
@@ -79,23 +79,31 @@ type HexPatriciaHashed struct {
 	// and for the extension, account, and leaf type, the `l` and `k`
 	lockFn   func()
 	unlockFn func()
-	branchFn func(prefix []byte) []byte
+	// Errors of the functions (failed reads of the state) are returned by ProcessUpdates
+	branchFn func(prefix []byte) ([]byte, error)
 	// Function used to fetch account with given plain key
-	accountFn func(plainKey []byte, cell *Cell) []byte
-	// Function used to fetch account with given plain key
-	storageFn       func(plainKey []byte, cell *Cell) []byte
+	accountFn func(plainKey []byte, cell *Cell) ([]byte, error)
+	// Function used to fetch storage item with given plain key
+	storageFn func(plainKey []byte, cell *Cell) ([]byte, error)
+	// Function used to fetch storage root of the account with given plain key in account-only mode,
+	// nil means that storage trie is computed from the storage updates (full mode)
+	storageRootFn   func(accountPlainKey []byte) ([]byte, error)
 	keccak          keccakState
 	keccak2         keccakState
 	accountKeyLen   int
 	trace           bool
 	numBuf          [binary.MaxVarintLen64]byte
 	byteArrayWriter ByteArrayWriter
+	// Receipts of updates of current ProcessUpdatesWithReceipts call, receiptIdx - plain key => index of receipt
+	// of created or updated leaf, nil if receipts are not collected
+	receipts   []UpdateReceipt
+	receiptIdx map[string]int
 }
 
 func NewHexPatriciaHashed(accountKeyLen int,
-	branchFn func(prefix []byte) []byte,
-	accountFn func(plainKey []byte, cell *Cell) []byte,
-	storageFn func(plainKey []byte, cell *Cell) []byte,
+	branchFn func(prefix []byte) ([]byte, error),
+	accountFn func(plainKey []byte, cell *Cell) ([]byte, error),
+	storageFn func(plainKey []byte, cell *Cell) ([]byte, error),
 	lockFn func(),
 	unlockFn func(),
 ) *HexPatriciaHashed {
@@ -387,16 +395,22 @@ func (hph *HexPatriciaHashed) SetTrace(trace bool) {
 	hph.trace = trace
 }
 
+// SetStorageRootFn - switches the trie into account-only mode: storage tries are not built, storage updates
+// only mark their account as modified, and storage roots of the accounts are taken from storageRootFn
+// (nil result means empty storage). Passing nil switches back to the full mode.
+func (hph *HexPatriciaHashed) SetStorageRootFn(storageRootFn func(accountPlainKey []byte) ([]byte, error)) {
+	hph.storageRootFn = storageRootFn
+}
+
 // hasTerm returns whether a hex key has the terminator flag.
 func hasTerm(s []byte) bool {
 	return len(s) > 0 && s[len(s)-1] == 16
 }
 
-func (hph *HexPatriciaHashed) completeLeafHash(buf []byte, keyPrefix []byte, kp, kl, compactLen int, key []byte, compact0 byte, ni int, val rlp.RlpSerializable, singleton bool) ([]byte, error) {
-	totalLen := kp + kl + val.DoubleRLPLen()
-	var lenPrefix [4]byte
-	pt := rlp.GenerateStructLen(lenPrefix[:], totalLen)
-	embedded := !singleton && totalLen+pt < length.Hash
+// completeLeafHash - appends reference to the leaf node to buf: node itself if it's shorter than 32 bytes
+// and not singleton, hash with 0xa0 prefix otherwise
+func (hph *HexPatriciaHashed) completeLeafHash(buf []byte, key []byte, val rlp.RlpSerializable, singleton bool) ([]byte, error) {
+	embedded := !singleton && rlp.LeafLen(len(key), val) < length.Hash
 	var writer io.Writer
 	if embedded {
 		hph.byteArrayWriter.Setup(buf)
@@ -405,26 +419,7 @@ func (hph *HexPatriciaHashed) completeLeafHash(buf []byte, keyPrefix []byte, kp,
 		hph.keccak.Reset()
 		writer = hph.keccak
 	}
-	if _, err := writer.Write(lenPrefix[:pt]); err != nil {
-		return nil, err
-	}
-	if _, err := writer.Write(keyPrefix[:kp]); err != nil {
-		return nil, err
-	}
-	var b [1]byte
-	b[0] = compact0
-	if _, err := writer.Write(b[:]); err != nil {
-		return nil, err
-	}
-	for i := 1; i < compactLen; i++ {
-		b[0] = key[ni]*16 + key[ni+1]
-		if _, err := writer.Write(b[:]); err != nil {
-			return nil, err
-		}
-		ni += 2
-	}
-	var prefixBuf [8]byte
-	if err := val.ToDoubleRLP(writer, prefixBuf[:]); err != nil {
+	if err := rlp.LeafHash(writer, key, val); err != nil {
 		return nil, err
 	}
 	if embedded {
@@ -441,32 +436,7 @@ func (hph *HexPatriciaHashed) completeLeafHash(buf []byte, keyPrefix []byte, kp,
 }
 
 func (hph *HexPatriciaHashed) leafHashWithKeyVal(buf []byte, key []byte, val rlp.RlpSerializableBytes, singleton bool) ([]byte, error) {
-	// Compute the total length of binary representation
-	var kp, kl int
-	// Write key
-	var compactLen int
-	var ni int
-	var compact0 byte
-	compactLen = (len(key)-1)/2 + 1
-	if len(key)&1 == 0 {
-		compact0 = 0x30 + key[0] // Odd: (3<<4) + first nibble
-		ni = 1
-	} else {
-		compact0 = 0x20
-	}
-	var keyPrefix [1]byte
-	if compactLen > 1 {
-		keyPrefix[0] = 0x80 + byte(compactLen)
-		kp = 1
-		kl = compactLen
-	} else {
-		kl = 1
-	}
-	buf, err := hph.completeLeafHash(buf, keyPrefix[:], kp, kl, compactLen, key, compact0, ni, val, singleton)
-	if err != nil {
-		return nil, err
-	}
-	return buf, nil
+	return hph.completeLeafHash(buf, key, val, singleton)
 }
 
 func (cell *Cell) accountForHashing(buffer []byte, storageRootHash []byte) int {
@@ -538,99 +508,12 @@ func (cell *Cell) accountForHashing(buffer []byte, storageRootHash []byte) int {
 }
 
 func (hph *HexPatriciaHashed) accountLeafHashWithKey(buf []byte, key []byte, val rlp.RlpSerializable) ([]byte, error) {
-	// Compute the total length of binary representation
-	var kp, kl int
-	// Write key
-	var compactLen int
-	var ni int
-	var compact0 byte
-	if hasTerm(key) {
-		compactLen = (len(key)-1)/2 + 1
-		if len(key)&1 == 0 {
-			compact0 = 48 + key[0] // Odd (1<<4) + first nibble
-			ni = 1
-		} else {
-			compact0 = 32
-		}
-	} else {
-		compactLen = len(key)/2 + 1
-		if len(key)&1 == 1 {
-			compact0 = 16 + key[0] // Odd (1<<4) + first nibble
-			ni = 1
-		}
-	}
-	var keyPrefix [1]byte
-	if compactLen > 1 {
-		keyPrefix[0] = byte(128 + compactLen)
-		kp = 1
-		kl = compactLen
-	} else {
-		kl = 1
-	}
-	var err error
-	if buf, err = hph.completeLeafHash(buf, keyPrefix[:], kp, kl, compactLen, key, compact0, ni, val, true); err != nil {
-		return nil, err
-	}
-	return buf, nil
+	return hph.completeLeafHash(buf, key, val, true)
 }
 
 func (hph *HexPatriciaHashed) extensionHash(buf []byte, key []byte, hash []byte) ([]byte, error) {
-	// Compute the total length of binary representation
-	var kp, kl int
-	// Write key
-	var compactLen int
-	var ni int
-	var compact0 byte
-	if hasTerm(key) {
-		compactLen = (len(key)-1)/2 + 1
-		if len(key)&1 == 0 {
-			compact0 = 0x30 + key[0] // Odd: (3<<4) + first nibble
-			ni = 1
-		} else {
-			compact0 = 0x20
-		}
-	} else {
-		compactLen = len(key)/2 + 1
-		if len(key)&1 == 1 {
-			compact0 = 0x10 + key[0] // Odd: (1<<4) + first nibble
-			ni = 1
-		}
-	}
-	var keyPrefix [1]byte
-	if compactLen > 1 {
-		keyPrefix[0] = 0x80 + byte(compactLen)
-		kp = 1
-		kl = compactLen
-	} else {
-		kl = 1
-	}
-	totalLen := kp + kl + 33
-	var lenPrefix [4]byte
-	pt := rlp.GenerateStructLen(lenPrefix[:], totalLen)
 	hph.keccak.Reset()
-	if _, err := hph.keccak.Write(lenPrefix[:pt]); err != nil {
-		return nil, err
-	}
-	if _, err := hph.keccak.Write(keyPrefix[:kp]); err != nil {
-		return nil, err
-	}
-	var b [1]byte
-	b[0] = compact0
-	if _, err := hph.keccak.Write(b[:]); err != nil {
-		return nil, err
-	}
-	for i := 1; i < compactLen; i++ {
-		b[0] = key[ni]*16 + key[ni+1]
-		if _, err := hph.keccak.Write(b[:]); err != nil {
-			return nil, err
-		}
-		ni += 2
-	}
-	b[0] = 0x80 + length.Hash
-	if _, err := hph.keccak.Write(b[:]); err != nil {
-		return nil, err
-	}
-	if _, err := hph.keccak.Write(hash); err != nil {
+	if err := rlp.ExtensionHash(hph.keccak, key, hash); err != nil {
 		return nil, err
 	}
 	// Replace previous hash with the new one
@@ -645,20 +528,8 @@ func (hph *HexPatriciaHashed) extensionHash(buf []byte, key []byte, hash []byte)
 func (hph *HexPatriciaHashed) computeCellHashLen(cell *Cell, depth int) int {
 	if cell.spl > 0 && depth >= 64 {
 		keyLen := 128 - depth + 1 // Length of hex key with terminator character
-		var kp, kl int
-		compactLen := (keyLen-1)/2 + 1
-		if compactLen > 1 {
-			kp = 1
-			kl = compactLen
-		} else {
-			kl = 1
-		}
-		val := rlp.RlpSerializableBytes(cell.Storage[:cell.StorageLen])
-		totalLen := kp + kl + val.DoubleRLPLen()
-		var lenPrefix [4]byte
-		pt := rlp.GenerateStructLen(lenPrefix[:], totalLen)
-		if totalLen+pt < length.Hash {
-			return totalLen + pt
+		if l := rlp.LeafLen(keyLen, rlp.RlpSerializableBytes(cell.Storage[:cell.StorageLen])); l < length.Hash {
+			return l
 		}
 	}
 	return length.Hash + 1
@@ -685,13 +556,16 @@ func (hph *HexPatriciaHashed) computeCellHash(cell *Cell, depth int, buf []byte)
 				return nil, err
 			}
 			storageRootHash = storageRootHash[1:]
+			hph.receiptLeafHash(cell.spk[:cell.spl], storageRootHash)
 		} else {
 			if hph.trace {
 				fmt.Printf("leafHashWithKeyVal for [%x]=>[%x]\n", cell.downHashedKey[:64-hashedKeyOffset+1], cell.Storage[:cell.StorageLen])
 			}
+			start := len(buf)
 			if buf, err = hph.leafHashWithKeyVal(buf, cell.downHashedKey[:64-hashedKeyOffset+1], rlp.RlpSerializableBytes(cell.Storage[:cell.StorageLen]), false); err != nil {
 				return nil, err
 			}
+			hph.receiptLeafHash(cell.spk[:cell.spl], buf[start:])
 			return buf, nil
 		}
 	}
@@ -700,7 +574,14 @@ func (hph *HexPatriciaHashed) computeCellHash(cell *Cell, depth int, buf []byte)
 			return nil, err
 		}
 		cell.downHashedKey[64-depth] = 16 // Add terminator
-		if storageRootHash == nil {
+		if hph.storageRootFn != nil {
+			if storageRootHash, err = hph.storageRootFn(cell.apk[:cell.apl]); err != nil {
+				return nil, fmt.Errorf("storageRootFn for [%x]: %w", cell.apk[:cell.apl], err)
+			}
+			if storageRootHash == nil {
+				storageRootHash = EmptyRootHash
+			}
+		} else if storageRootHash == nil {
 			if cell.extLen > 0 {
 				// Extension
 				if cell.hl > 0 {
@@ -724,9 +605,11 @@ func (hph *HexPatriciaHashed) computeCellHash(cell *Cell, depth int, buf []byte)
 		if hph.trace {
 			fmt.Printf("accountLeafHashWithKey for [%x]=>[%x]\n", cell.downHashedKey[:65-depth], valBuf[:valLen])
 		}
+		start := len(buf)
 		if buf, err = hph.accountLeafHashWithKey(buf, cell.downHashedKey[:65-depth], rlp.RlpEncodedBytes(valBuf[:valLen])); err != nil {
 			return nil, err
 		}
+		hph.receiptLeafHash(cell.apk[:cell.apl], buf[start:])
 		return buf, nil
 	}
 	buf = append(buf, 0x80+32)
@@ -830,10 +713,25 @@ func (hph *HexPatriciaHashed) Reset() {
 	hph.rootPresent = true
 }
 
+// clear - Reset, and also forgets functions, settings and position in the grid, which may be left by failed
+// ProcessUpdates. Grid itself isn't cleared: unfold initialises every row it activates
+func (hph *HexPatriciaHashed) clear() {
+	hph.Reset()
+	hph.ResetFns(nil, nil, nil, nil, nil)
+	hph.storageRootFn = nil
+	hph.trace = false
+	hph.activeRows = 0
+	hph.currentKeyLen = 0
+	hph.receipts, hph.receiptIdx = nil, nil
+	hph.keccak.Reset()
+	hph.keccak2.Reset()
+	hph.byteArrayWriter.Setup(nil)
+}
+
 func (hph *HexPatriciaHashed) ResetFns(
-	branchFn func(prefix []byte) []byte,
-	accountFn func(plainKey []byte, cell *Cell) []byte,
-	storageFn func(plainKey []byte, cell *Cell) []byte,
+	branchFn func(prefix []byte) ([]byte, error),
+	accountFn func(plainKey []byte, cell *Cell) ([]byte, error),
+	storageFn func(plainKey []byte, cell *Cell) ([]byte, error),
 	lockFn func(),
 	unlockFn func(),
 ) {
@@ -894,7 +792,10 @@ func (hph *HexPatriciaHashed) needUnfolding(hashedKey []byte) int {
 func (hph *HexPatriciaHashed) unfoldBranchNode(row int, deleted bool, depth int) error {
 	//hph.lockFn()
 	//defer hph.unlockFn()
-	branchData := hph.branchFn(hexToCompact(hph.currentKey[:hph.currentKeyLen]))
+	branchData, err := hph.branchFn(hexToCompact(hph.currentKey[:hph.currentKeyLen]))
+	if err != nil {
+		return fmt.Errorf("branchFn [%x]: %w", hph.currentKey[:hph.currentKeyLen], err)
+	}
 	if !hph.rootChecked && hph.currentKeyLen == 0 && len(branchData) == 0 {
 		// Special case - empty or deleted root
 		hph.rootChecked = true
@@ -919,7 +820,6 @@ func (hph *HexPatriciaHashed) unfoldBranchNode(row int, deleted bool, depth int)
 		cell := &hph.grid[row][nibble]
 		fieldBits := branchData[pos]
 		pos++
-		var err error
 		if pos, err = cell.fillFromFields(branchData, pos, PartFlags(fieldBits)); err != nil {
 			return fmt.Errorf("prefix [%x], branchData[%x]: %w", hph.currentKey[:hph.currentKeyLen], branchData, err)
 		}
@@ -927,7 +827,10 @@ func (hph *HexPatriciaHashed) unfoldBranchNode(row int, deleted bool, depth int)
 			fmt.Printf("cell (%d, %x) depth=%d, hash=[%x], a=[%x], s=[%x], ex=[%x]\n", row, nibble, depth, cell.h[:cell.hl], cell.apk[:cell.apl], cell.spk[:cell.spl], cell.extension[:cell.extLen])
 		}
 		if cell.apl > 0 {
-			k := hph.accountFn(cell.apk[:cell.apl], cell)
+			k, err := hph.accountFn(cell.apk[:cell.apl], cell)
+			if err != nil {
+				return fmt.Errorf("accountFn [%x]: %w", cell.apk[:cell.apl], err)
+			}
 			cell.apl = len(k)
 			copy(cell.apk[:], k)
 			if hph.trace {
@@ -935,7 +838,10 @@ func (hph *HexPatriciaHashed) unfoldBranchNode(row int, deleted bool, depth int)
 			}
 		}
 		if cell.spl > 0 {
-			k := hph.storageFn(cell.spk[:cell.spl], cell)
+			k, err := hph.storageFn(cell.spk[:cell.spl], cell)
+			if err != nil {
+				return fmt.Errorf("storageFn [%x]: %w", cell.spk[:cell.spl], err)
+			}
 			cell.spl = len(k)
 			copy(cell.spk[:], k)
 		}
@@ -1215,51 +1121,30 @@ func (hph *HexPatriciaHashed) fold() ([]byte, []byte, error) {
 			hph.touchMap[row] |= hph.afterMap[row]
 			bitmap |= hph.afterMap[row]
 		}
-		// Calculate total length of all hashes
-		totalBranchLen := 17 - partsCount // For every empty cell, one byte
-		for bitset, j := hph.afterMap[row], 0; bitset != 0; j++ {
+		// Calculate total length of references to children
+		var refsLen int
+		for bitset := hph.afterMap[row]; bitset != 0; {
 			bit := bitset & -bitset
 			nibble := bits.TrailingZeros16(bit)
 			cell := &hph.grid[row][nibble]
-			totalBranchLen += hph.computeCellHashLen(cell, depth)
+			refsLen += hph.computeCellHashLen(cell, depth)
 			bitset ^= bit
 		}
 		binary.BigEndian.PutUint16(bitmapBuf[0:], hph.touchMap[row])
 		binary.BigEndian.PutUint16(bitmapBuf[2:], hph.afterMap[row])
 		branchData = append(branchData, bitmapBuf[:]...)
 		hph.keccak2.Reset()
-		var lenPrefix [4]byte
-		pt := rlp.GenerateStructLen(lenPrefix[:], totalBranchLen)
-		if _, err := hph.keccak2.Write(lenPrefix[:pt]); err != nil {
-			return nil, nil, err
-		}
-		var lastNibble int
-		var b [1]byte
 		var cellHashBuf [33]byte
-		for bitset, j := hph.afterMap[row], 0; bitset != 0; j++ {
-			bit := bitset & -bitset
-			nibble := bits.TrailingZeros16(bit)
-			b[0] = 0x80
-			for i := lastNibble; i < nibble; i++ {
-				if _, err := hph.keccak2.Write(b[:]); err != nil {
-					return nil, nil, err
-				}
-				if hph.trace {
-					fmt.Printf("%x: empty(%d,%x)\n", i, row, i)
-				}
-			}
-			lastNibble = nibble + 1
+		if err := rlp.BranchHash(hph.keccak2, hph.afterMap[row], refsLen, func(nibble int) ([]byte, error) {
+			bit := uint16(1) << nibble
 			cell := &hph.grid[row][nibble]
 			cellHash, err := hph.computeCellHash(cell, depth, cellHashBuf[:0])
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			if hph.trace {
 				fmt.Printf("%x: computeCellHash(%d,%x,depth=%d)=[%x]\n", nibble, row, nibble, depth, cellHash)
 			}
-			if _, err = hph.keccak2.Write(cellHash); err != nil {
-				return nil, nil, err
-			}
 			if bitmap&bit != 0 {
 				var fieldBits PartFlags
 				if cell.extLen > 0 && cell.spl == 0 {
@@ -1296,16 +1181,9 @@ func (hph *HexPatriciaHashed) fold() ([]byte, []byte, error) {
 					branchData = append(branchData, cell.h[:cell.hl]...)
 				}
 			}
-			bitset ^= bit
-		}
-		b[0] = 0x80
-		for i := lastNibble; i < 17; i++ {
-			if _, err := hph.keccak2.Write(b[:]); err != nil {
-				return nil, nil, err
-			}
-			if hph.trace {
-				fmt.Printf("%x: empty(%d,%x)\n", i, row, i)
-			}
+			return cellHash, nil
+		}); err != nil {
+			return nil, nil, err
 		}
 		upCell.extLen = depth - upDepth - 1
 		if upCell.extLen > 0 {
@@ -1412,6 +1290,110 @@ func (hph *HexPatriciaHashed) updateAccount(plainKey, hashedKey []byte) *Cell {
 	return cell
 }
 
+// touchAccount - marks existing account cell as modified without changing it, so that its hash gets recomputed
+func (hph *HexPatriciaHashed) touchAccount(plainKey, hashedKey []byte) {
+	if hph.trace {
+		fmt.Printf("touchAccount [%x] [%x], activeRows = %d\n", plainKey, hashedKey, hph.activeRows)
+	}
+	if hph.activeRows == 0 {
+		if hph.rootPresent && bytes.Equal(hph.root.apk[:hph.root.apl], plainKey) {
+			hph.rootTouched = true
+		}
+		return
+	}
+	row := hph.activeRows - 1
+	col := int(hashedKey[hph.currentKeyLen])
+	cell := &hph.grid[row][col]
+	if hph.afterMap[row]&(uint16(1)<<col) != 0 && bytes.Equal(cell.apk[:cell.apl], plainKey) {
+		hph.touchMap[row] |= (uint16(1) << col)
+	}
+}
+
+// clearStorage - makes storage of existing account empty, and emits deletion of every storage branch node
+// of the account found in the database, because these nodes would not be visited by fold
+func (hph *HexPatriciaHashed) clearStorage(plainKey, hashedKey []byte, branchNodeUpdates map[string][]byte) error {
+	if hph.trace {
+		fmt.Printf("clearStorage [%x] [%x], activeRows = %d\n", plainKey, hashedKey, hph.activeRows)
+	}
+	var cell *Cell
+	var depth int
+	if hph.activeRows == 0 {
+		if !hph.rootPresent || !bytes.Equal(hph.root.apk[:hph.root.apl], plainKey) {
+			return nil
+		}
+		cell = &hph.root
+		hph.rootTouched = true
+	} else {
+		row := hph.activeRows - 1
+		depth = hph.depths[row]
+		col := int(hashedKey[hph.currentKeyLen])
+		cell = &hph.grid[row][col]
+		if hph.afterMap[row]&(uint16(1)<<col) == 0 || !bytes.Equal(cell.apk[:cell.apl], plainKey) {
+			return nil
+		}
+		hph.touchMap[row] |= (uint16(1) << col)
+	}
+	if cell.spl == 0 && cell.hl > 0 {
+		// Storage root is a branch node, possibly behind the extension
+		prefix := make([]byte, 0, 128)
+		prefix = append(prefix, hashedKey[:64]...)
+		prefix = append(prefix, cell.extension[:cell.extLen]...)
+		if err := hph.deleteBranches(prefix, branchNodeUpdates); err != nil {
+			return err
+		}
+	}
+	cell.spl = 0
+	cell.StorageLen = 0
+	cell.hl = 0
+	cell.extLen = 0
+	if cell.downHashedLen > 64-depth {
+		cell.downHashedLen = 64 - depth
+	}
+	return nil
+}
+
+// deleteBranches - emits deletion of the branch node with given prefix and of all branch nodes below it
+func (hph *HexPatriciaHashed) deleteBranches(prefix []byte, branchNodeUpdates map[string][]byte) error {
+	branchData, err := hph.branchFn(hexToCompact(prefix))
+	if err != nil {
+		return fmt.Errorf("branchFn [%x]: %w", prefix, err)
+	}
+	if len(branchData) == 0 {
+		return nil
+	}
+	bitmap := binary.BigEndian.Uint16(branchData[0:])
+	pos := 2
+	var cell Cell
+	for bitset := bitmap; bitset != 0; {
+		bit := bitset & -bitset
+		nibble := bits.TrailingZeros16(bit)
+		fieldBits := branchData[pos]
+		pos++
+		if pos, err = cell.fillFromFields(branchData, pos, PartFlags(fieldBits)); err != nil {
+			return fmt.Errorf("prefix [%x], branchData[%x]: %w", prefix, branchData, err)
+		}
+		if cell.spl == 0 && cell.hl > 0 {
+			// Not a leaf - there is a branch node below
+			childPrefix := make([]byte, 0, 128)
+			childPrefix = append(childPrefix, prefix...)
+			childPrefix = append(childPrefix, byte(nibble))
+			childPrefix = append(childPrefix, cell.extension[:cell.extLen]...)
+			if err = hph.deleteBranches(childPrefix, branchNodeUpdates); err != nil {
+				return err
+			}
+		}
+		bitset ^= bit
+	}
+	if hph.trace {
+		fmt.Printf("deleteBranches [%x], touchMap: %016b\n", prefix, bitmap)
+	}
+	deleted := make([]byte, 4)
+	binary.BigEndian.PutUint16(deleted[0:], bitmap) // touchMap
+	binary.BigEndian.PutUint16(deleted[2:], 0)      // afterMap
+	branchNodeUpdates[string(hexToCompact(prefix))] = deleted
+	return nil
+}
+
 func (hph *HexPatriciaHashed) updateBalance(plainKey, hashedKey []byte, balance *uint256.Int) {
 	if hph.trace {
 		fmt.Printf("updateBalance [%x] [%x] = %d, activeRows = %d\n", plainKey, hashedKey, balance, hph.activeRows)
@@ -1496,11 +1478,14 @@ const (
 	BALANCE_UPDATE UpdateFlags = 4
 	NONCE_UPDATE   UpdateFlags = 8
 	STORAGE_UPDATE UpdateFlags = 16
+	// CLEAR_STORAGE wipes whole storage of the account (self-destructed or re-created contract), it is applied
+	// before other flags of the same update, and storage updates of the account coming after it in the batch
+	CLEAR_STORAGE UpdateFlags = 32
 )
 
 func (uf UpdateFlags) String() string {
 	var sb strings.Builder
-	if uf == DELETE_UPDATE {
+	if uf&^CLEAR_STORAGE == DELETE_UPDATE {
 		sb.WriteString("Delete")
 	} else {
 		if uf&BALANCE_UPDATE != 0 {
@@ -1516,6 +1501,9 @@ func (uf UpdateFlags) String() string {
 			sb.WriteString("+Storage")
 		}
 	}
+	if uf&CLEAR_STORAGE != 0 {
+		sb.WriteString("+ClearStorage")
+	}
 	return sb.String()
 }
 
@@ -1667,6 +1655,12 @@ func (hph *HexPatriciaHashed) ProcessUpdates(plainKeys, hashedKeys [][]byte, upd
 			fmt.Printf("plainKey=[%x], hashedKey=[%x], currentKey=[%x], update=%s\n",
 				plainKey, hashedKey, hph.currentKey[:hph.currentKeyLen], update)
 		}
+		// In account-only mode storage updates are reduced to touching their accounts
+		storageOnly := hph.storageRootFn != nil && len(plainKey) > hph.accountKeyLen
+		if storageOnly {
+			plainKey = plainKey[:hph.accountKeyLen]
+			hashedKey = hashedKey[:64]
+		}
 		// Keep folding until the currentKey is the prefix of the key we modify
 		for hph.needFolding(hashedKey) {
 			if branchData, updateKey, err := hph.fold(); err != nil {
@@ -1681,8 +1675,22 @@ func (hph *HexPatriciaHashed) ProcessUpdates(plainKeys, hashedKeys [][]byte, upd
 				return nil, fmt.Errorf("unfold: %w", err)
 			}
 		}
+		if hph.receiptIdx != nil {
+			hph.receipts[i].PlainKey = plainKeys[i]
+			hph.receipts[i].Change = hph.leafChange(plainKey, hashedKey, &update, storageOnly)
+			if c := hph.receipts[i].Change; c == LeafCreated || c == LeafUpdated {
+				hph.receiptIdx[string(plainKey)] = i
+			}
+		}
 		// Update the cell
-		if update.Flags == DELETE_UPDATE {
+		if !storageOnly && update.Flags&CLEAR_STORAGE != 0 {
+			if err := hph.clearStorage(plainKey, hashedKey, branchNodeUpdates); err != nil {
+				return nil, fmt.Errorf("clearStorage: %w", err)
+			}
+		}
+		if storageOnly {
+			hph.touchAccount(plainKey, hashedKey)
+		} else if update.Flags&^CLEAR_STORAGE == DELETE_UPDATE {
 			hph.deleteCell(hashedKey)
 		} else {
 			if update.Flags&BALANCE_UPDATE != 0 {
@@ -17,14 +17,17 @@
 package commitment
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
 	"testing"
 
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/rlp"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -50,35 +53,31 @@ func (ms MockState) lockFn() {
 func (ms MockState) unlockFn() {
 }
 
-func (ms MockState) branchFn(prefix []byte) []byte {
+func (ms MockState) branchFn(prefix []byte) ([]byte, error) {
 	if exBytes, ok := ms.cm[string(prefix)]; ok {
-		return exBytes[2:] // Skip touchMap, but keep afterMap
+		return exBytes[2:], nil // Skip touchMap, but keep afterMap
 	}
-	return nil
+	return nil, nil
 }
 
-func (ms MockState) accountFn(plainKey []byte, cell *Cell) []byte {
+func (ms MockState) accountFn(plainKey []byte, cell *Cell) ([]byte, error) {
 	exBytes, ok := ms.sm[string(plainKey)]
 	if !ok {
-		ms.t.Fatalf("accountFn not found key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("accountFn not found key [%x]", plainKey)
 	}
 	var ex Update
 	pos, err := ex.decode(exBytes, 0)
 	if err != nil {
-		ms.t.Fatalf("accountFn decode existing [%x], bytes: [%x]: %v", plainKey, exBytes, err)
-		return nil
+		return nil, fmt.Errorf("accountFn decode existing [%x], bytes: [%x]: %w", plainKey, exBytes, err)
 	}
 	if pos != len(exBytes) {
-		ms.t.Fatalf("accountFn key [%x] leftover bytes in [%x], comsumed %x", plainKey, exBytes, pos)
-		return nil
+		return nil, fmt.Errorf("accountFn key [%x] leftover bytes in [%x], comsumed %x", plainKey, exBytes, pos)
 	}
 	if ex.Flags&STORAGE_UPDATE != 0 {
-		ms.t.Fatalf("accountFn reading storage item for key [%x]", plainKey)
+		return nil, fmt.Errorf("accountFn reading storage item for key [%x]", plainKey)
 	}
 	if ex.Flags&DELETE_UPDATE != 0 {
-		ms.t.Fatalf("accountFn reading deleted account for key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("accountFn reading deleted account for key [%x]", plainKey)
 	}
 	if ex.Flags&BALANCE_UPDATE != 0 {
 		cell.Balance.Set(&ex.Balance)
@@ -95,52 +94,55 @@ func (ms MockState) accountFn(plainKey []byte, cell *Cell) []byte {
 	} else {
 		cell.CodeHash = [32]byte{}
 	}
-	return plainKey
+	return plainKey, nil
 }
 
-func (ms MockState) storageFn(plainKey []byte, cell *Cell) []byte {
+func (ms MockState) storageFn(plainKey []byte, cell *Cell) ([]byte, error) {
 	exBytes, ok := ms.sm[string(plainKey)]
 	if !ok {
-		ms.t.Fatalf("storageFn not found key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("storageFn not found key [%x]", plainKey)
 	}
 	var ex Update
 	pos, err := ex.decode(exBytes, 0)
 	if err != nil {
-		ms.t.Fatalf("storageFn decode existing [%x], bytes: [%x]: %v", plainKey, exBytes, err)
-		return nil
+		return nil, fmt.Errorf("storageFn decode existing [%x], bytes: [%x]: %w", plainKey, exBytes, err)
 	}
 	if pos != len(exBytes) {
-		ms.t.Fatalf("storageFn key [%x] leftover bytes in [%x], comsumed %x", plainKey, exBytes, pos)
-		return nil
+		return nil, fmt.Errorf("storageFn key [%x] leftover bytes in [%x], comsumed %x", plainKey, exBytes, pos)
 	}
 	if ex.Flags&BALANCE_UPDATE != 0 {
-		ms.t.Fatalf("storageFn reading balance for key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("storageFn reading balance for key [%x]", plainKey)
 	}
 	if ex.Flags&NONCE_UPDATE != 0 {
-		ms.t.Fatalf("storageFn reading nonce for key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("storageFn reading nonce for key [%x]", plainKey)
 	}
 	if ex.Flags&CODE_UPDATE != 0 {
-		ms.t.Fatalf("storageFn reading codeHash for key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("storageFn reading codeHash for key [%x]", plainKey)
 	}
 	if ex.Flags&DELETE_UPDATE != 0 {
-		ms.t.Fatalf("storageFn reading deleted item for key [%x]", plainKey)
-		return nil
+		return nil, fmt.Errorf("storageFn reading deleted item for key [%x]", plainKey)
 	}
 	if ex.Flags&STORAGE_UPDATE != 0 {
 		copy(cell.Storage[:], ex.CodeHashOrStorage[:])
 	} else {
 		cell.Storage = [32]byte{}
 	}
-	return plainKey
+	return plainKey, nil
 }
 
 func (ms *MockState) applyPlainUpdates(plainKeys [][]byte, updates []Update) error {
 	for i, key := range plainKeys {
 		update := updates[i]
+		if update.Flags&CLEAR_STORAGE != 0 {
+			for k := range ms.sm {
+				if len(k) > len(key) && k[:len(key)] == string(key) {
+					delete(ms.sm, k)
+				}
+			}
+			if update.Flags &^= CLEAR_STORAGE; update.Flags == 0 {
+				continue
+			}
+		}
 		if update.Flags&DELETE_UPDATE != 0 {
 			delete(ms.sm, string(key))
 		} else {
@@ -210,6 +212,7 @@ type UpdateBuilder struct {
 	storages   map[string]map[string][]byte
 	deletes    map[string]struct{}
 	deletes2   map[string]map[string]struct{}
+	clears     map[string]struct{}
 	keyset     map[string]struct{}
 	keyset2    map[string]map[string]struct{}
 }
@@ -222,6 +225,7 @@ func NewUpdateBuilder() *UpdateBuilder {
 		storages:   make(map[string]map[string][]byte),
 		deletes:    make(map[string]struct{}),
 		deletes2:   make(map[string]map[string]struct{}),
+		clears:     make(map[string]struct{}),
 		keyset:     make(map[string]struct{}),
 		keyset2:    make(map[string]map[string]struct{}),
 	}
@@ -311,6 +315,17 @@ func (ub *UpdateBuilder) DeleteStorage(addr string, loc string) *UpdateBuilder {
 	return ub
 }
 
+// ClearStorage wipes storage of the account, storage items set after it are kept
+func (ub *UpdateBuilder) ClearStorage(addr string) *UpdateBuilder {
+	sk := string(decodeHex(addr))
+	delete(ub.storages, sk)
+	delete(ub.keyset2, sk)
+	delete(ub.deletes2, sk)
+	ub.clears[sk] = struct{}{}
+	ub.keyset[sk] = struct{}{}
+	return ub
+}
+
 // Build returns three slices (in the order sorted by the hashed keys)
 // 1. Plain keys
 // 2. Corresponding hashed keys
@@ -384,6 +399,9 @@ func (ub *UpdateBuilder) Build() (plainKeys, hashedKeys [][]byte, updates []Upda
 				u.Flags |= CODE_UPDATE
 				copy(u.CodeHashOrStorage[:], codeHash[:])
 			}
+			if _, ok := ub.clears[string(key)]; ok {
+				u.Flags |= CLEAR_STORAGE
+			}
 		} else {
 			if sm, ok1 := ub.storages[string(key)]; ok1 {
 				if storage, ok2 := sm[string(key2)]; ok2 {
@@ -481,3 +499,457 @@ func TestEmptyState(t *testing.T) {
 		fmt.Printf("%x => %s\n", CompactToHex([]byte(key)), branchToString(branchNodeUpdate))
 	}
 }
+
+func TestAccountOnlyMode(t *testing.T) {
+	// Code hashes are set explicitly, because MockState.accountFn does not default them to EmptyCodeHash
+	var codeHash [32]byte
+	copy(codeHash[:], EmptyCodeHash)
+	builder := func() *UpdateBuilder {
+		ub := NewUpdateBuilder()
+		for i, addr := range []string{"00", "01", "02", "03", "04"} {
+			ub.Balance(addr, uint64(4+i)).CodeHash(addr, codeHash)
+		}
+		return ub.Storage("03", "56", "050505")
+	}
+	// Full mode
+	ms := NewMockState(t)
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+	plainKeys, hashedKeys, updates := builder().Build()
+	if err := ms.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	branchNodeUpdates, err := hph.ProcessUpdates(plainKeys, hashedKeys, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.applyBranchNodeUpdates(branchNodeUpdates)
+	fullRoot, err := hph.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Storage root of account 03, which has single storage item
+	var storageKey [65]byte
+	if err = hashKey(hph.keccak, decodeHex("56"), storageKey[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	storageKey[64] = 16
+	storageRoot, err := hph.leafHashWithKeyVal(nil, storageKey[:], rlp.RlpSerializableBytes(nil), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageRoots := map[string][]byte{"03": common.Copy(storageRoot[1:])}
+	storageRootFn := func(accountPlainKey []byte) ([]byte, error) {
+		return storageRoots[fmt.Sprintf("%x", accountPlainKey)], nil
+	}
+	// Account-only mode must produce the same root with storage roots supplied by the callback
+	ms2 := NewMockState(t)
+	hph2 := NewHexPatriciaHashed(1, ms2.branchFn, ms2.accountFn, ms2.storageFn, ms2.lockFn, ms2.unlockFn)
+	hph2.SetStorageRootFn(storageRootFn)
+	if err = ms2.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	if branchNodeUpdates, err = hph2.ProcessUpdates(plainKeys, hashedKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	ms2.applyBranchNodeUpdates(branchNodeUpdates)
+	accountRoot, err := hph2.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fullRoot, accountRoot) {
+		t.Fatalf("account-only root %x, full root %x", accountRoot, fullRoot)
+	}
+	// Storage update only touches its account, the new storage root is taken from the callback
+	storageRoots["03"] = EmptyCodeHash
+	hph2.Reset()
+	plainKeys, hashedKeys, updates = NewUpdateBuilder().
+		Storage("03", "57", "060606").
+		Build()
+	if err = ms2.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	if branchNodeUpdates, err = hph2.ProcessUpdates(plainKeys, hashedKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	ms2.applyBranchNodeUpdates(branchNodeUpdates)
+	if accountRoot, err = hph2.RootHash(); err != nil {
+		t.Fatal(err)
+	}
+	// Reference is computed from scratch
+	ms3 := NewMockState(t)
+	hph3 := NewHexPatriciaHashed(1, ms3.branchFn, ms3.accountFn, ms3.storageFn, ms3.lockFn, ms3.unlockFn)
+	hph3.SetStorageRootFn(storageRootFn)
+	plainKeys, hashedKeys, updates = builder().Storage("03", "57", "060606").Build()
+	if err = ms3.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = hph3.ProcessUpdates(plainKeys, hashedKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	expectedRoot, err := hph3.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(expectedRoot, fullRoot) {
+		t.Fatalf("root did not change after storage root change")
+	}
+	if !bytes.Equal(expectedRoot, accountRoot) {
+		t.Fatalf("incremental account-only root %x, expected %x", accountRoot, expectedRoot)
+	}
+}
+
+func TestClearStorage(t *testing.T) {
+	// Code hashes are set explicitly, because MockState.accountFn does not default them to EmptyCodeHash
+	var codeHash [32]byte
+	copy(codeHash[:], EmptyCodeHash)
+	builder := func() *UpdateBuilder {
+		ub := NewUpdateBuilder()
+		for i, addr := range []string{"02", "03", "04"} {
+			ub.Balance(addr, uint64(4+i)).CodeHash(addr, codeHash)
+		}
+		return ub.Storage("04", "01", "0401")
+	}
+	ms := NewMockState(t)
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+	ub := builder()
+	for i := 0; i < 64; i++ {
+		ub.Storage("03", fmt.Sprintf("%02x", i), fmt.Sprintf("%02x%02x", i, i))
+	}
+	plainKeys, hashedKeys, updates := ub.Build()
+	if err := ms.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	branchNodeUpdates, err := hph.ProcessUpdates(plainKeys, hashedKeys, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.applyBranchNodeUpdates(branchNodeUpdates)
+	// Storage branch nodes of account 03 are the ones with keys longer than 64 nibbles under its hashed key
+	var accountKey [64]byte
+	if err = hashKey(hph.keccak, decodeHex("03"), accountKey[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	storageBranches := func() (present int) {
+		for key, branchData := range ms.cm {
+			hexKey := CompactToHex([]byte(key))
+			if len(hexKey) > 64 && bytes.HasPrefix(hexKey, accountKey[:]) && binary.BigEndian.Uint16(branchData[2:]) != 0 {
+				present++
+			}
+		}
+		return present
+	}
+	if storageBranches() < 2 {
+		t.Fatalf("expected nested storage branch nodes of account 03")
+	}
+	// Contract is re-created with single storage item
+	hph.Reset()
+	plainKeys, hashedKeys, updates = NewUpdateBuilder().
+		ClearStorage("03").
+		Nonce("03", 1).
+		Storage("03", "99", "0909").
+		Build()
+	if err = ms.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	if branchNodeUpdates, err = hph.ProcessUpdates(plainKeys, hashedKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	ms.applyBranchNodeUpdates(branchNodeUpdates)
+	root, err := hph.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if present := storageBranches(); present != 0 {
+		t.Fatalf("%d storage branch nodes of account 03 left after clearing", present)
+	}
+	// Reference is computed from scratch
+	ms2 := NewMockState(t)
+	hph2 := NewHexPatriciaHashed(1, ms2.branchFn, ms2.accountFn, ms2.storageFn, ms2.lockFn, ms2.unlockFn)
+	plainKeys, hashedKeys, updates = builder().Nonce("03", 1).Storage("03", "99", "0909").Build()
+	if err = ms2.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = hph2.ProcessUpdates(plainKeys, hashedKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	expectedRoot, err := hph2.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expectedRoot, root) {
+		t.Fatalf("root after clearing storage %x, expected %x", root, expectedRoot)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	// Code hashes are set explicitly, because MockState.accountFn does not default them to EmptyCodeHash
+	var codeHash [32]byte
+	copy(codeHash[:], EmptyCodeHash)
+	ms := NewMockState(t)
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+	verifier := NewVerifier(1, ms.branchFn, ms.accountFn, ms.storageFn)
+	ub := NewUpdateBuilder()
+	for i := 0; i < 16; i++ {
+		ub.Balance(fmt.Sprintf("%02x", i), uint64(i+1)).CodeHash(fmt.Sprintf("%02x", i), codeHash)
+	}
+	for i := 0; i < 32; i++ {
+		ub.Storage("03", fmt.Sprintf("%02x", i), fmt.Sprintf("%02x%02x", i, i))
+	}
+	ub.Storage("05", "01", "0501")
+	batches := []*UpdateBuilder{
+		ub,
+		NewUpdateBuilder().Balance("20", 7).CodeHash("20", codeHash).Storage("20", "01", "2001").Storage("20", "02", "2002"),
+		NewUpdateBuilder().Delete("04").DeleteStorage("03", "05").Storage("03", "40", "4040"),
+	}
+	for i, b := range batches {
+		hph.Reset()
+		plainKeys, hashedKeys, updates := b.Build()
+		if err := ms.applyPlainUpdates(plainKeys, updates); err != nil {
+			t.Fatal(err)
+		}
+		branchNodeUpdates, err := hph.ProcessUpdates(plainKeys, hashedKeys, updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms.applyBranchNodeUpdates(branchNodeUpdates)
+		expected, err := hph.RootHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots := make(chan []byte, 4)
+		errs := make(chan error, 4)
+		for j := 0; j < 4; j++ {
+			go func() {
+				root, err := verifier.RootHash()
+				roots <- root
+				errs <- err
+			}()
+		}
+		for j := 0; j < 4; j++ {
+			if err = <-errs; err != nil {
+				t.Fatalf("batch %d: %v", i, err)
+			}
+			if root := <-roots; !bytes.Equal(root, expected) {
+				t.Fatalf("batch %d: verifier root %x, expected %x", i, root, expected)
+			}
+		}
+	}
+	// Hashes are recomputed from the state, not taken from the branch nodes
+	plainKeys, _, updates := NewUpdateBuilder().Balance("07", 100).Build()
+	if err := ms.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := hph.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := verifier.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(root, expected) {
+		t.Fatalf("verifier did not notice the change of the state")
+	}
+}
+
+func TestProcessUpdatesWithReceipts(t *testing.T) {
+	// Code hashes are set explicitly, because MockState.accountFn does not default them to EmptyCodeHash
+	var codeHash [32]byte
+	copy(codeHash[:], EmptyCodeHash)
+	ms := NewMockState(t)
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+	// UpdateBuilder.Delete produces empty updates, so deleted keys are marked explicitly. The state is changed after
+	// processing, because MockState can't serve deleted keys referenced by the branch nodes
+	process := func(ub *UpdateBuilder, deleted ...string) map[string]UpdateReceipt {
+		hph.Reset()
+		plainKeys, hashedKeys, updates := ub.Build()
+		for i := range updates {
+			for _, k := range deleted {
+				if bytes.Equal(plainKeys[i], decodeHex(k)) {
+					updates[i].Flags = DELETE_UPDATE
+				}
+			}
+		}
+		branchNodeUpdates, receipts, err := hph.ProcessUpdatesWithReceipts(plainKeys, hashedKeys, updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ms.applyPlainUpdates(plainKeys, updates); err != nil {
+			t.Fatal(err)
+		}
+		ms.applyBranchNodeUpdates(branchNodeUpdates)
+		if len(receipts) != len(plainKeys) {
+			t.Fatalf("%d receipts for %d updates", len(receipts), len(plainKeys))
+		}
+		byKey := make(map[string]UpdateReceipt, len(receipts))
+		for i, r := range receipts {
+			if !bytes.Equal(r.PlainKey, plainKeys[i]) {
+				t.Fatalf("receipt %d of key %x, expected %x", i, r.PlainKey, plainKeys[i])
+			}
+			if (r.LeafHash != nil) != (r.Change == LeafCreated || r.Change == LeafUpdated) {
+				t.Fatalf("key %x %s, leaf hash [%x]", r.PlainKey, r.Change, r.LeafHash)
+			}
+			byKey[string(r.PlainKey)] = r
+		}
+		return byKey
+	}
+	key := func(hexKey string) string { return string(decodeHex(hexKey)) }
+
+	// single account - its leaf is the root
+	receipts := process(NewUpdateBuilder().Balance("01", 1).CodeHash("01", codeHash))
+	root, err := hph.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root) == 33 {
+		root = root[1:] // RootHash of single leaf trie comes with RLP prefix
+	}
+	if r := receipts[key("01")]; r.Change != LeafCreated || !bytes.Equal(r.LeafHash, root) {
+		t.Fatalf("single leaf %s [%x], root [%x]", r.Change, r.LeafHash, root)
+	}
+
+	ub := NewUpdateBuilder()
+	for i := 2; i < 16; i++ {
+		ub.Balance(fmt.Sprintf("%02x", i), uint64(i)).CodeHash(fmt.Sprintf("%02x", i), codeHash)
+	}
+	for i := 0; i < 8; i++ {
+		ub.Storage("03", fmt.Sprintf("%02x", i), fmt.Sprintf("%02x%02x", i, i))
+	}
+	receipts = process(ub)
+	for k, r := range receipts {
+		if r.Change != LeafCreated {
+			t.Fatalf("key %x %s, expected created", k, r.Change)
+		}
+	}
+	balanceHash := receipts[key("05")].LeafHash
+
+	receipts = process(NewUpdateBuilder().
+		Balance("05", 100).
+		Delete("04").
+		Delete("20").
+		Storage("03", "01", "0101").
+		Storage("03", "40", "4040").
+		DeleteStorage("03", "02"), "04", "20", "0302")
+	expected := map[string]LeafChange{
+		key("05"):   LeafUpdated,
+		key("04"):   LeafDeleted,
+		key("20"):   LeafUnchanged,
+		key("0301"): LeafUpdated,
+		key("0340"): LeafCreated,
+		key("0302"): LeafDeleted,
+	}
+	for k, change := range expected {
+		if r := receipts[k]; r.Change != change {
+			t.Fatalf("key %x %s, expected %s", k, r.Change, change)
+		}
+	}
+	if bytes.Equal(receipts[key("05")].LeafHash, balanceHash) {
+		t.Fatalf("leaf hash of updated account is not changed")
+	}
+}
+
+func TestStateFnErrors(t *testing.T) {
+	// Code hashes are set explicitly, because MockState.accountFn does not default them to EmptyCodeHash
+	var codeHash [32]byte
+	copy(codeHash[:], EmptyCodeHash)
+	ms := NewMockState(t)
+	ub := NewUpdateBuilder()
+	for i := 0; i < 16; i++ {
+		ub.Balance(fmt.Sprintf("%02x", i), uint64(i+1)).CodeHash(fmt.Sprintf("%02x", i), codeHash)
+	}
+	ub.Storage("03", "01", "0301").Storage("03", "02", "0302")
+	plainKeys, hashedKeys, updates := ub.Build()
+	if err := ms.applyPlainUpdates(plainKeys, updates); err != nil {
+		t.Fatal(err)
+	}
+	hph := NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+	branchNodeUpdates, err := hph.ProcessUpdates(plainKeys, hashedKeys, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.applyBranchNodeUpdates(branchNodeUpdates)
+
+	ioErr := errors.New("read failed")
+	branchFn := func(prefix []byte) ([]byte, error) { return nil, ioErr }
+	accountFn := func(plainKey []byte, cell *Cell) ([]byte, error) { return nil, ioErr }
+	storageFn := func(plainKey []byte, cell *Cell) ([]byte, error) { return nil, ioErr }
+	for name, failing := range map[string]*HexPatriciaHashed{
+		"branchFn":  NewHexPatriciaHashed(1, branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn),
+		"accountFn": NewHexPatriciaHashed(1, ms.branchFn, accountFn, ms.storageFn, ms.lockFn, ms.unlockFn),
+		"storageFn": NewHexPatriciaHashed(1, ms.branchFn, ms.accountFn, storageFn, ms.lockFn, ms.unlockFn),
+	} {
+		plainKeys, hashedKeys, updates := NewUpdateBuilder().Storage("03", "03", "0303").Build()
+		if _, err = failing.ProcessUpdates(plainKeys, hashedKeys, updates); !errors.Is(err, ioErr) {
+			t.Fatalf("%s: expected error of state reading, got %v", name, err)
+		}
+	}
+	for name, verifier := range map[string]*Verifier{
+		"branchFn":  NewVerifier(1, branchFn, ms.accountFn, ms.storageFn),
+		"accountFn": NewVerifier(1, ms.branchFn, accountFn, ms.storageFn),
+		"storageFn": NewVerifier(1, ms.branchFn, ms.accountFn, storageFn),
+	} {
+		if _, err = verifier.RootHash(); !errors.Is(err, ioErr) {
+			t.Fatalf("verifier %s: expected error of state reading, got %v", name, err)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	// Code hashes are set explicitly, because MockState.accountFn does not default them to EmptyCodeHash
+	var codeHash [32]byte
+	copy(codeHash[:], EmptyCodeHash)
+	ms := NewMockState(t)
+	pool := NewPool(1, 1)
+	process := func(ub *UpdateBuilder) []byte {
+		hph := pool.Get(ms.branchFn, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+		defer pool.Put(hph)
+		plainKeys, hashedKeys, updates := ub.Build()
+		if err := ms.applyPlainUpdates(plainKeys, updates); err != nil {
+			t.Fatal(err)
+		}
+		branchNodeUpdates, err := hph.ProcessUpdates(plainKeys, hashedKeys, updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms.applyBranchNodeUpdates(branchNodeUpdates)
+		root, err := hph.RootHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	ub := NewUpdateBuilder()
+	for i := 0; i < 16; i++ {
+		ub.Balance(fmt.Sprintf("%02x", i), uint64(i+1)).CodeHash(fmt.Sprintf("%02x", i), codeHash)
+	}
+	ub.Storage("03", "01", "0301").Storage("03", "02", "0302")
+	process(ub)
+	if pool.Idle() != 1 {
+		t.Fatalf("expected 1 idle instance, got %d", pool.Idle())
+	}
+	// failed processing leaves instance in the middle of the grid, it must be cleared by Put
+	failing := pool.Get(func(prefix []byte) ([]byte, error) {
+		if len(prefix) > 1 {
+			return nil, fmt.Errorf("read failed")
+		}
+		return ms.branchFn(prefix)
+	}, ms.accountFn, ms.storageFn, ms.lockFn, ms.unlockFn)
+	plainKeys, hashedKeys, updates := NewUpdateBuilder().Storage("03", "03", "0303").Build()
+	if _, err := failing.ProcessUpdates(plainKeys, hashedKeys, updates); err == nil {
+		t.Fatal("expected error")
+	}
+	pool.Put(failing)
+	pool.Put(NewHexPatriciaHashed(1, nil, nil, nil, nil, nil)) // pool is full, dropped
+	if pool.Idle() != 1 {
+		t.Fatalf("expected 1 idle instance, got %d", pool.Idle())
+	}
+
+	root := process(NewUpdateBuilder().Balance("20", 7).CodeHash("20", codeHash).Storage("03", "03", "0303"))
+	verified, err := NewVerifier(1, ms.branchFn, ms.accountFn, ms.storageFn).WithPool(pool).RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, verified) {
+		t.Fatalf("root of pooled instance %x, verified %x", root, verified)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package commitment
+
+// Pool - bounded set of warm HexPatriciaHashed instances. Every instance owns large grid (~1MB) and keccak states,
+// so code which keeps several tries at once (for example, sharded commitment) or creates them often (Verifier)
+// reuses them instead of allocating new ones. Safe for concurrent use
+type Pool struct {
+	accountKeyLen int
+	free          chan *HexPatriciaHashed
+}
+
+// NewPool - keeps at most size idle instances, Get doesn't wait when pool is empty: it creates new instance
+func NewPool(accountKeyLen, size int) *Pool {
+	return &Pool{accountKeyLen: accountKeyLen, free: make(chan *HexPatriciaHashed, size)}
+}
+
+// Get - idle instance with given functions, or new one. Instance must be returned by Put after use
+func (p *Pool) Get(
+	branchFn func(prefix []byte) ([]byte, error),
+	accountFn func(plainKey []byte, cell *Cell) ([]byte, error),
+	storageFn func(plainKey []byte, cell *Cell) ([]byte, error),
+	lockFn func(),
+	unlockFn func(),
+) *HexPatriciaHashed {
+	select {
+	case hph := <-p.free:
+		hph.ResetFns(branchFn, accountFn, storageFn, lockFn, unlockFn)
+		return hph
+	default:
+		return NewHexPatriciaHashed(p.accountKeyLen, branchFn, accountFn, storageFn, lockFn, unlockFn)
+	}
+}
+
+// Put - prepares instance for reuse and keeps it, if pool has space. Instance must not be used after Put
+func (p *Pool) Put(hph *HexPatriciaHashed) {
+	if hph.accountKeyLen != p.accountKeyLen {
+		return
+	}
+	hph.clear()
+	select {
+	case p.free <- hph:
+	default:
+	}
+}
+
+// Idle - amount of instances waiting in pool
+func (p *Pool) Idle() int { return len(p.free) }
@@ -0,0 +1,130 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package commitment
+
+import (
+	"bytes"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+)
+
+// LeafChange - what happened to the leaf of plain key during ProcessUpdates
+type LeafChange uint8
+
+const (
+	LeafUnchanged LeafChange = iota // deletion of absent key, or storage update in account-only mode (no storage leaves)
+	LeafCreated
+	LeafUpdated
+	LeafDeleted
+)
+
+func (c LeafChange) String() string {
+	switch c {
+	case LeafCreated:
+		return "created"
+	case LeafUpdated:
+		return "updated"
+	case LeafDeleted:
+		return "deleted"
+	default:
+		return "unchanged"
+	}
+}
+
+// UpdateReceipt - outcome of single update, to maintain external indexes (for example flat state snapshots)
+// without re-deriving them from branch nodes
+type UpdateReceipt struct {
+	PlainKey []byte // not copied, refers to plainKeys passed to ProcessUpdatesWithReceipts
+	Change   LeafChange
+	// LeafHash - reference to the leaf node from its parent: keccak of the node, or the node itself
+	// if its RLP is shorter than 32 bytes. Nil for deleted and unchanged leaves
+	LeafHash []byte
+}
+
+// ProcessUpdatesWithReceipts - same as ProcessUpdates, and also returns receipt of every update, in order of updates
+func (hph *HexPatriciaHashed) ProcessUpdatesWithReceipts(plainKeys, hashedKeys [][]byte, updates []Update) (map[string][]byte, []UpdateReceipt, error) {
+	hph.receipts = make([]UpdateReceipt, len(plainKeys))
+	hph.receiptIdx = make(map[string]int, len(plainKeys))
+	defer func() { hph.receipts, hph.receiptIdx = nil, nil }()
+	branchNodeUpdates, err := hph.ProcessUpdates(plainKeys, hashedKeys, updates)
+	if err != nil {
+		return nil, nil, err
+	}
+	// leaf in the root (single leaf trie) is hashed only by RootHash
+	if _, err = hph.RootHash(); err != nil {
+		return nil, nil, err
+	}
+	return branchNodeUpdates, hph.receipts, nil
+}
+
+// leafChange - classifies update of plainKey, must be called after unfolding to hashedKey and before applying the update
+func (hph *HexPatriciaHashed) leafChange(plainKey, hashedKey []byte, update *Update, storageOnly bool) LeafChange {
+	if storageOnly {
+		return LeafUnchanged
+	}
+	exists := hph.leafExists(plainKey, hashedKey)
+	switch {
+	case update.Flags&^CLEAR_STORAGE == DELETE_UPDATE && exists:
+		return LeafDeleted
+	case update.Flags&^CLEAR_STORAGE == DELETE_UPDATE:
+		return LeafUnchanged
+	case update.Flags&^CLEAR_STORAGE == 0 && (update.Flags == 0 || !exists):
+		return LeafUnchanged // empty update, or clearing storage of absent account
+	case exists:
+		return LeafUpdated
+	default:
+		return LeafCreated
+	}
+}
+
+// leafExists - whether cell at hashedKey is the leaf of plainKey
+func (hph *HexPatriciaHashed) leafExists(plainKey, hashedKey []byte) bool {
+	var cell *Cell
+	if hph.activeRows == 0 {
+		if !hph.rootPresent {
+			return false
+		}
+		cell = &hph.root
+	} else {
+		row := hph.activeRows - 1
+		col := int(hashedKey[hph.currentKeyLen])
+		if hph.afterMap[row]&(uint16(1)<<col) == 0 {
+			return false
+		}
+		cell = &hph.grid[row][col]
+	}
+	if len(plainKey) > hph.accountKeyLen {
+		return bytes.Equal(cell.spk[:cell.spl], plainKey)
+	}
+	return bytes.Equal(cell.apk[:cell.apl], plainKey)
+}
+
+// receiptLeafHash - remembers computed hash of the leaf of plainKey, if it was created or updated by the batch
+func (hph *HexPatriciaHashed) receiptLeafHash(plainKey []byte, leafHash []byte) {
+	if hph.receiptIdx == nil {
+		return
+	}
+	i, ok := hph.receiptIdx[string(plainKey)]
+	if !ok {
+		return
+	}
+	if len(leafHash) == length.Hash+1 && leafHash[0] == 0x80+length.Hash {
+		leafHash = leafHash[1:]
+	}
+	hph.receipts[i].LeafHash = common.Copy(leafHash)
+}
@@ -0,0 +1,183 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package commitment
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/ledgerwatch/erigon-lib/rlp"
+)
+
+// Verifier recomputes root hash from the persisted branch nodes and the plain state, without trusting hashes
+// stored in the branch nodes and without touching any HexPatriciaHashed used for writing.
+// It keeps no state between calls, so RootHash can be called concurrently, as long as the functions are safe for that
+type Verifier struct {
+	accountKeyLen int
+	branchFn      func(prefix []byte) ([]byte, error)
+	accountFn     func(plainKey []byte, cell *Cell) ([]byte, error)
+	storageFn     func(plainKey []byte, cell *Cell) ([]byte, error)
+	pool          *Pool // nil - every RootHash allocates own hasher
+}
+
+func NewVerifier(accountKeyLen int,
+	branchFn func(prefix []byte) ([]byte, error),
+	accountFn func(plainKey []byte, cell *Cell) ([]byte, error),
+	storageFn func(plainKey []byte, cell *Cell) ([]byte, error),
+) *Verifier {
+	return &Verifier{accountKeyLen: accountKeyLen, branchFn: branchFn, accountFn: accountFn, storageFn: storageFn}
+}
+
+// WithPool - RootHash takes hasher from the pool instead of allocating new one.
+// Pool of another accountKeyLen is ignored
+func (v *Verifier) WithPool(pool *Pool) *Verifier {
+	if pool != nil && pool.accountKeyLen == v.accountKeyLen {
+		v.pool = pool
+	}
+	return v
+}
+
+// RootHash - same as HexPatriciaHashed.RootHash after ProcessUpdates has produced the persisted branch nodes
+func (v *Verifier) RootHash() ([]byte, error) {
+	// Used only as a hasher, local to this call
+	var hph *HexPatriciaHashed
+	if v.pool != nil {
+		hph = v.pool.Get(v.branchFn, v.accountFn, v.storageFn, func() {}, func() {})
+		defer v.pool.Put(hph)
+	} else {
+		hph = NewHexPatriciaHashed(v.accountKeyLen, v.branchFn, v.accountFn, v.storageFn, func() {}, func() {})
+	}
+	var root Cell
+	root.fillEmpty()
+	branchData, err := v.branchFn(hexToCompact([]byte{}))
+	if err != nil {
+		return nil, fmt.Errorf("branchFn root: %w", err)
+	}
+	if len(branchData) > 0 {
+		if bitmap := binary.BigEndian.Uint16(branchData[0:]); bits.OnesCount16(bitmap) > 1 {
+			h, err := v.branchHash(hph, nil)
+			if err != nil {
+				return nil, err
+			}
+			return h, nil
+		}
+		// Root is a single leaf or extension, persisted by foldRoot
+		if _, err := root.fillFromFields(branchData, 3, PartFlags(branchData[2])); err != nil {
+			return nil, fmt.Errorf("root branchData[%x]: %w", branchData, err)
+		}
+		if err := v.resolve(hph, &root, nil); err != nil {
+			return nil, err
+		}
+		if root.hl > 0 {
+			return root.h[:root.hl], nil
+		}
+	}
+	return hph.computeCellHash(&root, 0, nil)
+}
+
+// resolve - loads leaf values from the state and recomputes hashes of the branch nodes below the cell,
+// path is the hashed key of the cell, including its own nibble
+func (v *Verifier) resolve(hph *HexPatriciaHashed, cell *Cell, path []byte) error {
+	if cell.apl > 0 {
+		k, err := v.accountFn(cell.apk[:cell.apl], cell)
+		if err != nil {
+			return fmt.Errorf("accountFn [%x]: %w", cell.apk[:cell.apl], err)
+		}
+		cell.apl = copy(cell.apk[:], k)
+	}
+	if cell.spl > 0 {
+		k, err := v.storageFn(cell.spk[:cell.spl], cell)
+		if err != nil {
+			return fmt.Errorf("storageFn [%x]: %w", cell.spk[:cell.spl], err)
+		}
+		cell.spl = copy(cell.spk[:], k)
+		return nil
+	}
+	if cell.hl == 0 {
+		return nil
+	}
+	var childPath []byte
+	if cell.apl > 0 {
+		// Storage root of the account is a branch node
+		var accountKey [64]byte
+		if err := hashKey(hph.keccak, cell.apk[:cell.apl], accountKey[:], 0); err != nil {
+			return err
+		}
+		childPath = append(childPath, accountKey[:]...)
+	} else {
+		childPath = append(childPath, path...)
+	}
+	childPath = append(childPath, cell.extension[:cell.extLen]...)
+	h, err := v.branchHash(hph, childPath)
+	if err != nil {
+		return err
+	}
+	cell.hl = copy(cell.h[:], h)
+	return nil
+}
+
+// branchHash - hash of the branch node persisted under given hashed key prefix, computed the same way as in fold
+func (v *Verifier) branchHash(hph *HexPatriciaHashed, prefix []byte) ([]byte, error) {
+	branchData, err := v.branchFn(hexToCompact(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("branchFn [%x]: %w", prefix, err)
+	}
+	if len(branchData) < 2 {
+		return nil, fmt.Errorf("branch node [%x] not found", prefix)
+	}
+	bitmap := binary.BigEndian.Uint16(branchData[0:])
+	if bits.OnesCount16(bitmap) < 2 {
+		return nil, fmt.Errorf("branch node [%x] has less than 2 children: %016b", prefix, bitmap)
+	}
+	depth := len(prefix) + 1
+	var cells [16]Cell
+	pos := 2
+	var refsLen int
+	for bitset := bitmap; bitset != 0; {
+		bit := bitset & -bitset
+		nibble := bits.TrailingZeros16(bit)
+		cell := &cells[nibble]
+		cell.fillEmpty()
+		fieldBits := branchData[pos]
+		pos++
+		var err error
+		if pos, err = cell.fillFromFields(branchData, pos, PartFlags(fieldBits)); err != nil {
+			return nil, fmt.Errorf("prefix [%x], branchData[%x]: %w", prefix, branchData, err)
+		}
+		path := make([]byte, depth)
+		copy(path, prefix)
+		path[depth-1] = byte(nibble)
+		if err = v.resolve(hph, cell, path); err != nil {
+			return nil, err
+		}
+		refsLen += hph.computeCellHashLen(cell, depth)
+		bitset ^= bit
+	}
+	hph.keccak2.Reset()
+	var cellHashBuf [33]byte
+	if err := rlp.BranchHash(hph.keccak2, bitmap, refsLen, func(nibble int) ([]byte, error) {
+		return hph.computeCellHash(&cells[nibble], depth, cellHashBuf[:0])
+	}); err != nil {
+		return nil, err
+	}
+	var h [32]byte
+	if _, err := hph.keccak2.Read(h[:]); err != nil {
+		return nil, err
+	}
+	return h[:], nil
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+// Arena - chunked allocator of small byte slices (hashes, keys) on hot paths: slices are carved from
+// chunks of chunkSize bytes, so there is one allocation per chunk instead of one per slice.
+// Allocated slices have cap == len, appending to them never overwrites neighbours.
+// Full chunks are not referenced by the Arena - they are freed by GC when all their slices are released,
+// so slices may be handed over to other goroutines as long as Reset is not called. Not thread-safe
+type Arena struct {
+	chunkSize int
+	chunk     []byte // current chunk, free space is chunk[off:]
+	off       int
+}
+
+func NewArena(chunkSize int) *Arena {
+	return &Arena{chunkSize: chunkSize}
+}
+
+// Alloc - slice of n bytes, zeroed unless memory is reused after Reset. Slices larger than chunk are allocated separately
+func (a *Arena) Alloc(n int) []byte {
+	if n > a.chunkSize {
+		return make([]byte, n)
+	}
+	if a.chunk == nil || a.off+n > len(a.chunk) {
+		a.chunk, a.off = make([]byte, a.chunkSize), 0
+	}
+	b := a.chunk[a.off : a.off+n : a.off+n]
+	a.off += n
+	return b
+}
+
+// Copy - like common.Copy, but allocates from the arena
+func (a *Arena) Copy(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := a.Alloc(len(b))
+	copy(c, b)
+	return c
+}
+
+// Reset - next allocations reuse current chunk from the beginning, slices allocated from it must not be used anymore
+func (a *Arena) Reset() {
+	a.off = 0
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	a := NewArena(64)
+	h1 := a.Copy(bytes.Repeat([]byte{1}, 32))
+	h2 := a.Copy(bytes.Repeat([]byte{2}, 32))
+	if cap(h1) != 32 || !bytes.Equal(h1, bytes.Repeat([]byte{1}, 32)) {
+		t.Fatalf("unexpected first slice %x, cap %d", h1, cap(h1))
+	}
+	_ = append(h1, 9) // must not overwrite h2
+	if !bytes.Equal(h2, bytes.Repeat([]byte{2}, 32)) {
+		t.Fatalf("neighbour is overwritten: %x", h2)
+	}
+
+	// chunk is full - slices of the new one don't share memory with the old one
+	h3 := a.Copy(bytes.Repeat([]byte{3}, 32))
+	if !bytes.Equal(h1, bytes.Repeat([]byte{1}, 32)) || !bytes.Equal(h3, bytes.Repeat([]byte{3}, 32)) {
+		t.Fatalf("unexpected slices %x %x", h1, h3)
+	}
+	if big := a.Alloc(100); len(big) != 100 {
+		t.Fatalf("unexpected len %d", len(big))
+	}
+
+	// memory of the current chunk is reused after reset
+	a.Reset()
+	h4 := a.Copy(bytes.Repeat([]byte{4}, 32))
+	if &h4[0] != &h3[0] {
+		t.Fatal("current chunk is not reused after reset")
+	}
+	if a.Copy(nil) != nil {
+		t.Fatal("copy of nil must be nil")
+	}
+}
+
+var arenaSink []byte // keeps copies escaping to heap, like in real code
+
+func BenchmarkCopyHashes(b *testing.B) {
+	hash := make([]byte, 32)
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1024; j++ {
+				hash[0] = byte(j)
+				arenaSink = Copy(hash)
+			}
+		}
+	})
+	b.Run("arena", func(b *testing.B) {
+		b.ReportAllocs()
+		a := NewArena(1024 * 32)
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1024; j++ {
+				hash[0] = byte(j)
+				arenaSink = a.Copy(hash)
+			}
+		}
+	})
+	b.Run("arena_reset", func(b *testing.B) {
+		b.ReportAllocs()
+		a := NewArena(1024 * 32)
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1024; j++ {
+				hash[0] = byte(j)
+				arenaSink = a.Copy(hash)
+			}
+			a.Reset()
+		}
+	})
+}
@@ -120,7 +120,10 @@ const (
 	ElasticityMultiplier     = 2          // Bounds the maximum gas limit an EIP-1559 block may have.
 	InitialBaseFee           = 1000000000 // Initial base fee for EIP-1559 blocks.
 
-	MaxCodeSize = 24576 // Maximum bytecode to permit for a contract
+	MaxCodeSize     = 24576           // Maximum bytecode to permit for a contract
+	MaxInitCodeSize = 2 * MaxCodeSize // Maximum initcode to permit in a creation transaction and create instructions (EIP-3860)
+
+	InitCodeWordGas uint64 = 2 // Once per word of the init code when creating a contract (EIP-3860)
 
 	// Precompiled contract gas prices
 
@@ -155,3 +158,12 @@ const (
 	RefundQuotient        uint64 = 2
 	RefundQuotientEIP3529 uint64 = 5
 )
+
+// CalcInitcodeGas - gas for initcode of contract-creation transaction of given length (EIP-3860)
+func CalcInitcodeGas(initcodeLen uint64) uint64 {
+	words := initcodeLen / 32
+	if initcodeLen%32 != 0 {
+		words++
+	}
+	return InitCodeWordGas * words
+}
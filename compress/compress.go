@@ -36,6 +36,8 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/patricia"
+	"github.com/ledgerwatch/log/v3"
+	atomic2 "go.uber.org/atomic"
 )
 
 const ASSERT = false
@@ -64,9 +66,59 @@ type Compressor struct {
 	logPrefix string
 	Ratio     CompressionRatio
 	trace     bool
+
+	superstringsSent uint64
+	superstringsDone *atomic2.Uint64
+
+	// resumable Compressor keeps its intermediate files (.idt and .dict) on Close, unless Compress succeeded
+	resumable  bool
+	compressed bool
+	dictPath   string             // checkpoint of built dictionary, see PersistDictionaryCheckpoint
+	checkpoint *DictionaryBuilder // dictionary loaded by ResumeCompressor, built for all words of uncompressedFile
+
+	progress      func(Progress)
+	progressEvery time.Duration
+	lastProgress  time.Time
+	started       time.Time
+	phase         CompressPhase
 }
 
 func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, minPatternScore uint64, workers int) (*Compressor, error) {
+	return newCompressor(ctx, logPrefix, outputFile, tmpDir, minPatternScore, workers, false)
+}
+
+// ResumeCompressor - same as NewCompressor, but continues from intermediate files left by resumable Compressor
+// (see SetResumable) with the same outputFile and tmpDir. Words which were already added are kept,
+// and dictionary is not built again if its checkpoint matches these words. If there are no intermediate files,
+// it starts from scratch. Returned Compressor is resumable
+func ResumeCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, minPatternScore uint64, workers int) (*Compressor, error) {
+	c, err := newCompressor(ctx, logPrefix, outputFile, tmpDir, minPatternScore, workers, true)
+	if err != nil {
+		return nil, err
+	}
+	c.resumable = true
+	c.wordsCount = c.uncompressedFile.count
+	if c.wordsCount == 0 {
+		return c, nil
+	}
+	wordsCount, db, err := ReadDictionaryCheckpoint(c.dictPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn(fmt.Sprintf("[%s] dictionary checkpoint is ignored", logPrefix), "file", c.dictPath, "err", err)
+	}
+	if err == nil && wordsCount == c.wordsCount {
+		c.checkpoint = db
+		log.Info(fmt.Sprintf("[%s] resuming with built dictionary", logPrefix), "words", c.wordsCount, "patterns", db.Len())
+		return c, nil
+	}
+	log.Info(fmt.Sprintf("[%s] resuming", logPrefix), "words", c.wordsCount)
+	if err = c.feedSuperstrings(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, minPatternScore uint64, workers int, resume bool) (*Compressor, error) {
 	dir, fileName := filepath.Split(outputFile)
 	tmpOutFilePath := filepath.Join(dir, fileName) + ".tmp"
 	ext := filepath.Ext(fileName)
@@ -74,15 +126,23 @@ func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, mi
 	// tmpOutFilePath - it's ".seg.tmp" ("dat.tmp") file which will be renamed to .seg file if everything succeed.
 	// It allow atomically create .seg file (downloader will not see partially ready/ non-ready .seg files).
 	// I didn't create ".seg.tmp" file in tmpDir, because I think tmpDir and snapsthoDir may be mounted to different drives
-	uncompressedPath := filepath.Join(tmpDir, fileName[:len(fileName)-len(ext)]) + ".idt"
+	basePath := filepath.Join(tmpDir, fileName[:len(fileName)-len(ext)])
+	uncompressedPath := basePath + ".idt"
 
-	uncompressedFile, err := NewUncompressedFile(uncompressedPath)
+	var uncompressedFile *DecompressedFile
+	var err error
+	if resume {
+		uncompressedFile, err = OpenUncompressedFile(uncompressedPath)
+	} else {
+		uncompressedFile, err = NewUncompressedFile(uncompressedPath)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Collector for dictionary superstrings (sorted by their score)
 	superstrings := make(chan []byte, workers*2)
+	superstringsDone := atomic2.NewUint64(0)
 	wg := &sync.WaitGroup{}
 	wg.Add(workers)
 	suffixCollectors := make([]*etl.Collector, workers)
@@ -90,7 +150,7 @@ func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, mi
 		//nolint
 		collector := etl.NewCollector(compressLogPrefix, tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
 		suffixCollectors[i] = collector
-		go processSuperstring(superstrings, collector, minPatternScore, wg)
+		go processSuperstring(ctx, superstrings, collector, minPatternScore, wg, superstringsDone)
 	}
 
 	return &Compressor{
@@ -102,13 +162,21 @@ func NewCompressor(ctx context.Context, logPrefix, outputFile, tmpDir string, mi
 		workers:          workers,
 		ctx:              ctx,
 		superstrings:     superstrings,
+		superstringsDone: superstringsDone,
 		suffixCollectors: suffixCollectors,
 		wg:               wg,
+		dictPath:         basePath + ".dict",
+		progressEvery:    DefaultProgressEvery,
 	}, nil
 }
 
 func (c *Compressor) Close() {
-	c.uncompressedFile.Close()
+	if c.resumable && !c.compressed {
+		c.uncompressedFile.closeKeep()
+	} else {
+		c.uncompressedFile.Close()
+		os.Remove(c.dictPath)
+	}
 	for _, collector := range c.suffixCollectors {
 		collector.Close()
 	}
@@ -119,34 +187,74 @@ func (c *Compressor) SetTrace(trace bool) {
 	c.trace = trace
 }
 
+// SetResumable - if set, intermediate files are kept when Compressor is closed without successful Compress
+// (for example, after ctx was cancelled), so that compression can be continued by ResumeCompressor
+func (c *Compressor) SetResumable(resumable bool) {
+	c.resumable = resumable
+}
+
 func (c *Compressor) AddWord(word []byte) error {
+	if c.checkpoint != nil {
+		// Checkpoint doesn't cover new word, and superstrings of previous words need to be processed now
+		c.checkpoint = nil
+		if err := c.feedSuperstrings(); err != nil {
+			return err
+		}
+	}
 	c.wordsCount++
+	if err := c.addSuperstring(word); err != nil {
+		return err
+	}
+	return c.uncompressedFile.Append(word)
+}
 
+func (c *Compressor) addSuperstring(word []byte) error {
 	if len(c.superstring)+2*len(word)+2 > superstringLimit {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
 		c.superstrings <- c.superstring
+		c.superstringsSent++
 		c.superstring = nil
 	}
 	for _, a := range word {
 		c.superstring = append(c.superstring, 1, a)
 	}
 	c.superstring = append(c.superstring, 0, 0)
+	return nil
+}
 
-	return c.uncompressedFile.Append(word)
+// feedSuperstrings - sends all words which are already in uncompressedFile to suffix array workers
+func (c *Compressor) feedSuperstrings() error {
+	if err := c.uncompressedFile.w.Flush(); err != nil {
+		return err
+	}
+	if err := c.uncompressedFile.ForEach(c.addSuperstring); err != nil {
+		return err
+	}
+	if _, err := c.uncompressedFile.f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	return nil
 }
 
 func (c *Compressor) Compress() error {
-	c.uncompressedFile.w.Flush()
-	logEvery := time.NewTicker(20 * time.Second)
-	defer logEvery.Stop()
+	c.started = time.Now()
+	if err := c.uncompressedFile.w.Flush(); err != nil {
+		return err
+	}
 	if len(c.superstring) > 0 {
 		c.superstrings <- c.superstring
+		c.superstringsSent++
+		c.superstring = nil
 	}
 	close(c.superstrings)
-	c.wg.Wait()
+	if err := c.waitSuperstrings(); err != nil {
+		return err
+	}
 
-	db, err := DictionaryBuilderFromCollectors(c.ctx, compressLogPrefix, c.tmpDir, c.suffixCollectors)
+	db, err := c.buildDictionary()
 	if err != nil {
-
 		return err
 	}
 	if c.trace {
@@ -157,7 +265,10 @@ func (c *Compressor) Compress() error {
 	}
 
 	defer os.Remove(c.tmpOutFilePath)
-	if err := reducedict(c.trace, c.logPrefix, c.tmpOutFilePath, c.tmpDir, c.uncompressedFile, c.workers, db); err != nil {
+	if err := reducedict(c.ctx, c.trace, c.logPrefix, c.tmpOutFilePath, c.tmpDir, c.uncompressedFile, c.workers, db, c.onProgress); err != nil {
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
 		return err
 	}
 
@@ -168,10 +279,56 @@ func (c *Compressor) Compress() error {
 	if err != nil {
 		return fmt.Errorf("ratio: %w", err)
 	}
-
+	c.compressed = true
+	c.onProgress(PhaseDone, 0, 0)
 	return nil
 }
 
+// waitSuperstrings - waits for suffix array workers, reporting progress. Workers skip the rest of
+// superstrings when ctx is cancelled, so it doesn't take long to return ctx error
+func (c *Compressor) waitSuperstrings() error {
+	c.onProgress(PhaseSuperstrings, c.superstringsDone.Load(), c.superstringsSent)
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	progressEvery := time.NewTicker(c.progressEvery)
+	defer progressEvery.Stop()
+	for {
+		select {
+		case <-done:
+			return c.ctx.Err()
+		case <-progressEvery.C:
+			c.onProgress(PhaseSuperstrings, c.superstringsDone.Load(), c.superstringsSent)
+		}
+	}
+}
+
+// buildDictionary - builds dictionary from suffix collectors, or takes the one loaded from checkpoint.
+// Resumable Compressor persists built dictionary, so it doesn't need to be built again
+func (c *Compressor) buildDictionary() (*DictionaryBuilder, error) {
+	c.onProgress(PhaseDictionary, 0, 0)
+	if c.checkpoint != nil {
+		db := c.checkpoint
+		c.checkpoint = nil
+		return db, nil
+	}
+	db, err := DictionaryBuilderFromCollectors(c.ctx, compressLogPrefix, c.tmpDir, c.suffixCollectors)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return nil, c.ctx.Err()
+		}
+		return nil, err
+	}
+	if c.resumable {
+		if err := PersistDictionaryCheckpoint(c.dictPath, c.wordsCount, db); err != nil {
+			return nil, fmt.Errorf("dictionary checkpoint: %w", err)
+		}
+	}
+	return db, nil
+}
+
 type CompressorSequential struct {
 	outputFile      string // File where to output the dictionary and compressed data
 	tmpDir          string // temporary directory to use for ETL when building dictionary
@@ -1388,6 +1545,45 @@ func NewUncompressedFile(filePath string) (*DecompressedFile, error) {
 	w := bufio.NewWriterSize(f, etl.BufIOSize)
 	return &DecompressedFile{filePath: filePath, f: f, w: w, buf: make([]byte, 128)}, nil
 }
+
+// OpenUncompressedFile - opens existing file to append more words to it (creates it if there is no file).
+// Incomplete last word (left by interrupted write) is truncated
+func OpenUncompressedFile(filePath string) (*DecompressedFile, error) {
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(f, etl.BufIOSize)
+	numBuf := make([]byte, binary.MaxVarintLen64)
+	var count, size uint64
+	for {
+		l, e := binary.ReadUvarint(r)
+		if e != nil {
+			break
+		}
+		if _, e = r.Discard(int(l)); e != nil {
+			break
+		}
+		count++
+		size += uint64(binary.PutUvarint(numBuf, l)) + l
+	}
+	if err = f.Truncate(int64(size)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(int64(size), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w := bufio.NewWriterSize(f, etl.BufIOSize)
+	return &DecompressedFile{filePath: filePath, f: f, w: w, count: count, buf: make([]byte, 128)}, nil
+}
+
+// closeKeep - closes the file without removing it
+func (f *DecompressedFile) closeKeep() {
+	f.w.Flush()
+	f.f.Close()
+}
 func (f *DecompressedFile) Close() {
 	f.w.Flush()
 	//f.f.Sync()
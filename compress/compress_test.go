@@ -18,6 +18,7 @@ package compress
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -117,3 +118,79 @@ func TestCompressDict1(t *testing.T) {
 		t.Errorf("result file hash changed")
 	}
 }
+
+func TestCompressResume(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "compressed")
+	addWords := func(c *Compressor, from, to int) {
+		for i := from; i < to; i++ {
+			if err := c.AddWord([]byte(fmt.Sprintf("longlongword %d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Cancelled before dictionary is built - words are kept
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := NewCompressor(ctx, t.Name(), file, tmpDir, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetResumable(true)
+	addWords(c, 0, 50)
+	cancel()
+	if err = c.Compress(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	c.Close()
+
+	// Cancelled after dictionary is built - dictionary is kept too
+	ctx, cancel = context.WithCancel(context.Background())
+	if c, err = ResumeCompressor(ctx, t.Name(), file, tmpDir, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	addWords(c, 50, 100)
+	var phases []CompressPhase
+	c.SetProgress(func(p Progress) {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+		if p.Phase == PhaseReduce {
+			cancel()
+		}
+	}, 0)
+	if err = c.Compress(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	c.Close()
+	if fmt.Sprint(phases) != fmt.Sprint([]CompressPhase{PhaseSuperstrings, PhaseDictionary, PhaseReduce}) {
+		t.Fatalf("unexpected phases %v", phases)
+	}
+
+	if c, err = ResumeCompressor(context.Background(), t.Name(), file, tmpDir, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if c.checkpoint == nil {
+		t.Fatalf("expected dictionary checkpoint")
+	}
+	phases = nil
+	c.SetProgress(func(p Progress) {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+	}, 0)
+	if err = c.Compress(); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if phases[len(phases)-1] != PhaseDone {
+		t.Fatalf("unexpected phases %v", phases)
+	}
+	if _, err = os.Stat(c.dictPath); !os.IsNotExist(err) {
+		t.Fatalf("expected checkpoint to be removed, got %v", err)
+	}
+	// Same result as compression without interruptions, see TestCompressDict1
+	if checksum(file) != 1949470243 {
+		t.Errorf("result file hash changed")
+	}
+}
@@ -17,6 +17,9 @@
 package compress
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 )
 
@@ -67,3 +70,41 @@ func BenchmarkDecompressMatchPrefix(b *testing.B) {
 		_ = g.MatchPrefix([]byte("longlongword"))
 	}
 }
+
+// BenchmarkDecompressSharedGetter - concurrent readers sharing one getter, for comparison with BenchmarkDecompressReaderPool
+func BenchmarkDecompressSharedGetter(b *testing.B) {
+	t := new(testing.T)
+	d := prepareDict(t)
+	defer d.Close()
+	offsets := wordOffsets(d)
+	g := d.MakeGetter()
+	var lock sync.Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		var buf []byte
+		for i := 0; pb.Next(); i++ {
+			lock.Lock()
+			g.Reset(offsets[i%len(offsets)])
+			buf, _ = g.Next(buf[:0])
+			lock.Unlock()
+		}
+	})
+}
+
+func BenchmarkDecompressReaderPool(b *testing.B) {
+	t := new(testing.T)
+	d := prepareDict(t)
+	defer d.Close()
+	offsets := wordOffsets(d)
+	for _, size := range []int{1, 4, 16} {
+		p := d.ReaderPool(size)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			ctx := context.Background()
+			b.RunParallel(func(pb *testing.PB) {
+				var buf []byte
+				for i := 0; pb.Next(); i++ {
+					buf, _ = p.Read(ctx, offsets[i%len(offsets)], buf[:0])
+				}
+			})
+		})
+	}
+}
@@ -205,7 +205,8 @@ func reduceDictWorker(trace bool, inputCh chan []byte, outCh chan *pair, complet
 type pair struct{ k, v []byte }
 
 // reduceDict reduces the dictionary by trying the substitutions and counting frequency for each word
-func reducedict(trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *DecompressedFile, workers int, dictBuilder *DictionaryBuilder) error {
+// ctx cancellation is checked between words, progress is called with PhaseReduce and PhaseEncode
+func reducedict(ctx context.Context, trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *DecompressedFile, workers int, dictBuilder *DictionaryBuilder, progress func(phase CompressPhase, processed, total uint64)) error {
 	logEvery := time.NewTicker(20 * time.Second)
 	defer logEvery.Stop()
 
@@ -258,7 +259,14 @@ func reducedict(trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *
 		go reduceDictWorker(trace, ch, out, &wg, &pt, inputSize, outputSize, posMap)
 	}
 	var wordsCount uint64
+	progress(PhaseReduce, 0, datFile.count)
 	if err := datFile.ForEach(func(v []byte) error {
+		if wordsCount%progressWordsStep == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			progress(PhaseReduce, wordsCount, datFile.count)
+		}
 		input := make([]byte, 8+int(len(v)))
 		binary.BigEndian.PutUint64(input, wordsCount)
 		copy(input[8:], v)
@@ -276,6 +284,10 @@ func reducedict(trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *
 		}
 		return nil
 	}); err != nil {
+		close(ch)
+		wg.Wait()
+		close(out)
+		wgAggregator.Wait()
 		return err
 	}
 	close(ch)
@@ -542,6 +554,7 @@ func reducedict(trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *
 	log.Debug(fmt.Sprintf("[%s] Positional dictionary", logPrefix), "size", common.ByteCount(offset), "position cutoff", positionCutoff)
 
 	wc := 0
+	progress(PhaseEncode, 0, wordsCount)
 	var hc HuffmanCoder
 	hc.w = cw
 	r := bytes.NewReader(nil)
@@ -618,11 +631,14 @@ func reducedict(trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *
 			}
 		}
 		wc++
+		if wc%progressWordsStep == 0 {
+			progress(PhaseEncode, uint64(wc), wordsCount)
+		}
 		if wc%10_000_000 == 0 {
 			log.Info(fmt.Sprintf("[%s] Compressed", logPrefix), "millions", wc/1_000_000)
 		}
 		return nil
-	}, etl.TransformArgs{}); err != nil {
+	}, etl.TransformArgs{Quit: ctx.Done()}); err != nil {
 		return err
 	}
 	aggregator.Close()
@@ -638,8 +654,8 @@ func reducedict(trace bool, logPrefix, segmentFilePath, tmpDir string, datFile *
 // processSuperstring is the worker that processes one superstring and puts results
 // into the collector, using lock to mutual exclusion. At the end (when the input channel is closed),
 // it notifies the waitgroup before exiting, so that the caller known when all work is done
-// No error channels for now
-func processSuperstring(superstringCh chan []byte, dictCollector *etl.Collector, minPatternScore uint64, completion *sync.WaitGroup) {
+// No error channels for now. When ctx is cancelled, remaining superstrings are skipped (but still read from the channel)
+func processSuperstring(ctx context.Context, superstringCh chan []byte, dictCollector *etl.Collector, minPatternScore uint64, completion *sync.WaitGroup, processed *atomic2.Uint64) {
 	defer completion.Done()
 	var dictVal [8]byte
 	dictKey := make([]byte, maxPatternLen)
@@ -649,6 +665,9 @@ func processSuperstring(superstringCh chan []byte, dictCollector *etl.Collector,
 		log.Error("processSuperstring", "create divsufsoet", err)
 	}
 	for superstring := range superstringCh {
+		if ctx.Err() != nil {
+			continue
+		}
 		sa = sa[:len(superstring)]
 		//log.Info("Superstring", "len", len(superstring))
 		//start := time.Now()
@@ -796,6 +815,7 @@ func processSuperstring(superstringCh chan []byte, dictCollector *etl.Collector,
 				break
 			}
 		}
+		processed.Inc()
 	}
 }
 
@@ -822,6 +842,81 @@ func DictionaryBuilderFromCollectors(ctx context.Context, logPrefix, tmpDir stri
 	return db, nil
 }
 
+// PersistDictionaryCheckpoint - writes built dictionary (together with number of words it was built for)
+// into binary file, which can be read by ReadDictionaryCheckpoint. File is written atomically
+func PersistDictionaryCheckpoint(fileName string, wordsCount uint64, db *DictionaryBuilder) error {
+	tmpFileName := fileName + ".tmp"
+	df, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFileName)
+	defer df.Close()
+	w := bufio.NewWriterSize(df, etl.BufIOSize)
+	numBuf := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(numBuf, v)
+		w.Write(numBuf[:n]) //nolint:errcheck
+	}
+	writeUvarint(wordsCount)
+	writeUvarint(uint64(db.Len()))
+	db.ForEach(func(score uint64, word []byte) {
+		writeUvarint(score)
+		writeUvarint(uint64(len(word)))
+		w.Write(word) //nolint:errcheck
+	})
+	if err = w.Flush(); err != nil { // bufio.Writer remembers the first write error
+		return err
+	}
+	if err = df.Sync(); err != nil {
+		return err
+	}
+	if err = df.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// ReadDictionaryCheckpoint - reads dictionary written by PersistDictionaryCheckpoint
+func ReadDictionaryCheckpoint(fileName string) (wordsCount uint64, db *DictionaryBuilder, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, etl.BufIOSize)
+	if wordsCount, err = binary.ReadUvarint(r); err != nil {
+		return 0, nil, fmt.Errorf("words count: %w", err)
+	}
+	var n uint64
+	if n, err = binary.ReadUvarint(r); err != nil {
+		return 0, nil, fmt.Errorf("patterns count: %w", err)
+	}
+	if n > maxDictPatterns {
+		return 0, nil, fmt.Errorf("too many patterns: %d", n)
+	}
+	db = &DictionaryBuilder{limit: maxDictPatterns, items: make([]*Pattern, n)}
+	// ForEach goes from the last item, so patterns are read in reverse order
+	for i := int(n) - 1; i >= 0; i-- {
+		var score, l uint64
+		if score, err = binary.ReadUvarint(r); err != nil {
+			return 0, nil, fmt.Errorf("pattern score: %w", err)
+		}
+		if l, err = binary.ReadUvarint(r); err != nil {
+			return 0, nil, fmt.Errorf("pattern length: %w", err)
+		}
+		if l > maxPatternLen {
+			return 0, nil, fmt.Errorf("pattern is too long: %d", l)
+		}
+		word := make([]byte, l)
+		if _, err = io.ReadFull(r, word); err != nil {
+			return 0, nil, fmt.Errorf("pattern: %w", err)
+		}
+		db.items[i] = &Pattern{score: score, word: word}
+	}
+	return wordsCount, db, nil
+}
+
 func PersistDictrionary(fileName string, db *DictionaryBuilder) error {
 	df, err := os.Create(fileName)
 	if err != nil {
@@ -0,0 +1,113 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"fmt"
+	"time"
+)
+
+// CompressPhase - stage of Compressor.Compress, reported in Progress
+type CompressPhase int
+
+const (
+	PhaseSuperstrings CompressPhase = iota // suffix arrays of superstrings are built by workers
+	PhaseDictionary                        // candidate patterns are aggregated into the dictionary
+	PhaseReduce                            // words are matched against the dictionary to count pattern uses
+	PhaseEncode                            // huffman-coded words are written into the output file
+	PhaseDone
+)
+
+func (p CompressPhase) String() string {
+	switch p {
+	case PhaseSuperstrings:
+		return "superstrings"
+	case PhaseDictionary:
+		return "dictionary"
+	case PhaseReduce:
+		return "reduce"
+	case PhaseEncode:
+		return "encode"
+	case PhaseDone:
+		return "done"
+	default:
+		return fmt.Sprintf("phase(%d)", int(p))
+	}
+}
+
+const (
+	DefaultProgressEvery = time.Second
+	progressWordsStep    = 4096 // words processed between checks of time
+)
+
+// Progress - state of Compressor.Compress. Processed and Total are counted in superstrings
+// for PhaseSuperstrings and in words for PhaseReduce and PhaseEncode; both are 0 for other phases
+type Progress struct {
+	Phase     CompressPhase
+	Processed uint64
+	Total     uint64
+	Elapsed   time.Duration // since Compress was called
+}
+
+// Percent - completeness of current phase, 0 for phases which don't report it
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return 100 * float64(p.Processed) / float64(p.Total)
+}
+
+func (p Progress) String() string {
+	s := fmt.Sprintf("phase=%s elapsed=%s", p.Phase, p.Elapsed.Round(time.Second))
+	if p.Total > 0 {
+		s += fmt.Sprintf(" processed=%d/%d (%.1f%%)", p.Processed, p.Total, p.Percent())
+	}
+	return s
+}
+
+// SetProgress - sets callback which is called on every phase change of Compress,
+// and at most every `every` (DefaultProgressEvery if 0) while a phase is running
+func (c *Compressor) SetProgress(progress func(Progress), every time.Duration) {
+	if every == 0 {
+		every = DefaultProgressEvery
+	}
+	c.progress = progress
+	c.progressEvery = every
+}
+
+// onProgress - reports progress of the phase, always if it's a new phase
+func (c *Compressor) onProgress(phase CompressPhase, processed, total uint64) {
+	if phase != c.phase || processed == 0 {
+		c.phase = phase
+		c.reportProgress(processed, total, true)
+		return
+	}
+	c.reportProgress(processed, total, false)
+}
+
+// reportProgress - calls progress callback at most every progressEvery (unless forced)
+func (c *Compressor) reportProgress(processed, total uint64, force bool) {
+	if c.progress == nil {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(c.lastProgress) < c.progressEvery {
+		return
+	}
+	c.lastProgress = now
+	c.progress(Progress{Phase: c.phase, Processed: processed, Total: total, Elapsed: now.Sub(c.started)})
+}
@@ -0,0 +1,83 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import "context"
+
+// ReaderPool - fixed set of getters over the same mmap'd file, for concurrent random reads (for example from RPC requests).
+// Getters share only read-only data (mmap and dictionaries), each has own cursor and buffers.
+// Getter taken from the pool must be returned to it, and must not be used after Decompressor is closed.
+type ReaderPool struct {
+	getters chan *Getter
+}
+
+// ReaderPool creates pool of n getters (at least one)
+func (d *Decompressor) ReaderPool(n int) *ReaderPool {
+	if n < 1 {
+		n = 1
+	}
+	p := &ReaderPool{getters: make(chan *Getter, n)}
+	for i := 0; i < n; i++ {
+		p.getters <- d.MakeGetter()
+	}
+	return p
+}
+
+// Size - amount of getters in the pool
+func (p *ReaderPool) Size() int { return cap(p.getters) }
+
+// Get - waits for free getter, or until ctx is done
+func (p *ReaderPool) Get(ctx context.Context) (*Getter, error) {
+	select {
+	case g := <-p.getters:
+		return g, nil
+	default:
+	}
+	select {
+	case g := <-p.getters:
+		return g, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryGet - returns free getter or nil, without waiting
+func (p *ReaderPool) TryGet() *Getter {
+	select {
+	case g := <-p.getters:
+		return g
+	default:
+		return nil
+	}
+}
+
+// Put - returns getter to the pool
+func (p *ReaderPool) Put(g *Getter) {
+	p.getters <- g
+}
+
+// Read - reads word at given offset using getter from the pool, appending it to buf
+func (p *ReaderPool) Read(ctx context.Context, offset uint64, buf []byte) ([]byte, error) {
+	g, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(g)
+	g.Reset(offset)
+	word, _ := g.Next(buf)
+	return word, nil
+}
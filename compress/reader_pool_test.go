@@ -0,0 +1,87 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compress
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wordOffsets - offsets of all words in the file
+func wordOffsets(d *Decompressor) []uint64 {
+	var offsets []uint64
+	var offset uint64
+	g := d.MakeGetter()
+	for g.HasNext() {
+		offsets = append(offsets, offset)
+		offset = g.Skip()
+	}
+	return offsets
+}
+
+func TestReaderPool(t *testing.T) {
+	d := prepareLoremDict(t)
+	defer d.Close()
+	offsets := wordOffsets(d)
+	p := d.ReaderPool(4)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				k := (i*7 + j) % len(offsets)
+				word, err := p.Read(ctx, offsets[k], nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if expected := fmt.Sprintf("%s %d", loremStrings[k], k); string(word) != expected {
+					errs <- fmt.Errorf("expected %s, got %s", expected, word)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	var taken []*Getter
+	for g := p.TryGet(); g != nil; g = p.TryGet() {
+		taken = append(taken, g)
+	}
+	if len(taken) != p.Size() {
+		t.Fatalf("expected %d getters, got %d", p.Size(), len(taken))
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	for _, g := range taken {
+		p.Put(g)
+	}
+}
@@ -2,7 +2,6 @@ package direct
 
 import (
 	"context"
-	"io"
 
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
@@ -11,13 +10,20 @@ import (
 )
 
 type EthBackendClientDirect struct {
-	server remote.ETHBACKENDServer
+	server       remote.ETHBACKENDServer
+	bufferPolicy BufferPolicy
 }
 
 func NewEthBackendClientDirect(server remote.ETHBACKENDServer) *EthBackendClientDirect {
 	return &EthBackendClientDirect{server: server}
 }
 
+// WithBufferPolicy - buffering of server-stream methods, see BufferPolicy
+func (s *EthBackendClientDirect) WithBufferPolicy(policy BufferPolicy) *EthBackendClientDirect {
+	s.bufferPolicy = policy
+	return s
+}
+
 func (s *EthBackendClientDirect) Etherbase(ctx context.Context, in *remote.EtherbaseRequest, opts ...grpc.CallOption) (*remote.EtherbaseReply, error) {
 	return s.server.Etherbase(ctx, in)
 }
@@ -57,49 +63,44 @@ func (s *EthBackendClientDirect) ClientVersion(ctx context.Context, in *remote.C
 // -- start Subscribe
 
 func (s *EthBackendClientDirect) Subscribe(ctx context.Context, in *remote.SubscribeRequest, opts ...grpc.CallOption) (remote.ETHBACKEND_SubscribeClient, error) {
-	ch := make(chan *subscribeReply, 16384)
-	streamServer := &SubscribeStreamS{ch: ch, ctx: ctx}
+	buf := newStreamBuffer(ctx, s.bufferPolicy)
+	streamServer := &SubscribeStreamS{buf: buf, ctx: ctx}
 	go func() {
-		defer close(ch)
+		defer buf.Close()
 		streamServer.Err(s.server.Subscribe(in, streamServer))
 	}()
-	return &SubscribeStreamC{ch: ch, ctx: ctx}, nil
+	return &SubscribeStreamC{buf: buf, ctx: ctx}, nil
 }
 
-type subscribeReply struct {
-	r   *remote.SubscribeReply
-	err error
-}
 type SubscribeStreamS struct {
-	ch  chan *subscribeReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ServerStream
 }
 
 func (s *SubscribeStreamS) Send(m *remote.SubscribeReply) error {
-	s.ch <- &subscribeReply{r: m}
-	return nil
+	return s.buf.Send(m)
 }
 func (s *SubscribeStreamS) Context() context.Context { return s.ctx }
 func (s *SubscribeStreamS) Err(err error) {
 	if err == nil {
 		return
 	}
-	s.ch <- &subscribeReply{err: err}
+	s.buf.Err(err)
 }
 
 type SubscribeStreamC struct {
-	ch  chan *subscribeReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ClientStream
 }
 
 func (c *SubscribeStreamC) Recv() (*remote.SubscribeReply, error) {
-	m, ok := <-c.ch
-	if !ok || m == nil {
-		return nil, io.EOF
+	m, err := c.buf.Recv()
+	if err != nil {
+		return nil, err
 	}
-	return m.r, m.err
+	return m.(*remote.SubscribeReply), nil
 }
 func (c *SubscribeStreamC) Context() context.Context { return c.ctx }
 
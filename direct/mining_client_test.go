@@ -0,0 +1,86 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	"github.com/stretchr/testify/require"
+)
+
+type testMiningServer struct {
+	txpool_proto.UnimplementedMiningServer
+	blocks [][]byte
+	err    error
+}
+
+func (s *testMiningServer) OnPendingBlock(_ *txpool_proto.OnPendingBlockRequest, stream txpool_proto.Mining_OnPendingBlockServer) error {
+	for _, b := range s.blocks {
+		if err := stream.Send(&txpool_proto.OnPendingBlockReply{RplBlock: b}); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func (s *testMiningServer) OnMinedBlock(_ *txpool_proto.OnMinedBlockRequest, stream txpool_proto.Mining_OnMinedBlockServer) error {
+	for _, b := range s.blocks {
+		if err := stream.Send(&txpool_proto.OnMinedBlockReply{RplBlock: b}); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func (s *testMiningServer) Mining(context.Context, *txpool_proto.MiningRequest) (*txpool_proto.MiningReply, error) {
+	return &txpool_proto.MiningReply{Enabled: true, Running: true}, nil
+}
+
+func TestMiningClientDirect(t *testing.T) {
+	ctx := context.Background()
+	server := &testMiningServer{blocks: [][]byte{{1}, {2, 3}}}
+	client := NewMiningClientDirect(server)
+
+	reply, err := client.Mining(ctx, &txpool_proto.MiningRequest{})
+	require.NoError(t, err)
+	require.True(t, reply.Running)
+
+	pending, err := client.OnPendingBlock(ctx, &txpool_proto.OnPendingBlockRequest{})
+	require.NoError(t, err)
+	for _, b := range server.blocks {
+		r, err := pending.Recv()
+		require.NoError(t, err)
+		require.Equal(t, b, r.RplBlock)
+	}
+	_, err = pending.Recv()
+	require.Equal(t, io.EOF, err)
+
+	server.err = errors.New("miner stopped")
+	mined, err := client.OnMinedBlock(ctx, &txpool_proto.OnMinedBlockRequest{})
+	require.NoError(t, err)
+	for _, b := range server.blocks {
+		r, err := mined.Recv()
+		require.NoError(t, err)
+		require.Equal(t, b, r.RplBlock)
+	}
+	_, err = mined.Recv()
+	require.EqualError(t, err, "miner stopped")
+}
@@ -19,7 +19,6 @@ package direct
 import (
 	"context"
 	"fmt"
-	"io"
 	"sync"
 
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
@@ -150,14 +149,45 @@ func (c *SentryClientRemote) PeerCount(ctx context.Context, in *sentry.PeerCount
 // SentryClientDirect implements SentryClient interface by connecting the instance of the client directly with the corresponding
 // instance of SentryServer
 type SentryClientDirect struct {
-	protocol uint
-	server   sentry.SentryServer
+	protocol     uint
+	server       sentry.SentryServer
+	bufferPolicy BufferPolicy
+	hooks        []inboundHook
+}
+
+// InboundHook - middleware of inbound messages of SentryClientDirect, called before message is buffered for client.
+// Returns message to pass further (the same or transformed), nil to drop it, or error which ends Messages stream
+type InboundHook func(ctx context.Context, msg *sentry.InboundMessage) (*sentry.InboundMessage, error)
+
+type inboundHook struct {
+	ids  map[sentry.MessageId]struct{} // nil - all messages
+	hook InboundHook
 }
 
 func NewSentryClientDirect(protocol uint, sentryServer sentry.SentryServer) *SentryClientDirect {
 	return &SentryClientDirect{protocol: protocol, server: sentryServer}
 }
 
+// WithBufferPolicy - buffering of server-stream methods, see BufferPolicy
+func (c *SentryClientDirect) WithBufferPolicy(policy BufferPolicy) *SentryClientDirect {
+	c.bufferPolicy = policy
+	return c
+}
+
+// WithInboundHook - registers hook for inbound messages with given ids (all messages if no ids given).
+// Hooks are called in order of registration, each gets result of previous one. Must be called before Messages
+func (c *SentryClientDirect) WithInboundHook(hook InboundHook, ids ...sentry.MessageId) *SentryClientDirect {
+	h := inboundHook{hook: hook}
+	if len(ids) > 0 {
+		h.ids = make(map[sentry.MessageId]struct{}, len(ids))
+		for _, id := range ids {
+			h.ids[id] = struct{}{}
+		}
+	}
+	c.hooks = append(c.hooks, h)
+	return c
+}
+
 func (c *SentryClientDirect) Protocol() uint    { return c.protocol }
 func (c *SentryClientDirect) Ready() bool       { return true }
 func (c *SentryClientDirect) MarkDisconnected() {}
@@ -202,51 +232,60 @@ func (c *SentryClientDirect) PeerCount(ctx context.Context, in *sentry.PeerCount
 
 func (c *SentryClientDirect) Messages(ctx context.Context, in *sentry.MessagesRequest, opts ...grpc.CallOption) (sentry.Sentry_MessagesClient, error) {
 	in.Ids = filterIds(in.Ids, c.Protocol())
-	ch := make(chan *inboundMessageReply, 16384)
-	streamServer := &SentryMessagesStreamS{ch: ch, ctx: ctx}
+	buf := newStreamBuffer(ctx, c.bufferPolicy)
+	streamServer := &SentryMessagesStreamS{buf: buf, ctx: ctx, hooks: c.hooks}
 	go func() {
-		defer close(ch)
+		defer buf.Close()
 		streamServer.Err(c.server.Messages(in, streamServer))
 	}()
-	return &SentryMessagesStreamC{ch: ch, ctx: ctx}, nil
-}
-
-type inboundMessageReply struct {
-	r   *sentry.InboundMessage
-	err error
+	return &SentryMessagesStreamC{buf: buf, ctx: ctx}, nil
 }
 
 // SentryMessagesStreamS implements proto_sentry.Sentry_ReceiveMessagesServer
 type SentryMessagesStreamS struct {
-	ch  chan *inboundMessageReply
-	ctx context.Context
+	buf   *streamBuffer
+	ctx   context.Context
+	hooks []inboundHook
 	grpc.ServerStream
 }
 
 func (s *SentryMessagesStreamS) Send(m *sentry.InboundMessage) error {
-	s.ch <- &inboundMessageReply{r: m}
-	return nil
+	for _, h := range s.hooks {
+		if h.ids != nil {
+			if _, ok := h.ids[m.Id]; !ok {
+				continue
+			}
+		}
+		var err error
+		if m, err = h.hook(s.ctx, m); err != nil {
+			return err
+		}
+		if m == nil {
+			return nil
+		}
+	}
+	return s.buf.Send(m)
 }
 func (s *SentryMessagesStreamS) Context() context.Context { return s.ctx }
 func (s *SentryMessagesStreamS) Err(err error) {
 	if err == nil {
 		return
 	}
-	s.ch <- &inboundMessageReply{err: err}
+	s.buf.Err(err)
 }
 
 type SentryMessagesStreamC struct {
-	ch  chan *inboundMessageReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ClientStream
 }
 
 func (c *SentryMessagesStreamC) Recv() (*sentry.InboundMessage, error) {
-	m, ok := <-c.ch
-	if !ok || m == nil {
-		return nil, io.EOF
+	m, err := c.buf.Recv()
+	if err != nil {
+		return nil, err
 	}
-	return m.r, m.err
+	return m.(*sentry.InboundMessage), nil
 }
 func (c *SentryMessagesStreamC) Context() context.Context { return c.ctx }
 
@@ -254,51 +293,45 @@ func (c *SentryMessagesStreamC) Context() context.Context { return c.ctx }
 // -- start Peers
 
 func (c *SentryClientDirect) Peers(ctx context.Context, in *sentry.PeersRequest, opts ...grpc.CallOption) (sentry.Sentry_PeersClient, error) {
-	ch := make(chan *peersReply, 16384)
-	streamServer := &SentryPeersStreamS{ch: ch, ctx: ctx}
+	buf := newStreamBuffer(ctx, c.bufferPolicy)
+	streamServer := &SentryPeersStreamS{buf: buf, ctx: ctx}
 	go func() {
-		defer close(ch)
+		defer buf.Close()
 		streamServer.Err(c.server.Peers(in, streamServer))
 	}()
-	return &SentryPeersStreamC{ch: ch, ctx: ctx}, nil
-}
-
-type peersReply struct {
-	r   *sentry.PeersReply
-	err error
+	return &SentryPeersStreamC{buf: buf, ctx: ctx}, nil
 }
 
 // SentryPeersStreamS - implements proto_sentry.Sentry_ReceivePeersServer
 type SentryPeersStreamS struct {
-	ch  chan *peersReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ServerStream
 }
 
 func (s *SentryPeersStreamS) Send(m *sentry.PeersReply) error {
-	s.ch <- &peersReply{r: m}
-	return nil
+	return s.buf.Send(m)
 }
 func (s *SentryPeersStreamS) Context() context.Context { return s.ctx }
 func (s *SentryPeersStreamS) Err(err error) {
 	if err == nil {
 		return
 	}
-	s.ch <- &peersReply{err: err}
+	s.buf.Err(err)
 }
 
 type SentryPeersStreamC struct {
-	ch  chan *peersReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ClientStream
 }
 
 func (c *SentryPeersStreamC) Recv() (*sentry.PeersReply, error) {
-	m, ok := <-c.ch
-	if !ok || m == nil {
-		return nil, io.EOF
+	m, err := c.buf.Recv()
+	if err != nil {
+		return nil, err
 	}
-	return m.r, m.err
+	return m.(*sentry.PeersReply), nil
 }
 func (c *SentryPeersStreamC) Context() context.Context { return c.ctx }
 
@@ -0,0 +1,89 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentryClientDirectInboundHooks(t *testing.T) {
+	ctx := context.Background()
+	server := &sentry.SentryServerMock{
+		MessagesFunc: func(_ *sentry.MessagesRequest, stream sentry.Sentry_MessagesServer) error {
+			for _, m := range []*sentry.InboundMessage{
+				{Id: sentry.MessageId_TRANSACTIONS_66, Data: []byte{1}},
+				{Id: sentry.MessageId_BLOCK_HEADERS_66, Data: bytes.Repeat([]byte{2}, 10)},
+				{Id: sentry.MessageId_NEW_BLOCK_HASHES_66, Data: []byte{3}},
+				{Id: sentry.MessageId_TRANSACTIONS_66, Data: bytes.Repeat([]byte{4}, 10)},
+			} {
+				if err := stream.Send(m); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	var seen int
+	client := NewSentryClientDirect(ETH66, server).
+		WithInboundHook(func(_ context.Context, msg *sentry.InboundMessage) (*sentry.InboundMessage, error) {
+			if len(msg.Data) > 4 {
+				return nil, nil // drop oversized
+			}
+			return msg, nil
+		}).
+		WithInboundHook(func(_ context.Context, msg *sentry.InboundMessage) (*sentry.InboundMessage, error) {
+			seen++
+			return &sentry.InboundMessage{Id: msg.Id, Data: append([]byte{0}, msg.Data...)}, nil
+		}, sentry.MessageId_TRANSACTIONS_66, sentry.MessageId_BLOCK_HEADERS_66)
+
+	stream, err := client.Messages(ctx, &sentry.MessagesRequest{})
+	require.NoError(t, err)
+	var got []*sentry.InboundMessage
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, m)
+	}
+	require.Equal(t, 2, len(got))
+	require.Equal(t, sentry.MessageId_TRANSACTIONS_66, got[0].Id)
+	require.Equal(t, []byte{0, 1}, got[0].Data)
+	require.Equal(t, sentry.MessageId_NEW_BLOCK_HASHES_66, got[1].Id)
+	require.Equal(t, []byte{3}, got[1].Data)
+	require.Equal(t, 1, seen) // dropped messages don't reach next hooks
+
+	errStop := errors.New("stop")
+	client = NewSentryClientDirect(ETH66, server).WithInboundHook(func(context.Context, *sentry.InboundMessage) (*sentry.InboundMessage, error) {
+		return nil, errStop
+	}, sentry.MessageId_BLOCK_HEADERS_66)
+	stream, err = client.Messages(ctx, &sentry.MessagesRequest{})
+	require.NoError(t, err)
+	m, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, sentry.MessageId_TRANSACTIONS_66, m.Id)
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, errStop)
+}
@@ -0,0 +1,451 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var ErrNoReadySentry = errors.New("no ready sentry")
+
+// SentryMux - aggregates multiple sentries behind one SentryClient.
+// Remembers which sentry owns peer (from Messages and Peers streams) and routes
+// peer-specific requests to it, requests without peer are routed by peer count of sentries.
+// Streams are merged, and opened only on sentries which are Ready (passed HandShake) at the moment.
+// Sentries are expected to speak same protocol.
+type SentryMux struct {
+	sentries     []SentryClient
+	bufferPolicy BufferPolicy
+
+	lock   sync.RWMutex
+	owners map[[32]byte]int // peer -> index of sentry
+}
+
+var _ SentryClient = (*SentryMux)(nil) // compile-time interface check
+
+func NewSentryMux(sentries ...SentryClient) *SentryMux {
+	return &SentryMux{sentries: sentries, owners: map[[32]byte]int{}}
+}
+
+// WithBufferPolicy - buffering of merged streams, see BufferPolicy
+func (m *SentryMux) WithBufferPolicy(policy BufferPolicy) *SentryMux {
+	m.bufferPolicy = policy
+	return m
+}
+
+func (m *SentryMux) Sentries() []SentryClient { return m.sentries }
+
+// Protocol - the lowest protocol of ready sentries
+func (m *SentryMux) Protocol() (protocol uint) {
+	for _, s := range m.sentries {
+		if s.Ready() && (protocol == 0 || s.Protocol() < protocol) {
+			protocol = s.Protocol()
+		}
+	}
+	return protocol
+}
+
+// Ready - if at least one sentry is ready
+func (m *SentryMux) Ready() bool {
+	for _, s := range m.sentries {
+		if s.Ready() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *SentryMux) MarkDisconnected() {
+	for _, s := range m.sentries {
+		s.MarkDisconnected()
+	}
+}
+
+func (m *SentryMux) setOwner(peer *types.H256, i int) {
+	if peer == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.owners[gointerfaces.ConvertH256ToHash(peer)] = i
+}
+
+func (m *SentryMux) dropOwner(peer *types.H256, i int) {
+	if peer == nil {
+		return
+	}
+	key := gointerfaces.ConvertH256ToHash(peer)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if owner, ok := m.owners[key]; ok && owner == i {
+		delete(m.owners, key)
+	}
+}
+
+// route - sentries which may own peer: owner if known, otherwise all ready sentries
+func (m *SentryMux) route(peer *types.H256) []SentryClient {
+	if peer != nil {
+		m.lock.RLock()
+		i, ok := m.owners[gointerfaces.ConvertH256ToHash(peer)]
+		m.lock.RUnlock()
+		if ok {
+			return m.sentries[i : i+1]
+		}
+	}
+	return m.ready()
+}
+
+func (m *SentryMux) ready() (ready []SentryClient) {
+	for _, s := range m.sentries {
+		if s.Ready() {
+			ready = append(ready, s)
+		}
+	}
+	return ready
+}
+
+// each - calls f for every sentry, returns first error (annotated by index of sentry) after all calls
+func each(sentries []SentryClient, f func(s SentryClient) error) error {
+	if len(sentries) == 0 {
+		return ErrNoReadySentry
+	}
+	var firstErr error
+	for i, s := range sentries {
+		if err := f(s); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("sentry %d: %w", i, err)
+		}
+	}
+	return firstErr
+}
+
+func mergeSentPeers(sentries []SentryClient, f func(s SentryClient) (*sentry.SentPeers, error)) (*sentry.SentPeers, error) {
+	reply := &sentry.SentPeers{}
+	err := each(sentries, func(s SentryClient) error {
+		sent, err := f(s)
+		if err != nil {
+			return err
+		}
+		reply.Peers = append(reply.Peers, sent.GetPeers()...)
+		return nil
+	})
+	if err != nil && len(reply.Peers) == 0 {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// HandShake - handshakes all sentries, succeeds if at least one of them succeeded
+func (m *SentryMux) HandShake(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*sentry.HandShakeReply, error) {
+	var reply *sentry.HandShakeReply
+	err := each(m.sentries, func(s SentryClient) error {
+		r, err := s.HandShake(ctx, in, opts...)
+		if err == nil && (reply == nil || r.GetProtocol() < reply.GetProtocol()) {
+			reply = r
+		}
+		return err
+	})
+	if reply == nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (m *SentryMux) SetStatus(ctx context.Context, in *sentry.StatusData, opts ...grpc.CallOption) (*sentry.SetStatusReply, error) {
+	var reply *sentry.SetStatusReply
+	err := each(m.sentries, func(s SentryClient) error {
+		r, err := s.SetStatus(ctx, in, opts...)
+		if err == nil && reply == nil {
+			reply = r
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (m *SentryMux) PenalizePeer(ctx context.Context, in *sentry.PenalizePeerRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	err := each(m.route(in.PeerId), func(s SentryClient) error {
+		_, err := s.PenalizePeer(ctx, in, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (m *SentryMux) PeerMinBlock(ctx context.Context, in *sentry.PeerMinBlockRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	err := each(m.route(in.PeerId), func(s SentryClient) error {
+		_, err := s.PeerMinBlock(ctx, in, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// SendMessageById - sends to sentry which owns peer, or to all ready sentries if owner is unknown
+func (m *SentryMux) SendMessageById(ctx context.Context, in *sentry.SendMessageByIdRequest, opts ...grpc.CallOption) (*sentry.SentPeers, error) {
+	return mergeSentPeers(m.route(in.PeerId), func(s SentryClient) (*sentry.SentPeers, error) {
+		return s.SendMessageById(ctx, in, opts...)
+	})
+}
+
+// SendMessageByMinBlock - tries ready sentries starting from the one with most peers, until message is sent
+func (m *SentryMux) SendMessageByMinBlock(ctx context.Context, in *sentry.SendMessageByMinBlockRequest, opts ...grpc.CallOption) (*sentry.SentPeers, error) {
+	sentries, _, err := m.byPeerCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, s := range sentries {
+		reply, err := s.SendMessageByMinBlock(ctx, in, opts...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(reply.GetPeers()) > 0 {
+			return reply, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return &sentry.SentPeers{}, nil
+}
+
+// SendMessageToRandomPeers - splits MaxPeers between ready sentries proportionally to their peer count
+func (m *SentryMux) SendMessageToRandomPeers(ctx context.Context, in *sentry.SendMessageToRandomPeersRequest, opts ...grpc.CallOption) (*sentry.SentPeers, error) {
+	sentries, counts, err := m.byPeerCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return &sentry.SentPeers{}, nil
+	}
+	reply := &sentry.SentPeers{}
+	var firstErr error
+	left := in.MaxPeers
+	for i, s := range sentries {
+		share := in.MaxPeers * counts[i] / total
+		if share == 0 && counts[i] > 0 {
+			share = 1
+		}
+		if share > left {
+			share = left
+		}
+		if share == 0 {
+			break
+		}
+		left -= share
+		sent, err := s.SendMessageToRandomPeers(ctx, &sentry.SendMessageToRandomPeersRequest{Data: in.Data, MaxPeers: share}, opts...)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		reply.Peers = append(reply.Peers, sent.GetPeers()...)
+	}
+	if firstErr != nil && len(reply.Peers) == 0 {
+		return nil, firstErr
+	}
+	return reply, nil
+}
+
+func (m *SentryMux) SendMessageToAll(ctx context.Context, in *sentry.OutboundMessageData, opts ...grpc.CallOption) (*sentry.SentPeers, error) {
+	return mergeSentPeers(m.ready(), func(s SentryClient) (*sentry.SentPeers, error) {
+		return s.SendMessageToAll(ctx, in, opts...)
+	})
+}
+
+// PeerCount - sum of peers of ready sentries
+func (m *SentryMux) PeerCount(ctx context.Context, in *sentry.PeerCountRequest, opts ...grpc.CallOption) (*sentry.PeerCountReply, error) {
+	_, counts, err := m.byPeerCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply := &sentry.PeerCountReply{}
+	for _, c := range counts {
+		reply.Count += c
+	}
+	return reply, nil
+}
+
+// byPeerCount - ready sentries which answered PeerCount, sorted by peer count descending
+func (m *SentryMux) byPeerCount(ctx context.Context) ([]SentryClient, []uint64, error) {
+	var sentries []SentryClient
+	var counts []uint64
+	err := each(m.ready(), func(s SentryClient) error {
+		reply, err := s.PeerCount(ctx, &sentry.PeerCountRequest{})
+		if err != nil {
+			return err
+		}
+		sentries, counts = append(sentries, s), append(counts, reply.GetCount())
+		return nil
+	})
+	if len(sentries) == 0 {
+		return nil, nil, err
+	}
+	sort.Stable(byCount{sentries, counts})
+	return sentries, counts, nil
+}
+
+type byCount struct {
+	sentries []SentryClient
+	counts   []uint64
+}
+
+func (b byCount) Len() int           { return len(b.sentries) }
+func (b byCount) Less(i, j int) bool { return b.counts[i] > b.counts[j] }
+func (b byCount) Swap(i, j int) {
+	b.sentries[i], b.sentries[j] = b.sentries[j], b.sentries[i]
+	b.counts[i], b.counts[j] = b.counts[j], b.counts[i]
+}
+
+// NodeInfo - of the first ready sentry
+func (m *SentryMux) NodeInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.NodeInfoReply, error) {
+	ready := m.ready()
+	if len(ready) == 0 {
+		return nil, ErrNoReadySentry
+	}
+	return ready[0].NodeInfo(ctx, in, opts...)
+}
+
+// Messages - merged stream of messages of all ready sentries. Error of any stream ends merged stream.
+func (m *SentryMux) Messages(ctx context.Context, in *sentry.MessagesRequest, opts ...grpc.CallOption) (sentry.Sentry_MessagesClient, error) {
+	buf, err := m.fanIn(ctx, func(ctx context.Context, i int) (func() (interface{}, error), error) {
+		// sentries filter ids by own protocol - each needs own copy of request
+		stream, err := m.sentries[i].Messages(ctx, &sentry.MessagesRequest{Ids: in.Ids}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return func() (interface{}, error) {
+			msg, err := stream.Recv()
+			if err != nil {
+				return nil, err
+			}
+			m.setOwner(msg.PeerId, i)
+			return msg, nil
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SentryMessagesStreamC{buf: buf, ctx: ctx}, nil
+}
+
+// Peers - merged stream of peer events of all ready sentries. Error of any stream ends merged stream.
+func (m *SentryMux) Peers(ctx context.Context, in *sentry.PeersRequest, opts ...grpc.CallOption) (sentry.Sentry_PeersClient, error) {
+	buf, err := m.fanIn(ctx, func(ctx context.Context, i int) (func() (interface{}, error), error) {
+		stream, err := m.sentries[i].Peers(ctx, in, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return func() (interface{}, error) {
+			event, err := stream.Recv()
+			if err != nil {
+				return nil, err
+			}
+			switch event.Event {
+			case sentry.PeersReply_Connect:
+				m.setOwner(event.PeerId, i)
+			case sentry.PeersReply_Disconnect:
+				m.dropOwner(event.PeerId, i)
+			}
+			return event, nil
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SentryPeersStreamC{buf: buf, ctx: ctx}, nil
+}
+
+// fanIn - opens stream on every ready sentry and pumps their messages into one buffer.
+// Merged stream ends with io.EOF when all streams ended, or with first error of any stream
+func (m *SentryMux) fanIn(ctx context.Context, open func(ctx context.Context, i int) (func() (interface{}, error), error)) (*streamBuffer, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	var recvs []func() (interface{}, error)
+	for i, s := range m.sentries {
+		if !s.Ready() {
+			continue
+		}
+		recv, err := open(streamCtx, i)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("sentry %d: %w", i, err)
+		}
+		recvs = append(recvs, recv)
+	}
+	if len(recvs) == 0 {
+		cancel()
+		return nil, ErrNoReadySentry
+	}
+
+	buf := newStreamBuffer(ctx, m.bufferPolicy)
+	var wg sync.WaitGroup
+	var failOnce sync.Once
+	for _, recv := range recvs {
+		wg.Add(1)
+		go func(recv func() (interface{}, error)) {
+			defer wg.Done()
+			for {
+				msg, err := recv()
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				if err != nil {
+					failOnce.Do(func() {
+						if ctx.Err() == nil { // otherwise client already knows
+							buf.Err(err)
+						}
+						cancel()
+					})
+					return
+				}
+				if err := buf.Send(msg); err != nil { // client's ctx is done
+					return
+				}
+			}
+		}(recv)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		buf.Close()
+	}()
+	return buf, nil
+}
@@ -0,0 +1,159 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"github.com/stretchr/testify/require"
+)
+
+func testPeer(b byte) *types.H256 { return gointerfaces.ConvertHashToH256([32]byte{b}) }
+
+// testSentry - owns given peers, announces them in Peers stream, sends one message from each peer in Messages stream
+func testSentry(peers ...*types.H256) *sentry.SentryServerMock {
+	return &sentry.SentryServerMock{
+		PeerCountFunc: func(context.Context, *sentry.PeerCountRequest) (*sentry.PeerCountReply, error) {
+			return &sentry.PeerCountReply{Count: uint64(len(peers))}, nil
+		},
+		PeersFunc: func(_ *sentry.PeersRequest, stream sentry.Sentry_PeersServer) error {
+			for _, p := range peers {
+				if err := stream.Send(&sentry.PeersReply{PeerId: p, Event: sentry.PeersReply_Connect}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		MessagesFunc: func(_ *sentry.MessagesRequest, stream sentry.Sentry_MessagesServer) error {
+			for _, p := range peers {
+				if err := stream.Send(&sentry.InboundMessage{Id: sentry.MessageId_TRANSACTIONS_66, PeerId: p}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		SendMessageByIdFunc: func(_ context.Context, in *sentry.SendMessageByIdRequest) (*sentry.SentPeers, error) {
+			for _, p := range peers {
+				if gointerfaces.ConvertH256ToHash(p) == gointerfaces.ConvertH256ToHash(in.PeerId) {
+					return &sentry.SentPeers{Peers: []*types.H256{p}}, nil
+				}
+			}
+			return &sentry.SentPeers{}, nil
+		},
+		SendMessageToRandomPeersFunc: func(_ context.Context, in *sentry.SendMessageToRandomPeersRequest) (*sentry.SentPeers, error) {
+			return &sentry.SentPeers{Peers: peers[:in.MaxPeers]}, nil
+		},
+	}
+}
+
+func TestSentryMux(t *testing.T) {
+	ctx := context.Background()
+	s1, s2 := testSentry(testPeer(1)), testSentry(testPeer(2), testPeer(3), testPeer(4))
+	mux := NewSentryMux(NewSentryClientDirect(ETH66, s1), NewSentryClientDirect(ETH66, s2))
+	require.True(t, mux.Ready())
+	require.Equal(t, uint(ETH66), mux.Protocol())
+
+	count, err := mux.PeerCount(ctx, &sentry.PeerCountRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), count.Count)
+
+	// owner is unknown - sent to all sentries
+	sent, err := mux.SendMessageById(ctx, &sentry.SendMessageByIdRequest{PeerId: testPeer(3)})
+	require.NoError(t, err)
+	require.Len(t, sent.Peers, 1)
+	require.Len(t, s1.SendMessageByIdCalls(), 1)
+
+	peers, err := mux.Peers(ctx, &sentry.PeersRequest{})
+	require.NoError(t, err)
+	connected := map[[32]byte]bool{}
+	for {
+		event, err := peers.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		connected[gointerfaces.ConvertH256ToHash(event.PeerId)] = true
+	}
+	require.Len(t, connected, 4)
+
+	// owner is known now - sent only to it
+	sent, err = mux.SendMessageById(ctx, &sentry.SendMessageByIdRequest{PeerId: testPeer(3)})
+	require.NoError(t, err)
+	require.Len(t, sent.Peers, 1)
+	require.Len(t, s1.SendMessageByIdCalls(), 1)
+	require.Len(t, s2.SendMessageByIdCalls(), 2)
+
+	// split by peer count: 1 of 4 peers in s1, 3 of 4 in s2
+	sent, err = mux.SendMessageToRandomPeers(ctx, &sentry.SendMessageToRandomPeersRequest{MaxPeers: 4})
+	require.NoError(t, err)
+	require.Len(t, sent.Peers, 4)
+	require.Equal(t, uint64(3), s2.SendMessageToRandomPeersCalls()[0].SendMessageToRandomPeersRequest.MaxPeers)
+	require.Equal(t, uint64(1), s1.SendMessageToRandomPeersCalls()[0].SendMessageToRandomPeersRequest.MaxPeers)
+
+	messages, err := mux.Messages(ctx, &sentry.MessagesRequest{Ids: []sentry.MessageId{sentry.MessageId_TRANSACTIONS_66}})
+	require.NoError(t, err)
+	received := 0
+	for {
+		_, err := messages.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		received++
+	}
+	require.Equal(t, 4, received)
+}
+
+func TestSentryMuxStreamError(t *testing.T) {
+	ctx := context.Background()
+	failing := &sentry.SentryServerMock{
+		PeersFunc: func(*sentry.PeersRequest, sentry.Sentry_PeersServer) error { return errors.New("sentry is down") },
+	}
+	done := make(chan struct{})
+	endless := &sentry.SentryServerMock{
+		PeersFunc: func(_ *sentry.PeersRequest, stream sentry.Sentry_PeersServer) error {
+			defer close(done)
+			for {
+				if err := stream.Send(&sentry.PeersReply{PeerId: testPeer(1)}); err != nil {
+					return err
+				}
+			}
+		},
+	}
+	mux := NewSentryMux(NewSentryClientDirect(ETH66, endless), NewSentryClientDirect(ETH66, failing)).WithBufferPolicy(BufferPolicy{Size: 4})
+	peers, err := mux.Peers(ctx, &sentry.PeersRequest{})
+	require.NoError(t, err)
+	for {
+		_, err = peers.Recv()
+		if err != nil {
+			break
+		}
+	}
+	require.EqualError(t, err, "sentry is down")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("streams of other sentries must be cancelled")
+	}
+}
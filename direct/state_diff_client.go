@@ -18,77 +18,86 @@ package direct
 
 import (
 	"context"
-	"io"
 
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type StateDiffClient interface {
 	StateChanges(ctx context.Context, in *remote.StateChangeRequest, opts ...grpc.CallOption) (remote.KV_StateChangesClient, error)
 }
 
-var _ StateDiffClient = (*StateDiffClientDirect)(nil) // compile-time interface check
+var _ StateDiffClient = (*StateDiffClientDirect)(nil)            // compile-time interface check
+var _ gointerfaces.VersionClient = (*StateDiffClientDirect)(nil) // compile-time interface check
 
 // SentryClientDirect implements SentryClient interface by connecting the instance of the client directly with the corresponding
 // instance of SentryServer
 type StateDiffClientDirect struct {
-	server remote.KVServer
+	server       remote.KVServer
+	bufferPolicy BufferPolicy
 }
 
 func NewStateDiffClientDirect(server remote.KVServer) *StateDiffClientDirect {
 	return &StateDiffClientDirect{server: server}
 }
 
+// WithBufferPolicy - buffering of server-stream methods, see BufferPolicy
+func (c *StateDiffClientDirect) WithBufferPolicy(policy BufferPolicy) *StateDiffClientDirect {
+	c.bufferPolicy = policy
+	return c
+}
+
+// Version - lets clients negotiate version of KV interface same way as with remote server
+func (c *StateDiffClientDirect) Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.VersionReply, error) {
+	return c.server.Version(ctx, in)
+}
+
 // -- start StateChanges
 
 func (c *StateDiffClientDirect) StateChanges(ctx context.Context, in *remote.StateChangeRequest, opts ...grpc.CallOption) (remote.KV_StateChangesClient, error) {
-	ch := make(chan *stateDiffReply, 16384)
-	streamServer := &StateDiffStreamS{ch: ch, ctx: ctx}
+	buf := newStreamBuffer(ctx, c.bufferPolicy)
+	streamServer := &StateDiffStreamS{buf: buf, ctx: ctx}
 	go func() {
-		defer close(ch)
+		defer buf.Close()
 		streamServer.Err(c.server.StateChanges(in, streamServer))
 	}()
-	return &StateDiffStreamC{ch: ch, ctx: ctx}, nil
-}
-
-type stateDiffReply struct {
-	r   *remote.StateChangeBatch
-	err error
+	return &StateDiffStreamC{buf: buf, ctx: ctx}, nil
 }
 
 type StateDiffStreamC struct {
-	ch  chan *stateDiffReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ClientStream
 }
 
 func (c *StateDiffStreamC) Recv() (*remote.StateChangeBatch, error) {
-	m, ok := <-c.ch
-	if !ok || m == nil {
-		return nil, io.EOF
+	m, err := c.buf.Recv()
+	if err != nil {
+		return nil, err
 	}
-	return m.r, m.err
+	return m.(*remote.StateChangeBatch), nil
 }
 func (c *StateDiffStreamC) Context() context.Context { return c.ctx }
 
 // StateDiffStreamS implements proto_sentry.Sentry_ReceiveMessagesServer
 type StateDiffStreamS struct {
-	ch  chan *stateDiffReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ServerStream
 }
 
 func (s *StateDiffStreamS) Send(m *remote.StateChangeBatch) error {
-	s.ch <- &stateDiffReply{r: m}
-	return nil
+	return s.buf.Send(m)
 }
 func (s *StateDiffStreamS) Context() context.Context { return s.ctx }
 func (s *StateDiffStreamS) Err(err error) {
 	if err == nil {
 		return
 	}
-	s.ch <- &stateDiffReply{err: err}
+	s.buf.Err(err)
 }
 
 // -- end StateChanges
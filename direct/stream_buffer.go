@@ -0,0 +1,172 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// BufferPolicy - what direct stream adapter does when server sends messages faster than client receives them.
+// By default buffer holds Size messages and server's Send blocks until client receives.
+// If OnOverflow is set - new message is dropped and passed to OnOverflow instead of blocking.
+// If Unbounded - buffer grows, server never blocks and no messages are lost.
+// Zero value is DefaultBufferPolicy.
+type BufferPolicy struct {
+	Size       int
+	Unbounded  bool
+	OnOverflow func(msg interface{})
+}
+
+var DefaultBufferPolicy = BufferPolicy{Size: 16384}
+
+func (p BufferPolicy) orDefault() BufferPolicy {
+	if p.Size == 0 && !p.Unbounded {
+		p.Size = DefaultBufferPolicy.Size
+	}
+	return p
+}
+
+type streamReply struct {
+	r   interface{}
+	err error
+}
+
+// streamBuffer - ring buffer between server-side and client-side of direct stream adapter.
+// Cancellation of ctx (context of client's call) unblocks both sides: Send and Recv return ctx.Err()
+type streamBuffer struct {
+	ctx    context.Context
+	policy BufferPolicy
+
+	lock   sync.Mutex
+	ring   []streamReply
+	head   int
+	count  int
+	closed bool
+
+	notEmpty chan struct{} // signals to receiver
+	notFull  chan struct{} // signals to blocked sender
+}
+
+const unboundedInitialSize = 64
+
+func newStreamBuffer(ctx context.Context, policy BufferPolicy) *streamBuffer {
+	policy = policy.orDefault()
+	size := policy.Size
+	if policy.Unbounded {
+		size = unboundedInitialSize
+	}
+	return &streamBuffer{
+		ctx:      ctx,
+		policy:   policy,
+		ring:     make([]streamReply, size),
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Send - called by server-side of stream
+func (b *streamBuffer) Send(r interface{}) error {
+	for {
+		if err := b.ctx.Err(); err != nil {
+			return err
+		}
+		b.lock.Lock()
+		if b.count < len(b.ring) || b.policy.Unbounded {
+			b.put(streamReply{r: r})
+			b.lock.Unlock()
+			return nil
+		}
+		b.lock.Unlock()
+		if b.policy.OnOverflow != nil {
+			b.policy.OnOverflow(r)
+			return nil
+		}
+		select {
+		case <-b.notFull:
+		case <-b.ctx.Done():
+		}
+	}
+}
+
+// Err - passes error of server to client, error is never dropped
+func (b *streamBuffer) Err(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.put(streamReply{err: err})
+}
+
+func (b *streamBuffer) put(r streamReply) {
+	if b.count == len(b.ring) {
+		b.grow()
+	}
+	b.ring[(b.head+b.count)%len(b.ring)] = r
+	b.count++
+	signal(b.notEmpty)
+}
+
+func (b *streamBuffer) grow() {
+	ring := make([]streamReply, 2*len(b.ring))
+	for i := 0; i < b.count; i++ {
+		ring[i] = b.ring[(b.head+i)%len(b.ring)]
+	}
+	b.ring, b.head = ring, 0
+}
+
+// Close - called when server finished stream: client receives io.EOF after buffered messages
+func (b *streamBuffer) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	signal(b.notEmpty)
+}
+
+// Recv - called by client-side of stream
+func (b *streamBuffer) Recv() (interface{}, error) {
+	for {
+		if err := b.ctx.Err(); err != nil {
+			return nil, err
+		}
+		b.lock.Lock()
+		if b.count > 0 {
+			r := b.ring[b.head]
+			b.ring[b.head] = streamReply{}
+			b.head = (b.head + 1) % len(b.ring)
+			b.count--
+			b.lock.Unlock()
+			signal(b.notFull)
+			return r.r, r.err
+		}
+		closed := b.closed
+		b.lock.Unlock()
+		if closed {
+			return nil, io.EOF
+		}
+		select {
+		case <-b.notEmpty:
+		case <-b.ctx.Done():
+		}
+	}
+}
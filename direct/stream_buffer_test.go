@@ -0,0 +1,107 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamBuffer(t *testing.T) {
+	t.Run("blocking", func(t *testing.T) {
+		b := newStreamBuffer(context.Background(), BufferPolicy{Size: 2})
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for i := 0; i < 3; i++ {
+				require.NoError(t, b.Send(i))
+			}
+		}()
+		select {
+		case <-sent:
+			t.Fatal("3rd Send must block")
+		case <-time.After(10 * time.Millisecond):
+		}
+		for i := 0; i < 3; i++ {
+			r, err := b.Recv()
+			require.NoError(t, err)
+			require.Equal(t, i, r)
+		}
+		<-sent
+	})
+	t.Run("overflow", func(t *testing.T) {
+		var dropped []interface{}
+		b := newStreamBuffer(context.Background(), BufferPolicy{Size: 2, OnOverflow: func(msg interface{}) { dropped = append(dropped, msg) }})
+		for i := 0; i < 4; i++ {
+			require.NoError(t, b.Send(i))
+		}
+		require.Equal(t, []interface{}{2, 3}, dropped)
+		b.Err(errors.New("server error")) // errors are not dropped
+		b.Close()
+		for i := 0; i < 2; i++ {
+			r, err := b.Recv()
+			require.NoError(t, err)
+			require.Equal(t, i, r)
+		}
+		_, err := b.Recv()
+		require.EqualError(t, err, "server error")
+		_, err = b.Recv()
+		require.Equal(t, io.EOF, err)
+	})
+	t.Run("unbounded", func(t *testing.T) {
+		b := newStreamBuffer(context.Background(), BufferPolicy{Unbounded: true})
+		for i := 0; i < 3*unboundedInitialSize; i++ {
+			require.NoError(t, b.Send(i))
+			if i%3 == 0 { // move head to check wrapping of ring
+				r, err := b.Recv()
+				require.NoError(t, err)
+				require.Equal(t, i/3, r)
+			}
+		}
+		b.Close()
+		for i := unboundedInitialSize; i < 3*unboundedInitialSize; i++ {
+			r, err := b.Recv()
+			require.NoError(t, err)
+			require.Equal(t, i, r)
+		}
+		_, err := b.Recv()
+		require.Equal(t, io.EOF, err)
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		b := newStreamBuffer(ctx, BufferPolicy{Size: 1})
+		require.NoError(t, b.Send(1))
+		sendErr := make(chan error)
+		go func() { sendErr <- b.Send(2) }() // blocked: buffer is full
+		cancel()
+		require.Equal(t, context.Canceled, <-sendErr)
+		_, err := b.Recv()
+		require.Equal(t, context.Canceled, err)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		b := newStreamBuffer(ctx, DefaultBufferPolicy)
+		_, err := b.Recv() // nothing sent
+		require.Equal(t, context.DeadlineExceeded, err)
+	})
+}
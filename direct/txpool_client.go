@@ -2,7 +2,6 @@ package direct
 
 import (
 	"context"
-	"io"
 
 	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
@@ -10,96 +9,170 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-var _ txpool_proto.TxpoolClient = (*TxPoolClient)(nil)
+var _ txpool_proto.TxpoolClient = (*TxPoolClientDirect)(nil) // compile-time interface check
 
-type TxPoolClient struct {
-	server txpool_proto.TxpoolServer
+// TxPoolClientDirect implements TxpoolClient interface by calling methods of TxpoolServer in same process:
+// without grpc serialization of transactions. OnAdd stream is adapted like SubscribeStream of EthBackendClientDirect
+type TxPoolClientDirect struct {
+	server       txpool_proto.TxpoolServer
+	bufferPolicy BufferPolicy
 }
 
-func NewTxPoolClient(server txpool_proto.TxpoolServer) *TxPoolClient {
-	return &TxPoolClient{server}
+func NewTxPoolClientDirect(server txpool_proto.TxpoolServer) *TxPoolClientDirect {
+	return &TxPoolClientDirect{server: server}
 }
 
-func (s *TxPoolClient) Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.VersionReply, error) {
+// Deprecated: use TxPoolClientDirect
+type TxPoolClient = TxPoolClientDirect
+
+// Deprecated: use NewTxPoolClientDirect
+func NewTxPoolClient(server txpool_proto.TxpoolServer) *TxPoolClientDirect {
+	return NewTxPoolClientDirect(server)
+}
+
+// WithBufferPolicy - buffering of server-stream methods, see BufferPolicy
+func (s *TxPoolClientDirect) WithBufferPolicy(policy BufferPolicy) *TxPoolClientDirect {
+	s.bufferPolicy = policy
+	return s
+}
+
+func (s *TxPoolClientDirect) Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.VersionReply, error) {
 	return s.server.Version(ctx, in)
 }
 
-func (s *TxPoolClient) FindUnknown(ctx context.Context, in *txpool_proto.TxHashes, opts ...grpc.CallOption) (*txpool_proto.TxHashes, error) {
+func (s *TxPoolClientDirect) FindUnknown(ctx context.Context, in *txpool_proto.TxHashes, opts ...grpc.CallOption) (*txpool_proto.TxHashes, error) {
 	return s.server.FindUnknown(ctx, in)
 }
 
-func (s *TxPoolClient) Add(ctx context.Context, in *txpool_proto.AddRequest, opts ...grpc.CallOption) (*txpool_proto.AddReply, error) {
+func (s *TxPoolClientDirect) Add(ctx context.Context, in *txpool_proto.AddRequest, opts ...grpc.CallOption) (*txpool_proto.AddReply, error) {
 	return s.server.Add(ctx, in)
 }
 
-func (s *TxPoolClient) Transactions(ctx context.Context, in *txpool_proto.TransactionsRequest, opts ...grpc.CallOption) (*txpool_proto.TransactionsReply, error) {
+func (s *TxPoolClientDirect) Transactions(ctx context.Context, in *txpool_proto.TransactionsRequest, opts ...grpc.CallOption) (*txpool_proto.TransactionsReply, error) {
 	return s.server.Transactions(ctx, in)
 }
 
-func (s *TxPoolClient) All(ctx context.Context, in *txpool_proto.AllRequest, opts ...grpc.CallOption) (*txpool_proto.AllReply, error) {
+func (s *TxPoolClientDirect) All(ctx context.Context, in *txpool_proto.AllRequest, opts ...grpc.CallOption) (*txpool_proto.AllReply, error) {
 	return s.server.All(ctx, in)
 }
 
-func (s *TxPoolClient) Pending(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*txpool_proto.PendingReply, error) {
+func (s *TxPoolClientDirect) Pending(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*txpool_proto.PendingReply, error) {
 	return s.server.Pending(ctx, in)
 }
 
 // -- start OnAdd
 
-func (s *TxPoolClient) OnAdd(ctx context.Context, in *txpool_proto.OnAddRequest, opts ...grpc.CallOption) (txpool_proto.Txpool_OnAddClient, error) {
-	ch := make(chan *onAddReply, 16384)
-	streamServer := &TxPoolOnAddS{ch: ch, ctx: ctx}
+func (s *TxPoolClientDirect) OnAdd(ctx context.Context, in *txpool_proto.OnAddRequest, opts ...grpc.CallOption) (txpool_proto.Txpool_OnAddClient, error) {
+	buf := newStreamBuffer(ctx, s.bufferPolicy)
+	streamServer := &TxPoolOnAddS{buf: buf, ctx: ctx}
 	go func() {
-		defer close(ch)
+		defer buf.Close()
 		streamServer.Err(s.server.OnAdd(in, streamServer))
 	}()
-	return &TxPoolOnAddC{ch: ch, ctx: ctx}, nil
-}
-
-type onAddReply struct {
-	r   *txpool_proto.OnAddReply
-	err error
+	return &TxPoolOnAddC{buf: buf, ctx: ctx}, nil
 }
 
 type TxPoolOnAddS struct {
-	ch  chan *onAddReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ServerStream
 }
 
 func (s *TxPoolOnAddS) Send(m *txpool_proto.OnAddReply) error {
-	s.ch <- &onAddReply{r: m}
-	return nil
+	return s.buf.Send(m)
 }
 func (s *TxPoolOnAddS) Context() context.Context { return s.ctx }
 func (s *TxPoolOnAddS) Err(err error) {
 	if err == nil {
 		return
 	}
-	s.ch <- &onAddReply{err: err}
+	s.buf.Err(err)
 }
 
 type TxPoolOnAddC struct {
-	ch  chan *onAddReply
+	buf *streamBuffer
 	ctx context.Context
 	grpc.ClientStream
 }
 
 func (c *TxPoolOnAddC) Recv() (*txpool_proto.OnAddReply, error) {
-	m, ok := <-c.ch
-	if !ok || m == nil {
-		return nil, io.EOF
+	m, err := c.buf.Recv()
+	if err != nil {
+		return nil, err
 	}
-	return m.r, m.err
+	return m.(*txpool_proto.OnAddReply), nil
 }
 func (c *TxPoolOnAddC) Context() context.Context { return c.ctx }
 
 // -- end OnAdd
 
-func (s *TxPoolClient) Status(ctx context.Context, in *txpool_proto.StatusRequest, opts ...grpc.CallOption) (*txpool_proto.StatusReply, error) {
+func (s *TxPoolClientDirect) Status(ctx context.Context, in *txpool_proto.StatusRequest, opts ...grpc.CallOption) (*txpool_proto.StatusReply, error) {
 	return s.server.Status(ctx, in)
 }
 
-func (s *TxPoolClient) Nonce(ctx context.Context, in *txpool_proto.NonceRequest, opts ...grpc.CallOption) (*txpool_proto.NonceReply, error) {
+func (s *TxPoolClientDirect) Nonce(ctx context.Context, in *txpool_proto.NonceRequest, opts ...grpc.CallOption) (*txpool_proto.NonceReply, error) {
 	return s.server.Nonce(ctx, in)
 }
+
+func (s *TxPoolClientDirect) CountEligible(ctx context.Context, in *txpool_proto.CountEligibleRequest, opts ...grpc.CallOption) (*txpool_proto.CountEligibleReply, error) {
+	return s.server.CountEligible(ctx, in)
+}
+
+func (s *TxPoolClientDirect) SetLimits(ctx context.Context, in *txpool_proto.SetLimitsRequest, opts ...grpc.CallOption) (*txpool_proto.SetLimitsReply, error) {
+	return s.server.SetLimits(ctx, in)
+}
+
+func (s *TxPoolClientDirect) PreCheck(ctx context.Context, in *txpool_proto.AddRequest, opts ...grpc.CallOption) (*txpool_proto.AddReply, error) {
+	return s.server.PreCheck(ctx, in)
+}
+
+func (s *TxPoolClientDirect) PagedAll(ctx context.Context, in *txpool_proto.PagedAllRequest, opts ...grpc.CallOption) (*txpool_proto.PagedAllReply, error) {
+	return s.server.PagedAll(ctx, in)
+}
+
+// -- start BestStream
+
+// BestStream - buffer holds 1 reply regardless of buffer policy: server reads next batch only when client received previous
+func (s *TxPoolClientDirect) BestStream(ctx context.Context, in *txpool_proto.BestStreamRequest, opts ...grpc.CallOption) (txpool_proto.Txpool_BestStreamClient, error) {
+	buf := newStreamBuffer(ctx, BufferPolicy{Size: 1})
+	streamServer := &TxPoolBestStreamS{buf: buf, ctx: ctx}
+	go func() {
+		defer buf.Close()
+		streamServer.Err(s.server.BestStream(in, streamServer))
+	}()
+	return &TxPoolBestStreamC{buf: buf, ctx: ctx}, nil
+}
+
+type TxPoolBestStreamS struct {
+	buf *streamBuffer
+	ctx context.Context
+	grpc.ServerStream
+}
+
+func (s *TxPoolBestStreamS) Send(m *txpool_proto.BestStreamReply) error {
+	return s.buf.Send(m)
+}
+func (s *TxPoolBestStreamS) Context() context.Context { return s.ctx }
+func (s *TxPoolBestStreamS) Err(err error) {
+	if err == nil {
+		return
+	}
+	s.buf.Err(err)
+}
+
+type TxPoolBestStreamC struct {
+	buf *streamBuffer
+	ctx context.Context
+	grpc.ClientStream
+}
+
+func (c *TxPoolBestStreamC) Recv() (*txpool_proto.BestStreamReply, error) {
+	m, err := c.buf.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return m.(*txpool_proto.BestStreamReply), nil
+}
+func (c *TxPoolBestStreamC) Context() context.Context { return c.ctx }
+
+// -- end BestStream
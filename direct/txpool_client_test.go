@@ -0,0 +1,106 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package direct
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	"github.com/stretchr/testify/require"
+)
+
+type testTxPoolServer struct {
+	txpool_proto.UnimplementedTxpoolServer
+	onAdd   [][][]byte
+	err     error
+	endless bool          // OnAdd sends until stream's Send fails
+	done    chan struct{} // closed when OnAdd returns
+}
+
+func (s *testTxPoolServer) Add(_ context.Context, in *txpool_proto.AddRequest) (*txpool_proto.AddReply, error) {
+	return &txpool_proto.AddReply{Errors: make([]string, len(in.RlpTxs))}, nil
+}
+
+func (s *testTxPoolServer) OnAdd(_ *txpool_proto.OnAddRequest, stream txpool_proto.Txpool_OnAddServer) error {
+	if s.done != nil {
+		defer close(s.done)
+	}
+	for s.endless {
+		if err := stream.Send(&txpool_proto.OnAddReply{}); err != nil {
+			return err
+		}
+	}
+	for _, txs := range s.onAdd {
+		if err := stream.Send(&txpool_proto.OnAddReply{RplTxs: txs}); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func TestTxPoolClientDirect(t *testing.T) {
+	ctx := context.Background()
+	server := &testTxPoolServer{onAdd: [][][]byte{{{1}}, {{2}, {3}}}}
+	client := NewTxPoolClientDirect(server)
+
+	reply, err := client.Add(ctx, &txpool_proto.AddRequest{RlpTxs: [][]byte{{1}, {2}}})
+	require.NoError(t, err)
+	require.Len(t, reply.Errors, 2)
+
+	stream, err := client.OnAdd(ctx, &txpool_proto.OnAddRequest{})
+	require.NoError(t, err)
+	for _, txs := range server.onAdd {
+		r, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, txs, r.RplTxs)
+	}
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	server.err = errors.New("pool closed")
+	stream, err = client.OnAdd(ctx, &txpool_proto.OnAddRequest{})
+	require.NoError(t, err)
+	for range server.onAdd {
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	_, err = stream.Recv()
+	require.EqualError(t, err, "pool closed")
+}
+
+func TestTxPoolClientDirectCancel(t *testing.T) {
+	server := &testTxPoolServer{endless: true, done: make(chan struct{})}
+	client := NewTxPoolClientDirect(server).WithBufferPolicy(BufferPolicy{Size: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.OnAdd(ctx, &txpool_proto.OnAddRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	cancel()
+	select { // server must not leak, even if it's blocked on full buffer
+	case <-server.done:
+	case <-time.After(time.Second):
+		t.Fatal("server goroutine didn't stop after cancellation of client's context")
+	}
+	_, err = stream.Recv()
+	require.Equal(t, context.Canceled, err)
+}
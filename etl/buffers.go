@@ -111,7 +111,10 @@ func (b *sortableBuffer) Get(i int) sortableBufferEntry {
 }
 
 func (b *sortableBuffer) Reset() {
-	b.entries = nil
+	for i := range b.entries { // release keys/values, but keep capacity for reuse
+		b.entries[i] = sortableBufferEntry{}
+	}
+	b.entries = b.entries[:0]
 	b.size = 0
 }
 func (b *sortableBuffer) Sort() {
@@ -186,8 +189,13 @@ func (b *appendSortableBuffer) Get(i int) sortableBufferEntry {
 	return b.sortedBuf[i]
 }
 func (b *appendSortableBuffer) Reset() {
-	b.sortedBuf = nil
-	b.entries = make(map[string][]byte)
+	for i := range b.sortedBuf {
+		b.sortedBuf[i] = sortableBufferEntry{}
+	}
+	b.sortedBuf = b.sortedBuf[:0]
+	for k := range b.entries {
+		delete(b.entries, k)
+	}
 	b.size = 0
 }
 
@@ -261,8 +269,13 @@ func (b *oldestEntrySortableBuffer) Get(i int) sortableBufferEntry {
 	return b.sortedBuf[i]
 }
 func (b *oldestEntrySortableBuffer) Reset() {
-	b.sortedBuf = nil
-	b.entries = make(map[string][]byte)
+	for i := range b.sortedBuf {
+		b.sortedBuf[i] = sortableBufferEntry{}
+	}
+	b.sortedBuf = b.sortedBuf[:0]
+	for k := range b.entries {
+		delete(b.entries, k)
+	}
 	b.size = 0
 }
 
@@ -299,3 +312,17 @@ func getTypeByBuffer(b Buffer) int {
 		panic(fmt.Sprintf("unknown buffer type: %T ", b))
 	}
 }
+
+// optimalSizeOfBuffer - size of entries at which buffer is flushed
+func optimalSizeOfBuffer(b Buffer) int {
+	switch b := b.(type) {
+	case *sortableBuffer:
+		return b.optimalSize
+	case *appendSortableBuffer:
+		return b.optimalSize
+	case *oldestEntrySortableBuffer:
+		return b.optimalSize
+	default:
+		return int(BufferOptimalSize.Bytes())
+	}
+}
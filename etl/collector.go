@@ -18,7 +18,6 @@ package etl
 
 import (
 	"bytes"
-	"container/heap"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -27,6 +26,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/c2h5oh/datasize"
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
@@ -36,6 +36,8 @@ import (
 
 const TmpDirName = "etl-temp"
 
+var appendFallbackCounter = metrics.NewCounter(`etl_append_fallback_total`)
+
 type LoadNextFunc func(originalK, k, v []byte) error
 type LoadFunc func(k, v []byte, table CurrentTableReader, next LoadNextFunc) error
 
@@ -44,36 +46,50 @@ type LoadFunc func(k, v []byte, table CurrentTableReader, next LoadNextFunc) err
 type Collector struct {
 	extractNextFunc ExtractNextFunc
 	flushBuffer     func([]byte, bool) error
+	flushTransform  FlushTransformFunc
 	dataProviders   []dataProvider
+	buf             Buffer
 	allFlushed      bool
 	autoClean       bool
 	noLogs          bool
 	bufType         int
 	logPrefix       string
+	dirs            []string // collector's own subdirectories of tmpdir, removed by Close
+	sorted          *sortedInput
+	stats           CollectorStats
+	metrics         collectorMetrics
+	unflushed       uint64 // entries Put into buf since last flush, to count duplicates merged by buffer
 }
 
 // NewCollectorFromFiles creates collector from existing files (left over from previous unsuccessful loading)
+// Only files of the collectors with the same logPrefix are picked up
 func NewCollectorFromFiles(logPrefix, tmpdir string) (*Collector, error) {
 	if _, err := os.Stat(tmpdir); os.IsNotExist(err) {
 		return nil, nil
 	}
-	fileInfos, err := ioutil.ReadDir(tmpdir)
+	dirs, err := collectorDirs(tmpdir, logPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("collector from files - reading directory %s: %w", tmpdir, err)
 	}
-	if len(fileInfos) == 0 {
-		return nil, nil
-	}
-	dataProviders := make([]dataProvider, len(fileInfos))
-	for i, fileInfo := range fileInfos {
-		var dataProvider fileDataProvider
-		dataProvider.file, err = os.Open(filepath.Join(tmpdir, fileInfo.Name()))
+	var dataProviders []dataProvider
+	for _, dir := range dirs {
+		fileInfos, err := ioutil.ReadDir(dir)
 		if err != nil {
-			return nil, fmt.Errorf("collector from files - opening file %s: %w", fileInfo.Name(), err)
+			return nil, fmt.Errorf("collector from files - reading directory %s: %w", dir, err)
+		}
+		for _, fileInfo := range fileInfos {
+			var dataProvider fileDataProvider
+			dataProvider.file, err = os.Open(filepath.Join(dir, fileInfo.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("collector from files - opening file %s: %w", fileInfo.Name(), err)
+			}
+			dataProviders = append(dataProviders, &dataProvider)
 		}
-		dataProviders[i] = &dataProvider
 	}
-	return &Collector{dataProviders: dataProviders, allFlushed: true, autoClean: false, logPrefix: logPrefix}, nil
+	if len(dataProviders) == 0 {
+		return nil, nil
+	}
+	return &Collector{dataProviders: dataProviders, allFlushed: true, autoClean: false, logPrefix: logPrefix, dirs: dirs, metrics: newCollectorMetrics(logPrefix)}, nil
 }
 
 // NewCriticalCollector does not clean up temporary files if loading has failed
@@ -84,7 +100,11 @@ func NewCriticalCollector(logPrefix, tmpdir string, sortableBuffer Buffer) *Coll
 }
 
 func NewCollector(logPrefix, tmpdir string, sortableBuffer Buffer) *Collector {
-	c := &Collector{autoClean: true, bufType: getTypeByBuffer(sortableBuffer), logPrefix: logPrefix}
+	c := &Collector{autoClean: true, buf: sortableBuffer, bufType: getTypeByBuffer(sortableBuffer), logPrefix: logPrefix, metrics: newCollectorMetrics(logPrefix)}
+	dir := newCollectorDir(tmpdir, logPrefix)
+	if dir != "" {
+		c.dirs = []string{dir}
+	}
 	encoder := codec.NewEncoder(nil, &cbor)
 
 	c.flushBuffer = func(currentKey []byte, canStoreInRam bool) error {
@@ -94,16 +114,26 @@ func NewCollector(logPrefix, tmpdir string, sortableBuffer Buffer) *Collector {
 		var provider dataProvider
 		var err error
 		sortableBuffer.Sort()
+		c.statDuplicates(c.unflushed - distinctKeys(sortableBuffer.GetEntries()))
+		c.unflushed = 0
+		if c.flushTransform != nil {
+			if err = transformEntries(sortableBuffer.GetEntries(), c.flushTransform); err != nil {
+				return err
+			}
+		}
 		if canStoreInRam && len(c.dataProviders) == 0 {
 			provider = KeepInRAM(sortableBuffer)
 			c.allFlushed = true
 		} else {
 			doFsync := !c.autoClean /* is critical collector */
-			provider, err = FlushToDisk(encoder, sortableBuffer, tmpdir, doFsync, c.noLogs)
+			provider, err = FlushToDisk(encoder, sortableBuffer, dir, doFsync, c.noLogs)
 		}
 		if err != nil {
 			return err
 		}
+		if !c.allFlushed {
+			c.statFlush()
+		}
 		if provider != nil {
 			c.dataProviders = append(c.dataProviders, provider)
 		}
@@ -111,6 +141,8 @@ func NewCollector(logPrefix, tmpdir string, sortableBuffer Buffer) *Collector {
 	}
 
 	c.extractNextFunc = func(originalK, k []byte, v []byte) error {
+		c.statCollect(k, v)
+		c.unflushed++
 		sortableBuffer.Put(common.Copy(k), common.Copy(v))
 		if sortableBuffer.CheckFlushSize() {
 			if err := c.flushBuffer(originalK, false); err != nil {
@@ -128,6 +160,33 @@ func (c *Collector) Collect(k, v []byte) error {
 
 func (c *Collector) NoLogs(v bool) { c.noLogs = v }
 
+// SetFlushTransform - values are transformed once per flush instead of once per Load, and temp files keep
+// the transformed values. Must be set before the first Collect
+func (c *Collector) SetFlushTransform(f FlushTransformFunc) { c.flushTransform = f }
+
+// distinctKeys - amount of different keys in sorted entries
+func distinctKeys(entries []sortableBufferEntry) uint64 {
+	n := uint64(len(entries))
+	for i := 1; i < len(entries); i++ {
+		if bytes.Equal(entries[i-1].key, entries[i].key) {
+			n--
+		}
+	}
+	return n
+}
+
+// transformEntries - applies FlushTransformFunc to values of sorted entries, keys are left as is
+func transformEntries(entries []sortableBufferEntry, f FlushTransformFunc) error {
+	for i := range entries {
+		v, err := f(entries[i].key, entries[i].value)
+		if err != nil {
+			return fmt.Errorf("flush transform of key %x: %w", entries[i].key, err)
+		}
+		entries[i].value = v
+	}
+	return nil
+}
+
 func (c *Collector) Load(db kv.RwTx, toBucket string, loadFunc LoadFunc, args TransformArgs) error {
 	defer func() {
 		if c.autoClean {
@@ -139,6 +198,11 @@ func (c *Collector) Load(db kv.RwTx, toBucket string, loadFunc LoadFunc, args Tr
 			return e
 		}
 	}
+	if !c.noLogs {
+		log.Debug(fmt.Sprintf("[%s] etl: collector stats", c.logPrefix), "keys", c.stats.Keys,
+			"duplicates", fmt.Sprintf("%.2f", c.stats.DuplicateRatio()), "flushes", c.stats.Flushes,
+			"key sizes", c.stats.KeyLen.String(), "value sizes", c.stats.ValueLen.String())
+	}
 	if err := loadFilesIntoBucket(c.logPrefix, db, toBucket, c.bufType, c.dataProviders, loadFunc, args); err != nil {
 		return err
 	}
@@ -146,6 +210,9 @@ func (c *Collector) Load(db kv.RwTx, toBucket string, loadFunc LoadFunc, args Tr
 }
 
 func (c *Collector) Close() {
+	if c.sorted != nil {
+		c.sorted.close()
+	}
 	totalSize := uint64(0)
 	for _, p := range c.dataProviders {
 		totalSize += p.Dispose()
@@ -153,28 +220,89 @@ func (c *Collector) Close() {
 	if totalSize > 0 {
 		log.Info(fmt.Sprintf("[%s] etl: temp files removed", c.logPrefix), "total size", datasize.ByteSize(totalSize).HumanReadable())
 	}
+	for _, dir := range c.dirs {
+		// os.Remove keeps non-empty dir, files of other collectors are never there anyway
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			log.Warn(fmt.Sprintf("[%s] etl: can't remove temp dir", c.logPrefix), "dir", dir, "err", err)
+		}
+	}
+	if c.buf != nil {
+		PutBuffer(c.buf) // no-op if buffer was not borrowed from pool
+		c.buf = nil
+	}
 }
 
-func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType int, providers []dataProvider, loadFunc LoadFunc, args TransformArgs) error {
-	decoder := codec.NewDecoder(nil, &cbor)
-	var m runtime.MemStats
+// Iterate - walks over collected data in sorted order, without writing it into any DB table.
+// Useful for consumers which need a sorted stream (for example, to build recsplit index)
+func (c *Collector) Iterate(walker func(k, v []byte) error) error {
+	defer func() {
+		if c.autoClean {
+			c.Close()
+		}
+	}()
+	if !c.allFlushed {
+		if e := c.flushBuffer(nil, true); e != nil {
+			return e
+		}
+	}
+	var prevK []byte
+	return mergeSortFiles(c.logPrefix, c.dataProviders, func(k, v []byte) error {
+		// see comment about SortableOldestAppearedBuffer in loadFilesIntoBucket
+		if c.bufType == SortableOldestAppearedBuffer && bytes.Equal(prevK, k) {
+			return nil
+		}
+		prevK = k
+		return walker(k, v)
+	}, TransformArgs{})
+}
 
-	h := &Heap{comparator: args.Comparator}
-	heap.Init(h)
+// mergeSortFiles - does k-way merge of sorted providers and calls walker for each element in sorted order
+// newProvidersHeap - heap of first entries of all providers, for merge-sorting them
+func newProvidersHeap(logPrefix string, providers []dataProvider, decoder Decoder, comparator kv.CmpFunc) *Heap {
+	h := NewHeap(comparator, len(providers))
 	for i, provider := range providers {
 		if key, value, err := provider.Next(decoder); err == nil {
-			he := HeapElem{key, i, value}
-			heap.Push(h, he)
+			h.Push(HeapElem{key, i, value})
 		} else /* we must have at least one entry per file */ {
 			eee := fmt.Errorf("%s: error reading first readers: n=%d current=%d provider=%s err=%w",
 				logPrefix, len(providers), i, provider, err)
 			panic(eee)
 		}
 	}
+	return h
+}
+
+func mergeSortFiles(logPrefix string, providers []dataProvider, walker func(k, v []byte) error, args TransformArgs) error {
+	decoder := codec.NewDecoder(nil, &cbor)
+	h := newProvidersHeap(logPrefix, providers, decoder, args.Comparator)
+	for h.Len() > 0 {
+		if err := common.Stopped(args.Quit); err != nil {
+			return err
+		}
+
+		element := h.Top()
+		provider := providers[element.TimeIdx]
+		err := walker(element.Key, element.Value)
+		if err != nil {
+			return err
+		}
+		if element.Key, element.Value, err = provider.Next(decoder); err == nil {
+			h.ReplaceTop(element)
+		} else if err == io.EOF {
+			h.Pop()
+		} else {
+			return fmt.Errorf("%s: error while reading next element from disk: %w", logPrefix, err)
+		}
+	}
+	return nil
+}
+
+func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType int, providers []dataProvider, loadFunc LoadFunc, args TransformArgs) error {
+	var m runtime.MemStats
 	var c kv.RwCursor
 
-	currentTable := &currentTableReader{db, bucket}
-	haveSortingGuaranties := isIdentityLoadFunc(loadFunc) // user-defined loadFunc may change ordering
+	var currentTable CurrentTableReader = &currentTableReader{db, bucket}
+	haveSortingGuaranties := args.Ordered || isIdentityLoadFunc(loadFunc) // user-defined loadFunc may change ordering
 	var lastKey []byte
 	if bucket != "" { // passing empty bucket name is valid case for etl when DB modification is not expected
 		var err error
@@ -190,12 +318,23 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 	}
 	var canUseAppend bool
 	isDupSort := kv.ChaindataTablesCfg[bucket].Flags&kv.DupSort != 0 && !kv.ChaindataTablesCfg[bucket].AutoDupSortKeysConversion
+	if c != nil && lastKey == nil && args.Comparator == nil && isIdentityLoadFunc(loadFunc) {
+		return appendFilesIntoBucket(logPrefix, c, bucket, bufType, providers, isDupSort, args)
+	}
+	var cached *cachedTableReader
+	if c != nil && args.CurrentTableCacheSize > 0 {
+		var err error
+		if cached, err = newCachedTableReader(&currentTableReader{db, bucket}, args.CurrentTableCacheSize, isDupSort); err != nil {
+			return err
+		}
+		currentTable = cached
+	}
 
 	logEvery := time.NewTicker(30 * time.Second)
 	defer logEvery.Stop()
 
 	i := 0
-	var prevK []byte
+	var prevK, appendedK, appendedV []byte
 	loadNextFunc := func(originalK, k, v []byte) error {
 		if i == 0 {
 			isEndOfBucket := lastKey == nil || bytes.Compare(lastKey, k) == -1
@@ -214,18 +353,16 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 		select {
 		default:
 		case <-logEvery.C:
-			logArs := []interface{}{"into", bucket}
-			if args.LogDetailsLoad != nil {
-				logArs = append(logArs, args.LogDetailsLoad(k, v)...)
-			} else {
-				logArs = append(logArs, "current key", makeCurrentKeyStr(k))
-			}
-
-			runtime.ReadMemStats(&m)
-			logArs = append(logArs, "alloc", common.ByteCount(m.Alloc), "sys", common.ByteCount(m.Sys))
-			log.Info(fmt.Sprintf("[%s] ETL [2/2] Loading", logPrefix), logArs...)
+			logLoadProgress(logPrefix, bucket, k, v, args)
 		}
 
+		if canUseAppend && appendedK != nil && !isAppendOrdered(appendedK, appendedV, k, v, isDupSort) {
+			// loadFunc broke ordering - Append will fail on such keys, switch to Put once and don't try again
+			canUseAppend = false
+			appendFallbackCounter.Inc()
+			log.Warn(fmt.Sprintf("[%s] ETL: loadFunc produced out-of-order keys, fallback from Append to Put", logPrefix),
+				"bucket", bucket, "prev", fmt.Sprintf("%x", appendedK), "key", fmt.Sprintf("%x", k))
+		}
 		if canUseAppend && len(v) == 0 {
 			return nil // nothing to delete after end of bucket
 		}
@@ -233,8 +370,14 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 			if err := c.Delete(k, nil); err != nil {
 				return err
 			}
+			if cached != nil {
+				cached.delete(k)
+			}
 			return nil
 		}
+		if cached != nil {
+			cached.put(k, v)
+		}
 		if canUseAppend {
 			if isDupSort {
 				if err := c.(kv.RwCursorDupSort).AppendDup(k, v); err != nil {
@@ -245,7 +388,7 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 					return fmt.Errorf("%s: bucket: %s, append: k=%x, v=%x, %w", logPrefix, bucket, k, v, err)
 				}
 			}
-
+			appendedK, appendedV = k, v
 			return nil
 		}
 		if err := c.Put(k, v); err != nil {
@@ -254,32 +397,117 @@ func loadFilesIntoBucket(logPrefix string, db kv.RwTx, bucket string, bufType in
 		return nil
 	}
 	// Main loading loop
+	if err := mergeSortFiles(logPrefix, providers, func(k, v []byte) error {
+		return loadFunc(k, v, currentTable, loadNextFunc)
+	}, args); err != nil {
+		return err
+	}
+
+	runtime.ReadMemStats(&m)
+	log.Trace(
+		fmt.Sprintf("[%s] ETL Load done", logPrefix),
+		"bucket", bucket,
+		"records", i,
+		"alloc", common.ByteCount(m.Alloc), "sys", common.ByteCount(m.Sys))
+
+	return nil
+}
+
+// appendFilesIntoBucket - fast path of loadFilesIntoBucket for IdentityLoadFunc and empty bucket:
+// merge-sorted entries of providers go straight to cursor.Append, without walker and loadNextFunc closures
+func appendFilesIntoBucket(logPrefix string, c kv.RwCursor, bucket string, bufType int, providers []dataProvider, isDupSort bool, args TransformArgs) error {
+	logEvery := time.NewTicker(30 * time.Second)
+	defer logEvery.Stop()
+
+	decoder := codec.NewDecoder(nil, &cbor)
+	h := newProvidersHeap(logPrefix, providers, decoder, nil)
+	canUseAppend := true
+	i := 0
+	var prevK, appendedK, appendedV []byte
 	for h.Len() > 0 {
 		if err := common.Stopped(args.Quit); err != nil {
 			return err
 		}
+		element := h.Top()
+		k, v := element.Key, element.Value
+		i++
+		// see loadFilesIntoBucket: files of SortableOldestAppearedBuffer may overlap, skip repeated keys
+		if !(bufType == SortableOldestAppearedBuffer && bytes.Equal(prevK, k)) {
+			prevK = k
+
+			select {
+			default:
+			case <-logEvery.C:
+				logLoadProgress(logPrefix, bucket, k, v, args)
+			}
+
+			if canUseAppend && appendedK != nil && !isAppendOrdered(appendedK, appendedV, k, v, isDupSort) {
+				// values of the same DupSort key may come from different files out of order
+				canUseAppend = false
+				appendFallbackCounter.Inc()
+				log.Warn(fmt.Sprintf("[%s] ETL: providers produced out-of-order entries, fallback from Append to Put", logPrefix),
+					"bucket", bucket, "prev", fmt.Sprintf("%x", appendedK), "key", fmt.Sprintf("%x", k))
+			}
+			switch {
+			case len(v) == 0 && canUseAppend:
+				// nothing to delete in empty bucket
+			case len(v) == 0:
+				if err := c.Delete(k, nil); err != nil {
+					return err
+				}
+			case !canUseAppend:
+				if err := c.Put(k, v); err != nil {
+					return fmt.Errorf("%s: put: k=%x, %w", logPrefix, k, err)
+				}
+			case isDupSort:
+				if err := c.(kv.RwCursorDupSort).AppendDup(k, v); err != nil {
+					return fmt.Errorf("%s: bucket: %s, appendDup: k=%x, %w", logPrefix, bucket, k, err)
+				}
+				appendedK, appendedV = k, v
+			default:
+				if err := c.Append(k, v); err != nil {
+					return fmt.Errorf("%s: bucket: %s, append: k=%x, v=%x, %w", logPrefix, bucket, k, v, err)
+				}
+				appendedK, appendedV = k, v
+			}
+		}
 
-		element := (heap.Pop(h)).(HeapElem)
+		var err error
 		provider := providers[element.TimeIdx]
-		err := loadFunc(element.Key, element.Value, currentTable, loadNextFunc)
-		if err != nil {
-			return err
-		}
 		if element.Key, element.Value, err = provider.Next(decoder); err == nil {
-			heap.Push(h, element)
-		} else if err != io.EOF {
+			h.ReplaceTop(element)
+		} else if err == io.EOF {
+			h.Pop()
+		} else {
 			return fmt.Errorf("%s: error while reading next element from disk: %w", logPrefix, err)
 		}
 	}
 
+	log.Trace(fmt.Sprintf("[%s] ETL Load done", logPrefix), "bucket", bucket, "records", i, "append", canUseAppend)
+	return nil
+}
+
+func logLoadProgress(logPrefix, bucket string, k, v []byte, args TransformArgs) {
+	var m runtime.MemStats
+	logArs := []interface{}{"into", bucket}
+	if args.LogDetailsLoad != nil {
+		logArs = append(logArs, args.LogDetailsLoad(k, v)...)
+	} else {
+		logArs = append(logArs, "current key", makeCurrentKeyStr(k))
+	}
+
 	runtime.ReadMemStats(&m)
-	log.Trace(
-		fmt.Sprintf("[%s] ETL Load done", logPrefix),
-		"bucket", bucket,
-		"records", i,
-		"alloc", common.ByteCount(m.Alloc), "sys", common.ByteCount(m.Sys))
+	logArs = append(logArs, "alloc", common.ByteCount(m.Alloc), "sys", common.ByteCount(m.Sys))
+	log.Info(fmt.Sprintf("[%s] ETL [2/2] Loading", logPrefix), logArs...)
+}
 
-	return nil
+// isAppendOrdered - checks that (k, v) can be appended after (prevK, prevV)
+func isAppendOrdered(prevK, prevV, k, v []byte, isDupSort bool) bool {
+	c := bytes.Compare(prevK, k)
+	if c != 0 || !isDupSort {
+		return c < 0
+	}
+	return bytes.Compare(prevV, v) < 0
 }
 
 func makeCurrentKeyStr(k []byte) string {
@@ -23,11 +23,16 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"sync"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/log/v3"
+	"github.com/ugorji/go/codec"
 )
 
+// readAheadBatchSize - amount of entries decoded from file by read-ahead goroutine at once
+const readAheadBatchSize = 4096
+
 type dataProvider interface {
 	Next(decoder Decoder) ([]byte, []byte, error)
 	Dispose() uint64 // Safe for repeated call, doesn't return error - means defer-friendly
@@ -37,6 +42,18 @@ type fileDataProvider struct {
 	file      *os.File
 	reader    io.Reader
 	resultBuf [][]byte
+
+	// read-ahead goroutine decodes entries in batches, to keep merge heap fed while waiting for disk
+	readAhead chan readAheadBatch
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	batch     readAheadBatch
+	pos       int
+}
+
+type readAheadBatch struct {
+	entries []sortableBufferEntry
+	err     error // io.EOF at the end of file
 }
 
 type Encoder interface {
@@ -89,19 +106,59 @@ func FlushToDisk(encoder Encoder, b Buffer, tmpdir string, doFsync, noLogs bool)
 	return &fileDataProvider{file: bufferFile, reader: nil, resultBuf: make([][]byte, 2)}, nil
 }
 
-func (p *fileDataProvider) Next(decoder Decoder) ([]byte, []byte, error) {
+func (p *fileDataProvider) Next(_ Decoder) ([]byte, []byte, error) {
 	if p.reader == nil {
 		_, err := p.file.Seek(0, 0)
 		if err != nil {
 			return nil, nil, err
 		}
 		p.reader = bufio.NewReaderSize(p.file, BufIOSize)
+		p.resultBuf = make([][]byte, 2)
+		p.readAhead, p.quit = make(chan readAheadBatch, 2), make(chan struct{})
+		p.wg.Add(1)
+		go p.readAheadLoop()
+	}
+	for p.pos >= len(p.batch.entries) {
+		if p.batch.err != nil {
+			return nil, nil, p.batch.err
+		}
+		p.batch, p.pos = <-p.readAhead, 0
+	}
+	entry := p.batch.entries[p.pos]
+	p.pos++
+	return entry.key, entry.value, nil
+}
+
+func (p *fileDataProvider) readAheadLoop() {
+	defer p.wg.Done()
+	decoder := codec.NewDecoder(p.reader, &cbor)
+	for {
+		batch := readAheadBatch{entries: make([]sortableBufferEntry, 0, readAheadBatchSize)}
+		for len(batch.entries) < readAheadBatchSize {
+			k, v, err := readElementFromDisk(p.resultBuf, decoder)
+			if err != nil {
+				batch.err = err
+				break
+			}
+			batch.entries = append(batch.entries, sortableBufferEntry{k, v})
+		}
+		select {
+		case p.readAhead <- batch:
+		case <-p.quit:
+			return
+		}
+		if batch.err != nil {
+			return
+		}
 	}
-	decoder.Reset(p.reader)
-	return readElementFromDisk(p.resultBuf, decoder)
 }
 
 func (p *fileDataProvider) Dispose() uint64 {
+	if p.quit != nil { // stop read-ahead before closing file
+		close(p.quit)
+		p.wg.Wait()
+		p.quit = nil
+	}
 	info, _ := os.Stat(p.file.Name())
 	_ = p.file.Close()
 	_ = os.Remove(p.file.Name())
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/c2h5oh/datasize"
+	"github.com/hashicorp/golang-lru/simplelru"
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/log/v3"
@@ -47,6 +48,11 @@ type CurrentTableReader interface {
 type ExtractNextFunc func(originalK, k []byte, v []byte) error
 type ExtractFunc func(k []byte, v []byte, next ExtractNextFunc) error
 
+// FlushTransformFunc - transforms value right before the sorted buffer is flushed (to disk or kept in RAM),
+// for example to normalize or compress it. Key can't be changed - it would break the order.
+// With SortableAppendBuffer values of the same key from different flushes are still concatenated on load
+type FlushTransformFunc func(k, v []byte) ([]byte, error)
+
 // NextKey generates the possible next key w/o changing the key length.
 // for [0x01, 0x01, 0x01] it will generate [0x01, 0x01, 0x02], etc
 func NextKey(key []byte) ([]byte, error) {
@@ -85,6 +91,20 @@ type TransformArgs struct {
 	LogDetailsLoad    AdditionalLogArguments
 
 	Comparator kv.CmpFunc
+
+	// Ordered - caller guarantees that loadFunc doesn't change ordering of keys, then Append can be used.
+	// If out-of-order keys still appear - loading falls back to Put (see `etl_append_fallback_total` metric)
+	Ordered bool
+
+	// FlushTransform - optional, see Collector.SetFlushTransform
+	FlushTransform FlushTransformFunc
+
+	// SortedInput - extractFunc produces keys in ascending order, see Collector.SetSortedInput
+	SortedInput bool
+
+	// CurrentTableCacheSize - if > 0, CurrentTableReader passed to loadFunc keeps up to this many recently
+	// read entries of the target bucket (updated by loading), for loadFuncs doing read-modify-write of the same keys
+	CurrentTableCacheSize int
 }
 
 func Transform(
@@ -101,8 +121,10 @@ func Transform(
 	if args.BufferSize > 0 {
 		bufferSize = datasize.ByteSize(args.BufferSize)
 	}
-	buffer := getBufferByType(args.BufferType, bufferSize)
+	buffer := GetBuffer(args.BufferType, bufferSize)
 	collector := NewCollector(logPrefix, tmpdir, buffer)
+	collector.SetFlushTransform(args.FlushTransform)
+	collector.SetSortedInput(args.SortedInput)
 	defer collector.Close()
 
 	t := time.Now()
@@ -177,6 +199,52 @@ func (s *currentTableReader) Get(key []byte) ([]byte, error) {
 	return s.getter.GetOne(s.bucket, key)
 }
 
+// cachedTableReader - LRU cache over currentTableReader, lives for one Load.
+// Write-through: loading updates cached entries (see put/delete), so loadFunc never sees stale value
+type cachedTableReader struct {
+	reader    *currentTableReader
+	cache     *simplelru.LRU // string(key) -> []byte, nil for absent key
+	isDupSort bool
+}
+
+func newCachedTableReader(reader *currentTableReader, size int, isDupSort bool) (*cachedTableReader, error) {
+	cache, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTableReader{reader: reader, cache: cache, isDupSort: isDupSort}, nil
+}
+
+func (s *cachedTableReader) Get(key []byte) ([]byte, error) {
+	if v, ok := s.cache.Get(string(key)); ok {
+		return v.([]byte), nil
+	}
+	v, err := s.reader.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	v = common.Copy(v) // value returned by db is valid only until next write
+	s.cache.Add(string(key), v)
+	return v, nil
+}
+
+func (s *cachedTableReader) put(k, v []byte) {
+	if s.isDupSort {
+		// Put adds one more value of the key, Get returns the smallest one - just forget the key
+		s.cache.Remove(string(k))
+		return
+	}
+	if s.cache.Contains(string(k)) {
+		s.cache.Add(string(k), common.Copy(v))
+	}
+}
+
+func (s *cachedTableReader) delete(k []byte) {
+	if s.cache.Contains(string(k)) {
+		s.cache.Add(string(k), []byte(nil))
+	}
+}
+
 // IdentityLoadFunc loads entries as they are, without transformation
 var IdentityLoadFunc LoadFunc = func(k []byte, value []byte, _ CurrentTableReader, next LoadNextFunc) error {
 	return next(k, k, value)
@@ -17,13 +17,17 @@ package etl
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/c2h5oh/datasize"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
 	"github.com/stretchr/testify/assert"
@@ -269,6 +273,81 @@ func TestTransformDoubleOnLoad(t *testing.T) {
 	compareBucketsDouble(t, tx, sourceBucket, destBucket)
 }
 
+func TestTransformOrderedFallback(t *testing.T) {
+	// test invariant when loadFunc claims ordering but reverses keys: loading must fall back from Append to Put
+	_, tx := memdb.NewTestTx(t)
+	sourceBucket := kv.ChaindataTables[0]
+	destBucket := kv.ChaindataTables[1]
+	generateTestData(t, tx, sourceBucket, 10)
+	invert := func(k []byte) []byte {
+		res := make([]byte, len(k))
+		for i := range k {
+			res[i] = ^k[i]
+		}
+		return res
+	}
+	err := Transform(
+		"logPrefix",
+		tx,
+		sourceBucket,
+		destBucket,
+		"", // temp dir
+		testExtractToMapFunc,
+		func(k []byte, v []byte, table CurrentTableReader, next LoadNextFunc) error {
+			return testLoadFromMapFunc(k, v, table, func(originalK, k, v []byte) error {
+				return next(originalK, invert(k), v)
+			})
+		},
+		TransformArgs{Ordered: true},
+	)
+	assert.Nil(t, err)
+	count := 0
+	err = tx.ForEach(sourceBucket, nil, func(k, v []byte) error {
+		v2, err := tx.GetOne(destBucket, invert(k))
+		assert.NoError(t, err)
+		assert.Equal(t, v, v2)
+		count++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestCollectorIterate(t *testing.T) {
+	// test invariant when we go through files (> 1 buffer) and don't touch DB
+	collector := NewCollector("logPrefix", t.TempDir(), NewSortableBuffer(1))
+	for i := 9; i >= 0; i-- {
+		k := []byte(fmt.Sprintf("key-%02d", i))
+		assert.NoError(t, collector.Collect(k, []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	i := 0
+	err := collector.Iterate(func(k, v []byte) error {
+		assert.Equal(t, fmt.Sprintf("key-%02d", i), string(k))
+		assert.Equal(t, fmt.Sprintf("value-%02d", i), string(v))
+		i++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, i)
+}
+
+func TestBufferPool(t *testing.T) {
+	b := GetBuffer(SortableSliceBuffer, 1024)
+	b.Put([]byte("key"), []byte("value"))
+	PutBuffer(b)
+	assert.Equal(t, 0, b.Len())
+	PutBuffer(b) // repeated call is no-op
+
+	b2 := GetBuffer(SortableSliceBuffer, 2048)
+	assert.Equal(t, 0, b2.Len())
+	b2.Put([]byte("key"), []byte("value"))
+	assert.False(t, b2.CheckFlushSize())
+
+	collector := NewCollector("logPrefix", t.TempDir(), b2)
+	collector.Close() // returns borrowed buffer
+	assert.Equal(t, 0, b2.Len())
+}
+
 func generateTestData(t *testing.T, db kv.Putter, bucket string, count int) {
 	for i := 0; i < count; i++ {
 		k := []byte(fmt.Sprintf("%10d-key-%010d", i, i))
@@ -377,3 +456,324 @@ func compareBucketsDouble(t *testing.T, db kv.Tx, b1, b2 string) {
 	assert.NoError(t, err)
 	assert.Equal(t, b1Map, b2Map)
 }
+
+func TestLoadIdentityIntoEmptyBucket(t *testing.T) {
+	// identity loadFunc into empty bucket takes the Append fast path, files (> 1 buffer) may repeat keys
+	_, tx := memdb.NewTestTx(t)
+	destBucket := kv.ChaindataTables[1]
+	collector := NewCollector("logPrefix", t.TempDir(), NewOldestEntryBuffer(1))
+	defer collector.Close()
+	for i := 9; i >= 0; i-- {
+		assert.NoError(t, collector.Collect([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+	}
+	assert.NoError(t, collector.Collect([]byte("key-03"), []byte("newer")))
+	assert.NoError(t, collector.Collect([]byte("key-10"), nil))
+	assert.NoError(t, collector.Load(tx, destBucket, IdentityLoadFunc, TransformArgs{}))
+
+	i := 0
+	err := tx.ForEach(destBucket, nil, func(k, v []byte) error {
+		assert.Equal(t, fmt.Sprintf("key-%02d", i), string(k))
+		assert.Equal(t, fmt.Sprintf("value-%02d", i), string(v))
+		i++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, i)
+}
+
+func BenchmarkLoadIntoEmptyBucket(b *testing.B) {
+	// generic path is forced by loadFunc, which is identity but not IdentityLoadFunc
+	genericLoadFunc := func(k, v []byte, _ CurrentTableReader, next LoadNextFunc) error { return next(k, k, v) }
+	for _, bc := range []struct {
+		name     string
+		loadFunc LoadFunc
+	}{{"identity", IdentityLoadFunc}, {"generic", genericLoadFunc}} {
+		b.Run(bc.name, func(b *testing.B) {
+			db := memdb.NewTestDB(b)
+			destBucket := kv.ChaindataTables[1]
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				tx, err := db.BeginRw(context.Background())
+				if err != nil {
+					b.Fatal(err)
+				}
+				collector := NewCollector("logPrefix", b.TempDir(), NewSortableBuffer(BufferOptimalSize))
+				for i := 0; i < 100_000; i++ {
+					if err = collector.Collect([]byte(fmt.Sprintf("key-%010d", i)), []byte(fmt.Sprintf("value-%010d", i))); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StartTimer()
+				if err = collector.Load(tx, destBucket, bc.loadFunc, TransformArgs{Ordered: true}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				collector.Close()
+				tx.Rollback()
+			}
+		})
+	}
+}
+
+func TestHeap(t *testing.T) {
+	h := NewHeap(nil, 4)
+	for i, k := range []string{"c", "a", "b", "a"} {
+		h.Push(HeapElem{Key: []byte(k), TimeIdx: i})
+	}
+	// equal keys are ordered by provider
+	var got []string
+	for h.Len() > 0 {
+		e := h.Top()
+		got = append(got, fmt.Sprintf("%s%d", e.Key, e.TimeIdx))
+		if string(e.Key) == "a" && e.TimeIdx == 1 {
+			h.ReplaceTop(HeapElem{Key: []byte("bb"), TimeIdx: 1})
+			continue
+		}
+		assert.Equal(t, e, h.Pop())
+	}
+	assert.Equal(t, []string{"a1", "a3", "b2", "bb1", "c0"}, got)
+}
+
+func BenchmarkHeapMerge(b *testing.B) {
+	const providers, entries = 16, 1024
+	keys := make([][]byte, providers*entries)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%010d", i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		h := NewHeap(nil, providers)
+		next := make([]int, providers)
+		for i := 0; i < providers; i++ {
+			h.Push(HeapElem{Key: keys[i], TimeIdx: i})
+		}
+		for h.Len() > 0 {
+			e := h.Top()
+			if next[e.TimeIdx]++; next[e.TimeIdx] < entries {
+				e.Key = keys[next[e.TimeIdx]*providers+e.TimeIdx]
+				h.ReplaceTop(e)
+			} else {
+				h.Pop()
+			}
+		}
+	}
+}
+
+func TestCollectorTmpDirs(t *testing.T) {
+	tmpdir := t.TempDir()
+	// crashed critical collectors of two stages leave their files behind
+	for _, logPrefix := range []string{"1/2 Senders", "2/2 Execution"} {
+		collector := NewCriticalCollector(logPrefix, tmpdir, NewSortableBuffer(1))
+		collector.NoLogs(true)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, collector.Collect([]byte(fmt.Sprintf("key-%02d", i)), []byte(logPrefix)))
+		}
+	}
+	entries, err := os.ReadDir(tmpdir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// recovery picks up only files of the same stage
+	collector, err := NewCollectorFromFiles("1/2 Senders", tmpdir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(collector.dataProviders))
+	err = collector.Iterate(func(k, v []byte) error {
+		assert.Equal(t, "1/2 Senders", string(v))
+		return nil
+	})
+	assert.NoError(t, err)
+	collector.Close()
+	entries, err = os.ReadDir(tmpdir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	// janitor keeps fresh dirs and foreign dirs, removes old collector dirs
+	assert.NoError(t, os.Mkdir(filepath.Join(tmpdir, "snapshots"), 0755))
+	removed, err := CleanupOrphanedDirs(tmpdir, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	removed, err = CleanupOrphanedDirs(tmpdir, -time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	entries, err = os.ReadDir(tmpdir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "snapshots", entries[0].Name())
+}
+
+func TestCollectorFlushTransform(t *testing.T) {
+	for _, bufSize := range []datasize.ByteSize{1, BufferOptimalSize} { // through files and RAM only
+		collector := NewCollector("logPrefix", t.TempDir(), NewSortableBuffer(bufSize))
+		collector.NoLogs(true)
+		var calls int
+		collector.SetFlushTransform(func(k, v []byte) ([]byte, error) {
+			calls++
+			return bytes.ToUpper(v), nil
+		})
+		for i := 9; i >= 0; i-- {
+			assert.NoError(t, collector.Collect([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d", i))))
+		}
+		i := 0
+		err := collector.Iterate(func(k, v []byte) error {
+			assert.Equal(t, fmt.Sprintf("key-%02d", i), string(k))
+			assert.Equal(t, fmt.Sprintf("VALUE-%02d", i), string(v))
+			i++
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, i)
+		assert.Equal(t, 10, calls)
+	}
+
+	collector := NewCollector("logPrefix", t.TempDir(), NewSortableBuffer(1))
+	collector.NoLogs(true)
+	defer collector.Close()
+	errBadValue := fmt.Errorf("bad value")
+	collector.SetFlushTransform(func(k, v []byte) ([]byte, error) { return nil, errBadValue })
+	assert.ErrorIs(t, collector.Collect([]byte("key"), []byte("value")), errBadValue)
+}
+
+func TestCollectorSortedInput(t *testing.T) {
+	expected := map[int][]string{
+		SortableSliceBuffer:          {"key-00:a", "key-01:a", "key-01:b", "key-02:a"},
+		SortableAppendBuffer:         {"key-00:a", "key-01:ab", "key-02:a"},
+		SortableOldestAppearedBuffer: {"key-00:a", "key-01:a", "key-02:a"},
+	}
+	for bufType, entries := range expected {
+		for _, bufSize := range []datasize.ByteSize{1, BufferOptimalSize} { // through file and RAM only
+			collector := NewCollector("logPrefix", t.TempDir(), getBufferByType(bufType, bufSize))
+			collector.NoLogs(true)
+			collector.SetSortedInput(true)
+			for _, kv := range [][2]string{{"key-00", "a"}, {"key-01", "a"}, {"key-01", "b"}, {"key-02", "a"}} {
+				assert.NoError(t, collector.Collect([]byte(kv[0]), []byte(kv[1])))
+			}
+			var got []string
+			err := collector.Iterate(func(k, v []byte) error {
+				got = append(got, string(k)+":"+string(v))
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, entries, got, "buffer type %d, size %d", bufType, bufSize)
+			assert.Equal(t, 1, len(collector.dataProviders))
+		}
+	}
+
+	collector := NewCollector("logPrefix", t.TempDir(), NewSortableBuffer(1))
+	collector.NoLogs(true)
+	defer collector.Close()
+	collector.SetSortedInput(true)
+	assert.NoError(t, collector.Collect([]byte("key-01"), []byte("value")))
+	assert.ErrorIs(t, collector.Collect([]byte("key-00"), []byte("value")), ErrUnsortedInput)
+}
+
+func TestLoadCurrentTableCache(t *testing.T) {
+	// test invariant when loadFunc does read-modify-write of keys, which are deleted and put again during the same load:
+	// cached CurrentTableReader must see the same values as direct reads of the bucket
+	_, tx := memdb.NewTestTx(t)
+	expected := map[string]uint64{}
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("key-%02d", i)
+		expected[k] = 5
+		for _, bucket := range []string{kv.Headers, kv.HeaderCanonical, kv.AccountChangeSet, kv.StorageChangeSet} {
+			assert.NoError(t, tx.Put(bucket, []byte(k), []byte{5}))
+		}
+	}
+	collect := func() *Collector {
+		collector := NewCollector("logPrefix", "", NewSortableBuffer(BufferOptimalSize))
+		for r := 0; r < 4; r++ {
+			for i := 12; i >= 0; i-- {
+				op := "inc"
+				if (r+i)%3 == 0 {
+					op = "del"
+				}
+				assert.NoError(t, collector.Collect([]byte(fmt.Sprintf("key-%02d", i)), []byte(op)))
+			}
+		}
+		return collector
+	}
+	for r := 0; r < 4; r++ {
+		for i := 12; i >= 0; i-- {
+			k := fmt.Sprintf("key-%02d", i)
+			if (r+i)%3 == 0 {
+				delete(expected, k)
+			} else {
+				expected[k]++
+			}
+		}
+	}
+	loadFunc := func(k, v []byte, table CurrentTableReader, next LoadNextFunc) error {
+		if string(v) == "del" {
+			return next(k, k, nil)
+		}
+		current, err := table.Get(k)
+		if err != nil {
+			return err
+		}
+		var counter byte
+		if len(current) > 0 {
+			counter = current[0]
+		}
+		return next(k, k, []byte{counter + 1})
+	}
+	assert.NoError(t, collect().Load(tx, kv.Headers, loadFunc, TransformArgs{}))
+	assert.NoError(t, collect().Load(tx, kv.HeaderCanonical, loadFunc, TransformArgs{CurrentTableCacheSize: 2}))
+	for _, bucket := range []string{kv.Headers, kv.HeaderCanonical} {
+		count := 0
+		err := tx.ForEach(bucket, nil, func(k, v []byte) error {
+			assert.Equal(t, []byte{byte(expected[string(k)])}, v, "bucket %s, key %s", bucket, k)
+			count++
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, len(expected), count, bucket)
+	}
+	// in DupSort bucket Put adds one more value of the key
+	assert.NoError(t, collect().Load(tx, kv.AccountChangeSet, loadFunc, TransformArgs{}))
+	assert.NoError(t, collect().Load(tx, kv.StorageChangeSet, loadFunc, TransformArgs{CurrentTableCacheSize: 2}))
+	compareBuckets(t, tx, kv.AccountChangeSet, kv.StorageChangeSet, nil)
+}
+
+func TestCollectorStats(t *testing.T) {
+	keys := []string{"a", "b", "a", "c", "a"}
+	for _, bufType := range []int{SortableSliceBuffer, SortableAppendBuffer, SortableOldestAppearedBuffer} {
+		collector := NewCollector("logPrefix", t.TempDir(), getBufferByType(bufType, BufferOptimalSize))
+		collector.NoLogs(true)
+		for i, k := range keys {
+			assert.NoError(t, collector.Collect([]byte(k), make([]byte, 1<<i)))
+		}
+		assert.NoError(t, collector.Iterate(func(k, v []byte) error { return nil }))
+		stats := collector.Stats()
+		assert.Equal(t, uint64(5), stats.Keys, "buffer type %d", bufType)
+		assert.Equal(t, uint64(2), stats.Duplicates, "buffer type %d", bufType)
+		assert.Equal(t, 0.4, stats.DuplicateRatio())
+		assert.Equal(t, uint64(0), stats.Flushes, "buffer type %d", bufType)
+		assert.Equal(t, uint64(5), stats.KeyLen[1])
+		for i := range keys {
+			assert.Equal(t, uint64(1), stats.ValueLen[i+1]) // value of 2^i bytes
+		}
+		assert.Equal(t, 2, stats.KeyLen.Percentile(0.5))
+		assert.Equal(t, 8, stats.ValueLen.Percentile(0.5))
+	}
+
+	// every entry goes into own file, so no duplicates within buffer
+	collector := NewCollector("logPrefix", t.TempDir(), NewSortableBuffer(1))
+	collector.NoLogs(true)
+	for _, k := range keys {
+		assert.NoError(t, collector.Collect([]byte(k), []byte("value")))
+	}
+	assert.NoError(t, collector.Iterate(func(k, v []byte) error { return nil }))
+	assert.Equal(t, uint64(0), collector.Stats().Duplicates)
+	assert.Equal(t, uint64(5), collector.Stats().Flushes)
+
+	collector = NewCollector("logPrefix", t.TempDir(), NewAppendBuffer(BufferOptimalSize))
+	collector.NoLogs(true)
+	collector.SetSortedInput(true)
+	for _, k := range []string{"a", "a", "b", "c", "c"} {
+		assert.NoError(t, collector.Collect([]byte(k), []byte("value")))
+	}
+	assert.NoError(t, collector.Iterate(func(k, v []byte) error { return nil }))
+	assert.Equal(t, uint64(5), collector.Stats().Keys)
+	assert.Equal(t, uint64(2), collector.Stats().Duplicates)
+}
@@ -28,16 +28,23 @@ type HeapElem struct {
 	Value   []byte
 }
 
+// Heap - min-heap of current entries of providers for k-way merge. Unlike container/heap it stores elements by value
+// and doesn't box them into interface{}, and ReplaceTop sifts once where Pop followed by Push sifts twice
 type Heap struct {
 	comparator kv.CmpFunc
 	elems      []HeapElem
 }
 
-func (h Heap) Len() int {
+// NewHeap - heap with storage pre-allocated for capacity elements (one per provider), nil comparator - bytes.Compare of keys
+func NewHeap(comparator kv.CmpFunc, capacity int) *Heap {
+	return &Heap{comparator: comparator, elems: make([]HeapElem, 0, capacity)}
+}
+
+func (h *Heap) Len() int {
 	return len(h.elems)
 }
 
-func (h Heap) Less(i, j int) bool {
+func (h *Heap) less(i, j int) bool {
 	if h.comparator != nil {
 		if c := h.comparator(h.elems[i].Key, h.elems[j].Key, h.elems[i].Value, h.elems[j].Value); c != 0 {
 			return c < 0
@@ -51,20 +58,58 @@ func (h Heap) Less(i, j int) bool {
 	return h.elems[i].TimeIdx < h.elems[j].TimeIdx
 }
 
-func (h Heap) Swap(i, j int) {
-	h.elems[i], h.elems[j] = h.elems[j], h.elems[i]
+func (h *Heap) Push(x HeapElem) {
+	h.elems = append(h.elems, x)
+	h.up(len(h.elems) - 1)
 }
 
-func (h *Heap) Push(x interface{}) {
-	// Push and Pop use pointer receivers because they modify the slice's length,
-	// not just its contents.
-	h.elems = append(h.elems, x.(HeapElem))
+// Top - smallest element, heap must not be empty
+func (h *Heap) Top() HeapElem {
+	return h.elems[0]
 }
 
-func (h *Heap) Pop() interface{} {
-	old := h.elems
-	n := len(old)
-	x := old[n-1]
-	h.elems = old[0 : n-1]
+// Pop - removes and returns smallest element, heap must not be empty
+func (h *Heap) Pop() HeapElem {
+	n := len(h.elems) - 1
+	h.elems[0], h.elems[n] = h.elems[n], h.elems[0]
+	h.down(0, n)
+	x := h.elems[n]
+	h.elems[n] = HeapElem{} // for gc
+	h.elems = h.elems[:n]
 	return x
 }
+
+// ReplaceTop - replaces smallest element by x, like Pop followed by Push(x), heap must not be empty
+func (h *Heap) ReplaceTop(x HeapElem) {
+	h.elems[0] = x
+	h.down(0, len(h.elems))
+}
+
+func (h *Heap) up(j int) {
+	for {
+		i := (j - 1) / 2 // parent
+		if i == j || !h.less(j, i) {
+			break
+		}
+		h.elems[i], h.elems[j] = h.elems[j], h.elems[i]
+		j = i
+	}
+}
+
+func (h *Heap) down(i, n int) {
+	for {
+		j1 := 2*i + 1
+		if j1 >= n || j1 < 0 { // j1 < 0 after int overflow
+			break
+		}
+		j := j1 // left child
+		if j2 := j1 + 1; j2 < n && h.less(j2, j1) {
+			j = j2 // = 2*i + 2  // right child
+		}
+		if !h.less(j, i) {
+			break
+		}
+		h.elems[i], h.elems[j] = h.elems[j], h.elems[i]
+		i = j
+	}
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// buffersPool - process-wide pool of sortable buffers. Each stage needs buffers of hundreds of MB,
+// when many stages run back-to-back it's cheaper to reuse them than to let GC collect them.
+// Buffers are pooled by type and by size class (power of 2 of optimal size) - to not give huge buffer
+// to small collector.
+var buffersPool = &bufferPool{
+	pools:    map[bufferPoolKey]*sync.Pool{},
+	borrowed: map[Buffer]struct{}{},
+}
+
+type bufferPoolKey struct {
+	bufType   int
+	sizeClass int
+}
+
+type bufferPool struct {
+	lock     sync.Mutex
+	pools    map[bufferPoolKey]*sync.Pool
+	borrowed map[Buffer]struct{} // only borrowed buffers can be returned to the pool
+}
+
+func (p *bufferPool) pool(bufType int, size int) *sync.Pool {
+	key := bufferPoolKey{bufType: bufType, sizeClass: bits.Len(uint(size))}
+	pool, ok := p.pools[key]
+	if !ok {
+		pool = &sync.Pool{}
+		p.pools[key] = pool
+	}
+	return pool
+}
+
+// GetBuffer - borrows buffer of given type from process-wide pool (or allocates new one).
+// Collector returns borrowed buffer to the pool on Close, buffer must not be used after that.
+func GetBuffer(bufType int, size datasize.ByteSize) Buffer {
+	buffersPool.lock.Lock()
+	defer buffersPool.lock.Unlock()
+	b, ok := buffersPool.pool(bufType, int(size.Bytes())).Get().(Buffer)
+	if ok {
+		setOptimalSize(b, int(size.Bytes()))
+	} else {
+		b = getBufferByType(bufType, size)
+	}
+	buffersPool.borrowed[b] = struct{}{}
+	return b
+}
+
+// PutBuffer - returns buffer to process-wide pool. Buffers which were not borrowed by GetBuffer are ignored,
+// so it's safe to call it for any buffer and repeatedly.
+func PutBuffer(b Buffer) {
+	buffersPool.lock.Lock()
+	defer buffersPool.lock.Unlock()
+	if _, ok := buffersPool.borrowed[b]; !ok {
+		return
+	}
+	delete(buffersPool.borrowed, b)
+	b.Reset()
+	b.SetComparator(nil)
+	buffersPool.pool(getTypeByBuffer(b), optimalSize(b)).Put(b)
+}
+
+func optimalSize(b Buffer) int {
+	switch b := b.(type) {
+	case *sortableBuffer:
+		return b.optimalSize
+	case *appendSortableBuffer:
+		return b.optimalSize
+	case *oldestEntrySortableBuffer:
+		return b.optimalSize
+	default:
+		panic(fmt.Sprintf("unknown buffer type: %T ", b))
+	}
+}
+
+func setOptimalSize(b Buffer, size int) {
+	switch b := b.(type) {
+	case *sortableBuffer:
+		b.optimalSize = size
+	case *appendSortableBuffer:
+		b.optimalSize = size
+	case *oldestEntrySortableBuffer:
+		b.optimalSize = size
+	default:
+		panic(fmt.Sprintf("unknown buffer type: %T ", b))
+	}
+}
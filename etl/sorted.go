@@ -0,0 +1,161 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ugorji/go/codec"
+)
+
+// ErrUnsortedInput - collector with sorted input got key which is smaller than previous one
+var ErrUnsortedInput = errors.New("etl: collected keys are not sorted")
+
+// sortedInput - state of collector which doesn't sort: entries go into one buffer, which is spilled
+// into one temp file, so Load reads single provider instead of merging many
+type sortedInput struct {
+	buf      *sortableBuffer
+	pendingK []byte // last collected entry, kept until next key to merge equal keys according to bufType
+	pendingV []byte
+	file     *os.File
+	w        *bufio.Writer
+	encoder  *codec.Encoder
+}
+
+// SetSortedInput - caller guarantees that Collect is called in ascending order of keys (for example, keys come
+// from a cursor), then buffer is never sorted and temp files are not merged. Equal keys are merged on the fly
+// the same way as collector's buffer does. Collect returns ErrUnsortedInput on out-of-order key.
+// Must be called before the first Collect
+func (c *Collector) SetSortedInput(v bool) {
+	if !v {
+		return
+	}
+	s := &sortedInput{buf: NewSortableBuffer(datasize.ByteSize(optimalSizeOfBuffer(c.buf)))}
+	var dir string
+	if len(c.dirs) > 0 {
+		dir = c.dirs[0]
+	}
+	c.extractNextFunc = func(originalK, k, v []byte) error {
+		if s.pendingK != nil {
+			cmp := bytes.Compare(k, s.pendingK)
+			if cmp < 0 {
+				return fmt.Errorf("%w: key %x after %x", ErrUnsortedInput, k, s.pendingK)
+			}
+			c.statCollect(k, v)
+			if cmp == 0 {
+				c.statDuplicates(1)
+			}
+			switch {
+			case cmp == 0 && c.bufType == SortableAppendBuffer:
+				s.pendingV = append(s.pendingV, v...)
+				return nil
+			case cmp == 0 && c.bufType == SortableOldestAppearedBuffer:
+				return nil
+			}
+			s.buf.Put(s.pendingK, s.pendingV)
+		} else {
+			c.statCollect(k, v)
+		}
+		s.pendingK, s.pendingV = common.Copy(k), common.Copy(v)
+		if s.buf.CheckFlushSize() {
+			return c.flushBuffer(originalK, false)
+		}
+		return nil
+	}
+	c.flushBuffer = func(_ []byte, final bool) error {
+		if final && s.pendingK != nil {
+			s.buf.Put(s.pendingK, s.pendingV)
+			s.pendingK, s.pendingV = nil, nil
+		}
+		if c.flushTransform != nil {
+			if err := transformEntries(s.buf.GetEntries(), c.flushTransform); err != nil {
+				return err
+			}
+		}
+		if final && s.file == nil {
+			if s.buf.Len() > 0 {
+				c.dataProviders = append(c.dataProviders, KeepInRAM(s.buf))
+			}
+			c.allFlushed = true
+			return nil
+		}
+		if s.buf.Len() > 0 {
+			c.statFlush()
+		}
+		if err := s.spill(dir); err != nil {
+			return err
+		}
+		if !final {
+			return nil
+		}
+		if err := s.w.Flush(); err != nil {
+			return err
+		}
+		if !c.autoClean { // critical collector
+			if err := s.file.Sync(); err != nil {
+				return err
+			}
+		}
+		c.dataProviders = append(c.dataProviders, &fileDataProvider{file: s.file, resultBuf: make([][]byte, 2)})
+		s.file, s.w = nil, nil
+		c.allFlushed = true
+		return nil
+	}
+	c.sorted = s
+}
+
+// spill - appends buffered entries to the temp file, creating it on first call
+func (s *sortedInput) spill(dir string) error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	if s.file == nil {
+		if dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		var err error
+		if s.file, err = ioutil.TempFile(dir, "erigon-sorted-buf-"); err != nil {
+			return err
+		}
+		s.w = bufio.NewWriterSize(s.file, BufIOSize)
+		s.encoder = codec.NewEncoder(s.w, &cbor)
+	}
+	if err := writeToDisk(s.encoder, s.buf.GetEntries()); err != nil {
+		return err
+	}
+	s.buf.Reset()
+	return nil
+}
+
+// close - removes temp file which wasn't passed to data providers (Load was not called or failed)
+func (s *sortedInput) close() {
+	if s.file == nil {
+		return
+	}
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+	s.file, s.w = nil, nil
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/c2h5oh/datasize"
+)
+
+// SizeHistogram - counts of sizes by power-of-two buckets: bucket 0 is for empty slices, bucket i (i > 0) is
+// for sizes in [2^(i-1), 2^i), the last bucket also takes everything bigger
+type SizeHistogram [18]uint64
+
+func (h *SizeHistogram) add(size int) {
+	i := bits.Len(uint(size))
+	if i >= len(h) {
+		i = len(h) - 1
+	}
+	h[i]++
+}
+
+// Bound - upper (exclusive) size bound of bucket i, 0 for the last unbounded bucket
+func (h *SizeHistogram) Bound(i int) int {
+	if i == len(h)-1 {
+		return 0
+	}
+	return 1 << i
+}
+
+// Count - total amount of sizes in histogram
+func (h *SizeHistogram) Count() (n uint64) {
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// Percentile - upper bound of the bucket which contains p-th (0..1) percentile, 0 means "unbounded" or empty histogram
+func (h *SizeHistogram) Percentile(p float64) int {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	need := uint64(math.Ceil(p * float64(total)))
+	var seen uint64
+	for i, c := range h {
+		seen += c
+		if c > 0 && seen >= need {
+			return h.Bound(i)
+		}
+	}
+	return 0
+}
+
+func (h *SizeHistogram) String() string {
+	var sb strings.Builder
+	for i, c := range h {
+		if c == 0 {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		if b := h.Bound(i); b == 0 {
+			fmt.Fprintf(&sb, "inf:%d", c)
+		} else {
+			fmt.Fprintf(&sb, "<%s:%d", datasize.ByteSize(b).HumanReadable(), c)
+		}
+	}
+	return sb.String()
+}
+
+// CollectorStats - what went through collector, helps to pick buffer type and size for a stage:
+// high Duplicates ratio means SortableAppendBuffer or SortableOldestAppearedBuffer would keep less in RAM,
+// many Flushes mean buffer is too small for the data
+type CollectorStats struct {
+	Keys       uint64 // collected entries, including duplicates
+	Duplicates uint64 // entries whose key was already in the same buffer (between two flushes)
+	Flushes    uint64 // buffer spills into temp files, last in-RAM buffer is not counted
+	KeyLen     SizeHistogram
+	ValueLen   SizeHistogram
+}
+
+// DuplicateRatio - share of collected entries which had key equal to another entry of the same buffer
+func (s CollectorStats) DuplicateRatio() float64 {
+	if s.Keys == 0 {
+		return 0
+	}
+	return float64(s.Duplicates) / float64(s.Keys)
+}
+
+// collectorMetrics - counters of all collectors with the same logPrefix
+type collectorMetrics struct {
+	keys, duplicates, flushes *metrics.Counter
+}
+
+func newCollectorMetrics(logPrefix string) collectorMetrics {
+	return collectorMetrics{
+		keys:       metrics.GetOrCreateCounter(fmt.Sprintf(`etl_collected_keys_total{collector=%q}`, logPrefix)),
+		duplicates: metrics.GetOrCreateCounter(fmt.Sprintf(`etl_duplicate_keys_total{collector=%q}`, logPrefix)),
+		flushes:    metrics.GetOrCreateCounter(fmt.Sprintf(`etl_flushes_total{collector=%q}`, logPrefix)),
+	}
+}
+
+func (c *Collector) statCollect(k, v []byte) {
+	c.stats.Keys++
+	c.stats.KeyLen.add(len(k))
+	c.stats.ValueLen.add(len(v))
+	c.metrics.keys.Inc()
+}
+
+func (c *Collector) statDuplicates(n uint64) {
+	if n == 0 {
+		return
+	}
+	c.stats.Duplicates += n
+	c.metrics.duplicates.Add(int(n))
+}
+
+func (c *Collector) statFlush() {
+	c.stats.Flushes++
+	c.metrics.flushes.Inc()
+}
+
+// Stats - statistics of collected data, duplicates of not yet flushed buffer are counted on next flush or Load
+func (c *Collector) Stats() CollectorStats { return c.stats }
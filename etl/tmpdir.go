@@ -0,0 +1,126 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package etl
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+)
+
+// Every collector writes its files into own subdirectory of tmpdir: collector-<logPrefix>-<nonce>,
+// so leftovers of one crashed collector are never picked up by unrelated one
+const (
+	collectorDirPrefix = "collector-"
+	collectorNonceLen  = 8 // bytes, hex-encoded in the directory name
+)
+
+// sanitizeLogPrefix - log prefixes look like "5/16 Execution", keep only characters which are safe in file names
+func sanitizeLogPrefix(logPrefix string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, logPrefix)
+}
+
+// newCollectorDir - unique subdirectory of tmpdir for the collector, it is created on first flush.
+// Empty tmpdir means system temp dir, which is not subdivided
+func newCollectorDir(tmpdir, logPrefix string) string {
+	if tmpdir == "" {
+		return ""
+	}
+	var nonce [collectorNonceLen]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		binary.BigEndian.PutUint64(nonce[:], uint64(time.Now().UnixNano()))
+	}
+	return filepath.Join(tmpdir, collectorDirPrefix+sanitizeLogPrefix(logPrefix)+"-"+hex.EncodeToString(nonce[:]))
+}
+
+// parseCollectorDir - extracts sanitized log prefix from the name of collector subdirectory
+func parseCollectorDir(name string) (logPrefix string, ok bool) {
+	if !strings.HasPrefix(name, collectorDirPrefix) {
+		return "", false
+	}
+	rest := name[len(collectorDirPrefix):]
+	sep := len(rest) - 2*collectorNonceLen - 1
+	if sep < 0 || rest[sep] != '-' {
+		return "", false
+	}
+	if _, err := hex.DecodeString(rest[sep+1:]); err != nil {
+		return "", false
+	}
+	return rest[:sep], true
+}
+
+// collectorDirs - subdirectories of tmpdir left by collectors with given logPrefix, sorted by name
+func collectorDirs(tmpdir, logPrefix string) ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	sanitized := sanitizeLogPrefix(logPrefix)
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() {
+			continue
+		}
+		if prefix, ok := parseCollectorDir(fileInfo.Name()); ok && prefix == sanitized {
+			dirs = append(dirs, filepath.Join(tmpdir, fileInfo.Name()))
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// CleanupOrphanedDirs - deletes collector subdirectories of tmpdir which were not modified for longer than olderThan.
+// Meant to be called at startup, before any collector is created: subdirectories of running collectors
+// are modified on every flush, but long-living collectors may be still removed by too small olderThan
+func CleanupOrphanedDirs(tmpdir string, olderThan time.Duration) (removed int, err error) {
+	fileInfos, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("cleanup orphaned etl dirs - reading directory %s: %w", tmpdir, err)
+	}
+	deadline := time.Now().Add(-olderThan)
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() || !fileInfo.ModTime().Before(deadline) {
+			continue
+		}
+		if _, ok := parseCollectorDir(fileInfo.Name()); !ok {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(tmpdir, fileInfo.Name())); err != nil {
+			return removed, fmt.Errorf("cleanup orphaned etl dirs - removing %s: %w", fileInfo.Name(), err)
+		}
+		log.Info("etl: removed orphaned temp dir", "dir", fileInfo.Name(), "modified", fileInfo.ModTime())
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,165 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grpcutil
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/health" // registers client-side health checking
+	"google.golang.org/grpc/keepalive"
+)
+
+// DefaultBackoff - backoff between attempts of Dial and Retry
+var DefaultBackoff = backoff.Config{
+	BaseDelay:  500 * time.Millisecond,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   10 * time.Second,
+}
+
+// healthCheckConfig - enables gRPC health-checking of connection: server must register health service.
+// Client-side health-checking is not supported by pick_first balancer.
+const healthCheckConfig = `{"loadBalancingConfig": [{"round_robin":{}}], "healthCheckConfig": {"serviceName": ""}}`
+
+// Delay - how long to wait before attempt number `retries` (0-based), same formula as grpc's connection backoff
+func Delay(cfg backoff.Config, retries int) time.Duration {
+	if retries == 0 {
+		return cfg.BaseDelay
+	}
+	delay, max := float64(cfg.BaseDelay), float64(cfg.MaxDelay)
+	for delay < max && retries > 0 {
+		delay *= cfg.Multiplier
+		retries--
+	}
+	if delay > max {
+		delay = max
+	}
+	delay *= 1 + cfg.Jitter*(rand.Float64()*2-1) //nolint:gosec
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent - wraps error to stop Retry, Retry returns original error
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retry - calls f until it returns nil or Permanent error, or ctx is done (then returns ctx.Err()).
+// Between attempts waits with jittered exponential backoff. Errors IsRetryLater and IsEndOfStream are
+// expected when other side restarts - they are not reported, all other errors are passed to onErr (if not nil).
+func Retry(ctx context.Context, cfg backoff.Config, f func(ctx context.Context) error, onErr func(err error)) error {
+	return retry(ctx, cfg, f, onErr, false)
+}
+
+// RetryLoop - like Retry, but runs f again after success, until ctx is done or f returns Permanent error.
+// For long-living subscriptions: backoff is reset after attempt which lasted longer than cfg.MaxDelay.
+func RetryLoop(ctx context.Context, cfg backoff.Config, f func(ctx context.Context) error, onErr func(err error)) error {
+	return retry(ctx, cfg, f, onErr, true)
+}
+
+func retry(ctx context.Context, cfg backoff.Config, f func(ctx context.Context) error, onErr func(err error), loop bool) error {
+	for retries := 0; ; retries++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		started := time.Now()
+		err := f(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if err == nil {
+			if !loop {
+				return nil
+			}
+			retries = -1 // next attempt immediately, backoff from scratch
+			continue
+		}
+		if time.Since(started) > cfg.MaxDelay { // long attempt: connection was fine, start backoff from scratch
+			retries = 0
+		}
+		if onErr != nil && !IsRetryLater(err) && !IsEndOfStream(err) {
+			onErr(err)
+		}
+		select {
+		case <-time.After(Delay(cfg, retries)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Dial - like Connect, but doesn't give up: attempts to connect with DefaultBackoff until ctx is done.
+// Connection is health-checked: Dial waits until server's grpc_health_v1 service reports SERVING, and RPCs are not
+// sent to NOT_SERVING server. Server without health service is considered healthy.
+func Dial(ctx context.Context, creds credentials.TransportCredentials, dialAddress string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error) {
+	dialOpts := []grpc.DialOption{
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: DefaultBackoff, MinConnectTimeout: 10 * time.Minute}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(200 * datasize.MB))),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{}),
+		grpc.WithDefaultServiceConfig(healthCheckConfig),
+		grpc.WithBlock(),
+	}
+	if creds == nil {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+	}
+	dialOpts = append(dialOpts, opts...)
+	err = Retry(ctx, DefaultBackoff, func(ctx context.Context) error {
+		attemptCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		conn, err = grpc.DialContext(attemptCtx, dialAddress, dialOpts...)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Watch - calls onChange with current state of connection and then on every change,
+// until ctx is done or connection is shut down. Blocks.
+func Watch(ctx context.Context, conn *grpc.ClientConn, onChange func(state connectivity.State)) {
+	state := conn.GetState()
+	onChange(state)
+	for state != connectivity.Shutdown && conn.WaitForStateChange(ctx, state) {
+		state = conn.GetState()
+		onChange(state)
+	}
+}
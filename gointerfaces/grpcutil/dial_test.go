@@ -0,0 +1,92 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package grpcutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testBackoff = backoff.Config{BaseDelay: time.Millisecond, Multiplier: 2, Jitter: 0.2, MaxDelay: 10 * time.Millisecond}
+
+func TestDelay(t *testing.T) {
+	require.Equal(t, testBackoff.BaseDelay, Delay(testBackoff, 0))
+	for retries := 1; retries < 10; retries++ {
+		d := Delay(testBackoff, retries)
+		require.GreaterOrEqual(t, int64(d), int64(0))
+		require.LessOrEqual(t, int64(d), int64(float64(testBackoff.MaxDelay)*(1+testBackoff.Jitter)))
+	}
+	require.Greater(t, int64(Delay(testBackoff, 20)), int64(testBackoff.MaxDelay/2))
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	var reported []error
+	onErr := func(err error) { reported = append(reported, err) }
+
+	calls := 0
+	err := Retry(ctx, testBackoff, func(ctx context.Context) error {
+		calls++
+		switch calls {
+		case 1:
+			return status.Error(codes.Unavailable, "restarting")
+		case 2:
+			return io.EOF
+		case 3:
+			return errors.New("other")
+		}
+		return nil
+	}, onErr)
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+	require.Len(t, reported, 1) // retry-later and end-of-stream are not reported
+
+	stop := errors.New("stop")
+	err = Retry(ctx, testBackoff, func(ctx context.Context) error { return Permanent(stop) }, onErr)
+	require.Equal(t, stop, err)
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = Retry(ctx, testBackoff, func(ctx context.Context) error { return io.EOF }, nil)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestRetryLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err := RetryLoop(ctx, testBackoff, func(ctx context.Context) error {
+		calls++
+		if calls == 5 {
+			cancel()
+		}
+		if calls%2 == 0 {
+			return io.EOF
+		}
+		return nil // subscription ended normally - re-subscribe
+	}, nil)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 5, calls)
+}
@@ -39,6 +39,16 @@ type TxpoolClient interface {
 	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error)
 	// returns nonce for given account
 	Nonce(ctx context.Context, in *NonceRequest, opts ...grpc.CallOption) (*NonceReply, error)
+	// returns amount and total gas of pending transactions which can be included into block with given base fee
+	CountEligible(ctx context.Context, in *CountEligibleRequest, opts ...grpc.CallOption) (*CountEligibleReply, error)
+	// changes sub-pool limits and price bump at runtime, returns limits in effect
+	SetLimits(ctx context.Context, in *SetLimitsRequest, opts ...grpc.CallOption) (*SetLimitsReply, error)
+	// validates txs as Add does (fee, nonce, balance, replacement), but doesn't add them to the pool
+	PreCheck(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddReply, error)
+	// returns transactions of one sub-pool page by page, without rlp
+	PagedAll(ctx context.Context, in *PagedAllRequest, opts ...grpc.CallOption) (*PagedAllReply, error)
+	// returns pending transactions in ready-for-mining order by batches, client stops reading once block is full
+	BestStream(ctx context.Context, in *BestStreamRequest, opts ...grpc.CallOption) (Txpool_BestStreamClient, error)
 }
 
 type txpoolClient struct {
@@ -153,6 +163,74 @@ func (c *txpoolClient) Nonce(ctx context.Context, in *NonceRequest, opts ...grpc
 	return out, nil
 }
 
+func (c *txpoolClient) CountEligible(ctx context.Context, in *CountEligibleRequest, opts ...grpc.CallOption) (*CountEligibleReply, error) {
+	out := new(CountEligibleReply)
+	err := c.cc.Invoke(ctx, "/txpool.Txpool/CountEligible", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolClient) SetLimits(ctx context.Context, in *SetLimitsRequest, opts ...grpc.CallOption) (*SetLimitsReply, error) {
+	out := new(SetLimitsReply)
+	err := c.cc.Invoke(ctx, "/txpool.Txpool/SetLimits", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolClient) PreCheck(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddReply, error) {
+	out := new(AddReply)
+	err := c.cc.Invoke(ctx, "/txpool.Txpool/PreCheck", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolClient) PagedAll(ctx context.Context, in *PagedAllRequest, opts ...grpc.CallOption) (*PagedAllReply, error) {
+	out := new(PagedAllReply)
+	err := c.cc.Invoke(ctx, "/txpool.Txpool/PagedAll", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolClient) BestStream(ctx context.Context, in *BestStreamRequest, opts ...grpc.CallOption) (Txpool_BestStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Txpool_ServiceDesc.Streams[1], "/txpool.Txpool/BestStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &txpoolBestStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Txpool_BestStreamClient interface {
+	Recv() (*BestStreamReply, error)
+	grpc.ClientStream
+}
+
+type txpoolBestStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *txpoolBestStreamClient) Recv() (*BestStreamReply, error) {
+	m := new(BestStreamReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // TxpoolServer is the server API for Txpool service.
 // All implementations must embed UnimplementedTxpoolServer
 // for forward compatibility
@@ -176,6 +254,16 @@ type TxpoolServer interface {
 	Status(context.Context, *StatusRequest) (*StatusReply, error)
 	// returns nonce for given account
 	Nonce(context.Context, *NonceRequest) (*NonceReply, error)
+	// returns amount and total gas of pending transactions which can be included into block with given base fee
+	CountEligible(context.Context, *CountEligibleRequest) (*CountEligibleReply, error)
+	// changes sub-pool limits and price bump at runtime, returns limits in effect
+	SetLimits(context.Context, *SetLimitsRequest) (*SetLimitsReply, error)
+	// validates txs as Add does (fee, nonce, balance, replacement), but doesn't add them to the pool
+	PreCheck(context.Context, *AddRequest) (*AddReply, error)
+	// returns transactions of one sub-pool page by page, without rlp
+	PagedAll(context.Context, *PagedAllRequest) (*PagedAllReply, error)
+	// returns pending transactions in ready-for-mining order by batches, client stops reading once block is full
+	BestStream(*BestStreamRequest, Txpool_BestStreamServer) error
 	mustEmbedUnimplementedTxpoolServer()
 }
 
@@ -210,6 +298,21 @@ func (UnimplementedTxpoolServer) Status(context.Context, *StatusRequest) (*Statu
 func (UnimplementedTxpoolServer) Nonce(context.Context, *NonceRequest) (*NonceReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Nonce not implemented")
 }
+func (UnimplementedTxpoolServer) CountEligible(context.Context, *CountEligibleRequest) (*CountEligibleReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountEligible not implemented")
+}
+func (UnimplementedTxpoolServer) SetLimits(context.Context, *SetLimitsRequest) (*SetLimitsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLimits not implemented")
+}
+func (UnimplementedTxpoolServer) PreCheck(context.Context, *AddRequest) (*AddReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreCheck not implemented")
+}
+func (UnimplementedTxpoolServer) PagedAll(context.Context, *PagedAllRequest) (*PagedAllReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PagedAll not implemented")
+}
+func (UnimplementedTxpoolServer) BestStream(*BestStreamRequest, Txpool_BestStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method BestStream not implemented")
+}
 func (UnimplementedTxpoolServer) mustEmbedUnimplementedTxpoolServer() {}
 
 // UnsafeTxpoolServer may be embedded to opt out of forward compatibility for this service.
@@ -388,6 +491,99 @@ func _Txpool_Nonce_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Txpool_CountEligible_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountEligibleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolServer).CountEligible(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/txpool.Txpool/CountEligible",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolServer).CountEligible(ctx, req.(*CountEligibleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Txpool_SetLimits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLimitsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolServer).SetLimits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/txpool.Txpool/SetLimits",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolServer).SetLimits(ctx, req.(*SetLimitsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Txpool_PreCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolServer).PreCheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/txpool.Txpool/PreCheck",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolServer).PreCheck(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Txpool_PagedAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PagedAllRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolServer).PagedAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/txpool.Txpool/PagedAll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolServer).PagedAll(ctx, req.(*PagedAllRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Txpool_BestStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BestStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TxpoolServer).BestStream(m, &txpoolBestStreamServer{stream})
+}
+
+type Txpool_BestStreamServer interface {
+	Send(*BestStreamReply) error
+	grpc.ServerStream
+}
+
+type txpoolBestStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *txpoolBestStreamServer) Send(m *BestStreamReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Txpool_ServiceDesc is the grpc.ServiceDesc for Txpool service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -427,6 +623,22 @@ var Txpool_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Nonce",
 			Handler:    _Txpool_Nonce_Handler,
 		},
+		{
+			MethodName: "CountEligible",
+			Handler:    _Txpool_CountEligible_Handler,
+		},
+		{
+			MethodName: "SetLimits",
+			Handler:    _Txpool_SetLimits_Handler,
+		},
+		{
+			MethodName: "PreCheck",
+			Handler:    _Txpool_PreCheck_Handler,
+		},
+		{
+			MethodName: "PagedAll",
+			Handler:    _Txpool_PagedAll_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -434,6 +646,11 @@ var Txpool_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Txpool_OnAdd_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "BestStream",
+			Handler:       _Txpool_BestStream_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "txpool/txpool.proto",
 }
@@ -17,9 +17,13 @@
 package gointerfaces
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type Version struct {
@@ -32,15 +36,56 @@ func VersionFromProto(r *types.VersionReply) Version {
 
 // EnsureVersion - Default policy: allow only patch difference
 func EnsureVersion(local Version, remote *types.VersionReply) bool {
-	if remote.Major != local.Major {
-		return false
-	}
-	if remote.Minor != local.Minor {
-		return false
-	}
-	return true
+	return CheckVersion(local, remote, SameMinor) == nil
 }
 
 func (v Version) String() string {
 	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
 }
+
+var ErrIncompatibleVersion = errors.New("incompatible interface version")
+
+// VersionPolicy - which difference between versions of client and server is compatible
+type VersionPolicy uint8
+
+const (
+	SameMinor  VersionPolicy = iota // only patch versions may differ - default policy
+	NewerMinor                      // server may have newer minor version: it only adds methods/fields which client doesn't use
+	SamePatch                       // versions must be equal
+)
+
+// CheckVersion - returns ErrIncompatibleVersion with both versions in message, if they are incompatible by policy
+func CheckVersion(local Version, remote *types.VersionReply, policy VersionPolicy) error {
+	server := VersionFromProto(remote)
+	compatible := server.Major == local.Major
+	switch policy {
+	case SameMinor:
+		compatible = compatible && server.Minor == local.Minor
+	case NewerMinor:
+		compatible = compatible && server.Minor >= local.Minor
+	case SamePatch:
+		compatible = compatible && server.Minor == local.Minor && server.Patch == local.Patch
+	default:
+		return fmt.Errorf("unknown version policy: %d", policy)
+	}
+	if !compatible {
+		return fmt.Errorf("%w: client %s, server %s", ErrIncompatibleVersion, local, server)
+	}
+	return nil
+}
+
+// VersionClient - part of grpc clients of all services which have Version method
+type VersionClient interface {
+	Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.VersionReply, error)
+}
+
+// NegotiateVersion - requests version of server and checks it by policy. Use it at startup, to fail fast
+// instead of getting unmarshal errors of mismatched messages later. Returns version of server - to enable
+// optional features of newer servers
+func NegotiateVersion(ctx context.Context, client VersionClient, local Version, policy VersionPolicy) (Version, error) {
+	reply, err := client.Version(ctx, &emptypb.Empty{}, grpc.WaitForReady(true))
+	if err != nil {
+		return Version{}, fmt.Errorf("getting version: %w", err)
+	}
+	return VersionFromProto(reply), CheckVersion(local, reply, policy)
+}
@@ -0,0 +1,69 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gointerfaces
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestCheckVersion(t *testing.T) {
+	local := Version{Major: 5, Minor: 2, Patch: 1}
+	tests := []struct {
+		server     *types.VersionReply
+		policy     VersionPolicy
+		compatible bool
+	}{
+		{&types.VersionReply{Major: 5, Minor: 2, Patch: 7}, SameMinor, true},
+		{&types.VersionReply{Major: 5, Minor: 3, Patch: 0}, SameMinor, false},
+		{&types.VersionReply{Major: 4, Minor: 2, Patch: 1}, SameMinor, false},
+		{&types.VersionReply{Major: 5, Minor: 3, Patch: 0}, NewerMinor, true},
+		{&types.VersionReply{Major: 5, Minor: 1, Patch: 9}, NewerMinor, false},
+		{&types.VersionReply{Major: 6, Minor: 3, Patch: 0}, NewerMinor, false},
+		{&types.VersionReply{Major: 5, Minor: 2, Patch: 1}, SamePatch, true},
+		{&types.VersionReply{Major: 5, Minor: 2, Patch: 2}, SamePatch, false},
+	}
+	for i, tt := range tests {
+		err := CheckVersion(local, tt.server, tt.policy)
+		if tt.compatible {
+			require.NoError(t, err, i)
+		} else {
+			require.ErrorIs(t, err, ErrIncompatibleVersion, i)
+		}
+	}
+	err := CheckVersion(local, &types.VersionReply{Major: 6}, SameMinor)
+	require.EqualError(t, err, "incompatible interface version: client 5.2.1, server 6.0.0")
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	client := &remote.KVClientMock{
+		VersionFunc: func(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.VersionReply, error) {
+			return &types.VersionReply{Major: 5, Minor: 2}, nil
+		},
+	}
+	server, err := NegotiateVersion(context.Background(), client, Version{Major: 5, Minor: 1}, NewerMinor)
+	require.NoError(t, err)
+	require.Equal(t, Version{Major: 5, Minor: 2}, server)
+	_, err = NegotiateVersion(context.Background(), client, Version{Major: 5, Minor: 1}, SameMinor)
+	require.ErrorIs(t, err, ErrIncompatibleVersion)
+}
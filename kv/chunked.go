@@ -0,0 +1,137 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultChunkSize - size of chunks of PutChunked, keeps values out of long chains of MDBX overflow pages
+const DefaultChunkSize = 1024 * 1024
+
+// chunkKey - k + chunkIdx_u32. Chunks of one value are adjacent in table, but prefix-free keys are
+// caller's responsibility: chunks of key "a" must not be confused with entries of other keys.
+// Chunk 0 is a header with length of value (u64), data is stored in chunks 1..n, none of them is empty
+func chunkKey(k []byte, idx uint32) []byte {
+	key := make([]byte, len(k)+4)
+	copy(key, k)
+	binary.BigEndian.PutUint32(key[len(k):], idx)
+	return key
+}
+
+// PutChunked - stores value read from r under keys k+chunkIdx_u32 by chunks of chunkSize bytes (DefaultChunkSize
+// if chunkSize <= 0), removes leftover chunks of previous longer value
+func PutChunked(tx RwTx, table string, k []byte, r io.Reader, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	var size uint64
+	idx := uint32(1)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("put chunked %x: %w", k, err)
+		}
+		if n > 0 {
+			if err := tx.Put(table, chunkKey(k, idx), buf[:n]); err != nil {
+				return err
+			}
+			size += uint64(n)
+			idx++
+		}
+		if n < chunkSize {
+			break
+		}
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], size)
+	if err := tx.Put(table, chunkKey(k, 0), header[:]); err != nil {
+		return err
+	}
+	return deleteChunks(tx, table, k, idx)
+}
+
+// DeleteChunked - removes all chunks of value stored by PutChunked
+func DeleteChunked(tx RwTx, table string, k []byte) error {
+	return deleteChunks(tx, table, k, 0)
+}
+
+func deleteChunks(tx RwTx, table string, k []byte, from uint32) error {
+	for idx := from; ; idx++ {
+		key := chunkKey(k, idx)
+		has, err := tx.Has(table, key)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return nil
+		}
+		if err := tx.Delete(table, key, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// GetChunked - streaming reader of value stored by PutChunked, nil if there is no such value.
+// Chunks are read one by one on demand, reader is valid until the end of tx
+func GetChunked(tx Getter, table string, k []byte) (*ChunkedReader, error) {
+	header, err := tx.GetOne(table, chunkKey(k, 0))
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, nil
+	}
+	if len(header) != 8 {
+		return nil, fmt.Errorf("get chunked %x: header of %d bytes", k, len(header))
+	}
+	return &ChunkedReader{tx: tx, table: table, k: k, left: binary.BigEndian.Uint64(header)}, nil
+}
+
+// ChunkedReader - io.Reader over chunks of one value
+type ChunkedReader struct {
+	tx    Getter
+	table string
+	k     []byte
+	idx   uint32 // index of current chunk
+	chunk []byte // unread part of current chunk
+	left  uint64 // bytes of value in next chunks
+}
+
+func (r *ChunkedReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.left == 0 {
+			return 0, io.EOF
+		}
+		r.idx++
+		v, err := r.tx.GetOne(r.table, chunkKey(r.k, r.idx))
+		if err != nil {
+			return 0, err
+		}
+		if len(v) == 0 || uint64(len(v)) > r.left {
+			return 0, fmt.Errorf("get chunked %x: chunk %d of %d bytes, %d bytes left: %w", r.k, r.idx, len(v), r.left, io.ErrUnexpectedEOF)
+		}
+		r.chunk = v
+		r.left -= uint64(len(v))
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
@@ -98,3 +98,50 @@ func EnsureNotChangedBool(tx GetPut, bucket string, k []byte, value bool) (ok, e
 	enabled = bytes2bool(vBytes)
 	return value == enabled, enabled, nil
 }
+
+// UndoLog - undo callbacks of in-memory changes made together with writes of one RwTx,
+// so in-memory structures stay consistent with DB if transaction is not committed.
+// nil UndoLog is valid and ignores callbacks - for callers which don't need undo
+type UndoLog struct {
+	undo []func()
+}
+
+// OnRollback - registers callback reverting in-memory change, callbacks are executed in reverse order
+func (u *UndoLog) OnRollback(f func()) {
+	if u == nil {
+		return
+	}
+	u.undo = append(u.undo, f)
+}
+
+func (u *UndoLog) Len() int {
+	if u == nil {
+		return 0
+	}
+	return len(u.undo)
+}
+
+// Rollback - executes registered callbacks in reverse order and forgets them
+func (u *UndoLog) Rollback() {
+	if u == nil {
+		return
+	}
+	for i := len(u.undo) - 1; i >= 0; i-- {
+		u.undo[i]()
+		u.undo[i] = nil
+	}
+	u.undo = u.undo[:0]
+}
+
+// UpdateWithUndo - like RwDB.Update, for atomic writes into several tables together with in-memory changes:
+// if f or commit fails - in-memory changes registered in UndoLog are reverted
+func UpdateWithUndo(ctx context.Context, db RwDB, f func(tx RwTx, undo *UndoLog) error) error {
+	undo := &UndoLog{}
+	if err := db.Update(ctx, func(tx RwTx) error {
+		return f(tx, undo)
+	}); err != nil {
+		undo.Rollback()
+		return err
+	}
+	return nil
+}
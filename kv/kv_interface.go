@@ -121,7 +121,9 @@ type Getter interface {
 	//   - implementations of local db - stop
 	//   - implementations of remote db - do not handle this error and may finish (send all entries to client) before error happen.
 	ForEach(bucket string, fromPrefix []byte, walker func(k, v []byte) error) error
+	// ForPrefix iterates over entries with keys which have given prefix (nil prefix - all entries).
 	ForPrefix(bucket string, prefix []byte, walker func(k, v []byte) error) error
+	// ForAmount iterates over at most `amount` entries with keys greater or equal to prefix.
 	ForAmount(bucket string, prefix []byte, amount uint32, walker func(k, v []byte) error) error
 }
 
@@ -320,6 +322,8 @@ type CursorDupSort interface {
 	FirstDup() ([]byte, error)          // FirstDup - position at first data item of current key
 	NextDup() ([]byte, []byte, error)   // NextDup - position at next data item of current key
 	NextNoDup() ([]byte, []byte, error) // NextNoDup - position at first data item of next key
+	PrevDup() ([]byte, []byte, error)   // PrevDup - position at previous data item of current key
+	PrevNoDup() ([]byte, []byte, error) // PrevNoDup - position at last data item of previous key
 	LastDup() ([]byte, error)           // LastDup - position at last data item of current key
 
 	CountDuplicates() (uint64, error) // CountDuplicates - number of duplicates for the current key
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"sort"
@@ -26,10 +27,12 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
+	"github.com/c2h5oh/datasize"
 	"github.com/google/btree"
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"go.uber.org/atomic"
 	"golang.org/x/crypto/sha3"
@@ -38,21 +41,35 @@ import (
 type Cache interface {
 	// View - returns CacheView consistent with givent kv.Tx
 	View(ctx context.Context, tx kv.Tx) (CacheView, error)
+	// ViewAt - returns CacheView of state after block with given hash, if it's one of recent blocks.
+	// Given kv.Tx must be consistent with that block, it serves keys which are not in cache
+	ViewAt(ctx context.Context, tx kv.Tx, blockHash [32]byte) (CacheView, error)
 	OnNewBlock(sc *remote.StateChangeBatch)
 	Len() int
 }
 type CacheView interface {
 	Get(k []byte) ([]byte, error)
 	GetCode(k []byte) ([]byte, error)
+	// GetStorage - storage slot value, cached only if cache was created with CoherentConfig.WithStorage
+	GetStorage(addr []byte, incarnation uint64, location []byte) ([]byte, error)
+	// Pin - binds view to the state root it currently serves: root is not evicted until Unpin, and if it's
+	// replaced by state changes of another block - reads return ErrStale instead of data of the new root.
+	// Pin and Unpin must not be called concurrently with reads of the view
+	Pin() error
+	Unpin()
 }
 
+// ErrStale - state root of the view is gone (evicted or replaced), view can't serve consistent reads anymore
+var ErrStale = errors.New("kvcache view is stale")
+
 // Coherent works on top of Database Transaction and pair Coherent+ReadTransaction must
 // provide "Serializable Isolation Level" semantic: all data form consistent db view at moment
 // when read transaction started, read data are immutable until end of read transaction, reader can't see newer updates
 //
 // Every time a new state change comes, we do the following:
-// - Check that prevBlockHeight and prevBlockHash match what is the top values we have, and if they don't we
-// invalidate the cache, because we missed some messages and cannot consider the cache coherent anymore.
+// - Check that the batch starts from the block of the top view (parentHash of its first block), if it doesn't - start
+// from the root of that block found by hash, or invalidate the cache, because we missed some messages and cannot
+// consider the cache coherent anymore.
 // - Clone the cache pointer (such that the previous pointer is still accessible, but new one shared the content with it),
 // apply state updates to the cloned cache pointer and save under the new identified made from blockHeight and blockHash.
 // - If there is a conditional variable corresponding to the identifier, remove it from the map and notify conditional
@@ -89,8 +106,14 @@ type Coherent struct {
 	keys, evict                  *metrics.Counter
 	codeHits, codeMiss, codeKeys *metrics.Counter
 	codeEvictLen                 *metrics.Counter
+	evictions, codeEvictions     *metrics.Counter
+	viewWaits, invalidations     *metrics.Counter
+	staleViews                   *metrics.Counter
+	stateChanges                 *metrics.Counter
+	size, codeSize               *metrics.Counter
 	latestStateView              *CoherentRoot
 	roots                        map[ViewID]*CoherentRoot
+	blocks                       map[[32]byte]ViewID // hash of the last block of view -> view, for roots in `roots`
 	stateEvict, codeEvict        *ThreadSafeEvictionList
 	lock                         sync.RWMutex
 	cfg                          CoherentConfig
@@ -109,18 +132,54 @@ type CoherentRoot struct {
 	// keys added to `Non-Canonical` views SHOULD NOT be added to stateEvict
 	// cache.latestStateView is always `Canonical`
 	isCanonical bool
+	stateChanges int // amount of keys invalidated by state changes of this view
+
+	pins  int         // amount of pinned views, pinned root is not evicted
+	stale atomic.Bool // root is evicted or replaced, pinned views return ErrStale
+
+	blockHash    [32]byte // hash of the last block applied to the root
+	hasBlockHash bool
 }
 
 // CoherentView - dumb object, which proxy all requests to Coherent object.
-// It's thread-safe, because immutable
+// It's thread-safe, because immutable (except Pin/Unpin)
 type CoherentView struct {
-	viewID ViewID
-	cache  *Coherent
-	tx     kv.Tx
+	viewID  ViewID
+	cache   *Coherent
+	tx      kv.Tx
+	root    *CoherentRoot // set by Pin, then reads are served only by this root
+	foreign bool          // tx is not of viewID (see ViewAt) - values read from it are not added to the cache
+}
+
+func (c *CoherentView) Get(k []byte) ([]byte, error) {
+	return c.cache.get(k, c.tx, c.viewID, c.root, c.foreign)
+}
+func (c *CoherentView) GetCode(k []byte) ([]byte, error) {
+	return c.cache.getCode(k, c.tx, c.viewID, c.root, c.foreign)
+}
+func (c *CoherentView) GetStorage(addr []byte, incarnation uint64, location []byte) ([]byte, error) {
+	return c.cache.getStorage(addr, incarnation, location, c.tx, c.viewID, c.root, c.foreign)
+}
+
+func (c *CoherentView) Pin() error {
+	if c.root != nil {
+		return nil
+	}
+	root, err := c.cache.pin(c.viewID)
+	if err != nil {
+		return err
+	}
+	c.root = root
+	return nil
 }
 
-func (c *CoherentView) Get(k []byte) ([]byte, error)     { return c.cache.Get(k, c.tx, c.viewID) }
-func (c *CoherentView) GetCode(k []byte) ([]byte, error) { return c.cache.GetCode(k, c.tx, c.viewID) }
+func (c *CoherentView) Unpin() {
+	if c.root == nil {
+		return
+	}
+	c.cache.unpin(c.root)
+	c.root = nil
+}
 
 var _ Cache = (*Coherent)(nil)         // compile-time interface check
 var _ CacheView = (*CoherentView)(nil) // compile-time interface check
@@ -134,6 +193,10 @@ type CoherentConfig struct {
 	WithStorage   bool
 	KeysLimit     int
 	CodeKeysLimit int
+	// MemoryLimit, CodeMemoryLimit - least recently used keys are evicted when sum of len(key)+len(value)
+	// of all keys in cache exceeds limit (even if there are less than KeysLimit keys). 0 - no limit
+	MemoryLimit     datasize.ByteSize
+	CodeMemoryLimit datasize.ByteSize
 }
 
 var DefaultCoherentConfig = CoherentConfig{
@@ -143,6 +206,9 @@ var DefaultCoherentConfig = CoherentConfig{
 	CodeKeysLimit: 10_000,
 	MetricsLabel:  "default",
 	WithStorage:   true,
+
+	MemoryLimit:     512 * datasize.MB,
+	CodeMemoryLimit: 256 * datasize.MB,
 }
 
 func New(cfg CoherentConfig) *Coherent {
@@ -151,6 +217,7 @@ func New(cfg CoherentConfig) *Coherent {
 	}
 	return &Coherent{
 		roots:        map[ViewID]*CoherentRoot{},
+		blocks:       map[[32]byte]ViewID{},
 		stateEvict:   &ThreadSafeEvictionList{l: NewList()},
 		codeEvict:    &ThreadSafeEvictionList{l: NewList()},
 		hasher:       sha3.NewLegacyKeccak256(),
@@ -164,6 +231,15 @@ func New(cfg CoherentConfig) *Coherent {
 		codeHits:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_total{result="hit",name="%s"}`, cfg.MetricsLabel)),
 		codeKeys:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_keys_total{name="%s"}`, cfg.MetricsLabel)),
 		codeEvictLen: metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_list_total{name="%s"}`, cfg.MetricsLabel)),
+
+		evictions:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_evictions_total{name="%s"}`, cfg.MetricsLabel)),
+		codeEvictions: metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_evictions_total{name="%s"}`, cfg.MetricsLabel)),
+		viewWaits:     metrics.GetOrCreateCounter(fmt.Sprintf(`cache_view_wait_total{name="%s"}`, cfg.MetricsLabel)),
+		staleViews:    metrics.GetOrCreateCounter(fmt.Sprintf(`cache_stale_views_total{name="%s"}`, cfg.MetricsLabel)),
+		invalidations: metrics.GetOrCreateCounter(fmt.Sprintf(`cache_invalidations_total{name="%s"}`, cfg.MetricsLabel)),
+		stateChanges:  metrics.GetOrCreateCounter(fmt.Sprintf(`cache_state_changes_total{name="%s"}`, cfg.MetricsLabel)),
+		size:          metrics.GetOrCreateCounter(fmt.Sprintf(`cache_size_bytes{name="%s"}`, cfg.MetricsLabel)),
+		codeSize:      metrics.GetOrCreateCounter(fmt.Sprintf(`cache_code_size_bytes{name="%s"}`, cfg.MetricsLabel)),
 	}
 }
 
@@ -185,19 +261,109 @@ func (c *Coherent) selectOrCreateRoot(viewID ViewID) *CoherentRoot {
 	return r
 }
 
+// h256ToHash - converts optional hash of state change, false if it's not set
+func h256ToHash(h *types.H256) ([32]byte, bool) {
+	if h == nil || h.Hi == nil || h.Lo == nil {
+		return [32]byte{}, false
+	}
+	return gointerfaces.ConvertH256ToHash(h), true
+}
+
+// lastBlockHash - hash of the block state of the view corresponds to, after applying the batch
+func lastBlockHash(stateChanges *remote.StateChangeBatch) ([32]byte, bool) {
+	if len(stateChanges.ChangeBatch) == 0 {
+		return [32]byte{}, false
+	}
+	return h256ToHash(stateChanges.ChangeBatch[len(stateChanges.ChangeBatch)-1].BlockHash)
+}
+
+// baseBlockHash - hash of the block the batch is applied on top of: the block it starts unwinding to,
+// or the parent of its first block
+func baseBlockHash(stateChanges *remote.StateChangeBatch) ([32]byte, bool) {
+	if len(stateChanges.ChangeBatch) == 0 {
+		return [32]byte{}, false
+	}
+	first := stateChanges.ChangeBatch[0]
+	if first.Direction == remote.Direction_UNWIND {
+		return h256ToHash(first.BlockHash)
+	}
+	return h256ToHash(first.ParentHash)
+}
+
+// extends - false if the batch is known to start from another block than the one state of the view corresponds to,
+// for example, new block of other fork arrived without unwind
+func extends(r *CoherentRoot, stateChanges *remote.StateChangeBatch) bool {
+	if len(stateChanges.ChangeBatch) == 0 || stateChanges.ChangeBatch[0].Direction == remote.Direction_UNWIND {
+		return true // unwind changes restore values of the root
+	}
+	parent, ok := h256ToHash(stateChanges.ChangeBatch[0].ParentHash)
+	return !ok || !r.hasBlockHash || r.blockHash == parent
+}
+
+// baseRoot - canonical root of the block the batch is applied on top of, if it's still kept. Its state plus changes
+// of the batch is the state of the new view, like for the root of the previous view
+func (c *Coherent) baseRoot(stateChanges *remote.StateChangeBatch) (*CoherentRoot, bool) {
+	h, ok := baseBlockHash(stateChanges)
+	if !ok {
+		return nil, false
+	}
+	id, ok := c.blocks[h]
+	if !ok {
+		return nil, false
+	}
+	r := c.roots[id]
+	return r, r.isCanonical && !r.stale.Load()
+}
+
+// setBlockHash - indexes root by the hash of its last block, root replaced by re-applied view loses its hash
+func (c *Coherent) setBlockHash(viewID ViewID, r *CoherentRoot, stateChanges *remote.StateChangeBatch) {
+	if r.hasBlockHash && c.blocks[r.blockHash] == viewID {
+		delete(c.blocks, r.blockHash)
+	}
+	r.blockHash, r.hasBlockHash = lastBlockHash(stateChanges)
+	if r.hasBlockHash {
+		c.blocks[r.blockHash] = viewID
+	}
+}
+
 // advanceRoot - used for advancing root onNewBlock
-func (c *Coherent) advanceRoot(viewID ViewID) (r *CoherentRoot) {
+func (c *Coherent) advanceRoot(viewID ViewID, stateChanges *remote.StateChangeBatch) (r *CoherentRoot) {
 	r, rootExists := c.roots[viewID]
+	if rootExists && r.isCanonical {
+		// state changes of this view are applied again (after unwind) - don't change root pinned views are reading
+		r.stale.Store(true)
+		if r.hasBlockHash && c.blocks[r.blockHash] == viewID {
+			delete(c.blocks, r.blockHash)
+		}
+		rootExists = false
+	}
 	if !rootExists {
 		r = &CoherentRoot{ready: make(chan struct{})}
 		c.roots[viewID] = r
 	}
+	c.setBlockHash(viewID, r, stateChanges)
 
-	if prevView, ok := c.roots[viewID-1]; ok && prevView.isCanonical {
+	if prevView, ok := c.roots[viewID-1]; ok && prevView.isCanonical && extends(prevView, stateChanges) {
 		//log.Info("advance: clone", "from", viewID-1, "to", viewID)
 		r.cache = prevView.cache.Clone()
 		r.codeCache = prevView.codeCache.Clone()
+	} else if parent, ok := c.baseRoot(stateChanges); ok {
+		// views between parent and this one are of abandoned fork, evict lists are rebuilt from the parent's keys
+		r.cache = parent.cache.Clone()
+		r.codeCache = parent.codeCache.Clone()
+		c.stateEvict.Init()
+		c.codeEvict.Init()
+		r.cache.Ascend(func(i btree.Item) bool {
+			c.stateEvict.PushFront(i.(*Element))
+			return true
+		})
+		r.codeCache.Ascend(func(i btree.Item) bool {
+			c.codeEvict.PushFront(i.(*Element))
+			return true
+		})
 	} else {
+		// parent view is unknown - we missed some state changes, can't trust keys cached before
+		c.invalidations.Inc()
 		c.stateEvict.Init()
 		c.codeEvict.Init()
 		if r.cache == nil {
@@ -225,6 +391,8 @@ func (c *Coherent) advanceRoot(viewID ViewID) (r *CoherentRoot) {
 	c.codeKeys.Set(uint64(c.latestStateView.codeCache.Len()))
 	c.evict.Set(uint64(c.stateEvict.Len()))
 	c.codeEvictLen.Set(uint64(c.codeEvict.Len()))
+	c.size.Set(uint64(c.stateEvict.Size()))
+	c.codeSize.Set(uint64(c.codeEvict.Size()))
 	return r
 }
 
@@ -232,7 +400,7 @@ func (c *Coherent) OnNewBlock(stateChanges *remote.StateChangeBatch) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	id := ViewID(stateChanges.DatabaseViewID)
-	r := c.advanceRoot(id)
+	r := c.advanceRoot(id, stateChanges)
 	for _, sc := range stateChanges.ChangeBatch {
 		for i := range sc.Changes {
 			switch sc.Changes[i].Action {
@@ -264,20 +432,22 @@ func (c *Coherent) OnNewBlock(stateChanges *remote.StateChangeBatch) {
 			default:
 				panic("not implemented yet")
 			}
+			if sc.Changes[i].Action != remote.Action_STORAGE {
+				r.stateChanges++
+			}
 			if c.cfg.WithStorage && len(sc.Changes[i].StorageChanges) > 0 {
+				r.stateChanges += len(sc.Changes[i].StorageChanges)
 				addr := gointerfaces.ConvertH160toAddress(sc.Changes[i].Address)
 				for _, change := range sc.Changes[i].StorageChanges {
 					loc := gointerfaces.ConvertH256ToHash(change.Location)
-					k := make([]byte, 20+8+32)
-					copy(k, addr[:])
-					binary.BigEndian.PutUint64(k[20:], sc.Changes[i].Incarnation)
-					copy(k[20+8:], loc[:])
-					c.add(k, change.Data, r, id)
+					c.add(storageKey(addr[:], sc.Changes[i].Incarnation, loc[:]), change.Data, r, id)
 				}
 			}
 		}
 	}
 
+	c.stateChanges.Add(r.stateChanges)
+
 	switched := r.readyChanClosed.CAS(false, true)
 	if switched {
 		close(r.ready) //broadcast
@@ -297,6 +467,9 @@ func (c *Coherent) View(ctx context.Context, tx kv.Tx) (CacheView, error) {
 	default:
 	}
 
+	if c.cfg.NewBlockWait > 0 { // without NewBlockWait view is served at once, it's a timeout but not a wait
+		c.viewWaits.Inc()
+	}
 	select { // slow blocking path
 	case <-r.ready:
 		//fmt.Printf("recv broadcast2: %d\n", tx.ViewID())
@@ -309,14 +482,57 @@ func (c *Coherent) View(ctx context.Context, tx kv.Tx) (CacheView, error) {
 	return &CoherentView{viewID: ViewID(tx.ViewID()), tx: tx, cache: c}, nil
 }
 
-func (c *Coherent) getFromCache(k []byte, id ViewID, code bool) (btree.Item, *CoherentRoot, error) {
+// ViewAt - view served by the root of the view, where block with given hash was the last applied one.
+// Lets readers which lag behind or follow blocks of abandoned fork use warm cache of one of KeepViews recent roots.
+// Keys which are not in the root are read from tx, and cached only if tx is of the same view
+func (c *Coherent) ViewAt(ctx context.Context, tx kv.Tx, blockHash [32]byte) (CacheView, error) {
 	c.lock.RLock()
-	defer c.lock.RUnlock()
+	id, ok := c.blocks[blockHash]
+	latestViewID := c.latestViewID
+	c.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: block %x is not in recent views, latestViewID=%d", ErrStale, blockHash, latestViewID)
+	}
+	if id == ViewID(tx.ViewID()) {
+		return c.View(ctx, tx)
+	}
+	return &CoherentView{viewID: id, tx: tx, cache: c, foreign: true}, nil
+}
+
+// pin - root of given view, protected from eviction until unpin
+func (c *Coherent) pin(id ViewID) (*CoherentRoot, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	r, ok := c.roots[id]
 	if !ok {
-		return nil, r, fmt.Errorf("too old ViewID: %d, latestViewID=%d", id, c.latestViewID)
+		return nil, fmt.Errorf("%w: too old ViewID: %d, latestViewID=%d", ErrStale, id, c.latestViewID)
+	}
+	r.pins++
+	return r, nil
+}
+
+func (c *Coherent) unpin(r *CoherentRoot) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r.pins-- // unpinned root is evicted by next OnNewBlock, if it's too old
+}
+
+// getFromCache - pinned root is used if not nil, otherwise root of given view
+func (c *Coherent) getFromCache(k []byte, id ViewID, pinned *CoherentRoot, code bool) (btree.Item, *CoherentRoot, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	r, ok := pinned, pinned != nil
+	if pinned == nil {
+		r, ok = c.roots[id]
+	}
+	if !ok {
+		return nil, r, fmt.Errorf("%w: too old ViewID: %d, latestViewID=%d", ErrStale, id, c.latestViewID)
+	}
+	if r.stale.Load() {
+		c.staleViews.Inc()
+		return nil, r, fmt.Errorf("%w: root of ViewID %d is replaced, latestViewID=%d", ErrStale, id, c.latestViewID)
 	}
-	isLatest := c.latestViewID == id
+	isLatest := c.latestStateView == r
 
 	var it btree.Item
 	if code {
@@ -330,8 +546,9 @@ func (c *Coherent) getFromCache(k []byte, id ViewID, code bool) (btree.Item, *Co
 
 	return it, r, nil
 }
-func (c *Coherent) Get(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
-	it, r, err := c.getFromCache(k, id, false)
+func (c *Coherent) Get(k []byte, tx kv.Tx, id ViewID) ([]byte, error) { return c.get(k, tx, id, nil, false) }
+func (c *Coherent) get(k []byte, tx kv.Tx, id ViewID, pinned *CoherentRoot, foreign bool) ([]byte, error) {
+	it, r, err := c.getFromCache(k, id, pinned, false)
 	if err != nil {
 		return nil, err
 	}
@@ -348,6 +565,9 @@ func (c *Coherent) Get(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
 		return nil, err
 	}
 	//fmt.Printf("from db: %#x,%x\n", k, v)
+	if foreign {
+		return v, nil
+	}
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -355,8 +575,33 @@ func (c *Coherent) Get(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
 	return v, nil
 }
 
+// storageKey - key of storage slot in kv.PlainState: addr + incarnation + location
+func storageKey(addr []byte, incarnation uint64, location []byte) []byte {
+	k := make([]byte, 20+8+32)
+	copy(k, addr)
+	binary.BigEndian.PutUint64(k[20:], incarnation)
+	copy(k[20+8:], location)
+	return k
+}
+
+// GetStorage - storage slots share cache (and KeysLimit) with accounts. Without CoherentConfig.WithStorage
+// storage changes are not applied to the cache, so reads go directly to db
+func (c *Coherent) GetStorage(addr []byte, incarnation uint64, location []byte, tx kv.Tx, id ViewID) ([]byte, error) {
+	return c.getStorage(addr, incarnation, location, tx, id, nil, false)
+}
+func (c *Coherent) getStorage(addr []byte, incarnation uint64, location []byte, tx kv.Tx, id ViewID, pinned *CoherentRoot, foreign bool) ([]byte, error) {
+	k := storageKey(addr, incarnation, location)
+	if !c.cfg.WithStorage {
+		return tx.GetOne(kv.PlainState, k)
+	}
+	return c.get(k, tx, id, pinned, foreign)
+}
+
 func (c *Coherent) GetCode(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
-	it, r, err := c.getFromCache(k, id, true)
+	return c.getCode(k, tx, id, nil, false)
+}
+func (c *Coherent) getCode(k []byte, tx kv.Tx, id ViewID, pinned *CoherentRoot, foreign bool) ([]byte, error) {
+	it, r, err := c.getFromCache(k, id, pinned, true)
 	if err != nil {
 		return nil, err
 	}
@@ -373,25 +618,34 @@ func (c *Coherent) GetCode(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
 		return nil, err
 	}
 	//fmt.Printf("from db: %#x,%x\n", k, v)
+	if foreign {
+		return v, nil
+	}
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	v = c.addCode(common.Copy(k), common.Copy(v), r, id).V
 	return v, nil
 }
-func (c *Coherent) removeOldest(r *CoherentRoot) {
+func (c *Coherent) removeOldest(r *CoherentRoot) bool {
 	e := c.stateEvict.Oldest()
-	if e != nil {
-		c.stateEvict.Remove(e)
-		r.cache.Delete(e)
+	if e == nil {
+		return false
 	}
+	c.stateEvict.Remove(e)
+	r.cache.Delete(e)
+	c.evictions.Inc()
+	return true
 }
-func (c *Coherent) removeOldestCode(r *CoherentRoot) {
+func (c *Coherent) removeOldestCode(r *CoherentRoot) bool {
 	e := c.codeEvict.Oldest()
-	if e != nil {
-		c.codeEvict.Remove(e)
-		r.codeCache.Delete(e)
+	if e == nil {
+		return false
 	}
+	c.codeEvict.Remove(e)
+	r.codeCache.Delete(e)
+	c.codeEvictions.Inc()
+	return true
 }
 func (c *Coherent) add(k, v []byte, r *CoherentRoot, id ViewID) *Element {
 	it := &Element{K: k, V: v}
@@ -404,11 +658,13 @@ func (c *Coherent) add(k, v []byte, r *CoherentRoot, id ViewID) *Element {
 		c.stateEvict.Remove(replaced.(*Element))
 	}
 	c.stateEvict.PushFront(it)
-	evict := c.stateEvict.Len() > c.cfg.KeysLimit
 	// Verify size not exceeded
-	if evict {
-		c.removeOldest(r)
+	for c.stateEvict.Len() > c.cfg.KeysLimit || (c.cfg.MemoryLimit > 0 && c.stateEvict.Size() > int(c.cfg.MemoryLimit)) {
+		if !c.removeOldest(r) {
+			break
+		}
 	}
+	c.size.Set(uint64(c.stateEvict.Size()))
 	return it
 }
 func (c *Coherent) addCode(k, v []byte, r *CoherentRoot, id ViewID) *Element {
@@ -422,18 +678,52 @@ func (c *Coherent) addCode(k, v []byte, r *CoherentRoot, id ViewID) *Element {
 		c.codeEvict.Remove(replaced.(*Element))
 	}
 	c.codeEvict.PushFront(it)
-	evict := c.codeEvict.Len() > c.cfg.CodeKeysLimit
 	// Verify size not exceeded
-	if evict {
-		c.removeOldestCode(r)
+	for c.codeEvict.Len() > c.cfg.CodeKeysLimit || (c.cfg.CodeMemoryLimit > 0 && c.codeEvict.Size() > int(c.cfg.CodeMemoryLimit)) {
+		if !c.removeOldestCode(r) {
+			break
+		}
 	}
+	c.codeSize.Set(uint64(c.codeEvict.Size()))
 	return it
 }
 
 type Stat struct {
-	BlockNum  uint64
-	BlockHash [32]byte
-	Lenght    int
+	BlockNum     uint64
+	BlockHash    [32]byte
+	Lenght       int
+	CodeLength   int
+	Canonical    bool
+	StateChanges int // amount of keys invalidated by state changes of this view
+}
+
+// Counters - cumulative counters of cache behavior, also exported as metrics
+type Counters struct {
+	Hits, Misses             uint64
+	CodeHits, CodeMisses     uint64
+	Evictions, CodeEvictions uint64
+	ViewWaits, Timeouts      uint64 // View blocked waiting for OnNewBlock, and how many views were served before it
+	Invalidations            uint64 // Views created without parent - all cached keys were dropped
+	StateChanges             uint64
+}
+
+func DebugCounters(cache Cache) Counters {
+	c, ok := cache.(*Coherent)
+	if !ok {
+		return Counters{}
+	}
+	return Counters{
+		Hits:          c.hits.Get(),
+		Misses:        c.miss.Get(),
+		CodeHits:      c.codeHits.Get(),
+		CodeMisses:    c.codeMiss.Get(),
+		Evictions:     c.evictions.Get(),
+		CodeEvictions: c.codeEvictions.Get(),
+		ViewWaits:     c.viewWaits.Get(),
+		Timeouts:      c.timeout.Get(),
+		Invalidations: c.invalidations.Get(),
+		StateChanges:  c.stateChanges.Get(),
+	}
 }
 
 func DebugStats(cache Cache) []Stat {
@@ -446,8 +736,11 @@ func DebugStats(cache Cache) []Stat {
 	defer casted.lock.RUnlock()
 	for root, r := range casted.roots {
 		res = append(res, Stat{
-			BlockNum: uint64(root),
-			Lenght:   r.cache.Len(),
+			BlockNum:     uint64(root),
+			Lenght:       r.cache.Len(),
+			CodeLength:   r.codeCache.Len(),
+			Canonical:    r.isCanonical,
+			StateChanges: r.stateChanges,
 		})
 	}
 	sort.Slice(res, func(i, j int) bool { return res[i].BlockNum < res[j].BlockNum })
@@ -500,14 +793,19 @@ func (c *Coherent) evictRoots() {
 	}
 	to := c.latestViewID - ViewID(c.cfg.KeepViews)
 	var toDel []ViewID
-	for txId := range c.roots {
-		if txId > to {
+	for txId, r := range c.roots {
+		if txId > to || r.pins > 0 {
 			continue
 		}
 		toDel = append(toDel, txId)
 	}
 	//log.Info("forget old roots", "list", fmt.Sprintf("%d", toDel))
 	for _, txId := range toDel {
+		r := c.roots[txId]
+		r.stale.Store(true)
+		if r.hasBlockHash && c.blocks[r.blockHash] == txId {
+			delete(c.blocks, r.blockHash)
+		}
 		delete(c.roots, txId)
 	}
 }
@@ -542,17 +840,20 @@ func (e *Element) Less(than btree.Item) bool {
 
 type ThreadSafeEvictionList struct {
 	l    *List
+	size int // sum of len(K)+len(V) of all elements
 	lock sync.RWMutex
 }
 
 func (l *ThreadSafeEvictionList) Init() {
 	l.lock.Lock()
 	l.l.Init()
+	l.size = 0
 	l.lock.Unlock()
 }
 func (l *ThreadSafeEvictionList) PushFront(e *Element) {
 	l.lock.Lock()
 	l.l.PushFront(e)
+	l.size += len(e.K) + len(e.V)
 	l.lock.Unlock()
 }
 
@@ -564,6 +865,9 @@ func (l *ThreadSafeEvictionList) MoveToFront(e *Element) {
 
 func (l *ThreadSafeEvictionList) Remove(e *Element) {
 	l.lock.Lock()
+	if e.list == l.l {
+		l.size -= len(e.K) + len(e.V)
+	}
 	l.l.Remove(e)
 	l.lock.Unlock()
 }
@@ -582,6 +886,14 @@ func (l *ThreadSafeEvictionList) Len() int {
 	return length
 }
 
+// Size - sum of len(key)+len(value) of all elements
+func (l *ThreadSafeEvictionList) Size() int {
+	l.lock.RLock()
+	size := l.size
+	l.lock.RUnlock()
+	return size
+}
+
 // ========= copypaste of List implementation from stdlib ========
 
 // Next returns the next list element or nil.
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
@@ -44,7 +45,7 @@ func TestEvictionInUnexpectedOrder(t *testing.T) {
 	c.add([]byte{1}, nil, c.roots[2], 2)
 	require.Equal(0, c.stateEvict.Len())
 
-	c.advanceRoot(2)
+	c.advanceRoot(2, &remote.StateChangeBatch{})
 	require.Equal(1, len(c.roots))
 	require.Equal(2, int(c.latestViewID))
 	require.True(c.roots[2].isCanonical)
@@ -67,12 +68,12 @@ func TestEvictionInUnexpectedOrder(t *testing.T) {
 	require.Equal(2, int(c.latestViewID))
 	require.False(c.roots[6].isCanonical) // parrent exists, but parent has isCanonical=false
 
-	c.advanceRoot(3)
+	c.advanceRoot(3, &remote.StateChangeBatch{})
 	require.Equal(4, len(c.roots))
 	require.Equal(3, int(c.latestViewID))
 	require.True(c.roots[3].isCanonical)
 
-	c.advanceRoot(4)
+	c.advanceRoot(4, &remote.StateChangeBatch{})
 	require.Equal(5, len(c.roots))
 	require.Equal(4, int(c.latestViewID))
 	require.True(c.roots[4].isCanonical)
@@ -82,12 +83,12 @@ func TestEvictionInUnexpectedOrder(t *testing.T) {
 	require.Equal(4, int(c.latestViewID))
 	require.False(c.roots[5].isCanonical)
 
-	c.advanceRoot(5)
+	c.advanceRoot(5, &remote.StateChangeBatch{})
 	require.Equal(5, len(c.roots))
 	require.Equal(5, int(c.latestViewID))
 	require.True(c.roots[5].isCanonical)
 
-	c.advanceRoot(100)
+	c.advanceRoot(100, &remote.StateChangeBatch{})
 	require.Equal(6, len(c.roots))
 	require.Equal(100, int(c.latestViewID))
 	require.True(c.roots[100].isCanonical)
@@ -151,6 +152,88 @@ func TestEviction(t *testing.T) {
 	require.Equal(cfg.KeysLimit, c.stateEvict.Len())
 }
 
+func TestCounters(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	cfg := DefaultCoherentConfig
+	cfg.KeysLimit = 1
+	cfg.NewBlockWait = time.Millisecond
+	cfg.MetricsLabel = "test_counters"
+	c := New(cfg)
+	db := memdb.NewTestDB(t)
+	k1, k2 := [20]byte{1}, [20]byte{2}
+
+	var id uint64
+	_ = db.Update(ctx, func(tx kv.RwTx) error {
+		cacheView, _ := c.View(ctx, tx) // no OnNewBlock for this view - waits and times out
+		view := cacheView.(*CoherentView)
+		id = tx.ViewID()
+		_, _ = c.Get(k1[:], tx, view.viewID)
+		_, _ = c.Get(k1[:], tx, view.viewID)
+		return tx.Put(kv.PlainState, k1[:], []byte{2}) // commit of empty tx doesn't create new view
+	})
+	c.OnNewBlock(&remote.StateChangeBatch{
+		DatabaseViewID: id + 1,
+		ChangeBatch: []*remote.StateChange{
+			{
+				Direction: remote.Direction_FORWARD,
+				Changes: []*remote.AccountChange{{
+					Action:  remote.Action_UPSERT,
+					Address: gointerfaces.ConvertAddressToH160(k1),
+					Data:    []byte{2},
+				}},
+			},
+		},
+	})
+	_ = db.Update(ctx, func(tx kv.RwTx) error {
+		require.Equal(id+1, tx.ViewID())
+		cacheView, _ := c.View(ctx, tx) // latest view, evicts k1 and then k2
+		view := cacheView.(*CoherentView)
+		_, _ = c.Get(k2[:], tx, view.viewID)
+		_, _ = c.Get([]byte{5}, tx, view.viewID)
+		return nil
+	})
+
+	require.Equal(Counters{
+		Hits:          1,
+		Misses:        3,
+		Evictions:     2,
+		ViewWaits:     1,
+		Timeouts:      1,
+		Invalidations: 1,
+		StateChanges:  1,
+	}, DebugCounters(c))
+	stats := DebugStats(c)
+	require.Equal(id+1, stats[len(stats)-1].BlockNum)
+	require.True(stats[len(stats)-1].Canonical)
+	require.Equal(1, stats[len(stats)-1].StateChanges)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	require := require.New(t)
+	cfg := DefaultCoherentConfig
+	cfg.MemoryLimit = 100
+	c := New(cfg)
+	r := c.advanceRoot(1, &remote.StateChangeBatch{})
+
+	k1, k2, k3 := [20]byte{1}, [20]byte{2}, [20]byte{3}
+	c.add(k1[:], make([]byte, 30), r, 1)
+	c.add(k2[:], make([]byte, 30), r, 1)
+	require.Equal(100, c.stateEvict.Size())
+	require.Equal(2, r.cache.Len())
+
+	c.add(k3[:], make([]byte, 30), r, 1) // evicts least recently used k1
+	require.Equal(100, c.stateEvict.Size())
+	require.Nil(r.cache.Get(&Element{K: k1[:]}))
+	require.NotNil(r.cache.Get(&Element{K: k3[:]}))
+
+	c.add(k2[:], make([]byte, 10), r, 1) // replaced value is not counted
+	require.Equal(80, c.stateEvict.Size())
+
+	c.add(k1[:], make([]byte, 200), r, 1) // bigger than limit - evicts everything
+	require.Equal(0, c.stateEvict.Size())
+	require.Equal(0, r.cache.Len())
+}
+
 func TestAPI(t *testing.T) {
 	require := require.New(t)
 	c := New(DefaultCoherentConfig)
@@ -361,3 +444,207 @@ func TestCode(t *testing.T) {
 		return nil
 	})
 }
+
+func TestStorage(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	cfg := DefaultCoherentConfig
+	cfg.NewBlockWait = 0
+	c := New(cfg)
+	cfg.WithStorage = false
+	noStorage := New(cfg)
+	db := memdb.NewTestDB(t)
+	addr, loc := [20]byte{1}, [32]byte{2}
+
+	var id uint64
+	_ = db.Update(ctx, func(tx kv.RwTx) error {
+		_ = tx.Put(kv.PlainState, storageKey(addr[:], 1, loc[:]), []byte{3})
+		id = tx.ViewID()
+		cacheView, _ := c.View(ctx, tx)
+		v, err := cacheView.GetStorage(addr[:], 1, loc[:])
+		require.NoError(err)
+		require.Equal([]byte{3}, v)
+		return nil
+	})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID: id + 1,
+		ChangeBatch: []*remote.StateChange{
+			{
+				Direction: remote.Direction_FORWARD,
+				Changes: []*remote.AccountChange{{
+					Action:      remote.Action_STORAGE,
+					Address:     gointerfaces.ConvertAddressToH160(addr),
+					Incarnation: 1,
+					StorageChanges: []*remote.StorageChange{{
+						Location: gointerfaces.ConvertHashToH256(loc),
+						Data:     []byte{4},
+					}},
+				}},
+			},
+		},
+	}
+	c.OnNewBlock(change)
+	noStorage.OnNewBlock(change)
+	_ = db.Update(ctx, func(tx kv.RwTx) error {
+		require.Equal(id+1, tx.ViewID())
+		// cache has value from state change, which is not in db yet
+		cacheView, _ := c.View(ctx, tx)
+		v, err := cacheView.GetStorage(addr[:], 1, loc[:])
+		require.NoError(err)
+		require.Equal([]byte{4}, v)
+		// other incarnation is a different slot
+		v, err = cacheView.GetStorage(addr[:], 2, loc[:])
+		require.NoError(err)
+		require.Nil(v)
+
+		cacheView, _ = noStorage.View(ctx, tx)
+		v, err = cacheView.GetStorage(addr[:], 1, loc[:])
+		require.NoError(err)
+		require.Equal([]byte{3}, v)
+		return nil
+	})
+}
+
+func TestPinnedView(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	cfg := DefaultCoherentConfig
+	cfg.KeepViews = 2
+	cfg.NewBlockWait = 0
+	c := New(cfg)
+	db := memdb.NewTestDB(t)
+	k1 := [20]byte{1}
+	change := func(id uint64, v byte) *remote.StateChangeBatch {
+		return &remote.StateChangeBatch{
+			DatabaseViewID: id,
+			ChangeBatch: []*remote.StateChange{{
+				Direction: remote.Direction_FORWARD,
+				Changes: []*remote.AccountChange{{
+					Action:  remote.Action_UPSERT,
+					Address: gointerfaces.ConvertAddressToH160(k1),
+					Data:    []byte{v},
+				}},
+			}},
+		}
+	}
+
+	_ = db.Update(ctx, func(tx kv.RwTx) error {
+		id := tx.ViewID()
+		c.OnNewBlock(change(id, 1))
+		pinned, err := c.View(ctx, tx)
+		require.NoError(err)
+		require.NoError(pinned.Pin())
+		unpinned, err := c.View(ctx, tx)
+		require.NoError(err)
+
+		// root of pinned view is replaced by re-applied state changes of the same view (after unwind)
+		c.OnNewBlock(change(id, 42))
+		_, err = pinned.Get(k1[:])
+		require.ErrorIs(err, ErrStale)
+		v, err := unpinned.Get(k1[:])
+		require.NoError(err)
+		require.Equal([]byte{42}, v)
+		pinned.Unpin()
+		require.NoError(pinned.Pin())
+
+		// pinned view serves the same root while new blocks evict it from the cache
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := uint64(1); i <= 20; i++ {
+				c.OnNewBlock(change(id+i, byte(i)))
+			}
+		}()
+		for i := 0; i < 1000; i++ {
+			v, err := pinned.Get(k1[:])
+			require.NoError(err)
+			require.Equal([]byte{42}, v)
+		}
+		wg.Wait()
+		v, err = pinned.Get(k1[:])
+		require.NoError(err)
+		require.Equal([]byte{42}, v)
+		_, ok := c.roots[ViewID(id+1)]
+		require.False(ok) // roots of next views are evicted, pinned one is kept
+
+		pinned.Unpin()
+		c.OnNewBlock(change(id+21, 21))
+		_, err = pinned.Get(k1[:])
+		require.ErrorIs(err, ErrStale)
+		return nil
+	})
+}
+
+func TestViewAt(t *testing.T) {
+	require, ctx := require.New(t), context.Background()
+	cfg := DefaultCoherentConfig
+	cfg.KeepViews = 10
+	cfg.NewBlockWait = 0
+	c := New(cfg)
+	db := memdb.NewTestDB(t)
+	k1, k2, k3 := [20]byte{1}, [20]byte{2}, [20]byte{3}
+	upsert := func(k [20]byte, v byte) *remote.AccountChange {
+		return &remote.AccountChange{Action: remote.Action_UPSERT, Address: gointerfaces.ConvertAddressToH160(k), Data: []byte{v}}
+	}
+	forward := func(height uint64, hash byte, changes ...*remote.AccountChange) *remote.StateChange {
+		return &remote.StateChange{Direction: remote.Direction_FORWARD, BlockHeight: height, BlockHash: gointerfaces.ConvertHashToH256([32]byte{hash}), Changes: changes}
+	}
+
+	_ = db.View(ctx, func(tx kv.Tx) error {
+		id := tx.ViewID()
+		c.OnNewBlock(&remote.StateChangeBatch{DatabaseViewID: id, ChangeBatch: []*remote.StateChange{forward(1, 0xa, upsert(k1, 1))}})
+		c.OnNewBlock(&remote.StateChangeBatch{DatabaseViewID: id + 1, ChangeBatch: []*remote.StateChange{forward(2, 0xb, upsert(k1, 2), upsert(k3, 3))}})
+		c.OnNewBlock(&remote.StateChangeBatch{DatabaseViewID: id + 2, ChangeBatch: []*remote.StateChange{forward(3, 0xc, upsert(k1, 4))}})
+
+		// view of tx itself
+		view, err := c.ViewAt(ctx, tx, [32]byte{0xa})
+		require.NoError(err)
+		v, err := view.Get(k1[:])
+		require.NoError(err)
+		require.Equal([]byte{1}, v)
+
+		// older root is served by block hash, misses are read from tx but not cached
+		view, err = c.ViewAt(ctx, tx, [32]byte{0xb})
+		require.NoError(err)
+		v, err = view.Get(k1[:])
+		require.NoError(err)
+		require.Equal([]byte{2}, v)
+		before := c.roots[ViewID(id+1)].cache.Len()
+		v, err = view.Get(k2[:])
+		require.NoError(err)
+		require.Nil(v)
+		require.Equal(before, c.roots[ViewID(id+1)].cache.Len())
+
+		_, err = c.ViewAt(ctx, tx, [32]byte{0xff})
+		require.ErrorIs(err, ErrStale)
+
+		// reorg: unwind to block 2, new block 3' - next view doesn't follow previous one, but cache of block 2 is reused
+		invalidations := c.invalidations.Get()
+		c.OnNewBlock(&remote.StateChangeBatch{DatabaseViewID: id + 5, ChangeBatch: []*remote.StateChange{
+			{Direction: remote.Direction_UNWIND, BlockHeight: 2, BlockHash: gointerfaces.ConvertHashToH256([32]byte{0xb}), Changes: []*remote.AccountChange{upsert(k1, 2)}},
+			forward(3, 0xd, upsert(k1, 5)),
+		}})
+		require.Equal(invalidations, c.invalidations.Get())
+		view, err = c.ViewAt(ctx, tx, [32]byte{0xd})
+		require.NoError(err)
+		v, err = view.Get(k1[:])
+		require.NoError(err)
+		require.Equal([]byte{5}, v)
+		hits := c.hits.Get()
+		v, err = view.Get(k3[:])
+		require.NoError(err)
+		require.Equal([]byte{3}, v)
+		require.Equal(hits+1, c.hits.Get())
+
+		// sibling of block 3' without unwind: parent hash points to block 2, not to the previous view
+		sibling := forward(3, 0xe, upsert(k2, 6))
+		sibling.ParentHash = gointerfaces.ConvertHashToH256([32]byte{0xb})
+		c.OnNewBlock(&remote.StateChangeBatch{DatabaseViewID: id + 6, ChangeBatch: []*remote.StateChange{sibling}})
+		require.Equal(invalidations, c.invalidations.Get())
+		view, err = c.ViewAt(ctx, tx, [32]byte{0xe})
+		require.NoError(err)
+		v, err = view.Get(k1[:])
+		require.NoError(err)
+		require.Equal([]byte{2}, v)
+		return nil
+	})
+}
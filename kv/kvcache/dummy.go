@@ -32,6 +32,9 @@ func NewDummy() *DummyCache { return &DummyCache{} }
 func (c *DummyCache) View(_ context.Context, tx kv.Tx) (CacheView, error) {
 	return &DummyView{cache: c, tx: tx}, nil
 }
+func (c *DummyCache) ViewAt(_ context.Context, tx kv.Tx, _ [32]byte) (CacheView, error) {
+	return &DummyView{cache: c, tx: tx}, nil
+}
 func (c *DummyCache) OnNewBlock(sc *remote.StateChangeBatch) {}
 func (c *DummyCache) Evict() int                             { return 0 }
 func (c *DummyCache) Len() int                               { return 0 }
@@ -41,6 +44,9 @@ func (c *DummyCache) Get(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
 func (c *DummyCache) GetCode(k []byte, tx kv.Tx, id ViewID) ([]byte, error) {
 	return tx.GetOne(kv.Code, k)
 }
+func (c *DummyCache) GetStorage(addr []byte, incarnation uint64, location []byte, tx kv.Tx, id ViewID) ([]byte, error) {
+	return tx.GetOne(kv.PlainState, storageKey(addr, incarnation, location))
+}
 
 type DummyView struct {
 	cache *DummyCache
@@ -49,3 +55,8 @@ type DummyView struct {
 
 func (c *DummyView) Get(k []byte) ([]byte, error)     { return c.cache.Get(k, c.tx, 0) }
 func (c *DummyView) GetCode(k []byte) ([]byte, error) { return c.cache.GetCode(k, c.tx, 0) }
+func (c *DummyView) GetStorage(addr []byte, incarnation uint64, location []byte) ([]byte, error) {
+	return c.cache.GetStorage(addr, incarnation, location, c.tx, 0)
+}
+func (c *DummyView) Pin() error { return nil }
+func (c *DummyView) Unpin()     {}
@@ -17,14 +17,20 @@
 package mdbx_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
 	"github.com/ledgerwatch/erigon-lib/kv/remotedb"
@@ -33,7 +39,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestSequence(t *testing.T) {
@@ -157,6 +166,348 @@ func TestManagedTx(t *testing.T) {
 	}
 }
 
+func TestForPrefixAndForAmount(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	bucket := kv.ChaindataTables[0]
+	writeDBs, readDBs := setupDatabases(t, log.New(), func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		return map[string]kv.TableCfgItem{bucket: {Flags: 0}}
+	})
+	ctx := context.Background()
+	keys := [][]byte{{0}, {1}, {1, 0}, {1, 0xff}, {2}, {0xff}, {0xff, 0xff}}
+	for _, db := range writeDBs {
+		require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+			for _, k := range keys {
+				if err := tx.Put(bucket, k, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	errStop := fmt.Errorf("stop")
+	for _, db := range readDBs {
+		db := db
+		t.Run(fmt.Sprintf("%T", db), func(t *testing.T) {
+			require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+				forPrefix := func(prefix []byte) (res [][]byte) {
+					require.NoError(t, tx.ForPrefix(bucket, prefix, func(k, v []byte) error {
+						require.Equal(t, k, v)
+						res = append(res, common.Copy(k))
+						return nil
+					}))
+					return res
+				}
+				forAmount := func(from []byte, amount uint32) (res [][]byte) {
+					require.NoError(t, tx.ForAmount(bucket, from, amount, func(k, v []byte) error {
+						require.Equal(t, k, v)
+						res = append(res, common.Copy(k))
+						return nil
+					}))
+					return res
+				}
+				require.Equal(t, keys, forPrefix(nil))
+				require.Equal(t, [][]byte{{1}, {1, 0}, {1, 0xff}}, forPrefix([]byte{1}))
+				require.Equal(t, [][]byte{{0xff}, {0xff, 0xff}}, forPrefix([]byte{0xff}))
+				require.Nil(t, forPrefix([]byte{3}))
+				require.Nil(t, forPrefix([]byte{1, 0xff, 0}))
+				require.Nil(t, forPrefix([]byte{0xff, 0xff, 0xff}))
+
+				require.Equal(t, [][]byte{{1}, {1, 0}}, forAmount([]byte{1}, 2))
+				require.Equal(t, [][]byte{{0xff}, {0xff, 0xff}}, forAmount([]byte{0xff}, 10))
+				require.Equal(t, [][]byte{{2}}, forAmount([]byte{1, 0xff, 0}, 1))
+				require.Nil(t, forAmount(nil, 0))
+
+				var count int
+				require.Equal(t, errStop, tx.ForPrefix(bucket, []byte{1}, func(k, v []byte) error {
+					count++
+					return errStop
+				}))
+				require.Equal(t, 1, count)
+				return nil
+			}))
+		})
+	}
+}
+
+func TestTTLTable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	ttl := kv.NewTTLTable(kv.ChaindataTables[0])
+	db := mdbx.NewMDBX(log.New()).InMem().WithTablessCfg(func(_ kv.TableCfg) kv.TableCfg {
+		return kv.TableCfg{ttl.Table: {}, ttl.Index: {}}
+	}).MustOpen()
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	now := time.Unix(1_000_000, 0)
+	require.NoError(t, ttl.Put(tx, []byte{1}, []byte{1}, now.Add(time.Second)))
+	require.NoError(t, ttl.Put(tx, []byte{2}, []byte{2}, now.Add(time.Hour)))
+	require.NoError(t, ttl.Put(tx, []byte{3}, []byte{3}, now.Add(time.Second)))
+	require.NoError(t, ttl.Put(tx, []byte{3}, []byte{3}, now.Add(2*time.Hour))) // overwrite extends TTL
+	require.NoError(t, ttl.Put(tx, []byte{4}, []byte{4}, now.Add(time.Second)))
+	require.NoError(t, ttl.Delete(tx, []byte{4}))
+	require.ErrorIs(t, ttl.Put(tx, []byte{5}, []byte{5}, time.Unix(-1, 0)), kv.ErrExpireBeforeEpoch)
+
+	pruned, err := ttl.Prune(tx, time.Unix(-1, 0))
+	require.NoError(t, err)
+	require.Equal(t, 0, pruned)
+	pruned, err = ttl.Prune(tx, now)
+	require.NoError(t, err)
+	require.Equal(t, 0, pruned)
+	pruned, err = ttl.Prune(tx, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	for k, expected := range map[byte]bool{1: false, 2: true, 3: true, 4: false} {
+		has, err := tx.Has(ttl.Table, []byte{k})
+		require.NoError(t, err)
+		require.Equal(t, expected, has, k)
+	}
+
+	pruned, err = ttl.Prune(tx, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, pruned)
+	for _, table := range []string{ttl.Table, ttl.Index} {
+		c, err := tx.Cursor(table)
+		require.NoError(t, err)
+		k, _, err := c.First()
+		require.NoError(t, err)
+		require.Nil(t, k, table)
+		c.Close()
+	}
+}
+
+func TestChunked(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	table := kv.ChaindataTables[0]
+	db := mdbx.NewMDBX(log.New()).InMem().WithTablessCfg(func(_ kv.TableCfg) kv.TableCfg {
+		return kv.TableCfg{table: {}}
+	}).MustOpen()
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	read := func(k []byte) []byte {
+		r, err := kv.GetChunked(tx, table, k)
+		require.NoError(t, err)
+		if r == nil {
+			return nil
+		}
+		v, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return v
+	}
+	count := func() (n int) {
+		require.NoError(t, tx.ForEach(table, nil, func(k, v []byte) error { n++; return nil }))
+		return n
+	}
+
+	big := bytes.Repeat([]byte{1, 2, 3}, 10)
+	require.NoError(t, kv.PutChunked(tx, table, []byte{1}, bytes.NewReader(big), 8))
+	require.NoError(t, kv.PutChunked(tx, table, []byte{2}, bytes.NewReader(big[:16]), 8))
+	require.Equal(t, 1+4+1+2, count()) // header and data chunks
+	require.Equal(t, big, read([]byte{1}))
+	require.Equal(t, big[:16], read([]byte{2}))
+	require.Nil(t, read([]byte{3}))
+
+	require.NoError(t, kv.PutChunked(tx, table, []byte{1}, bytes.NewReader(big[:9]), 8)) // leftover chunks removed
+	require.Equal(t, 1+2+1+2, count())
+	require.Equal(t, big[:9], read([]byte{1}))
+
+	require.NoError(t, kv.PutChunked(tx, table, []byte{1}, bytes.NewReader(nil), 8))
+	require.Equal(t, 1+1+2, count())
+	require.Equal(t, []byte{}, read([]byte{1}))
+
+	require.NoError(t, tx.Delete(table, []byte{2, 0, 0, 0, 2}, nil)) // lost chunk is reported
+	r, err := kv.GetChunked(tx, table, []byte{2})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	require.NoError(t, kv.DeleteChunked(tx, table, []byte{2}))
+	require.Equal(t, 1, count())
+	require.Nil(t, read([]byte{2}))
+}
+
+func TestRoTxPool(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	db := mdbx.NewMDBX(log.New()).InMem().MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+	table := kv.ChaindataTables[0]
+	pool := mdbx.NewRoTxPool(db.(*mdbx.MdbxKV), 2)
+	defer pool.Close()
+
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error { return tx.Put(table, []byte{1}, []byte{1}) }))
+	require.NoError(t, pool.View(ctx, func(tx kv.Tx) error {
+		v, err := tx.GetOne(table, []byte{1})
+		require.NoError(t, err)
+		require.Equal(t, []byte{1}, v)
+		return nil
+	}))
+
+	// transaction returned to the pool is renewed on borrow
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error { return tx.Put(table, []byte{2}, []byte{2}) }))
+	tx, err := pool.BeginRo(ctx)
+	require.NoError(t, err)
+	v, err := tx.GetOne(table, []byte{2})
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, v)
+
+	// borrowed transaction can't outlive max blocks
+	pool.OnNewBlock()
+	_, err = tx.GetOne(table, []byte{2})
+	require.NoError(t, err)
+	pool.OnNewBlock()
+	_, err = tx.GetOne(table, []byte{2})
+	require.ErrorIs(t, err, mdbx.ErrTxExpired)
+	_, err = tx.Cursor(table)
+	require.ErrorIs(t, err, mdbx.ErrTxExpired)
+	tx.Rollback()
+
+	require.NoError(t, pool.View(ctx, func(tx kv.Tx) error {
+		_, err := tx.GetOne(table, []byte{2})
+		return err
+	}))
+}
+
+func TestStats(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	db := mdbx.NewMDBX(log.New()).InMem().MustOpen()
+	defer db.Close()
+	table := kv.ChaindataTables[0]
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, tx.Put(table, []byte(fmt.Sprintf("key%03d", i)), []byte{1}))
+	}
+
+	st, err := tx.(*mdbx.MdbxTx).Stats(table)
+	require.NoError(t, err)
+	require.Equal(t, table, st.Name)
+	require.Equal(t, uint64(100), st.Entries)
+
+	dbSt, err := tx.(*mdbx.MdbxTx).DBStats()
+	require.NoError(t, err)
+	require.NotZero(t, dbSt.PageSize)
+	require.NotZero(t, dbSt.FileSize)
+	require.Contains(t, dbSt.Tables, st)
+	for i := 1; i < len(dbSt.Tables); i++ {
+		require.Less(t, dbSt.Tables[i-1].Name, dbSt.Tables[i].Name)
+	}
+}
+
+func TestSnapshotRo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	db := mdbx.NewMDBX(log.New()).InMem().MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+	table := kv.ChaindataTables[0]
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error { return tx.Put(table, []byte{1}, []byte{1}) }))
+
+	snapshot := kv.SnapshotRo(db)
+	defer snapshot.Close()
+	tx, err := snapshot.BeginRo(ctx)
+	require.NoError(t, err)
+	c, err := tx.Cursor(table)
+	require.NoError(t, err)
+	k, _, err := c.First()
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, k)
+	viewID := tx.ViewID()
+	require.ErrorIs(t, snapshot.View(ctx, func(tx kv.Tx) error { return nil }), kv.ErrSnapshotInUse) // nested
+	tx.Rollback()
+
+	// changes made after snapshot was pinned are not visible
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error { return tx.Put(table, []byte{2}, []byte{2}) }))
+	require.NoError(t, snapshot.View(ctx, func(tx kv.Tx) error {
+		require.Equal(t, viewID, tx.ViewID())
+		v, err := tx.GetOne(table, []byte{2})
+		require.NoError(t, err)
+		require.Nil(t, v)
+		return nil
+	}))
+
+	// too old transaction is renewed
+	snapshot.MaxAge(0)
+	require.NoError(t, snapshot.View(ctx, func(tx kv.Tx) error {
+		v, err := tx.GetOne(table, []byte{2})
+		require.NoError(t, err)
+		require.Equal(t, []byte{2}, v)
+		return nil
+	}))
+
+	snapshot.Close()
+	_, err = snapshot.BeginRo(ctx)
+	require.Error(t, err)
+}
+
+func TestStatsDB(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+
+	db := kv.WithStats(mdbx.NewMDBX(log.New()).InMem().MustOpen(), "test")
+	defer db.Close()
+	ctx := context.Background()
+	hot, cold := kv.ChaindataTables[0], kv.ChaindataTables[1]
+	require.NoError(t, db.Update(ctx, func(tx kv.RwTx) error {
+		for i := byte(0); i < 10; i++ {
+			if err := tx.Put(hot, []byte{i}, []byte{i}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(hot, []byte{0}, nil); err != nil {
+			return err
+		}
+		return tx.Put(cold, []byte{1}, []byte{1})
+	}))
+	require.NoError(t, db.View(ctx, func(tx kv.Tx) error {
+		v, err := tx.GetOne(hot, []byte{1})
+		require.NoError(t, err)
+		require.Equal(t, []byte{1}, v)
+		has, err := tx.Has(cold, []byte{2})
+		require.NoError(t, err)
+		require.False(t, has)
+		return nil
+	}))
+
+	stats := db.Stats()
+	require.Len(t, stats, 2)
+	byTable := map[string]kv.TableStats{}
+	for _, s := range stats {
+		byTable[s.Table] = s
+	}
+	require.Equal(t, uint64(1), byTable[hot].Get.Count)
+	require.Equal(t, uint64(10), byTable[hot].Put.Count)
+	require.Equal(t, uint64(1), byTable[hot].Delete.Count)
+	require.Equal(t, uint64(1), byTable[cold].Get.Count)
+	require.Equal(t, uint64(1), byTable[cold].Put.Count)
+	require.Zero(t, byTable[cold].Delete.Count)
+	require.True(t, stats[0].Total() >= stats[1].Total())
+}
+
 func TestRemoteKvVersion(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("fix me on win please")
@@ -505,3 +856,214 @@ func testMultiCursor(t *testing.T, db kv.RwDB, bucket1, bucket2 string) {
 //		})
 //	}
 //}
+
+// flakyKVClient - drops tx stream on demand, counts requests
+type flakyKVClient struct {
+	remote.KVClient
+	drop    bool
+	sends   int
+	version *types.VersionReply // if set - replied instead of server's version
+}
+
+type flakyTxClient struct {
+	remote.KV_TxClient
+	client *flakyKVClient
+}
+
+func (c *flakyKVClient) Tx(ctx context.Context, opts ...grpc.CallOption) (remote.KV_TxClient, error) {
+	stream, err := c.KVClient.Tx(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyTxClient{KV_TxClient: stream, client: c}, nil
+}
+
+func (c *flakyKVClient) Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*types.VersionReply, error) {
+	if c.version != nil {
+		return c.version, nil
+	}
+	return c.KVClient.Version(ctx, in, opts...)
+}
+
+func (s *flakyTxClient) Send(req *remote.Cursor) error {
+	s.client.sends++
+	return s.KV_TxClient.Send(req)
+}
+
+func (s *flakyTxClient) Recv() (*remote.Pair, error) {
+	if s.client.drop {
+		s.client.drop = false
+		return nil, status.Error(codes.Unavailable, "connection dropped")
+	}
+	return s.KV_TxClient.Recv()
+}
+
+func setupFlakyRemote(t *testing.T) (writeDb kv.RwDB, db kv.RoDB, client *flakyKVClient) {
+	ctx := context.Background()
+	logger := log.New()
+	writeDb = mdbx.NewMDBX(logger).InMem().MustOpen()
+	t.Cleanup(writeDb.Close)
+	conn := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	go func() {
+		remote.RegisterKVServer(grpcServer, remotedbserver.NewKvServer(ctx, writeDb))
+		if err := grpcServer.Serve(conn); err != nil {
+			logger.Error("private RPC server fail", "err", err)
+		}
+	}()
+	t.Cleanup(grpcServer.Stop)
+	cc, err := grpc.Dial("", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, url string) (net.Conn, error) { return conn.Dial() }))
+	require.NoError(t, err)
+	client = &flakyKVClient{KVClient: remote.NewKVClient(cc)}
+	v := gointerfaces.VersionFromProto(remotedbserver.KvServiceAPIVersion)
+	rdb, err := remotedb.NewRemote(v, logger, client).Open()
+	require.NoError(t, err)
+	require.True(t, rdb.EnsureVersionCompatibility())
+	return writeDb, rdb, client
+}
+
+func TestRemoteReconnect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+	ctx := context.Background()
+	writeDb, db, client := setupFlakyRemote(t)
+
+	err := writeDb.Update(ctx, func(tx kv.RwTx) error {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			if err := tx.Put(kv.Headers, []byte(k), []byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	tx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	c, err := tx.Cursor(kv.Headers)
+	require.NoError(t, err)
+	k, _, err := c.First()
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), k)
+
+	// cursor continues from the same position on new stream
+	client.drop = true
+	k, _, err = c.Next()
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), k)
+	v1, err := tx.GetOne(kv.Headers, []byte("d"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("d"), v1)
+
+	// position of cursor disappeared in newer view
+	err = writeDb.Update(ctx, func(tx kv.RwTx) error { return tx.Delete(kv.Headers, []byte("b"), nil) })
+	require.NoError(t, err)
+	client.drop = true
+	_, _, err = c.Next()
+	require.ErrorIs(t, err, remotedb.ErrViewExpired)
+
+	// absolute positioning still works
+	k, _, err = c.Seek([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), k)
+}
+
+func u64(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
+
+func TestRemoteReadAhead(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+	ctx := context.Background()
+	writeDb, db, client := setupFlakyRemote(t)
+	err := writeDb.Update(ctx, func(tx kv.RwTx) error {
+		for i := uint64(0); i < 1000; i++ {
+			if err := tx.Put(kv.Headers, u64(i), u64(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	tx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	client.sends = 0
+	var i uint64
+	err = tx.ForEach(kv.Headers, nil, func(k, v []byte) error {
+		require.Equal(t, u64(i), k)
+		require.Equal(t, u64(i), v)
+		i++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), i)
+	require.Less(t, client.sends, 20)
+
+	// other operations see position of cursor as client sees it, not as read-ahead moved it
+	c, err := tx.Cursor(kv.Headers)
+	require.NoError(t, err)
+	defer c.Close()
+	k, _, err := c.First()
+	require.NoError(t, err)
+	for j := 0; j < 10; j++ {
+		k, _, err = c.Next()
+		require.NoError(t, err)
+	}
+	require.Equal(t, u64(10), k)
+	k, _, err = c.Current()
+	require.NoError(t, err)
+	require.Equal(t, u64(10), k)
+	k, _, err = c.Prev()
+	require.NoError(t, err)
+	require.Equal(t, u64(9), k)
+	k, _, err = c.Next()
+	require.NoError(t, err)
+	require.Equal(t, u64(10), k)
+}
+
+func TestRemoteReadAheadOldServer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fix me on win please")
+	}
+	ctx := context.Background()
+	writeDb, _, client := setupFlakyRemote(t)
+	err := writeDb.Update(ctx, func(tx kv.RwTx) error {
+		for i := uint64(0); i < 100; i++ {
+			if err := tx.Put(kv.Headers, u64(i), u64(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// server which doesn't know NEXT_N
+	client.version = &types.VersionReply{Major: 5, Minor: 1, Patch: 0}
+	db, err := remotedb.NewRemote(gointerfaces.Version{Major: 5, Minor: 1}, log.New(), client).Open()
+	require.NoError(t, err)
+	require.True(t, db.EnsureVersionCompatibility())
+
+	tx, err := db.BeginRo(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	client.sends = 0
+	var i uint64
+	err = tx.ForEach(kv.Headers, nil, func(k, v []byte) error {
+		require.Equal(t, u64(i), k)
+		i++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), i)
+	require.Greater(t, client.sends, 100)
+}
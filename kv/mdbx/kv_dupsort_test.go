@@ -0,0 +1,203 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mdbx_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// DupSort cursor conformance: every backend must behave the same, so tests can use memdb instead of MDBX on disk
+const dupSortTable = kv.AccountChangeSet
+
+// fillDupSort - k1: 1,3,5; k2: 2; k3: 1,2
+func fillDupSort(t *testing.T, db kv.RwDB) {
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		c, err := tx.RwCursorDupSort(dupSortTable)
+		require.NoError(t, err)
+		defer c.Close()
+		for _, pair := range [][2]byte{{1, 1}, {1, 3}, {1, 5}, {2, 2}, {3, 1}, {3, 2}} {
+			require.NoError(t, c.AppendDup([]byte{pair[0]}, []byte{pair[1]}))
+		}
+		return nil
+	}))
+}
+
+func TestDupSortConformance(t *testing.T) {
+	logger := log.New()
+	onDisk := mdbx.NewMDBX(logger).Path(t.TempDir()).MustOpen()
+	t.Cleanup(onDisk.Close)
+	writeDBs, readDBs := setupDatabases(t, logger, func(defaultBuckets kv.TableCfg) kv.TableCfg { return defaultBuckets })
+	backends := map[string]kv.RwDB{"memdb": memdb.NewTestDB(t), "mdbx": onDisk}
+	for _, db := range backends {
+		fillDupSort(t, db)
+	}
+	fillDupSort(t, writeDBs[1]) // served by remote
+	for name, db := range backends {
+		db := db
+		t.Run("read "+name, func(t *testing.T) { testDupSortRead(t, db) })
+	}
+	t.Run("read remote", func(t *testing.T) { testDupSortRead(t, readDBs[2]) })
+	for name, db := range backends {
+		db := db
+		t.Run("write "+name, func(t *testing.T) { testDupSortWrite(t, db) })
+	}
+}
+
+func testDupSortRead(t *testing.T, db kv.RoDB) {
+	require := require.New(t)
+	require.NoError(db.View(context.Background(), func(tx kv.Tx) error {
+		c, err := tx.CursorDupSort(dupSortTable)
+		require.NoError(err)
+		defer c.Close()
+
+		// SeekBothExact: exact pair, absent value, absent keys before and after all keys
+		k, v, err := c.SeekBothExact([]byte{1}, []byte{3})
+		require.NoError(err)
+		require.Equal([]byte{1}, k)
+		require.Equal([]byte{3}, v)
+		for _, pair := range [][2]byte{{1, 4}, {1, 6}, {0, 1}, {4, 1}} {
+			k, v, err = c.SeekBothExact([]byte{pair[0]}, []byte{pair[1]})
+			require.NoError(err)
+			require.Nil(k, "%x", pair)
+			require.Nil(v, "%x", pair)
+		}
+
+		// SeekBothRange: first value >= given one, only within the key
+		v, err = c.SeekBothRange([]byte{1}, []byte{4})
+		require.NoError(err)
+		require.Equal([]byte{5}, v)
+		v, err = c.SeekBothRange([]byte{1}, []byte{6})
+		require.NoError(err)
+		require.Nil(v)
+		v, err = c.SeekBothRange([]byte{0}, []byte{1})
+		require.NoError(err)
+		require.Nil(v)
+
+		// NextDup/PrevDup stop at the bounds of current key
+		_, _, err = c.SeekBothExact([]byte{1}, []byte{3})
+		require.NoError(err)
+		k, v, err = c.NextDup()
+		require.NoError(err)
+		require.Equal([]byte{1}, k)
+		require.Equal([]byte{5}, v)
+		k, v, err = c.NextDup()
+		require.NoError(err)
+		require.Nil(k)
+		require.Nil(v)
+		_, _, err = c.SeekBothExact([]byte{1}, []byte{3})
+		require.NoError(err)
+		k, v, err = c.PrevDup()
+		require.NoError(err)
+		require.Equal([]byte{1}, k)
+		require.Equal([]byte{1}, v)
+		k, _, err = c.PrevDup()
+		require.NoError(err)
+		require.Nil(k)
+
+		// FirstDup/LastDup of current key
+		_, _, err = c.SeekBothExact([]byte{3}, []byte{1})
+		require.NoError(err)
+		v, err = c.LastDup()
+		require.NoError(err)
+		require.Equal([]byte{2}, v)
+		v, err = c.FirstDup()
+		require.NoError(err)
+		require.Equal([]byte{1}, v)
+
+		// NextNoDup - first value of next key, PrevNoDup - previous key
+		_, _, err = c.SeekBothExact([]byte{1}, []byte{1})
+		require.NoError(err)
+		k, v, err = c.NextNoDup()
+		require.NoError(err)
+		require.Equal([]byte{2}, k)
+		require.Equal([]byte{2}, v)
+		k, _, err = c.PrevNoDup()
+		require.NoError(err)
+		require.Equal([]byte{1}, k)
+		_, _, err = c.SeekBothExact([]byte{3}, []byte{2})
+		require.NoError(err)
+		k, v, err = c.NextNoDup()
+		require.NoError(err)
+		require.Nil(k)
+		require.Nil(v)
+		return nil
+	}))
+}
+
+func testDupSortWrite(t *testing.T, db kv.RwDB) {
+	require := require.New(t)
+	require.NoError(db.Update(context.Background(), func(tx kv.RwTx) error {
+		c, err := tx.RwCursorDupSort(dupSortTable)
+		require.NoError(err)
+		defer c.Close()
+
+		// AppendDup: values of the last key must be appended in ascending order, without duplicates
+		require.NoError(c.AppendDup([]byte{3}, []byte{4}))
+		require.Error(c.AppendDup([]byte{3}, []byte{3}))
+		require.Error(c.AppendDup([]byte{3}, []byte{4}))
+		require.NoError(c.AppendDup([]byte{4}, []byte{1}))
+		_, _, err = c.SeekExact([]byte{3})
+		require.NoError(err)
+		count, err := c.CountDuplicates()
+		require.NoError(err)
+		require.Equal(uint64(3), count)
+		v, err := c.LastDup()
+		require.NoError(err)
+		require.Equal([]byte{4}, v)
+
+		// Put: existing pair is not duplicated, new value is added to the key
+		require.NoError(c.Put([]byte{2}, []byte{2}))
+		require.NoError(c.Put([]byte{2}, []byte{9}))
+		_, _, err = c.SeekExact([]byte{2})
+		require.NoError(err)
+		count, err = c.CountDuplicates()
+		require.NoError(err)
+		require.Equal(uint64(2), count)
+
+		// Delete: deletes only given pair, absent pair is not an error
+		require.NoError(c.Delete([]byte{3}, []byte{1}))
+		require.NoError(c.Delete([]byte{3}, []byte{1}))
+		require.NoError(c.Delete([]byte{5}, []byte{1}))
+		k, v, err := c.SeekExact([]byte{3})
+		require.NoError(err)
+		require.Equal([]byte{3}, k)
+		require.Equal([]byte{2}, v)
+		count, err = c.CountDuplicates()
+		require.NoError(err)
+		require.Equal(uint64(2), count)
+
+		// DeleteCurrentDuplicates: deletes all values of current key
+		_, _, err = c.SeekExact([]byte{1})
+		require.NoError(err)
+		require.NoError(c.DeleteCurrentDuplicates())
+		k, _, err = c.SeekExact([]byte{1})
+		require.NoError(err)
+		require.Nil(k)
+		k, v, err = c.First()
+		require.NoError(err)
+		require.Equal([]byte{2}, k)
+		require.Equal([]byte{2}, v)
+		return nil
+	}))
+}
@@ -54,6 +54,7 @@ type MdbxOpts struct {
 	log           log.Logger
 	augumentLimit uint64
 	pageSize      uint64
+	migrations    []Migration
 }
 
 func testKVPath() string {
@@ -133,6 +134,12 @@ func (opts MdbxOpts) WithTablessCfg(f TableCfgFunc) MdbxOpts {
 	return opts
 }
 
+// WithMigrations - migrations which Open applies to tables, if db is not opened as Readonly
+func (opts MdbxOpts) WithMigrations(migrations ...Migration) MdbxOpts {
+	opts.migrations = append(append([]Migration{}, opts.migrations...), migrations...)
+	return opts
+}
+
 func (opts MdbxOpts) Open() (kv.RwDB, error) {
 	var err error
 	if opts.inMem {
@@ -233,6 +240,9 @@ func (opts MdbxOpts) Open() (kv.RwDB, error) {
 	for name, cfg := range customBuckets { // copy map to avoid changing global variable
 		db.buckets[name] = cfg
 	}
+	if len(opts.migrations) > 0 {
+		db.buckets[kv.TableVersion] = kv.TableCfgItem{}
+	}
 
 	buckets := bucketSlice(db.buckets)
 	if err := db.openDBIs(buckets); err != nil {
@@ -273,6 +283,12 @@ func (opts MdbxOpts) Open() (kv.RwDB, error) {
 		}
 
 	}
+	if len(opts.migrations) > 0 && opts.flags&mdbx.Readonly == 0 {
+		if err := db.migrate(opts.migrations); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
@@ -1047,12 +1063,30 @@ func (c *MdbxCursor) getBothRange(k, v []byte) ([]byte, error) {
 	_, v, err := c.c.Get(k, v, mdbx.GetBothRange)
 	return v, err
 }
+
+// firstDup, lastDup - MDBX_FIRST_DUP and MDBX_LAST_DUP don't return key and mdbx-go fails to read such reply
+// in RawRead mode, so cursor is positioned by operations which return both key and value
 func (c *MdbxCursor) firstDup() ([]byte, error) {
-	_, v, err := c.c.Get(nil, nil, mdbx.FirstDup)
+	k, _, err := c.c.Get(nil, nil, mdbx.GetCurrent)
+	if err != nil {
+		return nil, err
+	}
+	_, v, err := c.c.Get(k, nil, mdbx.Set)
 	return v, err
 }
 func (c *MdbxCursor) lastDup() ([]byte, error) {
-	_, v, err := c.c.Get(nil, nil, mdbx.LastDup)
+	if _, _, err := c.c.Get(nil, nil, mdbx.GetCurrent); err != nil {
+		return nil, err
+	}
+	// first value of next key (or end of table), and then one step back
+	if _, _, err := c.c.Get(nil, nil, mdbx.NextNoDup); err != nil {
+		if !mdbx.IsNotFound(err) {
+			return nil, err
+		}
+		_, v, err := c.c.Get(nil, nil, mdbx.Last)
+		return v, err
+	}
+	_, v, err := c.c.Get(nil, nil, mdbx.Prev)
 	return v, err
 }
 
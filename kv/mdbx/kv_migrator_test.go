@@ -123,3 +123,58 @@ func TestReadOnlyMode(t *testing.T) {
 	_, _, err = c.Seek([]byte("some prefix"))
 	require.NoError(t, err)
 }
+
+func TestMigrations(t *testing.T) {
+	path := t.TempDir()
+	logger := log.New()
+	tables := func(defaultBuckets kv.TableCfg) kv.TableCfg { return kv.TxpoolTablesCfg }
+	var applied []uint64
+	v1 := mdbx.Migration{Table: kv.PoolInfo, Version: 1, Name: "add_a", Up: func(tx kv.RwTx) error {
+		applied = append(applied, 1)
+		return tx.Put(kv.PoolInfo, []byte("a"), []byte{1})
+	}}
+	v2 := mdbx.Migration{Table: kv.PoolInfo, Version: 2, Name: "add_b", Up: func(tx kv.RwTx) error {
+		applied = append(applied, 2)
+		return tx.Put(kv.PoolInfo, []byte("b"), []byte{2})
+	}}
+	broken := mdbx.Migration{Table: kv.PoolInfo, Version: 3, Name: "broken", Up: func(tx kv.RwTx) error {
+		if err := tx.Put(kv.PoolInfo, []byte("c"), []byte{3}); err != nil {
+			return err
+		}
+		return errors.New("broken")
+	}}
+
+	db := mdbx.NewMDBX(logger).Path(path).WithTablessCfg(tables).WithMigrations(v1).MustOpen()
+	db.Close()
+	require.Equal(t, []uint64{1}, applied)
+
+	// v1 is not applied twice, order of registration doesn't matter
+	db = mdbx.NewMDBX(logger).Path(path).WithTablessCfg(tables).WithMigrations(v2, v1).MustOpen()
+	db.Close()
+	require.Equal(t, []uint64{1, 2}, applied)
+
+	// failed migration doesn't change db
+	_, err := mdbx.NewMDBX(logger).Path(path).WithTablessCfg(tables).WithMigrations(v1, v2, broken).Open()
+	require.Error(t, err)
+
+	// gap in versions
+	_, err = mdbx.NewMDBX(logger).Path(path).WithTablessCfg(tables).WithMigrations(v2).Open()
+	require.Error(t, err)
+
+	db = mdbx.NewMDBX(logger).Path(path).WithTablessCfg(tables).WithMigrations(v1, v2).MustOpen()
+	defer db.Close()
+	require.Equal(t, []uint64{1, 2}, applied)
+	err = db.View(context.Background(), func(tx kv.Tx) error {
+		version, err := mdbx.TableVersion(tx, kv.PoolInfo)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), version)
+		v, err := tx.GetOne(kv.PoolInfo, []byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, []byte{2}, v)
+		v, err = tx.GetOne(kv.PoolInfo, []byte("c"))
+		require.NoError(t, err)
+		require.Nil(t, v)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mdbx
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Migration - changes layout of one table from Version-1 to Version. Must be pure function over tx:
+// no side effects outside of db, because transaction may be rolled back.
+// Version of table is stored in kv.TableVersion table, tables without applied migrations have version 0.
+type Migration struct {
+	Table   string
+	Version uint64
+	Name    string // for logs
+	Up      func(tx kv.RwTx) error
+}
+
+// TableVersion - schema version of table, which is version of last applied migration
+func TableVersion(tx kv.Getter, table string) (uint64, error) {
+	v, err := tx.GetOne(kv.TableVersion, []byte(table))
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func validateMigrations(migrations []Migration, tables kv.TableCfg) ([]Migration, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Table != sorted[j].Table {
+			return sorted[i].Table < sorted[j].Table
+		}
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, m := range sorted {
+		if _, ok := tables[m.Table]; !ok {
+			return nil, fmt.Errorf("migration %s: unknown table %s", m.Name, m.Table)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %s: nil Up function", m.Name)
+		}
+		// versions of table must be 1,2,3... without gaps - otherwise some migration was forgotten
+		var prev uint64
+		if i > 0 && sorted[i-1].Table == m.Table {
+			prev = sorted[i-1].Version
+		}
+		if m.Version != prev+1 {
+			return nil, fmt.Errorf("migration %s: table %s version %d must follow version %d", m.Name, m.Table, m.Version, prev)
+		}
+	}
+	return sorted, nil
+}
+
+// migrate - applies pending migrations in one RwTx: db sees either all of them or none
+func (db *MdbxKV) migrate(migrations []Migration) error {
+	migrations, err := validateMigrations(migrations, db.buckets)
+	if err != nil {
+		return err
+	}
+	return db.Update(context.Background(), func(tx kv.RwTx) error {
+		for _, m := range migrations {
+			current, err := TableVersion(tx, m.Table)
+			if err != nil {
+				return err
+			}
+			if m.Version <= current {
+				continue
+			}
+			if err := m.Up(tx); err != nil {
+				return fmt.Errorf("migration %s of table %s to version %d: %w", m.Name, m.Table, m.Version, err)
+			}
+			var v [8]byte
+			binary.BigEndian.PutUint64(v[:], m.Version)
+			if err := tx.Put(kv.TableVersion, []byte(m.Table), v[:]); err != nil {
+				return err
+			}
+			db.log.Info("[db] applied migration", "name", m.Name, "table", m.Table, "version", m.Version, "label", db.opts.label)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,219 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mdbx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/torquem-ch/mdbx-go/mdbx"
+	"go.uber.org/atomic"
+)
+
+// ErrTxExpired - transaction borrowed from RoTxPool was used after too many new blocks
+var ErrTxExpired = errors.New("read transaction borrowed from pool is too old")
+
+// RoTxPool - pool of read transactions on top of MdbxKV. Opening read transaction for every small operation
+// is expensive - pool keeps reset transactions and renews them on borrow, so borrowed transaction
+// always sees latest committed state. Rollback/Commit of borrowed transaction returns it to the pool.
+//
+// Long-living read transaction doesn't let MDBX reuse pages, so borrowed transaction can't outlive maxBlocks
+// calls of OnNewBlock: after that its operations return ErrTxExpired (already opened cursors still work).
+// maxBlocks=0 - no limit.
+type RoTxPool struct {
+	db        *MdbxKV
+	maxBlocks uint64
+	block     atomic.Uint64 // amount of OnNewBlock calls
+
+	lock   sync.Mutex
+	free   []*mdbx.Txn
+	closed bool
+}
+
+var _ kv.RoDB = (*RoTxPool)(nil) // compile-time interface check
+
+func NewRoTxPool(db *MdbxKV, maxBlocks uint64) *RoTxPool {
+	return &RoTxPool{db: db, maxBlocks: maxBlocks}
+}
+
+// OnNewBlock - transactions borrowed maxBlocks blocks ago become expired
+func (p *RoTxPool) OnNewBlock() { p.block.Inc() }
+
+func (p *RoTxPool) expired(borrowedAt uint64) bool {
+	return p.maxBlocks > 0 && p.block.Load()-borrowedAt >= p.maxBlocks
+}
+
+func (p *RoTxPool) AllBuckets() kv.TableCfg { return p.db.AllBuckets() }
+
+func (p *RoTxPool) View(ctx context.Context, f func(tx kv.Tx) error) error {
+	tx, err := p.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+func (p *RoTxPool) BeginRo(ctx context.Context) (kv.Tx, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, fmt.Errorf("read transactions pool closed")
+	}
+	var txn *mdbx.Txn
+	if n := len(p.free); n > 0 {
+		txn = p.free[n-1]
+		p.free = p.free[:n-1]
+	}
+	p.lock.Unlock()
+
+	if txn != nil {
+		if err := txn.Renew(); err != nil {
+			txn.Abort()
+			txn = nil
+		}
+	}
+	if txn == nil {
+		if p.db.env == nil {
+			return nil, fmt.Errorf("db closed")
+		}
+		var err error
+		if txn, err = p.db.env.BeginTxn(nil, mdbx.Readonly); err != nil {
+			return nil, fmt.Errorf("%w, label: %s", err, p.db.opts.label.String())
+		}
+		txn.RawRead = true
+	}
+	p.db.wg.Add(1)
+	return &pooledTx{
+		MdbxTx:     &MdbxTx{db: p.db, tx: txn, readOnly: true},
+		pool:       p,
+		borrowedAt: p.block.Load(),
+	}, nil
+}
+
+func (p *RoTxPool) put(txn *mdbx.Txn, borrowedAt uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		txn.Abort()
+		return
+	}
+	if p.expired(borrowedAt) {
+		p.db.log.Warn("[db] read transaction borrowed from pool outlived max blocks", "blocks", p.block.Load()-borrowedAt, "label", p.db.opts.label)
+	}
+	txn.Reset()
+	p.free = append(p.free, txn)
+}
+
+// Close - aborts transactions in the pool (borrowed are aborted when returned), doesn't close db.
+// Must be called before db.Close
+func (p *RoTxPool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, txn := range p.free {
+		txn.Abort()
+	}
+	p.free = nil
+	p.closed = true
+}
+
+// pooledTx - transaction borrowed from RoTxPool
+type pooledTx struct {
+	*MdbxTx
+	pool       *RoTxPool
+	borrowedAt uint64
+}
+
+func (tx *pooledTx) checkAge() error {
+	if tx.pool.expired(tx.borrowedAt) {
+		return ErrTxExpired
+	}
+	return nil
+}
+
+func (tx *pooledTx) Has(bucket string, key []byte) (bool, error) {
+	if err := tx.checkAge(); err != nil {
+		return false, err
+	}
+	return tx.MdbxTx.Has(bucket, key)
+}
+
+func (tx *pooledTx) GetOne(bucket string, key []byte) ([]byte, error) {
+	if err := tx.checkAge(); err != nil {
+		return nil, err
+	}
+	return tx.MdbxTx.GetOne(bucket, key)
+}
+
+func (tx *pooledTx) ForEach(bucket string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.checkAge(); err != nil {
+		return err
+	}
+	return tx.MdbxTx.ForEach(bucket, fromPrefix, walker)
+}
+
+func (tx *pooledTx) ForPrefix(bucket string, prefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.checkAge(); err != nil {
+		return err
+	}
+	return tx.MdbxTx.ForPrefix(bucket, prefix, walker)
+}
+
+func (tx *pooledTx) ForAmount(bucket string, fromPrefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	if err := tx.checkAge(); err != nil {
+		return err
+	}
+	return tx.MdbxTx.ForAmount(bucket, fromPrefix, amount, walker)
+}
+
+func (tx *pooledTx) Cursor(bucket string) (kv.Cursor, error) {
+	if err := tx.checkAge(); err != nil {
+		return nil, err
+	}
+	return tx.MdbxTx.Cursor(bucket)
+}
+
+func (tx *pooledTx) CursorDupSort(bucket string) (kv.CursorDupSort, error) {
+	if err := tx.checkAge(); err != nil {
+		return nil, err
+	}
+	return tx.MdbxTx.CursorDupSort(bucket)
+}
+
+func (tx *pooledTx) Commit() error {
+	tx.Rollback()
+	return nil
+}
+
+func (tx *pooledTx) Rollback() {
+	if tx.tx == nil {
+		return
+	}
+	txn := tx.tx
+	tx.closeCursors()
+	tx.tx = nil
+	tx.db.wg.Done()
+	tx.pool.put(txn, tx.borrowedAt)
+}
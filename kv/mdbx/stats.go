@@ -0,0 +1,91 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mdbx
+
+import (
+	"sort"
+)
+
+// TableStats - same numbers as `mdbx_stat -a` shows for one table
+type TableStats struct {
+	Name          string
+	Depth         uint
+	BranchPages   uint64
+	LeafPages     uint64
+	OverflowPages uint64
+	Entries       uint64
+	Size          uint64 // bytes in all pages of the table
+}
+
+// DBStats - aggregate report about the database, as seen by given transaction
+type DBStats struct {
+	FileSize   uint64 // current size of the data file
+	PageSize   uint64
+	FreePages  uint64 // pages which can be reused, estimated by size of GC table
+	LastTxnID  uint64
+	NumReaders uint32
+	Tables     []TableStats // sorted by name, without deprecated and not created tables
+}
+
+// Stats - statistics of given table, "gc" - is the table of free pages
+func (tx *MdbxTx) Stats(table string) (TableStats, error) {
+	st, err := tx.BucketStat(table)
+	if err != nil {
+		return TableStats{}, err
+	}
+	return TableStats{
+		Name:          table,
+		Depth:         st.Depth,
+		BranchPages:   st.BranchPages,
+		LeafPages:     st.LeafPages,
+		OverflowPages: st.OverflowPages,
+		Entries:       st.Entries,
+		Size:          (st.LeafPages + st.BranchPages + st.OverflowPages) * tx.db.opts.pageSize,
+	}, nil
+}
+
+// DBStats - statistics of all tables and of the database file
+func (tx *MdbxTx) DBStats() (DBStats, error) {
+	info, err := tx.db.env.Info(tx.tx)
+	if err != nil {
+		return DBStats{}, err
+	}
+	res := DBStats{
+		FileSize:   info.Geo.Current,
+		PageSize:   tx.db.opts.pageSize,
+		LastTxnID:  uint64(info.LastTxnID),
+		NumReaders: uint32(info.NumReaders),
+	}
+	gc, err := tx.BucketStat("gc")
+	if err != nil {
+		return DBStats{}, err
+	}
+	// GC stores lists of 8-bytes page numbers
+	res.FreePages = (gc.LeafPages + gc.OverflowPages) * tx.db.opts.pageSize / 8
+	for name, cfg := range tx.db.buckets {
+		if cfg.IsDeprecated || cfg.DBI == NonExistingDBI {
+			continue
+		}
+		st, err := tx.Stats(name)
+		if err != nil {
+			return DBStats{}, err
+		}
+		res.Tables = append(res.Tables, st)
+	}
+	sort.Slice(res.Tables, func(i, j int) bool { return res.Tables[i].Name < res.Tables[j].Name })
+	return res, nil
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/ledgerwatch/log/v3"
 )
 
+// New - in-memory MDBX, cursors (including DupSort ones) behave the same as on disk, see mdbx.TestDupSortConformance
 func New() kv.RwDB {
 	return mdbx.NewMDBX(log.New()).InMem().MustOpen()
 }
@@ -3,6 +3,7 @@ package remotedb
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
@@ -11,8 +12,6 @@ import (
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
 	"github.com/ledgerwatch/log/v3"
-	"google.golang.org/grpc"
-	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 // generate the messages and services
@@ -22,6 +21,7 @@ type remoteOpts struct {
 	version     gointerfaces.Version
 	remoteKV    remote.KVClient
 	log         log.Logger
+	reconnects  int // attempts to re-establish dropped tx stream, 0 - don't reconnect
 }
 
 type RemoteKV struct {
@@ -29,6 +29,7 @@ type RemoteKV struct {
 	log      log.Logger
 	buckets  kv.TableCfg
 	opts     remoteOpts
+	nextN    bool // server reported version which supports NEXT_N (5.2+), set by EnsureVersionCompatibility
 }
 
 type remoteTx struct {
@@ -40,6 +41,7 @@ type remoteTx struct {
 	statelessCursors   map[string]kv.Cursor
 	streamingRequested bool
 	id                 uint64
+	failures           int // reconnect attempts since last successful round-trip
 }
 
 type remoteCursor struct {
@@ -49,6 +51,14 @@ type remoteCursor struct {
 	bucketName string
 	bucketCfg  kv.TableCfgItem
 	id         uint32
+
+	// last position of cursor - to restore it on new stream after reconnect
+	k, v       []byte
+	positioned bool
+	lost       bool // position was not restored, relative operations will return ErrViewExpired
+
+	buf   []*remote.Pair // pairs read ahead by NEXT_N, server-side cursor is positioned at last of them
+	batch uint32         // size of next read-ahead, grows while client calls only Next
 }
 
 type remoteCursorDupSort struct {
@@ -64,6 +74,15 @@ func (opts remoteOpts) WithBucketsConfig(f mdbx.TableCfgFunc) remoteOpts {
 	return opts
 }
 
+// maxReadAhead - max amount of pairs requested by one NEXT_N
+const maxReadAhead = 255
+
+// MaxReconnects - how many times transaction tries to re-establish dropped stream before returning error
+func (opts remoteOpts) MaxReconnects(n int) remoteOpts {
+	opts.reconnects = n
+	return opts
+}
+
 func (opts remoteOpts) Open() (*RemoteKV, error) {
 	db := &RemoteKV{
 		opts:     opts,
@@ -91,7 +110,7 @@ func (opts remoteOpts) MustOpen() kv.RwDB {
 // version parameters represent the version the KV client is expecting,
 // compatibility check will be performed when the KV connection opens
 func NewRemote(v gointerfaces.Version, logger log.Logger, remoteKV remote.KVClient) remoteOpts {
-	return remoteOpts{bucketsCfg: mdbx.WithChaindataTables, version: v, log: logger, remoteKV: remoteKV}
+	return remoteOpts{bucketsCfg: mdbx.WithChaindataTables, version: v, log: logger, remoteKV: remoteKV, reconnects: DefaultMaxReconnects}
 }
 
 func (db *RemoteKV) AllBuckets() kv.TableCfg {
@@ -99,18 +118,17 @@ func (db *RemoteKV) AllBuckets() kv.TableCfg {
 }
 
 func (db *RemoteKV) EnsureVersionCompatibility() bool {
-	versionReply, err := db.remoteKV.Version(context.Background(), &emptypb.Empty{}, grpc.WaitForReady(true))
-	if err != nil {
-		db.log.Error("getting Version", "error", err)
+	server, err := gointerfaces.NegotiateVersion(context.Background(), db.remoteKV, db.opts.version, gointerfaces.SameMinor)
+	if errors.Is(err, gointerfaces.ErrIncompatibleVersion) {
+		db.log.Error("incompatible interface versions", "err", err)
 		return false
 	}
-	if !gointerfaces.EnsureVersion(db.opts.version, versionReply) {
-		db.log.Error("incompatible interface versions", "client", db.opts.version.String(),
-			"server", fmt.Sprintf("%d.%d.%d", versionReply.Major, versionReply.Minor, versionReply.Patch))
+	if err != nil {
+		db.log.Error("getting Version", "error", err)
 		return false
 	}
-	db.log.Info("interfaces compatible", "client", db.opts.version.String(),
-		"server", fmt.Sprintf("%d.%d.%d", versionReply.Major, versionReply.Minor, versionReply.Patch))
+	db.nextN = server.Major > 5 || (server.Major == 5 && server.Minor >= 2)
+	db.log.Info("interfaces compatible", "client", db.opts.version.String(), "server", server.String())
 	return true
 }
 
@@ -286,15 +304,12 @@ func (c *remoteCursor) Prev() ([]byte, []byte, error) {
 func (tx *remoteTx) Cursor(bucket string) (kv.Cursor, error) {
 	b := tx.db.buckets[bucket]
 	c := &remoteCursor{tx: tx, ctx: tx.ctx, bucketName: bucket, bucketCfg: b, stream: tx.stream}
-	tx.cursors = append(tx.cursors, c)
-	if err := c.stream.Send(&remote.Cursor{Op: remote.Op_OPEN, BucketName: c.bucketName}); err != nil {
-		return nil, err
-	}
-	msg, err := c.stream.Recv()
+	msg, err := c.op(&remote.Cursor{Op: remote.Op_OPEN, BucketName: c.bucketName})
 	if err != nil {
 		return nil, err
 	}
 	c.id = msg.CursorID
+	tx.cursors = append(tx.cursors, c)
 	return c, nil
 }
 
@@ -306,151 +321,144 @@ func (c *remoteCursor) DeleteCurrent() error                          { panic("n
 func (c *remoteCursor) Count() (uint64, error)                        { panic("not supported") }
 
 func (c *remoteCursor) first() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_FIRST}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_FIRST})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 
+// next - reads ahead by NEXT_N if client does sequential scan: batch size doubles on every round-trip
+// (up to maxReadAhead) and resets by any other operation. Servers older than 5.2 get plain NEXT
 func (c *remoteCursor) next() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_NEXT}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
-	if err != nil {
-		return []byte{}, nil, err
+	if len(c.buf) == 0 {
+		if c.batch < maxReadAhead {
+			c.batch = c.batch*2 + 1
+		}
+		if c.batch == 1 || !c.tx.db.nextN {
+			pair, err := c.op(&remote.Cursor{Op: remote.Op_NEXT})
+			if err != nil {
+				return []byte{}, nil, err
+			}
+			c.batch = 1 // op resets it
+			return pair.K, pair.V, nil
+		}
+		pairs, err := c.call(&remote.Cursor{Op: remote.Op_NEXT_N, N: c.batch})
+		if err != nil {
+			return []byte{}, nil, err
+		}
+		c.buf = pairs
 	}
+	pair := c.buf[0]
+	c.buf = c.buf[1:]
+	c.k, c.v, c.positioned, c.lost = pair.K, pair.V, true, false
 	return pair.K, pair.V, nil
 }
-func (c *remoteCursor) nextDup() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_NEXT_DUP}); err != nil {
-		return []byte{}, nil, err
+
+// dropReadAhead - before other operations server-side cursor must be moved back to position seen by client
+func (c *remoteCursor) dropReadAhead(op remote.Op) error {
+	c.batch = 0
+	if len(c.buf) == 0 {
+		return nil
 	}
-	pair, err := c.stream.Recv()
+	c.buf = nil
+	if !isRelativeOp(op) {
+		return nil
+	}
+	var err error
+	if c.bucketCfg.Flags&kv.DupSort != 0 {
+		_, err = c.call(&remote.Cursor{Op: remote.Op_SEEK_BOTH_EXACT, K: c.k, V: c.v})
+	} else {
+		_, err = c.call(&remote.Cursor{Op: remote.Op_SEEK_EXACT, K: c.k})
+	}
+	return err
+}
+func (c *remoteCursor) nextDup() ([]byte, []byte, error) {
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_NEXT_DUP})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) nextNoDup() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_NEXT_NO_DUP}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_NEXT_NO_DUP})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) prev() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_PREV}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_PREV})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) prevDup() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_PREV_DUP}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_PREV_DUP})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) prevNoDup() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_PREV_NO_DUP}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_PREV_NO_DUP})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) last() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_LAST}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_LAST})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) setRange(k []byte) ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK, K: k}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_SEEK, K: k})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) seekExact(k []byte) ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK_EXACT, K: k}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_SEEK_EXACT, K: k})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) getBothRange(k, v []byte) ([]byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK_BOTH, K: k, V: v}); err != nil {
-		return nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_SEEK_BOTH, K: k, V: v})
 	if err != nil {
 		return nil, err
 	}
 	return pair.V, nil
 }
 func (c *remoteCursor) seekBothExact(k, v []byte) ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK_BOTH_EXACT, K: k, V: v}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_SEEK_BOTH_EXACT, K: k, V: v})
 	if err != nil {
 		return []byte{}, nil, err
 	}
 	return pair.K, pair.V, nil
 }
 func (c *remoteCursor) firstDup() ([]byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_FIRST_DUP}); err != nil {
-		return nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_FIRST_DUP})
 	if err != nil {
 		return nil, err
 	}
 	return pair.V, nil
 }
 func (c *remoteCursor) lastDup() ([]byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_LAST_DUP}); err != nil {
-		return nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_LAST_DUP})
 	if err != nil {
 		return nil, err
 	}
 	return pair.V, nil
 }
 func (c *remoteCursor) getCurrent() ([]byte, []byte, error) {
-	if err := c.stream.Send(&remote.Cursor{Cursor: c.id, Op: remote.Op_CURRENT}); err != nil {
-		return []byte{}, nil, err
-	}
-	pair, err := c.stream.Recv()
+	pair, err := c.op(&remote.Cursor{Op: remote.Op_CURRENT})
 	if err != nil {
 		return []byte{}, nil, err
 	}
@@ -0,0 +1,205 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package remotedb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// ErrViewExpired - tx stream was re-established, but state which transaction was reading is not available anymore:
+// server opened older view, or position of cursor disappeared in the new view
+var ErrViewExpired = errors.New("remote view expired")
+
+const (
+	DefaultMaxReconnects = 5
+	reconnectBaseDelay   = 100 * time.Millisecond
+	reconnectMaxDelay    = 5 * time.Second
+)
+
+// op - sends request of cursor and receives reply. If stream dropped - re-establishes it and repeats request
+func (c *remoteCursor) op(req *remote.Cursor) (*remote.Pair, error) {
+	if err := c.dropReadAhead(req.Op); err != nil {
+		return nil, err
+	}
+	pairs, err := c.call(req)
+	if err != nil {
+		return nil, err
+	}
+	return pairs[0], nil
+}
+
+func (c *remoteCursor) call(req *remote.Cursor) ([]*remote.Pair, error) {
+	for {
+		if req.Op != remote.Op_OPEN {
+			if c.lost && isRelativeOp(req.Op) {
+				return nil, ErrViewExpired
+			}
+			req.Cursor = c.id
+		}
+		pairs, err := c.roundTrip(req)
+		if err == nil {
+			c.tx.failures = 0
+			if req.Op != remote.Op_OPEN && req.Op != remote.Op_NEXT_N {
+				c.track(req, pairs[0])
+			}
+			return pairs, nil
+		}
+		if err = c.tx.reconnect(err); err != nil {
+			return nil, err
+		}
+		c.stream = c.tx.stream
+	}
+}
+
+// roundTrip - sends request and receives 1 reply, or up to req.N replies for NEXT_N
+func (c *remoteCursor) roundTrip(req *remote.Cursor) ([]*remote.Pair, error) {
+	if err := c.stream.Send(req); err != nil {
+		return nil, err
+	}
+	var pairs []*remote.Pair
+	for {
+		pair, err := c.stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+		if req.Op != remote.Op_NEXT_N || pair.K == nil || uint32(len(pairs)) >= req.N {
+			return pairs, nil
+		}
+	}
+}
+
+// track - remembers position of cursor after successful operation
+func (c *remoteCursor) track(req *remote.Cursor, pair *remote.Pair) {
+	c.positioned, c.lost = true, false
+	switch req.Op {
+	case remote.Op_FIRST_DUP, remote.Op_LAST_DUP:
+		c.v = pair.V
+	case remote.Op_SEEK_BOTH:
+		c.k, c.v = common.Copy(req.K), pair.V
+	default:
+		c.k, c.v = pair.K, pair.V
+	}
+	if c.v == nil && c.bucketCfg.Flags&kv.DupSort != 0 {
+		c.k = nil
+	}
+}
+
+func isRelativeOp(op remote.Op) bool {
+	switch op {
+	case remote.Op_NEXT, remote.Op_NEXT_N, remote.Op_NEXT_DUP, remote.Op_NEXT_NO_DUP, remote.Op_PREV, remote.Op_PREV_DUP, remote.Op_PREV_NO_DUP,
+		remote.Op_CURRENT, remote.Op_FIRST_DUP, remote.Op_LAST_DUP:
+		return true
+	}
+	return false
+}
+
+// restore - opens cursor on new stream and moves it to the last known position
+func (c *remoteCursor) restore() error {
+	c.buf = nil // read-ahead pairs are from old view
+	pairs, err := c.roundTrip(&remote.Cursor{Op: remote.Op_OPEN, BucketName: c.bucketName})
+	if err != nil {
+		return err
+	}
+	c.id = pairs[0].CursorID
+	if !c.positioned {
+		return nil
+	}
+	if c.k == nil { // cursor was out of table, new one can't be put there
+		c.lost = true
+		return nil
+	}
+	if c.bucketCfg.Flags&kv.DupSort != 0 {
+		pairs, err = c.roundTrip(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK_BOTH_EXACT, K: c.k, V: c.v})
+	} else {
+		pairs, err = c.roundTrip(&remote.Cursor{Cursor: c.id, Op: remote.Op_SEEK_EXACT, K: c.k})
+	}
+	if err != nil {
+		return err
+	}
+	c.lost = !bytes.Equal(pairs[0].K, c.k)
+	return nil
+}
+
+func (tx *remoteTx) retriable(err error) bool {
+	return tx.ctx.Err() == nil && (grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err))
+}
+
+// reconnect - re-establishes tx stream with exponential backoff. Returns nil if view and cursors are resumed,
+// ErrViewExpired if it's impossible, or cause if stream can't be re-established
+func (tx *remoteTx) reconnect(cause error) error {
+	for tx.retriable(cause) && tx.failures < tx.db.opts.reconnects {
+		delay := reconnectBaseDelay << tx.failures
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+		tx.failures++
+		tx.db.log.Debug("[remote db] tx stream dropped, reconnecting", "err", cause, "attempt", tx.failures, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-tx.ctx.Done():
+			return tx.ctx.Err()
+		}
+		err := tx.resume()
+		if err == nil || errors.Is(err, ErrViewExpired) {
+			return err
+		}
+		cause = err
+	}
+	return cause
+}
+
+// resume - opens new stream and re-opens all cursors of transaction on it
+func (tx *remoteTx) resume() error {
+	if tx.streamCancelFn != nil {
+		tx.streamCancelFn()
+	}
+	streamCtx, streamCancelFn := context.WithCancel(tx.ctx)
+	stream, err := tx.db.remoteKV.Tx(streamCtx)
+	if err != nil {
+		streamCancelFn()
+		return err
+	}
+	msg, err := stream.Recv()
+	if err != nil {
+		streamCancelFn()
+		return err
+	}
+	tx.stream, tx.streamCancelFn, tx.streamingRequested = stream, streamCancelFn, false
+	if msg.TxID < tx.id { // server can't provide view older than current state
+		return ErrViewExpired
+	}
+	tx.id = msg.TxID
+	for _, c := range tx.cursors {
+		if c.stream == nil { // closed
+			continue
+		}
+		c.stream = stream
+		if err := c.restore(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -39,7 +39,9 @@ const MaxTxTTL = 60 * time.Second
 // 4.0.0 - Server send tx.ViewID() after open tx
 // 5.0 - BlockTransaction table now has canonical ids (txs of non-canonical blocks moving to NonCanonicalTransaction table)
 // 5.1.0 - Added blockGasLimit to the StateChangeBatch
-var KvServiceAPIVersion = &types.VersionReply{Major: 5, Minor: 1, Patch: 0}
+// 5.2.0 - Added Op NEXT_N - batch of Next replies for 1 request
+// 5.3.0 - Added parentHash to the StateChange
+var KvServiceAPIVersion = &types.VersionReply{Major: 5, Minor: 3, Patch: 0}
 
 type KvServer struct {
 	remote.UnimplementedKVServer // must be embedded to have forward compatible implementations.
@@ -186,6 +188,11 @@ func (s *KvServer) Tx(stream remote.KV_TxServer) error {
 				return fmt.Errorf("server-side error: %w", err)
 			}
 			continue
+		case remote.Op_NEXT_N:
+			if err := handleNextN(c, stream, in.N); err != nil {
+				return fmt.Errorf("server-side error: %w", err)
+			}
+			continue
 		default:
 		}
 
@@ -221,16 +228,10 @@ func handleOp(c kv.Cursor, stream remote.KV_TxServer, in *remote.Cursor) error {
 		k, v, err = c.(kv.CursorDupSort).NextNoDup()
 	case remote.Op_PREV:
 		k, v, err = c.Prev()
-	//case remote.Op_PREV_DUP:
-	//	k, v, err = c.(ethdb.CursorDupSort).Prev()
-	//	if err != nil {
-	//		return err
-	//	}
-	//case remote.Op_PREV_NO_DUP:
-	//	k, v, err = c.Prev()
-	//	if err != nil {
-	//		return err
-	//	}
+	case remote.Op_PREV_DUP:
+		k, v, err = c.(kv.CursorDupSort).PrevDup()
+	case remote.Op_PREV_NO_DUP:
+		k, v, err = c.(kv.CursorDupSort).PrevNoDup()
 	case remote.Op_SEEK_EXACT:
 		k, v, err = c.SeekExact(in.K)
 	case remote.Op_SEEK_BOTH_EXACT:
@@ -249,6 +250,32 @@ func handleOp(c kv.Cursor, stream remote.KV_TxServer, in *remote.Cursor) error {
 	return nil
 }
 
+// MaxNextN - limit of pairs in reply to 1 NEXT_N request
+const MaxNextN = 1024
+
+// handleNextN - sends up to n pairs (but at least 1), stops after pair with nil key
+func handleNextN(c kv.Cursor, stream remote.KV_TxServer, n uint32) error {
+	if n == 0 {
+		n = 1
+	}
+	if n > MaxNextN {
+		n = MaxNextN
+	}
+	for i := uint32(0); i < n; i++ {
+		k, v, err := c.Next()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&remote.Pair{K: k, V: v}); err != nil {
+			return err
+		}
+		if k == nil {
+			break
+		}
+	}
+	return nil
+}
+
 func bytesCopy(b []byte) []byte {
 	if b == nil {
 		return nil
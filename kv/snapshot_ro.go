@@ -0,0 +1,161 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSnapshotMaxAge - how long SnapshotRoDB keeps one read transaction. Long read transaction doesn't let
+// MDBX reuse pages freed after it started, and database file grows.
+const DefaultSnapshotMaxAge = 10 * time.Minute
+
+// SnapshotRoDB - RoDB which pins one read transaction of underlying db, so all transactions
+// opened by analytics tooling see the same state of the database.
+// Every BeginRo returns wrapper of pinned transaction with own cursors, they are closed by Rollback/Commit
+// (pinned transaction stays open). Like MDBX transaction, pinned transaction can be used by one goroutine
+// at a time: BeginRo returns ErrSnapshotInUse until previous wrapper is rolled back.
+// If pinned transaction is older than maxAge, it's renewed by next BeginRo - ViewID of transactions changes then.
+type SnapshotRoDB struct {
+	db     RoDB
+	maxAge time.Duration
+
+	lock     sync.Mutex
+	tx       Tx
+	openedAt time.Time
+	inUse    bool // wrapper of pinned transaction is not rolled back yet
+	closed   bool
+}
+
+// ErrSnapshotInUse - BeginRo of SnapshotRoDB while wrapper of pinned transaction is not rolled back, for example
+// nested View or missed Rollback
+var ErrSnapshotInUse = errors.New("snapshot transaction is in use")
+
+// SnapshotRo - creates SnapshotRoDB on top of db, first read transaction is opened lazily.
+// Close of SnapshotRoDB releases pinned transaction, but doesn't close db.
+func SnapshotRo(db RoDB) *SnapshotRoDB {
+	return &SnapshotRoDB{db: db, maxAge: DefaultSnapshotMaxAge}
+}
+
+func (s *SnapshotRoDB) MaxAge(maxAge time.Duration) *SnapshotRoDB {
+	s.maxAge = maxAge
+	return s
+}
+
+func (s *SnapshotRoDB) AllBuckets() TableCfg { return s.db.AllBuckets() }
+
+func (s *SnapshotRoDB) View(ctx context.Context, f func(tx Tx) error) error {
+	tx, err := s.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+func (s *SnapshotRoDB) BeginRo(ctx context.Context) (Tx, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("snapshot closed")
+	}
+	if s.inUse {
+		return nil, ErrSnapshotInUse
+	}
+	if s.tx != nil && time.Since(s.openedAt) > s.maxAge {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+	if s.tx == nil {
+		tx, err := s.db.BeginRo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.tx, s.openedAt = tx, time.Now()
+	}
+	s.inUse = true
+	return &snapshotTx{Tx: s.tx, db: s}, nil
+}
+
+// Close - pinned transaction is released at once, or by Rollback of its wrapper if it's in use
+func (s *SnapshotRoDB) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	if s.tx != nil && !s.inUse {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+// release - called by Rollback of wrapper
+func (s *SnapshotRoDB) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inUse = false
+	if s.closed && s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+// snapshotTx - wrapper of pinned transaction, which owns cursors opened through it
+type snapshotTx struct {
+	Tx
+	db      *SnapshotRoDB
+	cursors []Cursor
+	done    bool
+}
+
+func (tx *snapshotTx) Cursor(bucket string) (Cursor, error) {
+	c, err := tx.Tx.Cursor(bucket)
+	if err != nil {
+		return nil, err
+	}
+	tx.cursors = append(tx.cursors, c)
+	return c, nil
+}
+
+func (tx *snapshotTx) CursorDupSort(bucket string) (CursorDupSort, error) {
+	c, err := tx.Tx.CursorDupSort(bucket)
+	if err != nil {
+		return nil, err
+	}
+	tx.cursors = append(tx.cursors, c)
+	return c, nil
+}
+
+func (tx *snapshotTx) Commit() error {
+	tx.Rollback()
+	return nil
+}
+
+func (tx *snapshotTx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	for _, c := range tx.cursors {
+		c.Close()
+	}
+	tx.cursors = nil
+	tx.db.release()
+}
@@ -0,0 +1,221 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Operations counted by StatsRoDB/StatsRwDB, Append and AppendDup are counted as Put.
+// Operations made through cursors are not counted
+const (
+	statsGet = iota
+	statsPut
+	statsDelete
+	statsOps
+)
+
+var statsOpNames = [statsOps]string{"get", "put", "delete"}
+
+// OpStats - how many operations of one kind were made and how long they took in total
+type OpStats struct {
+	Count uint64
+	Took  time.Duration
+}
+
+type TableStats struct {
+	Table            string
+	Get, Put, Delete OpStats
+}
+
+// Total - time spent in all operations with the table
+func (s TableStats) Total() time.Duration { return s.Get.Took + s.Put.Took + s.Delete.Took }
+
+type opStats struct {
+	count   uint64 // atomic
+	nanos   uint64 // atomic
+	summary *metrics.Summary
+}
+
+type tableStats struct {
+	ops [statsOps]opStats
+}
+
+// dbStats - per-table statistics shared by all transactions of the decorated db
+type dbStats struct {
+	label  string
+	tables sync.Map // table name -> *tableStats
+}
+
+func (s *dbStats) table(name string) *tableStats {
+	if t, ok := s.tables.Load(name); ok {
+		return t.(*tableStats)
+	}
+	t := &tableStats{}
+	for op := range t.ops {
+		t.ops[op].summary = metrics.GetOrCreateSummary(fmt.Sprintf(`db_table_op_seconds{name="%s",table="%s",op="%s"}`, s.label, name, statsOpNames[op]))
+	}
+	actual, _ := s.tables.LoadOrStore(name, t)
+	return actual.(*tableStats)
+}
+
+func (s *dbStats) observe(table string, op int, start time.Time) {
+	took := time.Since(start)
+	o := &s.table(table).ops[op]
+	atomic.AddUint64(&o.count, 1)
+	atomic.AddUint64(&o.nanos, uint64(took))
+	o.summary.Update(took.Seconds())
+}
+
+// Stats - snapshot of statistics of all tables touched so far, tables which took most time go first
+func (s *dbStats) Stats() []TableStats {
+	var res []TableStats
+	s.tables.Range(func(k, v interface{}) bool {
+		t := v.(*tableStats)
+		load := func(op int) OpStats {
+			return OpStats{Count: atomic.LoadUint64(&t.ops[op].count), Took: time.Duration(atomic.LoadUint64(&t.ops[op].nanos))}
+		}
+		res = append(res, TableStats{Table: k.(string), Get: load(statsGet), Put: load(statsPut), Delete: load(statsDelete)})
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Total() != res[j].Total() {
+			return res[i].Total() > res[j].Total()
+		}
+		return res[i].Table < res[j].Table
+	})
+	return res
+}
+
+// StatsRoDB - RoDB which records count and latency of GetOne/Has of every table.
+// Latencies are exported as summary `db_table_op_seconds{name="<label>",table="<table>",op="get"}`,
+// to find hot tables without profiling
+type StatsRoDB struct {
+	RoDB
+	*dbStats
+}
+
+// WithStatsRo - decorates db, label distinguishes metrics of different databases.
+// Close of StatsRoDB closes db
+func WithStatsRo(db RoDB, label string) *StatsRoDB {
+	return &StatsRoDB{RoDB: db, dbStats: &dbStats{label: label}}
+}
+
+func (db *StatsRoDB) View(ctx context.Context, f func(tx Tx) error) error {
+	return db.RoDB.View(ctx, func(tx Tx) error { return f(&statsTx{Tx: tx, stats: db.dbStats}) })
+}
+
+func (db *StatsRoDB) BeginRo(ctx context.Context) (Tx, error) {
+	tx, err := db.RoDB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &statsTx{Tx: tx, stats: db.dbStats}, nil
+}
+
+// StatsRwDB - same as StatsRoDB, but also records Put/Append/AppendDup (as "put") and Delete of write transactions
+type StatsRwDB struct {
+	RwDB
+	*dbStats
+}
+
+// WithStats - decorates db, label distinguishes metrics of different databases.
+// Close of StatsRwDB closes db
+func WithStats(db RwDB, label string) *StatsRwDB {
+	return &StatsRwDB{RwDB: db, dbStats: &dbStats{label: label}}
+}
+
+func (db *StatsRwDB) View(ctx context.Context, f func(tx Tx) error) error {
+	return db.RwDB.View(ctx, func(tx Tx) error { return f(&statsTx{Tx: tx, stats: db.dbStats}) })
+}
+
+func (db *StatsRwDB) BeginRo(ctx context.Context) (Tx, error) {
+	tx, err := db.RwDB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &statsTx{Tx: tx, stats: db.dbStats}, nil
+}
+
+func (db *StatsRwDB) Update(ctx context.Context, f func(tx RwTx) error) error {
+	return db.RwDB.Update(ctx, func(tx RwTx) error { return f(&statsRwTx{RwTx: tx, stats: db.dbStats}) })
+}
+
+func (db *StatsRwDB) BeginRw(ctx context.Context) (RwTx, error) {
+	tx, err := db.RwDB.BeginRw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &statsRwTx{RwTx: tx, stats: db.dbStats}, nil
+}
+
+type statsTx struct {
+	Tx
+	stats *dbStats
+}
+
+func (tx *statsTx) GetOne(bucket string, key []byte) ([]byte, error) {
+	defer tx.stats.observe(bucket, statsGet, time.Now())
+	return tx.Tx.GetOne(bucket, key)
+}
+
+func (tx *statsTx) Has(bucket string, key []byte) (bool, error) {
+	defer tx.stats.observe(bucket, statsGet, time.Now())
+	return tx.Tx.Has(bucket, key)
+}
+
+type statsRwTx struct {
+	RwTx
+	stats *dbStats
+}
+
+func (tx *statsRwTx) GetOne(bucket string, key []byte) ([]byte, error) {
+	defer tx.stats.observe(bucket, statsGet, time.Now())
+	return tx.RwTx.GetOne(bucket, key)
+}
+
+func (tx *statsRwTx) Has(bucket string, key []byte) (bool, error) {
+	defer tx.stats.observe(bucket, statsGet, time.Now())
+	return tx.RwTx.Has(bucket, key)
+}
+
+func (tx *statsRwTx) Put(bucket string, k, v []byte) error {
+	defer tx.stats.observe(bucket, statsPut, time.Now())
+	return tx.RwTx.Put(bucket, k, v)
+}
+
+func (tx *statsRwTx) Append(bucket string, k, v []byte) error {
+	defer tx.stats.observe(bucket, statsPut, time.Now())
+	return tx.RwTx.Append(bucket, k, v)
+}
+
+func (tx *statsRwTx) AppendDup(bucket string, k, v []byte) error {
+	defer tx.stats.observe(bucket, statsPut, time.Now())
+	return tx.RwTx.AppendDup(bucket, k, v)
+}
+
+func (tx *statsRwTx) Delete(bucket string, k, v []byte) error {
+	defer tx.stats.observe(bucket, statsDelete, time.Now())
+	return tx.RwTx.Delete(bucket, k, v)
+}
@@ -295,7 +295,8 @@ const (
 	// in case of bug-report developer can ask content of this bucket
 	Migrations = "Migration"
 
-	Sequence      = "Sequence" // tbl_name -> seq_u64
+	Sequence      = "Sequence"     // tbl_name -> seq_u64
+	TableVersion  = "TableVersion" // tbl_name -> version_u64, see mdbx.Migration
 	HeadHeaderKey = "LastHeader"
 
 	Epoch        = "DevEpoch"        // block_num_u64+block_hash->transition_proof
@@ -406,14 +407,18 @@ var ChaindataTables = []string{
 
 const (
 	RecentLocalTransaction = "RecentLocalTransaction" // sequence_u64 -> tx_hash
-	PoolTransaction        = "PoolTransaction"        // txHash -> sender_id_u64+tx_rlp
+	PoolTransaction        = "PoolTransaction"        // txHash -> sender_address+tx_rlp, tx_rlp may be compressed
 	PoolInfo               = "PoolInfo"               // option_key -> option_value
+	PoolUnprocessedRemote  = "PoolUnprocessedRemote"  // sequence_u64 -> sender_address+tx_rlp, remote txs received but not validated yet
+	PoolNoPropagate        = "PoolNoPropagate"        // txHash -> nil, txs which are not announced to peers
 )
 
 var TxPoolTables = []string{
 	RecentLocalTransaction,
 	PoolTransaction,
 	PoolInfo,
+	PoolUnprocessedRemote,
+	PoolNoPropagate,
 }
 var SentryTables = []string{}
 
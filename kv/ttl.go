@@ -0,0 +1,129 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+)
+
+// Prefixes of records in the shadow index of TTLTable
+const (
+	ttlByTime byte = 0 // 0 + expireAt_u64 + key -> nil: to find expired keys without full scan
+	ttlByKey  byte = 1 // 1 + key -> expireAt_u64: to drop previous record by time when key is overwritten
+)
+
+// TTLTable - table with expiring entries. Expiration time of entries is kept in shadow index table
+// (both tables must be declared in TableCfg), and expired entries are removed by Prune.
+// Entries are readable by usual tx.GetOne(Table, k) until they are pruned.
+type TTLTable struct {
+	Table string
+	Index string
+}
+
+// ErrExpireBeforeEpoch - expiration time is kept as u64 of unix seconds, so it can't be before 1970
+var ErrExpireBeforeEpoch = errors.New("expiration time before unix epoch")
+
+func NewTTLTable(table string) TTLTable {
+	return TTLTable{Table: table, Index: TTLIndexTable(table)}
+}
+
+// TTLIndexTable - name of the shadow index table of TTLTable
+func TTLIndexTable(table string) string { return table + "TTL" }
+
+// Put inserts or updates entry, which will be removed by Prune after expireAt
+func (t TTLTable) Put(tx RwTx, k, v []byte, expireAt time.Time) error {
+	if expireAt.Unix() < 0 {
+		return fmt.Errorf("%w: %s", ErrExpireBeforeEpoch, expireAt)
+	}
+	if err := t.deleteIndex(tx, k); err != nil {
+		return err
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(expireAt.Unix()))
+	byTime := make([]byte, 1+8+len(k))
+	byTime[0] = ttlByTime
+	copy(byTime[1:], ts[:])
+	copy(byTime[9:], k)
+	if err := tx.Put(t.Index, byTime, nil); err != nil {
+		return err
+	}
+	if err := tx.Put(t.Index, append([]byte{ttlByKey}, k...), ts[:]); err != nil {
+		return err
+	}
+	return tx.Put(t.Table, k, v)
+}
+
+// Delete removes entry before its expiration
+func (t TTLTable) Delete(tx RwTx, k []byte) error {
+	if err := t.deleteIndex(tx, k); err != nil {
+		return err
+	}
+	return tx.Delete(t.Table, k, nil)
+}
+
+func (t TTLTable) deleteIndex(tx RwTx, k []byte) error {
+	byKey := append([]byte{ttlByKey}, k...)
+	ts, err := tx.GetOne(t.Index, byKey)
+	if err != nil {
+		return err
+	}
+	if ts == nil {
+		return nil
+	}
+	byTime := make([]byte, 1+8+len(k))
+	byTime[0] = ttlByTime
+	copy(byTime[1:], ts)
+	copy(byTime[9:], k)
+	if err = tx.Delete(t.Index, byTime, nil); err != nil {
+		return err
+	}
+	return tx.Delete(t.Index, byKey, nil)
+}
+
+// Prune removes all entries which expired at `now`, returns amount of removed entries
+func (t TTLTable) Prune(tx RwTx, now time.Time) (int, error) {
+	if now.Unix() < 0 { // nothing can expire before epoch, see Put
+		return 0, nil
+	}
+	c, err := tx.Cursor(t.Index)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	var expired [][]byte
+	k, _, err := c.Seek([]byte{ttlByTime})
+	for ; ; k, _, err = c.Next() {
+		if err != nil {
+			return 0, err
+		}
+		if k == nil || k[0] != ttlByTime || binary.BigEndian.Uint64(k[1:]) > uint64(now.Unix()) {
+			break
+		}
+		expired = append(expired, common.Copy(k[9:]))
+	}
+	for _, k := range expired {
+		if err := t.Delete(tx, k); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
@@ -66,6 +66,16 @@ func MadviseRandom(mmapHandle1 []byte) error {
 	return nil
 }
 
+// MadviseWillNeed - hints the kernel to read pages ahead, mmapHandle1 must start at page boundary
+func MadviseWillNeed(mmapHandle1 []byte) error {
+	err := unix.Madvise(mmapHandle1, syscall.MADV_WILLNEED)
+	if err != nil && err != syscall.ENOSYS {
+		// Ignore not implemented error in kernel because it still works.
+		return fmt.Errorf("madvise: %s", err)
+	}
+	return nil
+}
+
 // munmap unmaps a DB's data file from memory.
 func Munmap(mmapHandle1 []byte, _ *[MaxMapSize]byte) error {
 	// Ignore the unmap if we have no mapped data.
@@ -58,6 +58,10 @@ func MadviseRandom(mmapHandle1 []byte) error {
 	return nil
 }
 
+func MadviseWillNeed(mmapHandle1 []byte) error {
+	return nil
+}
+
 func Munmap(_ []byte, mmapHandle2 *[MaxMapSize]byte) error {
 	if mmapHandle2 == nil {
 		return nil
@@ -0,0 +1,134 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package recsplit
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// maxAuditMismatches - how many mismatched keys are kept in AuditReport, the rest are only counted
+const maxAuditMismatches = 100
+
+// KeysSource - stream of "key -> offset" pairs the index was built from, calls walker for every pair
+type KeysSource func(walker func(key []byte, offset uint64) error) error
+
+// AuditMismatch - key which index maps to another offset
+type AuditMismatch struct {
+	Key      []byte
+	Expected uint64 // offset from the keys source
+	Got      uint64 // offset from the index
+}
+
+// AuditReport - result of Index.Audit
+type AuditReport struct {
+	Keys       uint64          // Number of keys in the source
+	Mismatched uint64          // Keys which index maps to another offset
+	Unknown    uint64          // Keys which fingerprint doesn't match the recorded one - were not added to the index
+	Duplicates uint64          // Keys mapped to the record already claimed by another key of the source
+	Uncovered  uint64          // Records not claimed by any key of the source
+	Mismatches []AuditMismatch // First maxAuditMismatches of mismatched and unknown keys
+}
+
+// Ok returns true if every key of the source is mapped to its offset, and every record is claimed by exactly one key
+func (r *AuditReport) Ok() bool {
+	return r.Mismatched == 0 && r.Unknown == 0 && r.Duplicates == 0 && r.Uncovered == 0
+}
+
+func (r *AuditReport) String() string {
+	return fmt.Sprintf("keys=%d, mismatched=%d, unknown=%d, duplicates=%d, uncovered=%d",
+		r.Keys, r.Mismatched, r.Unknown, r.Duplicates, r.Uncovered)
+}
+
+// HasKeyHashes returns true if index was built with KeyHashes, and supports Audit
+func (idx *Index) HasKeyHashes() bool { return idx.keyHashes != nil }
+
+// Audit re-hashes every key of the source and checks that index maps it to the offset given by the source,
+// and that records of the index are covered by the source. Requires index built with KeyHashes.
+// In multi-value mode the offset is checked to be one of the offsets of the key
+func (idx *Index) Audit(keys KeysSource) (*AuditReport, error) {
+	if idx.keyHashes == nil {
+		return nil, fmt.Errorf("audit %s: index is built without key hashes", idx.indexFile)
+	}
+	report := &AuditReport{}
+	claimed := make([]uint64, (idx.keyCount+63)/64)
+	mismatch := func(key []byte, expected, got uint64) {
+		if len(report.Mismatches) < maxAuditMismatches {
+			report.Mismatches = append(report.Mismatches, AuditMismatch{Key: append([]byte{}, key...), Expected: expected, Got: got})
+		}
+	}
+	if err := keys(func(key []byte, offset uint64) error {
+		report.Keys++
+		if idx.keyCount == 0 {
+			report.Unknown++
+			mismatch(key, offset, 0)
+			return nil
+		}
+		bucketHash, fingerprint := murmur3.Sum128WithSeed(key, idx.salt)
+		var rec int
+		if idx.keyCount > 1 {
+			rec = idx.lookupRec(bucketHash, fingerprint)
+		}
+		if binary.BigEndian.Uint64(idx.keyHashes[8*rec:]) != fingerprint {
+			report.Unknown++
+			mismatch(key, offset, 0)
+			return nil
+		}
+		if claimed[rec/64]&(1<<(rec%64)) != 0 {
+			report.Duplicates++
+		}
+		claimed[rec/64] |= 1 << (rec % 64)
+		got, ok := idx.auditOffset(rec, bucketHash, fingerprint, offset)
+		if !ok {
+			report.Mismatched++
+			mismatch(key, offset, got)
+		}
+		return nil
+	}); err != nil {
+		return report, fmt.Errorf("audit %s: %w", idx.indexFile, err)
+	}
+	report.Uncovered = idx.keyCount
+	for _, word := range claimed {
+		for ; word != 0; word &= word - 1 {
+			report.Uncovered--
+		}
+	}
+	return report, nil
+}
+
+// auditOffset resolves offset of the key through enums, ordinals table or multi-value offsets, and compares it with expected
+func (idx *Index) auditOffset(rec int, bucketHash, fingerprint, expected uint64) (uint64, bool) {
+	if idx.countsEf != nil {
+		var got uint64
+		for it := idx.LookupMulti(bucketHash, fingerprint); it.HasNext(); {
+			if got = it.Next(); got == expected {
+				return got, true
+			}
+		}
+		return got, false
+	}
+	got := binary.BigEndian.Uint64(idx.data[1+8+idx.bytesPerRec*(rec+1):]) & idx.recMask
+	switch {
+	case idx.enums:
+		got = idx.Lookup2(got)
+	case idx.ordered:
+		got = idx.OrdinalLookup(got)
+	}
+	return got, got == expected
+}
@@ -14,6 +14,12 @@
    limitations under the License.
 */
 
+// Package eliasfano32 - compact encoding of monotone sequences of uint64, with constant time random access (Get),
+// sequential access (Iterator) and successor queries (NextGEQ). Used for offsets in recsplit indices.
+//
+// Sequence is built in 3 steps: NewEliasFano with number of values, maximum value and minimum difference
+// between consecutive values; Append of every value in ascending order; Build. After that EliasFano can be
+// serialised with Write and read back without copying with ReadEliasFano
 package eliasfano32
 
 import (
@@ -22,6 +28,7 @@ import (
 	"io"
 	"math"
 	"math/bits"
+	"reflect"
 	"unsafe"
 
 	"github.com/ledgerwatch/erigon-lib/common/bitutil"
@@ -56,6 +63,7 @@ type EliasFano struct {
 	i              uint64
 	delta          uint64
 	wordsUpperBits int
+	last           uint64 // last appended value, for checks in Append
 }
 
 func NewEliasFano(count uint64, maxOffset, minDelta uint64) *EliasFano {
@@ -88,6 +96,22 @@ func (ef *EliasFano) AddOffset(offset uint64) {
 	ef.delta += ef.minDelta
 }
 
+// Append - adds next value of the sequence. Unlike AddOffset, panics if the value breaks parameters given
+// to NewEliasFano: more than count values, value greater than maxOffset, or less than previous value + minDelta
+func (ef *EliasFano) Append(v uint64) {
+	if ef.i > ef.count {
+		panic(fmt.Sprintf("eliasfano: too many values, count=%d", ef.count+1))
+	}
+	if v > ef.maxOffset {
+		panic(fmt.Sprintf("eliasfano: value %d is greater than maxOffset %d", v, ef.maxOffset))
+	}
+	if ef.i > 0 && v < ef.last+ef.minDelta {
+		panic(fmt.Sprintf("eliasfano: value %d after %d breaks minDelta %d", v, ef.last, ef.minDelta))
+	}
+	ef.last = v
+	ef.AddOffset(v)
+}
+
 func (ef EliasFano) jumpSizeWords() int {
 	size := ((ef.count + 1) / superQ) * superQSize // Whole blocks
 	if (ef.count+1)%superQ != 0 {
@@ -215,6 +239,81 @@ func (ef EliasFano) Get2(i uint64) (val uint64, valNext uint64) {
 	return
 }
 
+// Count - number of values in the sequence
+func (ef *EliasFano) Count() uint64 { return ef.count + 1 }
+
+// Min - first (smallest) value of the sequence
+func (ef *EliasFano) Min() uint64 { return ef.Get(0) }
+
+// Max - last (largest) value of the sequence
+func (ef *EliasFano) Max() uint64 { return ef.Get(ef.count) }
+
+// lower - lower bits of the i-th value
+func (ef *EliasFano) lower(i uint64) uint64 {
+	pos := i * ef.l
+	idx64, shift := pos/64, pos%64
+	lower := ef.lowerBits[idx64] >> shift
+	if shift > 0 {
+		lower |= ef.lowerBits[idx64+1] << (64 - shift)
+	}
+	return lower & ef.lowerBitsMask
+}
+
+// NextGEQ - index and value of the first element which is greater or equal to v, ok=false if there is no such element
+func (ef *EliasFano) NextGEQ(v uint64) (i uint64, val uint64, ok bool) {
+	// Binary search over Get: values minus i*minDelta are not comparable with v, so upper bits can't be searched directly
+	lo, hi := uint64(0), ef.count+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if ef.Get(mid) < v {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo > ef.count {
+		return 0, 0, false
+	}
+	return lo, ef.Get(lo), true
+}
+
+// Iterator - sequential access to the values, cheaper than Get for every index
+func (ef *EliasFano) Iterator() *EliasFanoIter {
+	return &EliasFanoIter{ef: ef}
+}
+
+// IteratorFrom - iterator positioned at the first element which is greater or equal to v
+func (ef *EliasFano) IteratorFrom(v uint64) *EliasFanoIter {
+	i, val, ok := ef.NextGEQ(v)
+	if !ok {
+		return &EliasFanoIter{ef: ef, i: ef.count + 1}
+	}
+	return &EliasFanoIter{ef: ef, i: i, upperPos: ((val - i*ef.minDelta) >> ef.l) + i}
+}
+
+// EliasFanoIter - iterates over values of EliasFano in ascending order
+type EliasFanoIter struct {
+	ef       *EliasFano
+	i        uint64 // index of the value returned by the next call of Next
+	upperPos uint64 // position in upperBits to look for the next set bit from
+}
+
+func (it *EliasFanoIter) HasNext() bool { return it.i <= it.ef.count }
+
+func (it *EliasFanoIter) Next() uint64 {
+	currWord := it.upperPos / 64
+	window := it.ef.upperBits[currWord] & (uint64(0xffffffffffffffff) << (it.upperPos % 64))
+	for window == 0 {
+		currWord++
+		window = it.ef.upperBits[currWord]
+	}
+	pos := currWord*64 + uint64(bits.TrailingZeros64(window))
+	val := ((pos-it.i)<<it.ef.l | it.ef.lower(it.i)) + it.i*it.ef.minDelta
+	it.upperPos = pos + 1
+	it.i++
+	return val
+}
+
 // Write outputs the state of golomb rice encoding into a writer, which can be recovered later by Read
 func (ef *EliasFano) Write(w io.Writer) error {
 	var numBuf [8]byte
@@ -248,8 +347,13 @@ func ReadEliasFano(r []byte) (*EliasFano, int) {
 	//fmt.Printf("read: %d,%x\n", ef.count, r[:8])
 	ef.u = binary.BigEndian.Uint64(r[8:16])
 	ef.minDelta = binary.BigEndian.Uint64(r[16:24])
-	p := (*[maxDataSize / 8]uint64)(unsafe.Pointer(&r[24]))
-	ef.data = p[:]
+	// view of the rest of r as []uint64, without casting to array of maxDataSize - that is rejected by checkptr
+	// if r is not mmapped but allocated by Go (e.g. bytes.Buffer). Capacity is not limited by len(r), so that
+	// truncated data is reported by the caller comparing returned size with len(r), not by panic in deriveFields
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&ef.data))
+	hdr.Data = uintptr(unsafe.Pointer(&r[24]))
+	hdr.Len = (len(r) - 24) / 8
+	hdr.Cap = maxDataSize / 8
 	ef.deriveFields()
 	return ef, 24 + 8*len(ef.data)
 }
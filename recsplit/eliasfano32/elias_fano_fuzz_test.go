@@ -62,6 +62,31 @@ func FuzzSingleEliasFano(f *testing.F) {
 	})
 }
 
+func FuzzEliasFanoIterator(f *testing.F) {
+	f.Fuzz(func(t *testing.T, in []byte) {
+		if len(in) == 0 {
+			t.Skip()
+		}
+		// Treat each byte of the sequence as difference between previous value and the next
+		values := make([]uint64, len(in))
+		minDelta := uint64(in[0])
+		for i, b := range in {
+			if i > 0 {
+				values[i] = values[i-1] + uint64(b)
+				if uint64(b) < minDelta {
+					minDelta = uint64(b)
+				}
+			}
+		}
+		ef := NewEliasFano(uint64(len(values)), values[len(values)-1], minDelta)
+		for _, v := range values {
+			ef.Append(v)
+		}
+		ef.Build()
+		checkSequence(t, ef, values)
+	})
+}
+
 func FuzzDoubleEliasFano(f *testing.F) {
 	f.Fuzz(func(t *testing.T, in []byte) {
 		if len(in)%2 == 1 {
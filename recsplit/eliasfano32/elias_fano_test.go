@@ -0,0 +1,145 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package eliasfano32
+
+import (
+	"bytes"
+	"testing"
+)
+
+// checkSequence - compares Get, Iterator, NextGEQ and IteratorFrom with the plain sequence
+func checkSequence(t *testing.T, ef *EliasFano, values []uint64) {
+	t.Helper()
+	if ef.Count() != uint64(len(values)) {
+		t.Fatalf("count %d, expected %d", ef.Count(), len(values))
+	}
+	if ef.Min() != values[0] || ef.Max() != values[len(values)-1] {
+		t.Fatalf("min %d, max %d, expected %d, %d", ef.Min(), ef.Max(), values[0], values[len(values)-1])
+	}
+	it := ef.Iterator()
+	for i, v := range values {
+		if got := ef.Get(uint64(i)); got != v {
+			t.Fatalf("Get(%d) = %d, expected %d", i, got, v)
+		}
+		if !it.HasNext() {
+			t.Fatalf("iterator ended at %d", i)
+		}
+		if got := it.Next(); got != v {
+			t.Fatalf("iterator %d: %d, expected %d", i, got, v)
+		}
+	}
+	if it.HasNext() {
+		t.Fatalf("iterator did not end")
+	}
+	for v := uint64(0); v <= values[len(values)-1]+1; v++ {
+		j := 0
+		for j < len(values) && values[j] < v {
+			j++
+		}
+		i, val, ok := ef.NextGEQ(v)
+		if j == len(values) {
+			if ok {
+				t.Fatalf("NextGEQ(%d) = %d, expected none", v, val)
+			}
+			if ef.IteratorFrom(v).HasNext() {
+				t.Fatalf("IteratorFrom(%d) is not empty", v)
+			}
+			continue
+		}
+		if !ok || i != uint64(j) || val != values[j] {
+			t.Fatalf("NextGEQ(%d) = %d, %d, %t, expected %d, %d", v, i, val, ok, j, values[j])
+		}
+		it = ef.IteratorFrom(v)
+		for k := j; k < len(values); k++ {
+			if got := it.Next(); got != values[k] {
+				t.Fatalf("IteratorFrom(%d) %d: %d, expected %d", v, k, got, values[k])
+			}
+		}
+		if it.HasNext() {
+			t.Fatalf("IteratorFrom(%d) did not end", v)
+		}
+	}
+}
+
+func TestEliasFanoIterator(t *testing.T) {
+	values := []uint64{1, 4, 6, 8, 10, 14, 16, 19, 22, 34, 37, 39, 41, 43, 48, 51, 54, 58, 62}
+	ef := NewEliasFano(uint64(len(values)), values[len(values)-1], 2)
+	for _, v := range values {
+		ef.Append(v)
+	}
+	ef.Build()
+	checkSequence(t, ef, values)
+
+	// serialised form supports the same API
+	var buf bytes.Buffer
+	if err := ef.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ef2, _ := ReadEliasFano(buf.Bytes())
+	checkSequence(t, ef2, values)
+}
+
+func TestEliasFanoIteratorLarge(t *testing.T) {
+	// large enough to use superQ jumps
+	values := make([]uint64, 3*superQ)
+	for i := 1; i < len(values); i++ {
+		values[i] = values[i-1] + uint64(i*7919%13)
+	}
+	ef := NewEliasFano(uint64(len(values)), values[len(values)-1], 0)
+	for _, v := range values {
+		ef.Append(v)
+	}
+	ef.Build()
+	it := ef.Iterator()
+	for i, v := range values {
+		if got := it.Next(); got != v {
+			t.Fatalf("iterator %d: %d, expected %d", i, got, v)
+		}
+	}
+	for i := 0; i < len(values); i += 997 {
+		j := i // values may repeat, iterator starts from the first of them
+		for j > 0 && values[j-1] == values[i] {
+			j--
+		}
+		it = ef.IteratorFrom(values[i])
+		for k := j; k < len(values) && k < j+3; k++ {
+			if got := it.Next(); got != values[k] {
+				t.Fatalf("IteratorFrom(%d) %d: %d, expected %d", values[i], k, got, values[k])
+			}
+		}
+	}
+}
+
+func TestEliasFanoAppendPanics(t *testing.T) {
+	for name, values := range map[string][]uint64{
+		"minDelta":  {1, 2},
+		"maxOffset": {1, 100},
+		"count":     {1, 3, 5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic")
+				}
+			}()
+			ef := NewEliasFano(2, 10, 2)
+			for _, v := range values {
+				ef.Append(v)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package recsplit
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/FastFilter/xorfilter"
+)
+
+// Existence filter is binary fuse filter (https://arxiv.org/abs/2201.01174) of 64-bit key fingerprints with 8-bit
+// filter fingerprints: false positive rate ~1/256 at ~1.13 bytes per key. Serialised as
+// seed(8) + segmentLength(4) + segmentCount(4) + (segmentCount+2)*segmentLength bytes of filter fingerprints
+const existenceHeaderSize = 16
+
+// writeExistenceFilter builds filter of given key fingerprints and writes it out
+func writeExistenceFilter(w io.Writer, fingerprints []uint64) error {
+	filter, err := xorfilter.PopulateBinaryFuse8(fingerprints)
+	if err != nil {
+		return err
+	}
+	var header [existenceHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:], filter.Seed)
+	binary.BigEndian.PutUint32(header[8:], filter.SegmentLength)
+	binary.BigEndian.PutUint32(header[12:], filter.SegmentCount)
+	if _, err = w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(filter.Fingerprints)
+	return err
+}
+
+// existenceFilterSize returns size of serialised filter, given its header
+func existenceFilterSize(header []byte) uint64 {
+	segmentLength := uint64(binary.BigEndian.Uint32(header[8:]))
+	segmentCount := uint64(binary.BigEndian.Uint32(header[12:]))
+	return existenceHeaderSize + (segmentCount+2)*segmentLength
+}
+
+// readExistenceFilter returns filter which fingerprints point into data (without copying), and its size
+func readExistenceFilter(data []byte) (*xorfilter.BinaryFuse8, int) {
+	size := int(existenceFilterSize(data))
+	filter := &xorfilter.BinaryFuse8{
+		Seed:          binary.BigEndian.Uint64(data),
+		SegmentLength: binary.BigEndian.Uint32(data[8:]),
+		SegmentCount:  binary.BigEndian.Uint32(data[12:]),
+		Fingerprints:  data[existenceHeaderSize:size],
+	}
+	filter.SegmentLengthMask = filter.SegmentLength - 1
+	filter.SegmentCountLength = filter.SegmentCount * filter.SegmentLength
+	return filter, size
+}
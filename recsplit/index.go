@@ -17,11 +17,17 @@
 package recsplit
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"math"
+	"math/bits"
 	"os"
+	"runtime"
 	"unsafe"
 
+	"github.com/FastFilter/xorfilter"
+	"github.com/cespare/xxhash/v2"
 	"github.com/ledgerwatch/erigon-lib/mmap"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano16"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
@@ -41,6 +47,12 @@ type Index struct {
 	ef                 eliasfano16.DoubleEliasFano
 	enums              bool
 	offsetEf           *eliasfano32.EliasFano
+	ordered            bool                   // Whether keys were added in ascending order and "ordinal -> offset" table is present
+	ordinalsOffset     int                    // Position of "ordinal -> offset" table in data
+	existence          *xorfilter.BinaryFuse8 // Optional existence filter of key fingerprints
+	keyHashes          []byte                 // Optional key hashes: 64-bit fingerprint per record, for Audit
+	countsEf           *eliasfano32.EliasFano // Multi-value mode: cumulative number of offsets per key number
+	valuesEf           *eliasfano32.EliasFano // Multi-value mode: cumulative sum of deltas of sorted offsets of every key
 	baseDataID         uint64
 	bucketCount        uint64 // Number of buckets
 	bucketSize         int
@@ -51,6 +63,28 @@ type Index struct {
 	startSeed          []uint64
 	golombRice         []uint32
 	size               int64
+	version            uint16 // Format version, 0 for legacy files without header
+	headerBucketSize   uint16 // Build parameters from the header, to cross-check with the rest of the file
+	headerLeafSize     uint16
+	headerSalt         uint32
+	valueWidth         int // Fixed width of records from the header, 0 if it's derived from maximal value
+	dataOffset         int // Position of data in the file (after the header)
+	warmUpFrom         int // Golomb-rice and elias-fano sections (used by every Lookup) are in data[warmUpFrom:warmUpTo]
+	warmUpTo           int
+}
+
+// Madvise is a hint for the kernel about expected access pattern to the index file
+type Madvise int
+
+const (
+	MadviseRandom   Madvise = iota // Default - every Lookup touches few pages, read-ahead is wasteful
+	MadviseWillNeed                // Read whole file into page cache in background, for small and hot indices
+)
+
+// IndexOpts are optional parameters of opening the index
+type IndexOpts struct {
+	Madvise Madvise
+	Verify  bool // Check checksum of the whole file before use, see Index.Verify
 }
 
 func MustOpen(indexFile string) *Index {
@@ -62,6 +96,10 @@ func MustOpen(indexFile string) *Index {
 }
 
 func OpenIndex(indexFile string) (*Index, error) {
+	return OpenIndexWithOpts(indexFile, IndexOpts{})
+}
+
+func OpenIndexWithOpts(indexFile string, opts IndexOpts) (*Index, error) {
 	idx := &Index{
 		indexFile: indexFile,
 	}
@@ -78,13 +116,46 @@ func OpenIndex(indexFile string) (*Index, error) {
 	if idx.mmapHandle1, idx.mmapHandle2, err = mmap.Mmap(idx.f, int(idx.size)); err != nil {
 		return nil, err
 	}
-	idx.data = idx.mmapHandle1[:idx.size]
+	if opts.Madvise == MadviseWillNeed {
+		if err = mmap.MadviseWillNeed(idx.mmapHandle1); err != nil {
+			idx.Close()
+			return nil, err
+		}
+	}
+	if err = idx.readHeader(); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	if err = idx.readSections(); err != nil {
+		idx.Close()
+		return nil, err
+	}
+	if opts.Verify {
+		if err = idx.Verify(); err != nil {
+			idx.Close()
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// readSections reads sections of the index data, checking that every section is within the file
+func (idx *Index) readSections() error {
 	// Read number of keys and bytes per record
+	if err := idx.checkSection("number of keys", 0, 17, 1); err != nil {
+		return err
+	}
 	idx.baseDataID = binary.BigEndian.Uint64(idx.data[:8])
 	idx.keyCount = binary.BigEndian.Uint64(idx.data[8:16])
 	idx.bytesPerRec = int(idx.data[16])
 	idx.recMask = (uint64(1) << (8 * idx.bytesPerRec)) - 1
+	if err := idx.checkSection("records", 17, idx.keyCount, uint64(idx.bytesPerRec)); err != nil {
+		return err
+	}
 	offset := 16 + 1 + int(idx.keyCount)*idx.bytesPerRec
+	if err := idx.checkSection("build parameters", offset, 17, 1); err != nil {
+		return err
+	}
 
 	// Bucket count, bucketSize, leafSize
 	idx.bucketCount = binary.BigEndian.Uint64(idx.data[offset:])
@@ -105,19 +176,73 @@ func OpenIndex(indexFile string) (*Index, error) {
 	// Start seed
 	startSeedLen := int(idx.data[offset])
 	offset++
+	if err := idx.checkSection("start seed", offset, uint64(startSeedLen)*8+1, 1); err != nil {
+		return err
+	}
 	idx.startSeed = make([]uint64, startSeedLen)
 	for i := 0; i < startSeedLen; i++ {
 		idx.startSeed[i] = binary.BigEndian.Uint64(idx.data[offset:])
 		offset += 8
 	}
-	idx.enums = idx.data[offset] != 0
+	features := idx.data[offset]
+	idx.enums = features&featureEnums != 0
+	idx.ordered = features&featureOrderedKeys != 0
+	withExistence := features&featureExistence != 0
+	withKeyHashes := features&featureKeyHashes != 0
 	offset++
 	if idx.enums {
+		if err := idx.checkSection("offsets elias fano", offset, 24+8, 1); err != nil { // header and at least one word
+			return err
+		}
 		var size int
 		idx.offsetEf, size = eliasfano32.ReadEliasFano(idx.data[offset:])
+		if err := idx.checkSection("offsets elias fano", offset, uint64(size), 1); err != nil {
+			return err
+		}
+		offset += size
+	}
+	if features&featureMultiValue != 0 {
+		for _, ef := range []**eliasfano32.EliasFano{&idx.countsEf, &idx.valuesEf} {
+			if err := idx.checkSection("multi-value elias fano", offset, 24+8, 1); err != nil {
+				return err
+			}
+			var size int
+			*ef, size = eliasfano32.ReadEliasFano(idx.data[offset:])
+			if err := idx.checkSection("multi-value elias fano", offset, uint64(size), 1); err != nil {
+				return err
+			}
+			offset += size
+		}
+	}
+	if idx.ordered {
+		if err := idx.checkSection("ordinals", offset, idx.keyCount, uint64(idx.bytesPerRec)); err != nil {
+			return err
+		}
+		idx.ordinalsOffset = offset
+		offset += int(idx.keyCount) * idx.bytesPerRec
+	}
+	if withExistence {
+		if err := idx.checkSection("existence filter", offset, existenceHeaderSize, 1); err != nil {
+			return err
+		}
+		if err := idx.checkSection("existence filter", offset, existenceFilterSize(idx.data[offset:]), 1); err != nil {
+			return err
+		}
+		var size int
+		idx.existence, size = readExistenceFilter(idx.data[offset:])
 		offset += size
 	}
+	if withKeyHashes {
+		if err := idx.checkSection("key hashes", offset, idx.keyCount, 8); err != nil {
+			return err
+		}
+		idx.keyHashes = idx.data[offset : offset+8*int(idx.keyCount)]
+		offset += 8 * int(idx.keyCount)
+	}
 	// Size of golomb rice params
+	if err := idx.checkSection("golomb rice", offset, 12, 1); err != nil {
+		return err
+	}
 	golombParamSize := binary.BigEndian.Uint16(idx.data[offset:])
 	offset += 4
 	idx.golombRice = make([]uint32, golombParamSize)
@@ -132,13 +257,106 @@ func OpenIndex(indexFile string) (*Index, error) {
 	}
 	l := binary.BigEndian.Uint64(idx.data[offset:])
 	offset += 8
+	// Golomb rice is followed by double elias fano: header and at least one word
+	if err := idx.checkSection("golomb rice", offset, l, 8); err != nil {
+		return err
+	}
+	if err := idx.checkSection("elias fano", offset+8*int(l), 40+8, 1); err != nil {
+		return err
+	}
 	p := (*[maxDataSize / 8]uint64)(unsafe.Pointer(&idx.data[offset]))
 	idx.grData = p[:l]
+	idx.warmUpFrom = offset
 	offset += 8 * int(l)
-	idx.ef.Read(idx.data[offset:])
-	return idx, nil
+	size := idx.ef.Read(idx.data[offset:])
+	if err := idx.checkSection("elias fano", offset, uint64(size), 1); err != nil {
+		return err
+	}
+	offset += size
+	idx.warmUpTo = offset
+	if idx.version > 0 && offset != len(idx.data) {
+		return fmt.Errorf("%w: %s: size of sections %d doesn't match size of data %d", ErrCorrupted, idx.indexFile, offset, len(idx.data))
+	}
+	if idx.version > 0 && (idx.bucketSize != int(idx.headerBucketSize) || idx.leafSize != idx.headerLeafSize || idx.salt != idx.headerSalt) {
+		return fmt.Errorf("%w: %s: build parameters in header don't match", ErrCorrupted, idx.indexFile)
+	}
+	if idx.valueWidth != 0 && idx.valueWidth != idx.bytesPerRec {
+		return fmt.Errorf("%w: %s: value width %d in header, records of %d bytes", ErrCorrupted, idx.indexFile, idx.valueWidth, idx.bytesPerRec)
+	}
+	return nil
+}
+
+// checkSection returns ErrCorrupted if section of count*width bytes at offset doesn't fit into data (e.g. file is truncated)
+func (idx *Index) checkSection(name string, offset int, count, width uint64) error {
+	hi, size := bits.Mul64(count, width)
+	if hi != 0 || offset > len(idx.data) || size > uint64(len(idx.data)-offset) {
+		return fmt.Errorf("%w: %s: %s at %d doesn't fit into %d bytes of data", ErrCorrupted, idx.indexFile, name, offset, len(idx.data))
+	}
+	return nil
+}
+
+// readHeader validates header of the file, and sets idx.data to the part of the file between header and checksum.
+// Checksum itself is checked only by Verify - it requires reading of the whole file
+func (idx *Index) readHeader() error {
+	data := idx.mmapHandle1[:idx.size]
+	if len(data) < len(indexMagic) || !bytes.Equal(data[:len(indexMagic)], indexMagic) {
+		idx.data = data // legacy file without header
+		return nil
+	}
+	if len(data) < indexHeaderSize+indexChecksumSize {
+		return fmt.Errorf("%w: %s: file is too short: %d", ErrCorrupted, idx.indexFile, len(data))
+	}
+	idx.version = binary.BigEndian.Uint16(data[4:])
+	if idx.version > indexFormatVersion {
+		return fmt.Errorf("%w: %s: %d", ErrUnsupportedVersion, idx.indexFile, idx.version)
+	}
+	idx.headerBucketSize = binary.BigEndian.Uint16(data[6:])
+	idx.headerLeafSize = binary.BigEndian.Uint16(data[8:])
+	idx.headerSalt = binary.BigEndian.Uint32(data[12:])
+	idx.valueWidth = int(data[10])
+	idx.data = data[indexHeaderSize : len(data)-indexChecksumSize]
+	idx.dataOffset = indexHeaderSize
+	return nil
+}
+
+// Verify reads the whole file and checks its checksum. Legacy files without header have no checksum
+func (idx *Index) Verify() error {
+	if idx.version == 0 {
+		return nil
+	}
+	data := idx.mmapHandle1[:idx.size]
+	if xxhash.Sum64(data[:len(data)-indexChecksumSize]) != binary.BigEndian.Uint64(data[len(data)-indexChecksumSize:]) {
+		return fmt.Errorf("%w: %s: checksum mismatch", ErrCorrupted, idx.indexFile)
+	}
+	return nil
 }
 
+// WarmUp reads golomb-rice and elias-fano sections (touched by every Lookup) into page cache,
+// to not wait for disk on first lookups after restart
+func (idx *Index) WarmUp() error {
+	pageSize := os.Getpagesize()
+	from := (idx.dataOffset + idx.warmUpFrom) &^ (pageSize - 1) // madvise requires page-aligned address
+	to := idx.dataOffset + idx.warmUpTo
+	if from >= to {
+		return nil
+	}
+	if err := mmap.MadviseWillNeed(idx.mmapHandle1[from:to]); err != nil {
+		return err
+	}
+	var sum byte
+	for i := from; i < to; i += pageSize {
+		sum += idx.mmapHandle1[i]
+	}
+	runtime.KeepAlive(sum)
+	return nil
+}
+
+// Version returns format version of the index file, 0 for legacy files without header
+func (idx *Index) Version() uint16 { return idx.version }
+
+// ValueWidth returns fixed width of index records in bytes (RecSplitArgs.ValueWidth), 0 if it was not fixed
+func (idx *Index) ValueWidth() int { return idx.valueWidth }
+
 func (idx *Index) Size() int64 {
 	return idx.size
 }
@@ -182,11 +400,69 @@ func (idx *Index) Lookup(bucketHash, fingerprint uint64) uint64 {
 	if idx.keyCount == 1 {
 		return 0
 	}
-	var gr GolombRiceReader
-	gr.data = idx.grData
+	rec := idx.lookupRec(bucketHash, fingerprint)
+	return binary.BigEndian.Uint64(idx.data[1+8+idx.bytesPerRec*(rec+1):]) & idx.recMask
+}
 
+// Has returns false if key with given hashes was definitely not added to the index. Without existence filter,
+// or if key was added, returns true - false positive rate of existence filter is ~1/256
+func (idx *Index) Has(bucketHash, fingerprint uint64) bool {
+	if idx.keyCount == 0 {
+		return false
+	}
+	if idx.existence == nil {
+		return true
+	}
+	return idx.existence.Contains(fingerprint)
+}
+
+// lookupRec returns number of the record, which perfect hash function assigns to the key with given hashes
+func (idx *Index) lookupRec(bucketHash, fingerprint uint64) int {
 	bucket := remap(bucketHash, idx.bucketCount)
 	cumKeys, cumKeysNext, bitPos := idx.ef.Get3(bucket)
+	return idx.lookupRecInBucket(fingerprint, cumKeys, cumKeysNext, bitPos)
+}
+
+// lookupBatchSize - how many keys LookupMany resolves at once: first positions of all their buckets are found
+// (and golomb-rice data prefetched), then the trees are walked
+const lookupBatchSize = 64
+
+// LookupMany is Lookup for a batch of keys hashes, out must be at least as long as bucketHashes and fingerprints
+func (idx *Index) LookupMany(bucketHashes, fingerprints []uint64, out []uint64) {
+	if idx.keyCount == 0 {
+		panic("no Lookup should be done when keyCount==0, please use Empty function to guard")
+	}
+	out = out[:len(bucketHashes)]
+	fingerprints = fingerprints[:len(bucketHashes)]
+	if idx.keyCount == 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return
+	}
+	var cumKeys, cumKeysNext, bitPos [lookupBatchSize]uint64
+	var sink uint64
+	for start := 0; start < len(bucketHashes); start += lookupBatchSize {
+		batch := bucketHashes[start:]
+		if len(batch) > lookupBatchSize {
+			batch = batch[:lookupBatchSize]
+		}
+		for i, bucketHash := range batch {
+			cumKeys[i], cumKeysNext[i], bitPos[i] = idx.ef.Get3(remap(bucketHash, idx.bucketCount))
+			sink += idx.grData[bitPos[i]/64] // Prefetch golomb-rice data of the bucket
+		}
+		for i := range batch {
+			rec := idx.lookupRecInBucket(fingerprints[start+i], cumKeys[i], cumKeysNext[i], bitPos[i])
+			out[start+i] = binary.BigEndian.Uint64(idx.data[1+8+idx.bytesPerRec*(rec+1):]) & idx.recMask
+		}
+	}
+	runtime.KeepAlive(sink)
+}
+
+func (idx *Index) lookupRecInBucket(fingerprint, cumKeys, cumKeysNext, bitPos uint64) int {
+	var gr GolombRiceReader
+	gr.data = idx.grData
+
 	m := uint16(cumKeysNext - cumKeys) // Number of keys in this bucket
 	gr.ReadReset(int(bitPos), idx.skipBits(m))
 	var level int
@@ -234,10 +510,54 @@ func (idx *Index) Lookup(bucketHash, fingerprint uint64) uint64 {
 		level++
 	}
 	b := gr.ReadNext(idx.golombParam(m))
-	rec := int(cumKeys) + int(remap16(remix(fingerprint+idx.startSeed[level]+b), m))
-	return binary.BigEndian.Uint64(idx.data[1+8+idx.bytesPerRec*(rec+1):]) & idx.recMask
+	return int(cumKeys) + int(remap16(remix(fingerprint+idx.startSeed[level]+b), m))
 }
 
 func (idx *Index) Lookup2(i uint64) uint64 {
 	return idx.offsetEf.Get(i)
 }
+
+// MultiValue returns true if index was built with MultiValue, and supports LookupMulti
+func (idx *Index) MultiValue() bool { return idx.countsEf != nil }
+
+// LookupMulti returns iterator over offsets of the key (in ascending order), for index built with MultiValue.
+// For keys which were not added to the index result is undefined
+func (idx *Index) LookupMulti(bucketHash, fingerprint uint64) OffsetIterator {
+	if idx.countsEf == nil {
+		panic("LookupMulti requires index built with MultiValue")
+	}
+	if idx.keyCount == 0 {
+		return OffsetIterator{}
+	}
+	start, end := idx.countsEf.Get2(idx.Lookup(bucketHash, fingerprint))
+	return OffsetIterator{ef: idx.valuesEf, base: idx.valuesEf.Get(start), i: start, end: end}
+}
+
+// OffsetIterator iterates over offsets of one key in multi-value index
+type OffsetIterator struct {
+	ef     *eliasfano32.EliasFano
+	base   uint64
+	i, end uint64
+}
+
+// Count returns number of remaining offsets
+func (it *OffsetIterator) Count() int { return int(it.end - it.i) }
+
+func (it *OffsetIterator) HasNext() bool { return it.i < it.end }
+
+func (it *OffsetIterator) Next() uint64 {
+	it.i++
+	return it.ef.Get(it.i) - it.base
+}
+
+// Ordered returns true if index was built with ordered keys, and supports OrdinalLookup
+func (idx *Index) Ordered() bool { return idx.ordered }
+
+// OrdinalLookup returns offset of the i-th key in ascending order of keys.
+// Together with LookupOrdinal, allows to binary-search ranges of keys without separate index of sorted keys
+func (idx *Index) OrdinalLookup(i uint64) uint64 {
+	if !idx.ordered {
+		panic("OrdinalLookup requires index built with OrderedKeys")
+	}
+	return binary.BigEndian.Uint64(idx.data[idx.ordinalsOffset+idx.bytesPerRec*int(i+1)-8:]) & idx.recMask
+}
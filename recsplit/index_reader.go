@@ -17,32 +17,28 @@
 package recsplit
 
 import (
-	"sync"
+	"fmt"
 
 	"github.com/spaolacci/murmur3"
 )
 
-// IndexReader encapsulates Hash128 to allow concurrent access to Index
+// IndexReader encapsulates hashing of keys to allow concurrent access to Index.
+// murmur3 is computed on the stack, without shared hasher - so Lookup doesn't need any locks
 type IndexReader struct {
-	mu     sync.RWMutex
-	hasher murmur3.Hash128
-	index  *Index
+	salt  uint32
+	index *Index
 }
 
 // NewIndexReader creates new IndexReader
 func NewIndexReader(index *Index) *IndexReader {
 	return &IndexReader{
-		hasher: murmur3.New128WithSeed(index.salt),
-		index:  index,
+		salt:  index.salt,
+		index: index,
 	}
 }
 
 func (r *IndexReader) sum(key []byte) (uint64, uint64) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.hasher.Reset()
-	r.hasher.Write(key) //nolint:errcheck
-	return r.hasher.Sum128()
+	return murmur3.Sum128WithSeed(key, r.salt)
 }
 
 // Lookup wraps index Lookup
@@ -53,3 +49,74 @@ func (r *IndexReader) Lookup(key []byte) uint64 {
 	}
 	return 0
 }
+
+// LookupMany resolves batch of keys into out (must be at least as long as keys), it is faster than Lookup of every key
+func (r *IndexReader) LookupMany(keys [][]byte, out []uint64) {
+	var bucketHashes, fingerprints [lookupBatchSize]uint64
+	out = out[:len(keys)]
+	for start := 0; start < len(keys); start += lookupBatchSize {
+		batch := keys[start:]
+		if len(batch) > lookupBatchSize {
+			batch = batch[:lookupBatchSize]
+		}
+		for i, key := range batch {
+			bucketHashes[i], fingerprints[i] = r.sum(key)
+		}
+		r.index.LookupMany(bucketHashes[:len(batch)], fingerprints[:len(batch)], out[start:start+len(batch)])
+	}
+}
+
+// LookupOrdinal returns position of the key in ascending order of keys, for index built with OrderedKeys.
+// For keys which were not added to the index result is undefined - caller needs to check key found by OrdinalLookup
+func (r *IndexReader) LookupOrdinal(key []byte) uint64 {
+	if !r.index.Ordered() {
+		panic("LookupOrdinal requires index built with OrderedKeys")
+	}
+	return r.Lookup(key)
+}
+
+// LookupMulti returns iterator over offsets of the key, for index built with MultiValue
+func (r *IndexReader) LookupMulti(key []byte) OffsetIterator {
+	bucketHash, fingerprint := r.sum(key)
+	return r.index.LookupMulti(bucketHash, fingerprint)
+}
+
+// Has returns false if key was definitely not added to the index (requires index built with ExistenceFilter,
+// otherwise always returns true for non-empty index)
+func (r *IndexReader) Has(key []byte) bool {
+	bucketHash, fingerprint := r.sum(key)
+	return r.index.Has(bucketHash, fingerprint)
+}
+
+// LookupExisting is like Lookup, but also reports non-existence of the key, checked by existence filter
+func (r *IndexReader) LookupExisting(key []byte) (uint64, bool) {
+	bucketHash, fingerprint := r.sum(key)
+	if !r.index.Has(bucketHash, fingerprint) {
+		return 0, false
+	}
+	return r.index.Lookup(bucketHash, fingerprint), true
+}
+
+// Uint32Reader is IndexReader of index built with ValueWidth 4 (for example, ordinal map), returning values as uint32
+type Uint32Reader struct {
+	*IndexReader
+}
+
+// NewUint32Reader creates new Uint32Reader, fails if values of the index are not guaranteed to fit into uint32
+func NewUint32Reader(index *Index) (*Uint32Reader, error) {
+	if index.ValueWidth() != 4 {
+		return nil, fmt.Errorf("index %s has value width %d, expected 4", index.indexFile, index.ValueWidth())
+	}
+	return &Uint32Reader{IndexReader: NewIndexReader(index)}, nil
+}
+
+// Lookup wraps IndexReader Lookup
+func (r *Uint32Reader) Lookup(key []byte) uint32 {
+	return uint32(r.IndexReader.Lookup(key))
+}
+
+// LookupExisting wraps IndexReader LookupExisting
+func (r *Uint32Reader) LookupExisting(key []byte) (uint32, bool) {
+	v, ok := r.IndexReader.LookupExisting(key)
+	return uint32(v), ok
+}
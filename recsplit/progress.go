@@ -0,0 +1,155 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package recsplit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+)
+
+// BuildPhase - stage of index construction, reported in Progress
+type BuildPhase int
+
+const (
+	PhaseAddKeys    BuildPhase = iota // keys are added by AddKey
+	PhaseDuplicates                   // hashes of keys are sorted to find duplicates (DetectDuplicates, DedupKeys)
+	PhaseBuckets                      // buckets are split and hash function is written
+	PhaseOffsets                      // "enum -> offset" table is built (Enums)
+	PhaseWrite                        // the rest of tables is written into index file
+	PhaseDone
+)
+
+func (p BuildPhase) String() string {
+	switch p {
+	case PhaseAddKeys:
+		return "add keys"
+	case PhaseDuplicates:
+		return "duplicates"
+	case PhaseBuckets:
+		return "buckets"
+	case PhaseOffsets:
+		return "offsets"
+	case PhaseWrite:
+		return "write"
+	case PhaseDone:
+		return "done"
+	default:
+		return fmt.Sprintf("phase(%d)", int(p))
+	}
+}
+
+const (
+	DefaultProgressEvery = time.Second
+	progressKeysStep     = 1024 // keys added between checks of time, to keep AddKey cheap
+	logProgressEvery     = 30 * time.Second
+)
+
+// Progress - state of index construction
+type Progress struct {
+	Phase        BuildPhase
+	KeysAdded    uint64
+	KeyCount     uint64 // expected number of keys
+	BucketsBuilt uint64
+	BucketCount  uint64
+	Elapsed      time.Duration // since NewRecSplit
+	PhaseElapsed time.Duration
+}
+
+func (p Progress) phaseDone() (done, total uint64) {
+	switch p.Phase {
+	case PhaseAddKeys:
+		return p.KeysAdded, p.KeyCount
+	case PhaseBuckets:
+		return p.BucketsBuilt, p.BucketCount
+	default:
+		return 0, 0
+	}
+}
+
+// Percent - completeness of current phase, 0 for phases which don't report it
+func (p Progress) Percent() float64 {
+	done, total := p.phaseDone()
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(done) / float64(total)
+}
+
+// ETA - estimated time left of current phase, by its rate so far. 0 if unknown
+func (p Progress) ETA() time.Duration {
+	done, total := p.phaseDone()
+	if done == 0 || done >= total {
+		return 0
+	}
+	return time.Duration(float64(p.PhaseElapsed) * float64(total-done) / float64(done))
+}
+
+func (p Progress) String() string {
+	s := fmt.Sprintf("phase=%s keys=%d/%d buckets=%d/%d elapsed=%s", p.Phase, p.KeysAdded, p.KeyCount,
+		p.BucketsBuilt, p.BucketCount, p.Elapsed.Round(time.Second))
+	if done, total := p.phaseDone(); total > 0 && done < total {
+		s += fmt.Sprintf(" progress=%.1f%% eta=%s", p.Percent(), p.ETA().Round(time.Second))
+	}
+	return s
+}
+
+// Progress - current state of index construction
+func (rs *RecSplit) Progress() Progress {
+	now := time.Now()
+	return Progress{
+		Phase:        rs.phase,
+		KeysAdded:    rs.keysAdded,
+		KeyCount:     rs.keyExpectedCount,
+		BucketsBuilt: rs.bucketsBuilt,
+		BucketCount:  rs.bucketCount,
+		Elapsed:      now.Sub(rs.started),
+		PhaseElapsed: now.Sub(rs.phaseStarted),
+	}
+}
+
+// setPhase - switches to the next phase and always reports it
+func (rs *RecSplit) setPhase(phase BuildPhase) {
+	rs.phase = phase
+	rs.phaseStarted = time.Now()
+	if phase == PhaseDone {
+		rs.bucketsBuilt = rs.bucketCount
+	}
+	rs.reportProgress(true)
+}
+
+// reportProgress - calls RecSplitArgs.Progress at most every ProgressEvery (unless forced),
+// and logs progress every 30 seconds
+func (rs *RecSplit) reportProgress(force bool) {
+	if rs.progress == nil && rs.noLogs {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(rs.lastProgress) < rs.progressEvery {
+		return
+	}
+	rs.lastProgress = now
+	p := rs.Progress()
+	if rs.progress != nil {
+		rs.progress(p)
+	}
+	if !rs.noLogs && now.Sub(rs.lastLog) >= logProgressEvery {
+		rs.lastLog = now
+		log.Info(fmt.Sprintf("[%s] building %s", RecSplitLogPrefix, rs.indexFile), "progress", p.String())
+	}
+}
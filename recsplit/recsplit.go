@@ -18,14 +18,22 @@ package recsplit
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"math/bits"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano16"
 	"github.com/ledgerwatch/erigon-lib/recsplit/eliasfano32"
@@ -36,10 +44,45 @@ var ASSERT = false
 
 var ErrCollision = fmt.Errorf("duplicate key")
 
+// ErrDuplicateKey is returned by Build if RecSplitArgs.DetectDuplicates is set and some keys were added more than once.
+// Unlike ErrCollision, it can't be fixed by rebuilding with another salt
+var ErrDuplicateKey = fmt.Errorf("key added more than once")
+
+// maxReportedDuplicates - how many duplicate keys are listed in ErrDuplicateKey
+const maxReportedDuplicates = 8
+
+// ErrCorrupted is returned when index file doesn't pass validation (for example, truncated download)
+var ErrCorrupted = fmt.Errorf("index file is corrupted")
+
+// ErrUnsupportedVersion is returned when index file was written in the format this version can't read
+var ErrUnsupportedVersion = fmt.Errorf("unsupported index format version")
+
 const RecSplitLogPrefix = "recsplit"
 
 const MaxLeafSize = 24
 
+// Index file starts with the header: magic, format version, build parameters (bucket size, leaf size, salt),
+// and ends with xxhash of everything before it. Files without magic are read as legacy (version 0) files
+const (
+	indexFormatVersion uint16 = 1
+	indexHeaderSize           = 16 // magic(4) + version(2) + bucketSize(2) + leafSize(2) + valueWidth(1) + reserved(1) + salt(4)
+	indexChecksumSize         = 8
+)
+
+var indexMagic = []byte("rsix")
+
+// bucketsPerWorker - how many buckets are accumulated per worker before they are split concurrently
+const bucketsPerWorker = 64
+
+// Bit flags of optional index features, written into index file as one byte
+const (
+	featureEnums       byte = 0b1
+	featureOrderedKeys byte = 0b10
+	featureExistence   byte = 0b100
+	featureMultiValue  byte = 0b1000
+	featureKeyHashes   byte = 0b10000
+)
+
 /** David Stafford's (http://zimbry.blogspot.com/2011/09/better-bit-mixing-improving-on.html)
  * 13th variant of the 64-bit finalizer function in Austin Appleby's
  * MurmurHash3 (https://github.com/aappleby/smhasher).
@@ -68,12 +111,26 @@ type RecSplit struct {
 	bucketCollector   *etl.Collector  // Collector that sorts by buckets
 	enums             bool            // Whether to build two level index with perfect hash table pointing to enumeration and enumeration pointing to offsets
 	offsetCollector   *etl.Collector  // Collector that sorts by offsets
-	built             bool            // Flag indicating that the hash function has been built and no more keys can be added
-	currentBucketIdx  uint64          // Current bucket being accumulated
-	currentBucket     []uint64        // 64-bit fingerprints of keys in the current bucket accumulated before the recsplit is performed for that bucket
-	currentBucketOffs []uint64        // Index offsets for the current bucket
-	maxOffset         uint64          // Maximum value of index offset to later decide how many bytes to use for the encoding
-	gr                GolombRice      // Helper object to encode the tree of hash function salts using Golomb-Rice code.
+	ordered           bool            // Whether keys are added in ascending order and index keeps "ordinal -> offset" table
+	prevKey           []byte          // Previously added key (to check ascending order of keys in ordered mode)
+	existence         bool            // Whether existence filter (binary fuse filter of key fingerprints) is written into the index
+	existenceKeys     []uint64        // Fingerprints of all keys, to build existence filter after the main table
+	keyHashes         bool            // Whether full 64-bit fingerprint per record is written into the index, for Audit
+	keyHashesF        *os.File        // Temporary file for the key hashes, it's written after the existence filter
+	keyHashesW        *bufio.Writer
+	multiValue        bool                  // Whether one key maps to several offsets, perfect hash map points to the key number
+	valuesCollector   *etl.Collector        // Collector of "key number -> sorted offsets" in multi-value mode
+	valuesAdded       uint64                // Total number of offsets added in multi-value mode
+	dupCollector      *etl.Collector        // Collector of full 128-bit hashes of keys with their offsets, sorted to find duplicate keys
+	dedup             bool                  // Whether duplicate keys are indexed once instead of failing Build
+	dupBucketKeys     map[[16]byte]struct{} // Bucket keys (bucket number + fingerprint) of duplicate keys, to skip them in dedup mode
+	valuesSum         uint64                // Sum of deltas of all offsets added in multi-value mode (upper bound of values Elias Fano)
+	built             bool                  // Flag indicating that the hash function has been built and no more keys can be added
+	currentBucketIdx  uint64                // Current bucket being accumulated
+	currentBucket     []uint64              // 64-bit fingerprints of keys in the current bucket accumulated before the recsplit is performed for that bucket
+	currentBucketOffs []uint64              // Index offsets for the current bucket
+	maxOffset         uint64                // Maximum value of index offset to later decide how many bytes to use for the encoding
+	gr                GolombRice            // Helper object to encode the tree of hash function salts using Golomb-Rice code.
 	// Helper object to encode the sequence of cumulative number of keys in the buckets
 	// and the sequence of of cumulative bit offsets of buckets in the Golomb-Rice code.
 	ef                 eliasfano16.DoubleEliasFano
@@ -85,9 +142,9 @@ type RecSplit struct {
 	secondaryAggrBound uint16                 // The lower bound for secondary key aggregation (computed from leadSize)
 	startSeed          []uint64
 	golombRice         []uint32
-	buffer             []uint64
-	offsetBuffer       []uint64
-	count              []uint16
+	splitters          []*bucketSplitter // Per-worker state for splitting buckets concurrently
+	batch              []*bucketTask     // Buckets accumulated to be split by the workers
+	batchLen           int
 	salt               uint32 // Murmur3 hash used for converting keys to 64-bit values and assigning to buckets
 	collision          bool
 	tmpDir             string
@@ -95,11 +152,21 @@ type RecSplit struct {
 	indexF             *os.File
 	indexW             *bufio.Writer
 	bytesPerRec        int
+	valueWidth         int // Fixed bytesPerRec, 0 if it's derived from maximal value
 	numBuf             [8]byte
 	bucketKeyBuf       [16]byte
 	trace              bool
 	prevOffset         uint64 // Previously added offset (for calculating minDelta for Elias Fano encoding of "enum -> offset" index)
 	minDelta           uint64 // minDelta for Elias Fano encoding of "enum -> offset" index
+	noLogs             bool
+	progress           func(Progress) // Optional callback, see RecSplitArgs.Progress
+	progressEvery      time.Duration
+	phase              BuildPhase
+	bucketsBuilt       uint64 // Buckets up to this one are split and written
+	started            time.Time
+	phaseStarted       time.Time
+	lastProgress       time.Time
+	lastLog            time.Time
 }
 
 type RecSplitArgs struct {
@@ -111,7 +178,34 @@ type RecSplitArgs struct {
 	TmpDir     string
 	StartSeed  []uint64 // For each level of recursive split, the hash seed (salt) used for that level - need to be generated randomly and be large enough to accomodate all the levels
 	Enums      bool     // Whether two level index needs to be built, where perfect hash map points to an enumeration, and enumeration points to offsets
+	// Whether keys are added in ascending order. Then perfect hash map points to ordinal of the key (position in sorted order),
+	// and index stores "ordinal -> offset" table - allows readers to do range/prefix lookups by binary search over ordinals
+	OrderedKeys bool
+	// Whether to store existence filter (binary fuse filter of key fingerprints) - allows to detect absent keys
+	// with false positive rate ~1/256, at the cost of ~1.13 bytes per key and 8 bytes per key of memory during Build
+	ExistenceFilter bool
+	// Whether to store full 64-bit fingerprint per key - allows to Audit the index against the stream of keys
+	// it was built from, at the cost of 8 bytes per key
+	KeyHashes bool
+	// Whether one key maps to several offsets (keys are added by AddKeyMulti, KeyCount is number of distinct keys).
+	// Then perfect hash map points to the key number, and index stores offsets of every key as Elias Fano of deltas
+	MultiValue bool
 	BaseDataID uint64
+	Workers    int // Number of goroutines splitting buckets concurrently, output doesn't depend on it. 1 if not set
+	// Fixed width of index records in bytes: 4 or 8. By default records are as narrow as maximal value allows,
+	// fixed width 4 lets readers (Uint32Reader) rely on values of ordinal maps fitting into uint32
+	ValueWidth int
+	// Whether duplicate keys are detected: full 128-bit hashes of added keys are collected (and spilled to TmpDir like
+	// buckets) and sorted at Build, which fails with ErrDuplicateKey listing first duplicates with their offsets
+	DetectDuplicates bool
+	// Whether duplicate keys are indexed once, with the smallest offset, instead of failing Build. Implies DetectDuplicates,
+	// KeyCount still counts all added keys. Can't be used with Enums, OrderedKeys and MultiValue
+	DedupKeys bool
+	// Progress is called with the state of construction on every phase change and at most every ProgressEvery
+	// (DefaultProgressEvery if not set) within phases, from the goroutine calling AddKey and Build - must not block:
+	// to feed a progress bar from a channel, use non-blocking send
+	Progress      func(Progress)
+	ProgressEvery time.Duration
 }
 
 // NewRecSplit creates a new RecSplit instance with given number of keys and given bucket size
@@ -135,12 +229,43 @@ func NewRecSplit(args RecSplitArgs) (*RecSplit, error) {
 		rs.salt = binary.BigEndian.Uint32(seedBytes)
 	}
 	rs.hasher = murmur3.New128WithSeed(rs.salt)
+	rs.progress = args.Progress
+	rs.progressEvery = args.ProgressEvery
+	if rs.progressEvery == 0 {
+		rs.progressEvery = DefaultProgressEvery
+	}
+	rs.started = time.Now()
+	rs.phaseStarted, rs.lastProgress, rs.lastLog = rs.started, rs.started, rs.started
 	rs.tmpDir = args.TmpDir
 	rs.indexFile = args.IndexFile
 	rs.baseDataID = args.BaseDataID
 	rs.bucketCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
 	rs.enums = args.Enums
-	if args.Enums {
+	rs.ordered = args.OrderedKeys
+	rs.existence = args.ExistenceFilter
+	rs.keyHashes = args.KeyHashes
+	rs.multiValue = args.MultiValue
+	if args.Enums && args.OrderedKeys {
+		return nil, fmt.Errorf("enums and ordered keys modes can't be used together")
+	}
+	if args.MultiValue && (args.Enums || args.OrderedKeys) {
+		return nil, fmt.Errorf("multi-value mode can't be used together with enums or ordered keys")
+	}
+	if args.ValueWidth != 0 && args.ValueWidth != 4 && args.ValueWidth != 8 {
+		return nil, fmt.Errorf("value width must be 4 or 8 bytes: %d", args.ValueWidth)
+	}
+	rs.valueWidth = args.ValueWidth
+	if args.DedupKeys && (args.Enums || args.OrderedKeys || args.MultiValue) {
+		return nil, fmt.Errorf("dedup of keys can't be used together with enums, ordered keys or multi-value mode")
+	}
+	rs.dedup = args.DedupKeys
+	if args.DetectDuplicates || args.DedupKeys {
+		rs.dupCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	}
+	if args.MultiValue {
+		rs.valuesCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	}
+	if args.Enums || args.OrderedKeys {
 		rs.offsetCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
 	}
 	rs.currentBucket = make([]uint64, 0, args.BucketSize)
@@ -159,7 +284,20 @@ func NewRecSplit(args RecSplitArgs) (*RecSplit, error) {
 		rs.secondaryAggrBound = rs.primaryAggrBound * uint16(math.Ceil(0.21*float64(rs.leafSize)+9./10.))
 	}
 	rs.startSeed = args.StartSeed
-	rs.count = make([]uint16, rs.secondaryAggrBound)
+	workers := args.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	rs.splitters = make([]*bucketSplitter, workers)
+	for i := range rs.splitters {
+		rs.splitters[i] = &bucketSplitter{
+			leafSize:           rs.leafSize,
+			primaryAggrBound:   rs.primaryAggrBound,
+			secondaryAggrBound: rs.secondaryAggrBound,
+			startSeed:          rs.startSeed,
+			count:              make([]uint16, rs.secondaryAggrBound),
+		}
+	}
 	return rs, nil
 }
 
@@ -173,10 +311,28 @@ func (rs *RecSplit) Close() {
 	if rs.offsetCollector != nil {
 		rs.offsetCollector.Close()
 	}
+	if rs.valuesCollector != nil {
+		rs.valuesCollector.Close()
+	}
+	if rs.dupCollector != nil {
+		rs.dupCollector.Close()
+	}
+	rs.closeKeyHashesFile()
+}
+
+func (rs *RecSplit) closeKeyHashesFile() {
+	if rs.keyHashesF != nil {
+		rs.keyHashesF.Close()
+		os.Remove(rs.keyHashesF.Name())
+		rs.keyHashesF = nil
+	}
 }
 
 func (rs *RecSplit) SetTrace(trace bool) {
 	rs.trace = trace
+	for _, s := range rs.splitters {
+		s.trace = trace
+	}
 }
 
 // remap converts the number x which is assumed to be uniformly distributed over the range [0..2^64) to the number that is uniformly
@@ -206,11 +362,23 @@ func (rs *RecSplit) ResetNextSalt() {
 	if rs.offsetCollector != nil {
 		rs.offsetCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
 	}
+	if rs.valuesCollector != nil {
+		rs.valuesCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	}
+	if rs.dupCollector != nil {
+		rs.dupCollector = etl.NewCollector(RecSplitLogPrefix, rs.tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	}
+	rs.dupBucketKeys = nil
+	rs.valuesAdded = 0
+	rs.valuesSum = 0
 	rs.currentBucket = rs.currentBucket[:0]
 	rs.currentBucketOffs = rs.currentBucketOffs[:0]
+	rs.batchLen = 0
 	rs.maxOffset = 0
 	rs.bucketSizeAcc = rs.bucketSizeAcc[:1] // First entry is always zero
 	rs.bucketPosAcc = rs.bucketPosAcc[:1]   // First entry is always zero
+	rs.bucketsBuilt = 0
+	rs.setPhase(PhaseAddKeys)
 }
 
 func splitParams(m uint16, leafSize uint16, primaryAggrBound uint16, secondaryAggrBound uint16) (fanout, unit uint16) {
@@ -266,26 +434,35 @@ func computeGolombRice(m uint16, table []uint32, leafSize uint16, primaryAggrBou
 // salt for the part of the hash function separating m elements. It is based on
 // calculations with assumptions that we draw hash functions at random
 func (rs *RecSplit) golombParam(m uint16) int {
-	s := uint16(len(rs.golombRice))
+	rs.golombRice = growGolombRice(rs.golombRice, m, rs.leafSize, rs.primaryAggrBound, rs.secondaryAggrBound)
+	return int(rs.golombRice[m] >> 27)
+}
+
+// growGolombRice extends table of golomb rice parameters to accomodate bucket of size m
+func growGolombRice(table []uint32, m uint16, leafSize, primaryAggrBound, secondaryAggrBound uint16) []uint32 {
+	s := uint16(len(table))
 	for m >= s {
-		rs.golombRice = append(rs.golombRice, 0)
+		table = append(table, 0)
 		// For the case where bucket is larger than planned
 		if s == 0 {
-			rs.golombRice[0] = (bijMemo[0] << 27) | bijMemo[0]
-		} else if s <= rs.leafSize {
-			rs.golombRice[s] = (bijMemo[s] << 27) | (uint32(1) << 16) | bijMemo[s]
+			table[0] = (bijMemo[0] << 27) | bijMemo[0]
+		} else if s <= leafSize {
+			table[s] = (bijMemo[s] << 27) | (uint32(1) << 16) | bijMemo[s]
 		} else {
-			computeGolombRice(s, rs.golombRice, rs.leafSize, rs.primaryAggrBound, rs.secondaryAggrBound)
+			computeGolombRice(s, table, leafSize, primaryAggrBound, secondaryAggrBound)
 		}
 		s++
 	}
-	return int(rs.golombRice[m] >> 27)
+	return table
 }
 
 // Add key to the RecSplit. There can be many more keys than what fits in RAM, and RecSplit
 // spills data onto disk to accomodate that. The key gets copied by the collector, therefore
 // the slice underlying key is not getting accessed by RecSplit after this invocation.
 func (rs *RecSplit) AddKey(key []byte, offset uint64) error {
+	if rs.multiValue {
+		return rs.AddKeyMulti(key, []uint64{offset})
+	}
 	if rs.built {
 		return fmt.Errorf("cannot add keys after perfect hash function had been built")
 	}
@@ -298,6 +475,15 @@ func (rs *RecSplit) AddKey(key []byte, offset uint64) error {
 	if offset > rs.maxOffset {
 		rs.maxOffset = offset
 	}
+	if err := rs.collectDup(hi, lo, offset); err != nil {
+		return err
+	}
+	if rs.ordered {
+		if rs.keysAdded > 0 && bytes.Compare(rs.prevKey, key) >= 0 {
+			return fmt.Errorf("keys must be added in ascending order: %x after %x", key, rs.prevKey)
+		}
+		rs.prevKey = append(rs.prevKey[:0], key...)
+	}
 	if rs.keysAdded > 0 {
 		delta := offset - rs.prevOffset
 		if rs.keysAdded == 1 || delta < rs.minDelta {
@@ -313,6 +499,16 @@ func (rs *RecSplit) AddKey(key []byte, offset uint64) error {
 		if err := rs.bucketCollector.Collect(rs.bucketKeyBuf[:], rs.numBuf[:]); err != nil {
 			return err
 		}
+	} else if rs.ordered {
+		// ordinal -> offset, ordinals come in ascending order, so collector doesn't need to spill much
+		var ordinalBuf [8]byte
+		binary.BigEndian.PutUint64(ordinalBuf[:], rs.keysAdded)
+		if err := rs.offsetCollector.Collect(ordinalBuf[:], rs.numBuf[:]); err != nil {
+			return err
+		}
+		if err := rs.bucketCollector.Collect(rs.bucketKeyBuf[:], ordinalBuf[:]); err != nil {
+			return err
+		}
 	} else {
 		if err := rs.bucketCollector.Collect(rs.bucketKeyBuf[:], rs.numBuf[:]); err != nil {
 			return err
@@ -320,78 +516,290 @@ func (rs *RecSplit) AddKey(key []byte, offset uint64) error {
 	}
 	rs.keysAdded++
 	rs.prevOffset = offset
+	if rs.keysAdded%progressKeysStep == 0 {
+		rs.reportProgress(false)
+	}
+	return nil
+}
+
+// AddKeyMulti adds key with all its offsets, for index built with MultiValue. Each key must be added only once
+func (rs *RecSplit) AddKeyMulti(key []byte, offsets []uint64) error {
+	if !rs.multiValue {
+		return fmt.Errorf("AddKeyMulti requires MultiValue mode")
+	}
+	if rs.built {
+		return fmt.Errorf("cannot add keys after perfect hash function had been built")
+	}
+	if len(offsets) == 0 {
+		return fmt.Errorf("no offsets for key %x", key)
+	}
+	rs.hasher.Reset()
+	rs.hasher.Write(key) //nolint:errcheck
+	hi, lo := rs.hasher.Sum128()
+	binary.BigEndian.PutUint64(rs.bucketKeyBuf[:], remap(hi, rs.bucketCount))
+	binary.BigEndian.PutUint64(rs.bucketKeyBuf[8:], lo)
+	if err := rs.collectDup(hi, lo, offsets[0]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint64(rs.numBuf[:], rs.keysAdded)
+	if err := rs.bucketCollector.Collect(rs.bucketKeyBuf[:], rs.numBuf[:]); err != nil {
+		return err
+	}
+	sorted := make([]uint64, len(offsets))
+	copy(sorted, offsets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// key number -> sorted offsets, key numbers come in ascending order, so collector doesn't need to spill much
+	v := make([]byte, 8*len(sorted))
+	for i, offset := range sorted {
+		binary.BigEndian.PutUint64(v[8*i:], offset)
+	}
+	if err := rs.valuesCollector.Collect(rs.numBuf[:], v); err != nil {
+		return err
+	}
+	rs.valuesAdded += uint64(len(sorted))
+	rs.valuesSum += sorted[len(sorted)-1] // Sum of deltas within the key
+	rs.keysAdded++
+	if rs.keysAdded%progressKeysStep == 0 {
+		rs.reportProgress(false)
+	}
+	return nil
+}
+
+// collectDup collects full hash of the key with its offset for detection of duplicate keys, if enabled.
+// Offset is part of the collector key - so duplicates come sorted by offset, and dedup keeps the smallest one
+func (rs *RecSplit) collectDup(hi, lo, offset uint64) error {
+	if rs.dupCollector == nil {
+		return nil
+	}
+	var k [24]byte
+	binary.BigEndian.PutUint64(k[:], hi)
+	binary.BigEndian.PutUint64(k[8:], lo)
+	binary.BigEndian.PutUint64(k[16:], offset)
+	return rs.dupCollector.Collect(k[:], nil)
+}
+
+// findDuplicates sorts hashes of added keys, in dedup mode remembers bucket keys of duplicates and excludes
+// them from keysAdded, otherwise returns ErrDuplicateKey if there are any
+func (rs *RecSplit) findDuplicates() error {
+	if rs.dupCollector == nil {
+		return nil
+	}
+	defer rs.dupCollector.Close()
+	var prev []byte
+	var dups uint64
+	var reported []string
+	if err := rs.dupCollector.Load(nil, "", func(k, _ []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		if prev != nil && bytes.Equal(prev[:16], k[:16]) {
+			dups++
+			if len(reported) < maxReportedDuplicates {
+				reported = append(reported, fmt.Sprintf("%x: offsets %d and %d", k[:16], binary.BigEndian.Uint64(prev[16:]), binary.BigEndian.Uint64(k[16:])))
+			}
+			if rs.dedup {
+				var bucketKey [16]byte
+				binary.BigEndian.PutUint64(bucketKey[:], remap(binary.BigEndian.Uint64(k), rs.bucketCount))
+				copy(bucketKey[8:], k[8:16])
+				if rs.dupBucketKeys == nil {
+					rs.dupBucketKeys = map[[16]byte]struct{}{}
+				}
+				rs.dupBucketKeys[bucketKey] = struct{}{}
+			}
+			return nil // first occurrence stays in prev, to report offsets of every duplicate against it
+		}
+		prev = append(prev[:0], k...)
+		return nil
+	}, etl.TransformArgs{}); err != nil {
+		return err
+	}
+	if dups == 0 {
+		return nil
+	}
+	if !rs.dedup {
+		return fmt.Errorf("%w: %d duplicates, key hashes of first ones: %s", ErrDuplicateKey, dups, strings.Join(reported, ", "))
+	}
+	rs.keysAdded -= dups
 	return nil
 }
 
 func (rs *RecSplit) NoLogs(v bool) {
+	rs.noLogs = v
 	if rs.bucketCollector != nil {
 		rs.bucketCollector.NoLogs(v)
 	}
 	if rs.offsetCollector != nil {
 		rs.offsetCollector.NoLogs(v)
 	}
+	if rs.dupCollector != nil {
+		rs.dupCollector.NoLogs(v)
+	}
 }
 
+// recsplitCurrentBucket hands over current bucket to the batch of buckets, which are split by the workers
 func (rs *RecSplit) recsplitCurrentBucket() error {
+	var t *bucketTask
+	if rs.batchLen < len(rs.batch) {
+		t = rs.batch[rs.batchLen]
+	} else {
+		t = &bucketTask{}
+		rs.batch = append(rs.batch, t)
+	}
+	rs.batchLen++
+	t.bucketIdx = rs.currentBucketIdx
+	t.keys = append(t.keys[:0], rs.currentBucket...)
+	t.offsets = append(t.offsets[:0], rs.currentBucketOffs...)
+	// clear for the next buckey
+	rs.currentBucket = rs.currentBucket[:0]
+	rs.currentBucketOffs = rs.currentBucketOffs[:0]
+	if rs.batchLen >= bucketsPerWorker*len(rs.splitters) {
+		return rs.splitBatch()
+	}
+	return nil
+}
+
+// splitBatch splits accumulated buckets concurrently (buckets are independent), and then
+// merges results into the index in the order of buckets - to make output independent of the number of workers
+func (rs *RecSplit) splitBatch() error {
+	batch := rs.batch[:rs.batchLen]
+	rs.batchLen = 0
+	if len(rs.splitters) == 1 {
+		for _, t := range batch {
+			rs.splitters[0].split(t)
+		}
+	} else {
+		tasks := make(chan *bucketTask, len(batch))
+		for _, t := range batch {
+			tasks <- t
+		}
+		close(tasks)
+		var wg sync.WaitGroup
+		for _, s := range rs.splitters {
+			wg.Add(1)
+			go func(s *bucketSplitter) {
+				defer wg.Done()
+				for t := range tasks {
+					s.split(t)
+				}
+			}(s)
+		}
+		wg.Wait()
+	}
+	for _, t := range batch {
+		if err := rs.mergeBucket(t); err != nil {
+			return err
+		}
+	}
+	rs.reportProgress(false)
+	return nil
+}
+
+func (rs *RecSplit) mergeBucket(t *bucketTask) error {
+	if t.err != nil {
+		if errors.Is(t.err, ErrCollision) {
+			rs.collision = true
+		}
+		return t.err
+	}
 	// Extend rs.bucketSizeAcc to accomodate current bucket index + 1
-	for len(rs.bucketSizeAcc) <= int(rs.currentBucketIdx)+1 {
+	for len(rs.bucketSizeAcc) <= int(t.bucketIdx)+1 {
 		rs.bucketSizeAcc = append(rs.bucketSizeAcc, rs.bucketSizeAcc[len(rs.bucketSizeAcc)-1])
 	}
-	rs.bucketSizeAcc[int(rs.currentBucketIdx)+1] += uint64(len(rs.currentBucket))
+	rs.bucketSizeAcc[int(t.bucketIdx)+1] += uint64(len(t.keys))
 	// Sets of size 0 and 1 are not further processed, just write them to index
-	if len(rs.currentBucket) > 1 {
-		for i, key := range rs.currentBucket[1:] {
-			if key == rs.currentBucket[i] {
-				rs.collision = true
-				return fmt.Errorf("%w: %x", ErrCollision, key)
-			}
-		}
+	if len(t.keys) > 1 {
 		bitPos := rs.gr.bitCount
-		if rs.buffer == nil {
-			rs.buffer = make([]uint64, len(rs.currentBucket))
-			rs.offsetBuffer = make([]uint64, len(rs.currentBucketOffs))
-		} else {
-			for len(rs.buffer) < len(rs.currentBucket) {
-				rs.buffer = append(rs.buffer, 0)
-				rs.offsetBuffer = append(rs.offsetBuffer, 0)
-			}
-		}
-		unary, err := rs.recsplit(0 /* level */, rs.currentBucket, rs.currentBucketOffs, nil /* unary */)
-		if err != nil {
-			return err
+		rs.golombParam(uint16(len(t.keys))) // table of golomb rice params is written into index, make sure it's big enough
+		for i := 0; i < len(t.fixed); i += 2 {
+			rs.gr.appendFixed(t.fixed[i], int(t.fixed[i+1]))
 		}
-		rs.gr.appendUnaryAll(unary)
+		rs.gr.appendUnaryAll(t.unary)
 		if rs.trace {
-			fmt.Printf("recsplitBucket(%d, %d, bitsize = %d)\n", rs.currentBucketIdx, len(rs.currentBucket), rs.gr.bitCount-bitPos)
+			fmt.Printf("recsplitBucket(%d, %d, bitsize = %d)\n", t.bucketIdx, len(t.keys), rs.gr.bitCount-bitPos)
 		}
-	} else {
-		for _, offset := range rs.currentBucketOffs {
-			binary.BigEndian.PutUint64(rs.numBuf[:], offset)
-			if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
+	}
+	for _, offset := range t.out {
+		binary.BigEndian.PutUint64(rs.numBuf[:], offset)
+		if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
+			return err
+		}
+	}
+	if rs.existence {
+		rs.existenceKeys = append(rs.existenceKeys, t.keys...)
+	}
+	if rs.keyHashes {
+		for _, fingerprint := range t.outFps {
+			binary.BigEndian.PutUint64(rs.numBuf[:], fingerprint)
+			if _, err := rs.keyHashesW.Write(rs.numBuf[:]); err != nil {
 				return err
 			}
 		}
 	}
 	// Extend rs.bucketPosAcc to accomodate current bucket index + 1
-	for len(rs.bucketPosAcc) <= int(rs.currentBucketIdx)+1 {
+	for len(rs.bucketPosAcc) <= int(t.bucketIdx)+1 {
 		rs.bucketPosAcc = append(rs.bucketPosAcc, rs.bucketPosAcc[len(rs.bucketPosAcc)-1])
 	}
-	rs.bucketPosAcc[int(rs.currentBucketIdx)+1] = uint64(rs.gr.Bits())
-	// clear for the next buckey
-	rs.currentBucket = rs.currentBucket[:0]
-	rs.currentBucketOffs = rs.currentBucketOffs[:0]
+	rs.bucketPosAcc[int(t.bucketIdx)+1] = uint64(rs.gr.Bits())
+	rs.bucketsBuilt = t.bucketIdx + 1
 	return nil
 }
 
+// bucketTask is a bucket of keys, split by one of the workers independently of other buckets
+type bucketTask struct {
+	bucketIdx uint64
+	keys      []uint64 // 64-bit fingerprints of keys in the bucket
+	offsets   []uint64 // Index offsets for the keys in the bucket
+	out       []uint64 // Offsets in the order they need to be written to the index
+	outFps    []uint64 // Fingerprints of keys in the same order as out - for key hashes
+	fixed     []uint64 // Pairs of (value, log2golomb) to be appended to the golomb-rice encoding
+	unary     []uint64 // Values to be appended to the golomb-rice encoding in unary
+	err       error
+}
+
+// bucketSplitter is the per-worker state of the recursive split algorithm
+type bucketSplitter struct {
+	leafSize           uint16
+	primaryAggrBound   uint16
+	secondaryAggrBound uint16
+	startSeed          []uint64
+	golombRice         []uint32
+	buffer             []uint64
+	offsetBuffer       []uint64
+	count              []uint16
+	trace              bool
+}
+
+func (s *bucketSplitter) golombParam(m uint16) int {
+	s.golombRice = growGolombRice(s.golombRice, m, s.leafSize, s.primaryAggrBound, s.secondaryAggrBound)
+	return int(s.golombRice[m] >> 27)
+}
+
+func (s *bucketSplitter) split(t *bucketTask) {
+	t.out, t.outFps, t.fixed, t.unary, t.err = t.out[:0], t.outFps[:0], t.fixed[:0], t.unary[:0], nil
+	if len(t.keys) <= 1 {
+		t.out = append(t.out, t.offsets...)
+		t.outFps = append(t.outFps, t.keys...)
+		return
+	}
+	for i, key := range t.keys[1:] {
+		if key == t.keys[i] {
+			t.err = fmt.Errorf("%w: fingerprint %x of values %d and %d in bucket %d", ErrCollision, key, t.offsets[i], t.offsets[i+1], t.bucketIdx)
+			return
+		}
+	}
+	for len(s.buffer) < len(t.keys) {
+		s.buffer = append(s.buffer, 0)
+		s.offsetBuffer = append(s.offsetBuffer, 0)
+	}
+	s.recsplit(0 /* level */, t.keys, t.offsets, t)
+}
+
 // recsplit applies recSplit algorithm to the given bucket
-func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary []uint64) ([]uint64, error) {
-	if rs.trace {
+func (s *bucketSplitter) recsplit(level int, bucket []uint64, offsets []uint64, t *bucketTask) {
+	if s.trace {
 		fmt.Printf("recsplit(%d, %d, %x)\n", level, len(bucket), bucket)
 	}
 	// Pick initial salt for this level of recursive split
-	salt := rs.startSeed[level]
+	salt := s.startSeed[level]
 	m := uint16(len(bucket))
-	if m <= rs.leafSize {
+	if m <= s.leafSize {
 		// No need to build aggregation levels - just find find bijection
 		var mask uint32
 		for {
@@ -412,24 +820,21 @@ func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary
 		}
 		for i := uint16(0); i < m; i++ {
 			j := remap16(remix(bucket[i]+salt), m)
-			rs.offsetBuffer[j] = offsets[i]
-		}
-		for _, offset := range rs.offsetBuffer[:m] {
-			binary.BigEndian.PutUint64(rs.numBuf[:], offset)
-			if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
-				return nil, err
-			}
+			s.offsetBuffer[j] = offsets[i]
+			s.buffer[j] = bucket[i]
 		}
-		salt -= rs.startSeed[level]
-		log2golomb := rs.golombParam(m)
-		if rs.trace {
-			fmt.Printf("encode bij %d with log2golomn %d at p = %d\n", salt, log2golomb, rs.gr.bitCount)
+		t.out = append(t.out, s.offsetBuffer[:m]...)
+		t.outFps = append(t.outFps, s.buffer[:m]...)
+		salt -= s.startSeed[level]
+		log2golomb := s.golombParam(m)
+		if s.trace {
+			fmt.Printf("encode bij %d with log2golomn %d\n", salt, log2golomb)
 		}
-		rs.gr.appendFixed(salt, log2golomb)
-		unary = append(unary, salt>>log2golomb)
+		t.fixed = append(t.fixed, salt, uint64(log2golomb))
+		t.unary = append(t.unary, salt>>log2golomb)
 	} else {
-		fanout, unit := splitParams(m, rs.leafSize, rs.primaryAggrBound, rs.secondaryAggrBound)
-		count := rs.count
+		fanout, unit := splitParams(m, s.leafSize, s.primaryAggrBound, s.secondaryAggrBound)
+		count := s.count
 		for {
 			for i := uint16(0); i < fanout-1; i++ {
 				count[i] = 0
@@ -452,38 +857,30 @@ func (rs *RecSplit) recsplit(level int, bucket []uint64, offsets []uint64, unary
 		}
 		for i := uint16(0); i < m; i++ {
 			j := remap16(remix(bucket[i]+salt), m) / unit
-			rs.buffer[count[j]] = bucket[i]
-			rs.offsetBuffer[count[j]] = offsets[i]
+			s.buffer[count[j]] = bucket[i]
+			s.offsetBuffer[count[j]] = offsets[i]
 			count[j]++
 		}
-		copy(bucket, rs.buffer)
-		copy(offsets, rs.offsetBuffer)
-		salt -= rs.startSeed[level]
-		log2golomb := rs.golombParam(m)
-		if rs.trace {
-			fmt.Printf("encode fanout %d: %d with log2golomn %d at p = %d\n", fanout, salt, log2golomb, rs.gr.bitCount)
+		copy(bucket, s.buffer)
+		copy(offsets, s.offsetBuffer)
+		salt -= s.startSeed[level]
+		log2golomb := s.golombParam(m)
+		if s.trace {
+			fmt.Printf("encode fanout %d: %d with log2golomn %d\n", fanout, salt, log2golomb)
 		}
-		rs.gr.appendFixed(salt, log2golomb)
-		unary = append(unary, salt>>log2golomb)
-		var err error
+		t.fixed = append(t.fixed, salt, uint64(log2golomb))
+		t.unary = append(t.unary, salt>>log2golomb)
 		var i uint16
 		for i = 0; i < m-unit; i += unit {
-			if unary, err = rs.recsplit(level+1, bucket[i:i+unit], offsets[i:i+unit], unary); err != nil {
-				return nil, err
-			}
+			s.recsplit(level+1, bucket[i:i+unit], offsets[i:i+unit], t)
 		}
 		if m-i > 1 {
-			if unary, err = rs.recsplit(level+1, bucket[i:], offsets[i:], unary); err != nil {
-				return nil, err
-			}
+			s.recsplit(level+1, bucket[i:], offsets[i:], t)
 		} else if m-i == 1 {
-			binary.BigEndian.PutUint64(rs.numBuf[:], offsets[i])
-			if _, err := rs.indexW.Write(rs.numBuf[8-rs.bytesPerRec:]); err != nil {
-				return nil, err
-			}
+			t.out = append(t.out, offsets[i])
+			t.outFps = append(t.outFps, bucket[i])
 		}
 	}
-	return unary, nil
 }
 
 // loadFuncBucket is required to satisfy the type etl.LoadFunc type, to use with collector.Load
@@ -498,8 +895,20 @@ func (rs *RecSplit) loadFuncBucket(k, v []byte, _ etl.CurrentTableReader, _ etl.
 		}
 		rs.currentBucketIdx = bucketIdx
 	}
-	rs.currentBucket = append(rs.currentBucket, binary.BigEndian.Uint64(k[8:]))
-	rs.currentBucketOffs = append(rs.currentBucketOffs, binary.BigEndian.Uint64(v))
+	fingerprint, offset := binary.BigEndian.Uint64(k[8:]), binary.BigEndian.Uint64(v)
+	if n := len(rs.currentBucket); n > 0 && rs.currentBucket[n-1] == fingerprint && rs.dupBucketKeys != nil {
+		var bucketKey [16]byte
+		copy(bucketKey[:], k)
+		if _, ok := rs.dupBucketKeys[bucketKey]; ok {
+			// duplicate key, offsets of its occurrences come in any order - keep the smallest
+			if offset < rs.currentBucketOffs[n-1] {
+				rs.currentBucketOffs[n-1] = offset
+			}
+			return nil
+		}
+	}
+	rs.currentBucket = append(rs.currentBucket, fingerprint)
+	rs.currentBucketOffs = append(rs.currentBucketOffs, offset)
 	return nil
 }
 
@@ -509,25 +918,93 @@ func (rs *RecSplit) loadFuncOffset(k, _ []byte, _ etl.CurrentTableReader, _ etl.
 	return nil
 }
 
+// writeValues writes offsets of multi-value index as two Elias Fano sequences (both start with 0):
+// cumulative number of offsets per key number, and cumulative sum of deltas between sorted offsets of every key
+// (first delta of every key is its first offset). Then i-th offset of the key is values[start+i+1]-values[start]
+func (rs *RecSplit) writeValues() error {
+	countsEf := eliasfano32.NewEliasFano(rs.keysAdded+1, rs.valuesAdded, 0)
+	valuesEf := eliasfano32.NewEliasFano(rs.valuesAdded+1, rs.valuesSum, 0)
+	countsEf.AddOffset(0)
+	valuesEf.AddOffset(0)
+	var count, sum uint64
+	defer rs.valuesCollector.Close()
+	if err := rs.valuesCollector.Iterate(func(_, v []byte) error {
+		var prev uint64
+		for i := 0; i < len(v); i += 8 {
+			offset := binary.BigEndian.Uint64(v[i:])
+			sum += offset - prev
+			prev = offset
+			valuesEf.AddOffset(sum)
+		}
+		count += uint64(len(v) / 8)
+		countsEf.AddOffset(count)
+		return nil
+	}); err != nil {
+		return err
+	}
+	countsEf.Build()
+	valuesEf.Build()
+	if err := countsEf.Write(rs.indexW); err != nil {
+		return err
+	}
+	return valuesEf.Write(rs.indexW)
+}
+
 // Build has to be called after all the keys have been added, and it initiates the process
 // of building the perfect hash function and writing index into a file
 func (rs *RecSplit) Build() error {
-	tmpIdxFilePath := rs.indexFile + ".tmp"
+	return rs.BuildTo(rs.indexFile + ".tmp")
+}
 
+// BuildTo is like Build, but writes index into tmpIdxFilePath first and atomically renames it to the index file
+// only if build succeeded. On failure tmpIdxFilePath is removed, and existing index file (if any) stays untouched.
+// tmpIdxFilePath must be on the same filesystem as the index file
+func (rs *RecSplit) BuildTo(tmpIdxFilePath string) error {
+	if err := rs.build(tmpIdxFilePath); err != nil {
+		_ = os.Remove(tmpIdxFilePath)
+		return err
+	}
+	if err := os.Rename(tmpIdxFilePath, rs.indexFile); err != nil {
+		_ = os.Remove(tmpIdxFilePath)
+		return fmt.Errorf("rename %s: %w", tmpIdxFilePath, err)
+	}
+	rs.setPhase(PhaseDone)
+	return nil
+}
+
+func (rs *RecSplit) build(tmpIdxFilePath string) error {
 	if rs.built {
 		return fmt.Errorf("already built")
 	}
 	if rs.keysAdded != rs.keyExpectedCount {
 		return fmt.Errorf("expected keys %d, got %d", rs.keyExpectedCount, rs.keysAdded)
 	}
+	if rs.dupCollector != nil {
+		rs.setPhase(PhaseDuplicates)
+	}
+	if err := rs.findDuplicates(); err != nil {
+		return err
+	}
 	var err error
 	if rs.indexF, err = os.Create(tmpIdxFilePath); err != nil {
 		return fmt.Errorf("create index file %s: %w", rs.indexFile, err)
 	}
 	defer rs.indexF.Sync()
 	defer rs.indexF.Close()
-	rs.indexW = bufio.NewWriterSize(rs.indexF, etl.BufIOSize)
+	checksum := xxhash.New()
+	rs.indexW = bufio.NewWriterSize(io.MultiWriter(rs.indexF, checksum), etl.BufIOSize)
 	defer rs.indexW.Flush()
+	// Write header
+	var header [indexHeaderSize]byte
+	copy(header[:4], indexMagic)
+	binary.BigEndian.PutUint16(header[4:], indexFormatVersion)
+	binary.BigEndian.PutUint16(header[6:], uint16(rs.bucketSize))
+	binary.BigEndian.PutUint16(header[8:], rs.leafSize)
+	header[10] = byte(rs.valueWidth)
+	binary.BigEndian.PutUint32(header[12:], rs.salt)
+	if _, err = rs.indexW.Write(header[:]); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
 	// Write minimal app-specific dataID in this index file
 	binary.BigEndian.PutUint64(rs.numBuf[:], rs.baseDataID)
 	if _, err = rs.indexW.Write(rs.numBuf[:]); err != nil {
@@ -541,10 +1018,30 @@ func (rs *RecSplit) Build() error {
 	}
 	// Write number of bytes per index record
 	rs.bytesPerRec = (bits.Len64(rs.maxOffset) + 7) / 8
+	if (rs.ordered || rs.multiValue) && rs.keysAdded > 0 && bits.Len64(rs.keysAdded-1) > bits.Len64(rs.maxOffset) {
+		// records store ordinals (or key numbers) instead of offsets
+		rs.bytesPerRec = (bits.Len64(rs.keysAdded-1) + 7) / 8
+	}
+	if rs.valueWidth != 0 {
+		if rs.bytesPerRec > rs.valueWidth {
+			return fmt.Errorf("values need %d bytes, more than value width %d", rs.bytesPerRec, rs.valueWidth)
+		}
+		rs.bytesPerRec = rs.valueWidth
+	}
 	if err = rs.indexW.WriteByte(byte(rs.bytesPerRec)); err != nil {
 		return fmt.Errorf("write bytes per record: %w", err)
 	}
 
+	rs.existenceKeys = rs.existenceKeys[:0]
+	if rs.keyHashes {
+		if rs.keyHashesF, err = ioutil.TempFile(rs.tmpDir, "erigon-recsplit-keyhashes-"); err != nil {
+			return fmt.Errorf("create key hashes file: %w", err)
+		}
+		defer rs.closeKeyHashesFile()
+		rs.keyHashesW = bufio.NewWriterSize(rs.keyHashesF, etl.BufIOSize)
+	}
+
+	rs.setPhase(PhaseBuckets)
 	rs.currentBucketIdx = math.MaxUint64 // To make sure 0 bucket is detected
 	defer rs.bucketCollector.Close()
 	if err := rs.bucketCollector.Load(nil, "", rs.loadFuncBucket, etl.TransformArgs{}); err != nil {
@@ -555,17 +1052,21 @@ func (rs *RecSplit) Build() error {
 			return err
 		}
 	}
+	if err := rs.splitBatch(); err != nil {
+		return err
+	}
 
 	if ASSERT {
 		rs.indexW.Flush()
 		rs.indexF.Seek(0, 0)
 		b, _ := ioutil.ReadAll(rs.indexF)
-		if len(b) != 9+int(rs.keysAdded)*rs.bytesPerRec {
-			panic(fmt.Errorf("expected: %d, got: %d; rs.keysAdded=%d, rs.bytesPerRec=%d, %s", 9+int(rs.keysAdded)*rs.bytesPerRec, len(b), rs.keysAdded, rs.bytesPerRec, rs.indexFile))
+		if len(b) != indexHeaderSize+17+int(rs.keysAdded)*rs.bytesPerRec {
+			panic(fmt.Errorf("expected: %d, got: %d; rs.keysAdded=%d, rs.bytesPerRec=%d, %s", indexHeaderSize+17+int(rs.keysAdded)*rs.bytesPerRec, len(b), rs.keysAdded, rs.bytesPerRec, rs.indexFile))
 		}
 	}
 
 	if rs.enums {
+		rs.setPhase(PhaseOffsets)
 		rs.offsetEf = eliasfano32.NewEliasFano(rs.keysAdded, rs.maxOffset, rs.minDelta)
 		defer rs.offsetCollector.Close()
 		if err := rs.offsetCollector.Load(nil, "", rs.loadFuncOffset, etl.TransformArgs{}); err != nil {
@@ -573,6 +1074,7 @@ func (rs *RecSplit) Build() error {
 		}
 		rs.offsetEf.Build()
 	}
+	rs.setPhase(PhaseWrite)
 	rs.gr.appendFixed(1, 1) // Sentinel (avoids checking for parts of size 1)
 	// Construct Elias Fano index
 	rs.ef.Build(rs.bucketSizeAcc, rs.bucketPosAcc)
@@ -605,14 +1107,24 @@ func (rs *RecSplit) Build() error {
 			return fmt.Errorf("writing start seed: %w", err)
 		}
 	}
+	var features byte
 	if rs.enums {
-		if err := rs.indexW.WriteByte(1); err != nil {
-			return fmt.Errorf("writing enums = true: %w", err)
-		}
-	} else {
-		if err := rs.indexW.WriteByte(0); err != nil {
-			return fmt.Errorf("writing enums = true: %w", err)
-		}
+		features |= featureEnums
+	}
+	if rs.ordered {
+		features |= featureOrderedKeys
+	}
+	if rs.existence {
+		features |= featureExistence
+	}
+	if rs.multiValue {
+		features |= featureMultiValue
+	}
+	if rs.keyHashes {
+		features |= featureKeyHashes
+	}
+	if err := rs.indexW.WriteByte(features); err != nil {
+		return fmt.Errorf("writing features: %w", err)
 	}
 	if rs.enums {
 		// Write out elias fano for offsets
@@ -620,6 +1132,39 @@ func (rs *RecSplit) Build() error {
 			return fmt.Errorf("writing elias fano for offsets: %w", err)
 		}
 	}
+	if rs.multiValue {
+		if err := rs.writeValues(); err != nil {
+			return fmt.Errorf("writing multi-value offsets: %w", err)
+		}
+	}
+	if rs.ordered {
+		// Write out "ordinal -> offset" table, with the same number of bytes per record as the main table
+		defer rs.offsetCollector.Close()
+		if err := rs.offsetCollector.Iterate(func(_, v []byte) error {
+			_, err := rs.indexW.Write(v[8-rs.bytesPerRec:])
+			return err
+		}); err != nil {
+			return fmt.Errorf("writing ordinal to offset table: %w", err)
+		}
+	}
+	if rs.existence {
+		// Write out existence filter
+		if err := writeExistenceFilter(rs.indexW, rs.existenceKeys); err != nil {
+			return fmt.Errorf("writing existence filter: %w", err)
+		}
+	}
+	if rs.keyHashes {
+		// Write out key hashes, 8 bytes per record
+		if err := rs.keyHashesW.Flush(); err != nil {
+			return fmt.Errorf("flush key hashes: %w", err)
+		}
+		if _, err := rs.keyHashesF.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seek key hashes: %w", err)
+		}
+		if _, err := io.Copy(rs.indexW, rs.keyHashesF); err != nil {
+			return fmt.Errorf("writing key hashes: %w", err)
+		}
+	}
 	// Write out the size of golomb rice params
 	binary.BigEndian.PutUint16(rs.numBuf[:], uint16(len(rs.golombRice)))
 	if _, err := rs.indexW.Write(rs.numBuf[:4]); err != nil {
@@ -634,15 +1179,44 @@ func (rs *RecSplit) Build() error {
 		return fmt.Errorf("writing elias fano: %w", err)
 	}
 
-	_ = rs.indexW.Flush()
-	_ = rs.indexF.Sync()
-	_ = rs.indexF.Close()
-	if err := os.Rename(tmpIdxFilePath, rs.indexFile); err != nil {
+	if err := rs.indexW.Flush(); err != nil {
 		return err
 	}
+	// Write out checksum of the whole file, it is not part of the checksum itself
+	binary.BigEndian.PutUint64(rs.numBuf[:], checksum.Sum64())
+	if _, err := rs.indexF.Write(rs.numBuf[:]); err != nil {
+		return fmt.Errorf("writing checksum: %w", err)
+	}
+	if err := rs.indexF.Sync(); err != nil {
+		return fmt.Errorf("sync index file: %w", err)
+	}
 	return nil
 }
 
+// Rebuild builds index args.IndexFile from the keys added by addKeys, next to the existing index file,
+// and replaces it only on success - so readers never see half-written index. addKeys is called again
+// with the next salt if collision is detected
+func Rebuild(args RecSplitArgs, addKeys func(rs *RecSplit) error) error {
+	rs, err := NewRecSplit(args)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	for {
+		if err = addKeys(rs); err != nil {
+			return err
+		}
+		if err = rs.Build(); err != nil {
+			if rs.Collision() {
+				rs.ResetNextSalt()
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+}
+
 // Stats returns the size of golomb rice encoding and ellias fano encoding
 func (rs RecSplit) Stats() (int, int) {
 	return len(rs.gr.Data()), len(rs.ef.Data())
@@ -17,9 +17,17 @@
 package recsplit
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/common"
 )
 
 func TestRecSplit2(t *testing.T) {
@@ -180,3 +188,648 @@ func TestTwoLayerIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestOrderedKeysIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:   100,
+		BucketSize: 10,
+		Salt:       0,
+		TmpDir:     tmpDir,
+		IndexFile:  indexFile,
+		LeafSize:   8,
+		StartSeed: []uint64{0x106393c187cae21a, 0x6453cec3f7376937, 0x643e521ddbd2be98, 0x3740c6412f6572cb, 0x717d47562f1ce470, 0x4cd6eb4c63befb7c, 0x9bfd8c5e18c8da73,
+			0x082f20e10092a9a3, 0x2ada2ce68d21defc, 0xe33cb4f3e7c6466b, 0x3980be458c509c59, 0xc466fd9584828e8c, 0x45f0aabe1a61ede6, 0xf6e7b8b33ad9b98d,
+			0x4ef95e25f4b4983d, 0x81175195173b92d3, 0x4e50927d8dd15978, 0x1ea2099d1fafae7f, 0x425c8a06fbaaa815, 0xcd4216006c74052a},
+		OrderedKeys: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		// offsets are not in the same order as keys
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %03d", i)), uint64((99-i)*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = rs.AddKey([]byte("key 000"), 0); err == nil {
+		t.Errorf("test is expected to fail, key is out of order")
+	}
+	if err := rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	reader := NewIndexReader(idx)
+	for i := 0; i < 100; i++ {
+		ordinal := reader.LookupOrdinal([]byte(fmt.Sprintf("key %03d", i)))
+		if ordinal != uint64(i) {
+			t.Errorf("expected ordinal: %d, looked up: %d", i, ordinal)
+		}
+		offset := idx.OrdinalLookup(ordinal)
+		if offset != uint64((99-i)*17) {
+			t.Errorf("expected offset: %d, looked up: %d", (99-i)*17, offset)
+		}
+	}
+}
+
+func buildTestIndex(tb testing.TB, indexFile string, keyCount int, workers int) {
+	tb.Helper()
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:   keyCount,
+		BucketSize: 2000,
+		Salt:       1,
+		TmpDir:     filepath.Dir(indexFile),
+		IndexFile:  indexFile,
+		LeafSize:   8,
+		Workers:    workers,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer rs.Close()
+	rs.NoLogs(true)
+	for i := 0; i < keyCount; i++ {
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := rs.Build(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestWorkersDeterministic(t *testing.T) {
+	tmpDir := t.TempDir()
+	buildTestIndex(t, filepath.Join(tmpDir, "index1"), 10_000, 1)
+	buildTestIndex(t, filepath.Join(tmpDir, "index4"), 10_000, 4)
+	data1, err := os.ReadFile(filepath.Join(tmpDir, "index1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data4, err := os.ReadFile(filepath.Join(tmpDir, "index4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data1, data4) {
+		t.Errorf("index built by 4 workers differs from the index built by 1 worker")
+	}
+}
+
+// BenchmarkBuild compares build time with different number of workers,
+// on large builds (100M keys) splitting of buckets dominates the build time
+func BenchmarkBuild(b *testing.B) {
+	for _, workers := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			tmpDir := b.TempDir()
+			for i := 0; i < b.N; i++ {
+				buildTestIndex(b, filepath.Join(tmpDir, "index"), 1_000_000, workers)
+			}
+		})
+	}
+}
+
+func BenchmarkIndexReaderLookupParallel(b *testing.B) {
+	indexFile := filepath.Join(b.TempDir(), "index")
+	buildTestIndex(b, indexFile, 100_000, 1)
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	reader := NewIndexReader(idx)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			reader.Lookup([]byte(fmt.Sprintf("key %d", i%100_000)))
+			i++
+		}
+	})
+}
+
+func TestExistenceFilter(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:        100,
+		BucketSize:      10,
+		Salt:            0,
+		TmpDir:          tmpDir,
+		IndexFile:       indexFile,
+		LeafSize:        8,
+		ExistenceFilter: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	reader := NewIndexReader(idx)
+	for i := 0; i < 100; i++ {
+		offset, ok := reader.LookupExisting([]byte(fmt.Sprintf("key %d", i)))
+		if !ok {
+			t.Errorf("expected key %d to exist", i)
+		}
+		if offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+	var falsePositives int
+	for i := 100; i < 10100; i++ {
+		if reader.Has([]byte(fmt.Sprintf("key %d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 { // expected ~40 of 10000
+		t.Errorf("too many false positives: %d", falsePositives)
+	}
+	// filter of single key
+	indexFile = filepath.Join(tmpDir, "index1")
+	rs, err = NewRecSplit(RecSplitArgs{KeyCount: 1, BucketSize: 10, TmpDir: tmpDir, IndexFile: indexFile, LeafSize: 8, ExistenceFilter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rs.AddKey([]byte("key 0"), 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+	idx1 := MustOpen(indexFile)
+	defer idx1.Close()
+	if !NewIndexReader(idx1).Has([]byte("key 0")) {
+		t.Errorf("expected key 0 to exist")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, enums := range []bool{false, true} {
+		indexFile := filepath.Join(tmpDir, fmt.Sprintf("index-%t", enums))
+		rs, err := NewRecSplit(RecSplitArgs{
+			KeyCount:   100,
+			BucketSize: 10,
+			Salt:       0,
+			TmpDir:     tmpDir,
+			IndexFile:  indexFile,
+			LeafSize:   8,
+			Enums:      enums,
+			KeyHashes:  true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = rs.Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := MustOpen(indexFile)
+		if !idx.HasKeyHashes() {
+			t.Fatal("expected index with key hashes")
+		}
+		keys := func(from, to int, offset func(i int) uint64) KeysSource {
+			return func(walker func(key []byte, offset uint64) error) error {
+				for i := from; i < to; i++ {
+					if err := walker([]byte(fmt.Sprintf("key %d", i)), offset(i)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+		report, err := idx.Audit(keys(0, 100, func(i int) uint64 { return uint64(i * 17) }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Ok() || report.Keys != 100 {
+			t.Errorf("enums=%t: expected clean audit, got %s", enums, report)
+		}
+		// Keys 0..9 are missing from the source, keys 100..104 were never added, key 50 has wrong offset
+		report, err = idx.Audit(keys(10, 105, func(i int) uint64 {
+			if i == 50 {
+				return 1
+			}
+			return uint64(i * 17)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Keys != 95 || report.Unknown != 5 || report.Mismatched != 1 || report.Uncovered != 10 || report.Duplicates != 0 {
+			t.Errorf("enums=%t: unexpected audit report %s", enums, report)
+		}
+		if len(report.Mismatches) != 6 {
+			t.Fatalf("enums=%t: expected 6 mismatches, got %d", enums, len(report.Mismatches))
+		}
+		if m := report.Mismatches[0]; string(m.Key) != "key 50" || m.Expected != 1 || m.Got != 50*17 {
+			t.Errorf("enums=%t: unexpected mismatch %s %d %d", enums, m.Key, m.Expected, m.Got)
+		}
+		idx.Close()
+	}
+
+	indexFile := filepath.Join(tmpDir, "index")
+	buildTestIndex(t, indexFile, 100, 1)
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	if _, err := idx.Audit(func(func([]byte, uint64) error) error { return nil }); err == nil {
+		t.Fatal("expected error for index without key hashes")
+	}
+}
+
+func TestIndexCorrupted(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	buildTestIndex(t, indexFile, 1000, 1)
+	idx, err := OpenIndex(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version() != indexFormatVersion {
+		t.Errorf("expected version: %d, got: %d", indexFormatVersion, idx.Version())
+	}
+	idx.Close()
+
+	data, err := os.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flipped bit is detected by Verify
+	corrupted := common.Copy(data)
+	corrupted[len(corrupted)/2] ^= 1
+	if err = os.WriteFile(indexFile, corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if idx, err = OpenIndex(indexFile); err == nil {
+		err = idx.Verify()
+		idx.Close()
+	}
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, got: %v", err)
+	}
+	if _, err = OpenIndexWithOpts(indexFile, IndexOpts{Verify: true}); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, got: %v", err)
+	}
+	// truncated file is detected by OpenIndex
+	for _, size := range []int{10, 100, len(data) / 2, len(data) - 100, len(data) - 30, len(data) - 1} {
+		if err = os.WriteFile(indexFile, data[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = OpenIndex(indexFile); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("truncated to %d: expected ErrCorrupted, got: %v", size, err)
+		}
+	}
+}
+
+func TestIndexWarmUp(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	buildTestIndex(t, indexFile, 10_000, 1)
+
+	idx, err := OpenIndexWithOpts(indexFile, IndexOpts{Madvise: MadviseWillNeed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if err = idx.WarmUp(); err != nil {
+		t.Fatal(err)
+	}
+	reader := NewIndexReader(idx)
+	for i := 0; i < 10_000; i++ {
+		if offset := reader.Lookup([]byte(fmt.Sprintf("key %d", i))); offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	args := RecSplitArgs{
+		KeyCount:   100,
+		BucketSize: 10,
+		Salt:       0,
+		TmpDir:     tmpDir,
+		IndexFile:  indexFile,
+		LeafSize:   8,
+	}
+	addKeys := func(mul int) func(rs *RecSplit) error {
+		return func(rs *RecSplit) error {
+			for i := 0; i < 100; i++ {
+				if err := rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*mul)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if err := Rebuild(args, addKeys(17)); err != nil {
+		t.Fatal(err)
+	}
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+
+	// Interrupted rebuild leaves existing index untouched
+	errInterrupted := errors.New("interrupted")
+	if err := Rebuild(args, func(rs *RecSplit) error { return errInterrupted }); !errors.Is(err, errInterrupted) {
+		t.Fatalf("expected interrupted, got: %v", err)
+	}
+	// Failed build leaves existing index untouched too
+	if err := Rebuild(args, func(rs *RecSplit) error { return rs.AddKey([]byte("key"), 0) }); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(indexFile + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be removed, got: %v", err)
+	}
+	reader := NewIndexReader(idx)
+	for i := 0; i < 100; i++ {
+		if offset := reader.Lookup([]byte(fmt.Sprintf("key %d", i))); offset != uint64(i*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*17, offset)
+		}
+	}
+
+	if err := Rebuild(args, addKeys(19)); err != nil {
+		t.Fatal(err)
+	}
+	idx2 := MustOpen(indexFile)
+	defer idx2.Close()
+	reader2 := NewIndexReader(idx2)
+	for i := 0; i < 100; i++ {
+		if offset := reader2.Lookup([]byte(fmt.Sprintf("key %d", i))); offset != uint64(i*19) {
+			t.Errorf("expected offset: %d, looked up: %d", i*19, offset)
+		}
+	}
+	// Already opened index still reads old file
+	if offset := reader.Lookup([]byte("key 1")); offset != 17 {
+		t.Errorf("expected offset: %d, looked up: %d", 17, offset)
+	}
+}
+
+func TestMultiValueIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:   1000,
+		BucketSize: 100,
+		Salt:       0,
+		TmpDir:     tmpDir,
+		IndexFile:  indexFile,
+		LeafSize:   8,
+		MultiValue: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := func(i int) []uint64 {
+		res := make([]uint64, i%5+1)
+		for j := range res {
+			res[j] = uint64(i*100 + (len(res)-j)*7) // in descending order, index must sort them
+		}
+		return res
+	}
+	for i := 0; i < 1000; i++ {
+		if err = rs.AddKeyMulti([]byte(fmt.Sprintf("key %d", i)), offsets(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	if !idx.MultiValue() {
+		t.Fatal("expected multi-value index")
+	}
+	reader := NewIndexReader(idx)
+	for i := 0; i < 1000; i++ {
+		expected := offsets(i)
+		it := reader.LookupMulti([]byte(fmt.Sprintf("key %d", i)))
+		if it.Count() != len(expected) {
+			t.Fatalf("key %d: expected %d offsets, got %d", i, len(expected), it.Count())
+		}
+		for j := len(expected) - 1; it.HasNext(); j-- {
+			if offset := it.Next(); offset != expected[j] {
+				t.Errorf("key %d: expected offset: %d, looked up: %d", i, expected[j], offset)
+			}
+		}
+	}
+}
+
+func TestLookupMany(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	buildTestIndex(t, indexFile, 10_000, 1)
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	reader := NewIndexReader(idx)
+
+	keys := make([][]byte, 1000) // Not multiple of the batch size
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key %d", i*7))
+	}
+	out := make([]uint64, len(keys))
+	reader.LookupMany(keys, out)
+	for i := range keys {
+		if out[i] != uint64(i*7*17) {
+			t.Errorf("expected offset: %d, looked up: %d", i*7*17, out[i])
+		}
+	}
+}
+
+func TestValueWidth(t *testing.T) {
+	tmpDir := t.TempDir()
+	build := func(valueWidth int, maxValue uint64) (string, error) {
+		indexFile := filepath.Join(tmpDir, fmt.Sprintf("index-%d-%d", valueWidth, maxValue))
+		rs, err := NewRecSplit(RecSplitArgs{
+			KeyCount:   100,
+			BucketSize: 10,
+			Salt:       0,
+			TmpDir:     tmpDir,
+			IndexFile:  indexFile,
+			LeafSize:   8,
+			StartSeed: []uint64{0x106393c187cae21a, 0x6453cec3f7376937, 0x643e521ddbd2be98, 0x3740c6412f6572cb, 0x717d47562f1ce470, 0x4cd6eb4c63befb7c, 0x9bfd8c5e18c8da73,
+				0x082f20e10092a9a3, 0x2ada2ce68d21defc, 0xe33cb4f3e7c6466b, 0x3980be458c509c59, 0xc466fd9584828e8c, 0x45f0aabe1a61ede6, 0xf6e7b8b33ad9b98d,
+				0x4ef95e25f4b4983d, 0x81175195173b92d3, 0x4e50927d8dd15978, 0x1ea2099d1fafae7f, 0x425c8a06fbaaa815, 0xcd4216006c74052a},
+			ValueWidth: valueWidth,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer rs.Close()
+		for i := uint64(0); i < 100; i++ {
+			if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), maxValue-i); err != nil {
+				return "", err
+			}
+		}
+		return indexFile, rs.Build()
+	}
+	if _, err := build(3, 99); err == nil {
+		t.Fatal("expected error for value width 3")
+	}
+	if _, err := build(4, 1<<32); err == nil {
+		t.Fatal("expected error for values not fitting into value width")
+	}
+	indexFile, err := build(4, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	if idx.ValueWidth() != 4 || idx.bytesPerRec != 4 {
+		t.Fatalf("expected value width 4, got %d, records of %d bytes", idx.ValueWidth(), idx.bytesPerRec)
+	}
+	reader, err := NewUint32Reader(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 100; i++ {
+		if v := reader.Lookup([]byte(fmt.Sprintf("key %d", i))); v != uint32(99-i) {
+			t.Errorf("expected value: %d, looked up: %d", 99-i, v)
+		}
+	}
+
+	if indexFile, err = build(0, 99); err != nil {
+		t.Fatal(err)
+	}
+	idx2 := MustOpen(indexFile)
+	defer idx2.Close()
+	if idx2.ValueWidth() != 0 || idx2.bytesPerRec != 1 {
+		t.Fatalf("expected minimal records, got value width %d, records of %d bytes", idx2.ValueWidth(), idx2.bytesPerRec)
+	}
+	if _, err = NewUint32Reader(idx2); err == nil {
+		t.Fatal("expected error for index without fixed value width")
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexFile := filepath.Join(tmpDir, "index")
+	args := RecSplitArgs{
+		KeyCount:         103,
+		BucketSize:       10,
+		TmpDir:           tmpDir,
+		IndexFile:        indexFile,
+		LeafSize:         8,
+		DetectDuplicates: true,
+	}
+	// keys 5 and 7 are added again with other offsets, key 5 - twice
+	addKeys := func(rs *RecSplit) error {
+		for i := 0; i < 100; i++ {
+			if err := rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17+1000)); err != nil {
+				return err
+			}
+		}
+		for _, kv := range [][2]int{{5, 3}, {7, 2000}, {5, 4}} {
+			if err := rs.AddKey([]byte(fmt.Sprintf("key %d", kv[0])), uint64(kv[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Rebuild doesn't retry with another salt
+	err := Rebuild(args, addKeys)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected duplicate key error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "3 duplicates") || !strings.Contains(err.Error(), "offsets 1119 and 2000") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	args.DedupKeys = true
+	if err = Rebuild(args, addKeys); err != nil {
+		t.Fatal(err)
+	}
+	idx := MustOpen(indexFile)
+	defer idx.Close()
+	if idx.keyCount != 100 {
+		t.Errorf("expected 100 keys, got %d", idx.keyCount)
+	}
+	reader := NewIndexReader(idx)
+	for i := 0; i < 100; i++ {
+		expected := uint64(i*17 + 1000)
+		if i == 5 {
+			expected = 3 // the smallest offset is kept
+		}
+		if offset := reader.Lookup([]byte(fmt.Sprintf("key %d", i))); offset != expected {
+			t.Errorf("key %d: expected offset: %d, looked up: %d", i, expected, offset)
+		}
+	}
+
+	args.OrderedKeys = true
+	if _, err = NewRecSplit(args); err == nil {
+		t.Fatal("expected error for dedup of ordered keys")
+	}
+}
+
+func TestBuildProgress(t *testing.T) {
+	tmpDir := t.TempDir()
+	var reports []Progress
+	rs, err := NewRecSplit(RecSplitArgs{
+		KeyCount:         5000,
+		BucketSize:       100,
+		TmpDir:           tmpDir,
+		IndexFile:        filepath.Join(tmpDir, "index"),
+		LeafSize:         8,
+		Enums:            true,
+		DetectDuplicates: true,
+		Progress:         func(p Progress) { reports = append(reports, p) },
+		ProgressEvery:    time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	rs.NoLogs(true)
+	for i := 0; i < 5000; i++ {
+		if err = rs.AddKey([]byte(fmt.Sprintf("key %d", i)), uint64(i*17)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	var phases []BuildPhase
+	var keysAdded, bucketsBuilt uint64
+	for _, p := range reports {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+		if p.KeysAdded < keysAdded || p.BucketsBuilt < bucketsBuilt || p.BucketsBuilt > p.BucketCount {
+			t.Fatalf("progress goes back: %s", p)
+		}
+		keysAdded, bucketsBuilt = p.KeysAdded, p.BucketsBuilt
+	}
+	expected := []BuildPhase{PhaseAddKeys, PhaseDuplicates, PhaseBuckets, PhaseOffsets, PhaseWrite, PhaseDone}
+	if fmt.Sprint(phases) != fmt.Sprint(expected) {
+		t.Fatalf("expected phases %v, got %v", expected, phases)
+	}
+	if reports[0].KeysAdded != 1024 {
+		t.Errorf("expected first report after 1024 keys, got %s", reports[0])
+	}
+	last := reports[len(reports)-1]
+	if last.KeysAdded != 5000 || last.BucketsBuilt != 50 || last.BucketCount != 50 {
+		t.Errorf("unexpected final progress: %s", last)
+	}
+	if !strings.HasPrefix(last.String(), "phase=done keys=5000/5000 buckets=50/50") {
+		t.Errorf("unexpected summary: %s", last)
+	}
+
+	p := Progress{Phase: PhaseBuckets, BucketsBuilt: 25, BucketCount: 100, PhaseElapsed: 10 * time.Second}
+	if p.ETA() != 30*time.Second || p.Percent() != 25 {
+		t.Errorf("unexpected eta %s and percent %f", p.ETA(), p.Percent())
+	}
+}
@@ -234,6 +234,9 @@ func (b RlpEncodedBytes) RawBytes() []byte {
 }
 
 func (b RlpEncodedBytes) DoubleRLPLen() int {
+	if len(b) == 1 && b[0] >= 0x80 {
+		return 2 // single byte which is not a string itself, ToDoubleRLP writes prefix
+	}
 	return generateRlpPrefixLen(len(b)) + len(b)
 }
 
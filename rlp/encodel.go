@@ -24,6 +24,7 @@ import (
 // General design:
 //      - rlp package doesn't manage memory - and Caller must ensure buffers are big enough.
 //      - no io.Writer, because it's incompatible with binary.BigEndian functions and Writer can't be used as temporary buffer
+//        (exception: ListWriter - for streaming of payloads which are too large to build in memory)
 //
 // Composition:
 //     - each Encode method does write to given buffer and return written len
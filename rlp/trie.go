@@ -0,0 +1,133 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rlp
+
+import (
+	"io"
+	"math/bits"
+)
+
+// Encoding of Merkle Patricia trie nodes, shared by trie implementations. Keys are hex: one nibble per byte,
+// leaf keys end with terminator 16. Writers stream node into w: keccak state - to get hash of the node,
+// or buffer - for nodes shorter than 32 bytes, which are embedded into parent instead of referenced by hash
+
+const hexTerminator = 16
+
+// compactKey - hex-prefix encoding of key: first byte (flags and odd nibble), position in key of the nibble
+// which goes into second byte, length of encoding
+func compactKey(key []byte) (compact0 byte, ni, compactLen int) {
+	nibbles := len(key)
+	if nibbles > 0 && key[nibbles-1] == hexTerminator {
+		nibbles--
+		compact0 = 0x20
+	}
+	compactLen = nibbles/2 + 1
+	if nibbles&1 == 1 {
+		compact0 |= 0x10 + key[0]
+		ni = 1
+	}
+	return compact0, ni, compactLen
+}
+
+// compactKeyLen - length of RLP string of hex-prefix encoded key, single byte of encoding is a string itself
+func compactKeyLen(compactLen int) int {
+	if compactLen > 1 {
+		return 1 + compactLen
+	}
+	return 1
+}
+
+// putCompactKey - RLP string of hex-prefix encoded key, returns amount of written bytes (at most 34)
+func putCompactKey(to []byte, key []byte) int {
+	compact0, ni, compactLen := compactKey(key)
+	pos := 0
+	if compactLen > 1 {
+		to[pos] = 0x80 + byte(compactLen)
+		pos++
+	}
+	to[pos] = compact0
+	pos++
+	for i := 1; i < compactLen; i++ {
+		to[pos] = key[ni]*16 + key[ni+1]
+		pos++
+		ni += 2
+	}
+	return pos
+}
+
+// LeafLen - length of leaf node with key of keyLen nibbles (terminator included), nodes shorter than 32 bytes
+// are embedded into parent
+func LeafLen(keyLen int, val RlpSerializable) int {
+	l := compactKeyLen((keyLen-1)/2+1) + val.DoubleRLPLen()
+	return ListPrefixLen(l) + l
+}
+
+// LeafHash - writes leaf node [compact(key), val] into w
+func LeafHash(w io.Writer, key []byte, val RlpSerializable) error {
+	_, _, compactLen := compactKey(key)
+	var header [4 + 34]byte
+	pt := GenerateStructLen(header[:], compactKeyLen(compactLen)+val.DoubleRLPLen())
+	n := pt + putCompactKey(header[pt:], key)
+	if _, err := w.Write(header[:n]); err != nil {
+		return err
+	}
+	var prefixBuf [8]byte
+	return val.ToDoubleRLP(w, prefixBuf[:])
+}
+
+// ExtensionHash - writes extension node [compact(key), hash] into w, hash is 32 bytes reference to the child branch
+func ExtensionHash(w io.Writer, key []byte, hash []byte) error {
+	_, _, compactLen := compactKey(key)
+	var header [4 + 34 + 1]byte
+	pt := GenerateStructLen(header[:], compactKeyLen(compactLen)+1+len(hash))
+	n := pt + putCompactKey(header[pt:], key)
+	header[n] = 0x80 + byte(len(hash))
+	if _, err := w.Write(header[:n+1]); err != nil {
+		return err
+	}
+	_, err := w.Write(hash)
+	return err
+}
+
+// BranchHash - writes branch node of 16 children and empty value into w. bitmap - children which are present,
+// their references (hash with 0xa0 prefix, or embedded node) are refsLen bytes in total and are given by child
+// in nibble order. Absent children are empty strings
+func BranchHash(w io.Writer, bitmap uint16, refsLen int, child func(nibble int) ([]byte, error)) error {
+	var prefix [4]byte
+	pt := GenerateStructLen(prefix[:], refsLen+17-bits.OnesCount16(bitmap))
+	if _, err := w.Write(prefix[:pt]); err != nil {
+		return err
+	}
+	empty := []byte{0x80}
+	for nibble := 0; nibble < 16; nibble++ {
+		if bitmap&(uint16(1)<<nibble) == 0 {
+			if _, err := w.Write(empty); err != nil {
+				return err
+			}
+			continue
+		}
+		ref, err := child(nibble)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(ref); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(empty)
+	return err
+}
@@ -0,0 +1,175 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rlp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
+)
+
+// hexToCompact - hex-prefix encoding as in go-ethereum trie/encoding.go
+func hexToCompact(hex []byte) []byte {
+	terminator := byte(0)
+	if len(hex) > 0 && hex[len(hex)-1] == 16 {
+		terminator = 1
+		hex = hex[:len(hex)-1]
+	}
+	buf := make([]byte, len(hex)/2+1)
+	buf[0] = terminator << 5 // the flag byte
+	if len(hex)&1 == 1 {
+		buf[0] |= 1 << 4 // odd flag
+		buf[0] |= hex[0] // first nibble is contained in the first byte
+		hex = hex[1:]
+	}
+	for bi, ni := 1, 0; ni < len(hex); bi, ni = bi+1, ni+2 {
+		buf[bi] = hex[ni]<<4 | hex[ni+1]
+	}
+	return buf
+}
+
+// encodeString, encodeList - reference encoding of go-ethereum nodes, by RLP spec: shortNode is [compact(key), val],
+// fullNode is 17 items, child references are hashes or embedded nodes
+func encodeString(s []byte) []byte {
+	if len(s) == 1 && s[0] < 0x80 {
+		return []byte{s[0]}
+	}
+	return append(encodeLen(0x80, len(s)), s...)
+}
+
+func encodeList(items ...[]byte) []byte {
+	payload := bytes.Join(items, nil)
+	return append(encodeLen(0xc0, len(payload)), payload...)
+}
+
+func encodeLen(offset byte, l int) []byte {
+	if l < 56 {
+		return []byte{offset + byte(l)}
+	}
+	var be []byte
+	for ; l > 0; l >>= 8 {
+		be = append([]byte{byte(l)}, be...)
+	}
+	return append([]byte{offset + 55 + byte(len(be))}, be...)
+}
+
+func randomKey(rnd *rand.Rand, nibbles int, leaf bool) []byte {
+	key := make([]byte, nibbles)
+	for i := range key {
+		key[i] = byte(rnd.Intn(16))
+	}
+	if leaf {
+		key = append(key, 16)
+	}
+	return key
+}
+
+func TestLeafHash(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	values := [][]byte{{0x01}, {0x7f}, {0x80}, {0xff}, bytes.Repeat([]byte{0xab}, 54), bytes.Repeat([]byte{0xab}, 55),
+		bytes.Repeat([]byte{0xab}, 56), bytes.Repeat([]byte{0xab}, 300), bytes.Repeat([]byte{0xab}, 70000)}
+	for nibbles := 0; nibbles <= 64; nibbles++ {
+		for _, leaf := range []bool{true, false} {
+			key := randomKey(rnd, nibbles, leaf)
+			for _, v := range values {
+				// RlpEncodedBytes - value is encoded once, RlpSerializableBytes - value is rlp of string and encoded again
+				for _, tc := range []struct {
+					val      RlpSerializable
+					expected []byte
+				}{
+					{RlpEncodedBytes(v), encodeList(encodeString(hexToCompact(key)), encodeString(v))},
+					{RlpSerializableBytes(v), encodeList(encodeString(hexToCompact(key)), encodeString(encodeString(v)))},
+				} {
+					var buf bytes.Buffer
+					require.NoError(t, LeafHash(&buf, key, tc.val))
+					require.Equal(t, tc.expected, buf.Bytes(), "key %x, value %x", key, v)
+					if leaf {
+						require.Equal(t, len(tc.expected), LeafLen(len(key), tc.val), "key %x, value %x", key, v)
+					}
+				}
+			}
+		}
+	}
+
+	// go-ethereum trie with single key "A"
+	h := sha3.NewLegacyKeccak256()
+	require.NoError(t, LeafHash(h, []byte{4, 1, 16}, RlpEncodedBytes(bytes.Repeat([]byte("a"), 50))))
+	require.Equal(t, "d23786fb4a010da3ce639d66d5e904a11dbc02746d1ce25029e53290cabf28ab", hex.EncodeToString(h.Sum(nil)))
+}
+
+func TestExtensionHash(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	hash := make([]byte, 32)
+	for nibbles := 1; nibbles <= 64; nibbles++ {
+		key := randomKey(rnd, nibbles, false)
+		rnd.Read(hash)
+		var buf bytes.Buffer
+		require.NoError(t, ExtensionHash(&buf, key, hash))
+		require.Equal(t, encodeList(encodeString(hexToCompact(key)), encodeString(hash)), buf.Bytes(), "key %x", key)
+	}
+}
+
+func TestBranchHash(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	for _, bitmap := range []uint16{0, 1, 0x8000, 0x8001, 0xffff, 0x5555} {
+		for i := 0; i < 8; i++ {
+			refs := make([][]byte, 16)
+			items := make([][]byte, 17)
+			var refsLen int
+			for nibble := 0; nibble < 16; nibble++ {
+				if bitmap&(1<<nibble) == 0 {
+					items[nibble] = encodeString(nil)
+					continue
+				}
+				if rnd.Intn(2) == 0 {
+					hash := make([]byte, 32)
+					rnd.Read(hash)
+					refs[nibble] = encodeString(hash)
+				} else { // embedded leaf
+					refs[nibble] = encodeList(encodeString(hexToCompact(randomKey(rnd, rnd.Intn(8), true))), encodeString([]byte{byte(nibble)}))
+				}
+				items[nibble] = refs[nibble]
+				refsLen += len(refs[nibble])
+			}
+			items[16] = encodeString(nil)
+			var buf bytes.Buffer
+			var requested []int
+			require.NoError(t, BranchHash(&buf, bitmap, refsLen, func(nibble int) ([]byte, error) {
+				requested = append(requested, nibble)
+				return refs[nibble], nil
+			}))
+			require.Equal(t, encodeList(items...), buf.Bytes(), "bitmap %016b", bitmap)
+			require.Len(t, requested, bitsSet(bitmap))
+		}
+	}
+
+	errChild := errors.New("child")
+	err := BranchHash(&bytes.Buffer{}, 1, 33, func(int) ([]byte, error) { return nil, errChild })
+	require.ErrorIs(t, err, errChild)
+}
+
+func bitsSet(bitmap uint16) (n int) {
+	for ; bitmap != 0; bitmap &= bitmap - 1 {
+		n++
+	}
+	return n
+}
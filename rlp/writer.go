@@ -0,0 +1,102 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rlp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+var ErrListLen = fmt.Errorf("%w encode: list length mismatch", ErrBase)
+
+// ListWriter - streams RLP list to io.Writer, for payloads which are too large to build in memory.
+// Two-pass: caller calculates length of list payload first (length funcs are cheap: ListPrefixLen, StringLen, U64Len, ...),
+// then ListWriter writes list prefix and items one by one - only prefixes are buffered, items are written as is.
+// Nested lists are written by ListPrefix + their items.
+type ListWriter struct {
+	w       io.Writer
+	dataLen int
+	written int
+	prefix  [10]byte // EncodeListPrefix needs 10 bytes
+}
+
+// NewListWriter - writes prefix of list with payload of dataLen bytes
+func NewListWriter(w io.Writer, dataLen int) (*ListWriter, error) {
+	lw := &ListWriter{w: w, dataLen: dataLen}
+	n := EncodeListPrefix(dataLen, lw.prefix[:])
+	if _, err := w.Write(lw.prefix[:n]); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+func (lw *ListWriter) write(b []byte) error {
+	if lw.written+len(b) > lw.dataLen {
+		return fmt.Errorf("%w: declared %d, writing %d", ErrListLen, lw.dataLen, lw.written+len(b))
+	}
+	n, err := lw.w.Write(b)
+	lw.written += n
+	return err
+}
+
+// WriteRaw - writes already RLP-encoded item
+func (lw *ListWriter) WriteRaw(item []byte) error { return lw.write(item) }
+
+// WriteString - writes s as RLP string, without copying it
+func (lw *ListWriter) WriteString(s []byte) error {
+	switch {
+	case len(s) == 1 && s[0] < 128:
+		return lw.write(s)
+	case len(s) < 56:
+		lw.prefix[0] = 128 + byte(len(s))
+		if err := lw.write(lw.prefix[:1]); err != nil {
+			return err
+		}
+	default:
+		beLen := (bits.Len(uint(len(s))) + 7) / 8
+		binary.BigEndian.PutUint64(lw.prefix[1:], uint64(len(s)))
+		lw.prefix[8-beLen] = 183 + byte(beLen)
+		if err := lw.write(lw.prefix[8-beLen : 9]); err != nil {
+			return err
+		}
+	}
+	return lw.write(s)
+}
+
+func (lw *ListWriter) WriteU64(i uint64) error {
+	n := EncodeU64(i, lw.prefix[:])
+	return lw.write(lw.prefix[:n])
+}
+
+// ListPrefix - starts nested list with payload of dataLen bytes, its items must follow
+func (lw *ListWriter) ListPrefix(dataLen int) error {
+	n := EncodeListPrefix(dataLen, lw.prefix[:])
+	return lw.write(lw.prefix[:n])
+}
+
+// Written - amount of bytes of list payload written so far
+func (lw *ListWriter) Written() int { return lw.written }
+
+// Close - checks that exactly declared amount of payload was written. Doesn't close underlying writer.
+func (lw *ListWriter) Close() error {
+	if lw.written != lw.dataLen {
+		return fmt.Errorf("%w: declared %d, written %d", ErrListLen, lw.dataLen, lw.written)
+	}
+	return nil
+}
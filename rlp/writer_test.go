@@ -0,0 +1,62 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rlp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListWriter(t *testing.T) {
+	long := bytes.Repeat([]byte{0xab}, 300)
+	dataLen := U64Len(1024) + ListPrefixLen(1+4) + 1 + 4 + StringLen(len(long))
+
+	var buf bytes.Buffer
+	lw, err := NewListWriter(&buf, dataLen)
+	require.NoError(t, err)
+	require.NoError(t, lw.WriteU64(1024))
+	require.NoError(t, lw.ListPrefix(1+4))
+	require.NoError(t, lw.WriteString([]byte{7}))
+	require.NoError(t, lw.WriteString([]byte("dog")))
+	require.NoError(t, lw.WriteString(long))
+	require.NoError(t, lw.Close())
+
+	payload := buf.Bytes()
+	pos, l, err := List(payload, 0)
+	require.NoError(t, err)
+	require.Equal(t, dataLen, l)
+	pos, i, err := U64(payload, pos)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1024), i)
+	pos, l, err = List(payload, pos)
+	require.NoError(t, err)
+	require.Equal(t, 5, l)
+	require.Equal(t, []byte{7, 0x83, 'd', 'o', 'g'}, payload[pos:pos+l])
+	pos, l, err = String(payload, pos+l)
+	require.NoError(t, err)
+	require.Equal(t, long, payload[pos:pos+l])
+	require.Equal(t, len(payload), pos+l)
+
+	lw, err = NewListWriter(&buf, 2)
+	require.NoError(t, err)
+	require.NoError(t, lw.WriteU64(1))
+	require.True(t, errors.Is(lw.Close(), ErrListLen))
+	require.True(t, errors.Is(lw.WriteU64(1024), ErrListLen))
+}
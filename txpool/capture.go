@@ -0,0 +1,226 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/log/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// Capture - sequence of records: kind (1 byte), unix time in nanoseconds when record was written (8 bytes, big-endian),
+// payload length (4 bytes, big-endian), payload.
+// Written by Fetch.SetCapture on live node, read by Replay
+const (
+	CaptureStateChange byte = iota + 1 // protobuf of remote.StateChangeBatch
+	CaptureMessage                     // protobuf of sentry.InboundMessage (with PeerId), all messages received by Fetch
+	CaptureComposition                 // PoolComposition after the preceding state change batch, see PoolComposition.Encode
+)
+
+const (
+	captureHeadLen   = 1 + 8 + 4
+	captureMaxRecord = 256 * 1024 * 1024
+)
+
+// CaptureWriter - appends records to capture, safe for concurrent use. Every record is written by one Write call
+type CaptureWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+	buf  []byte
+}
+
+func NewCaptureWriter(w io.Writer) *CaptureWriter { return &CaptureWriter{w: w} }
+
+func (c *CaptureWriter) write(kind byte, payload []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var head [captureHeadLen]byte
+	head[0] = kind
+	binary.BigEndian.PutUint64(head[1:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(head[9:], uint32(len(payload)))
+	c.buf = append(append(c.buf[:0], head[:]...), payload...)
+	_, err := c.w.Write(c.buf)
+	return err
+}
+
+func (c *CaptureWriter) StateChange(batch *remote.StateChangeBatch) error {
+	payload, err := proto.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	return c.write(CaptureStateChange, payload)
+}
+
+func (c *CaptureWriter) Message(msg *sentry.InboundMessage) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.write(CaptureMessage, payload)
+}
+
+func (c *CaptureWriter) Composition(comp *PoolComposition) error {
+	return c.write(CaptureComposition, comp.Encode())
+}
+
+// isReplayableMessage - only messages which bring transactions can be replayed, announcements and requests need peers
+func isReplayableMessage(id sentry.MessageId) bool {
+	return id == sentry.MessageId_TRANSACTIONS_66 || id == sentry.MessageId_POOLED_TRANSACTIONS_66
+}
+
+const captureFilePrefix, captureFileExt = "txpool-capture-", ".dat"
+
+// CaptureFiles - io.Writer into dir, which starts new file when current one exceeds maxSize and keeps at most
+// maxFiles newest files (0 - keeps all). Data of one Write always goes into one file, so CaptureWriter records
+// are never split between files
+type CaptureFiles struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+	seq  int // distinguishes files created within same clock tick
+}
+
+func OpenCaptureFiles(dir string, maxSize int64, maxFiles int) (*CaptureFiles, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("capture dir: %w", err)
+	}
+	c := &CaptureFiles{dir: dir, maxSize: maxSize, maxFiles: maxFiles}
+	if err := c.rotate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CaptureFiles) Write(p []byte) (int, error) {
+	if c.size > 0 && c.size+int64(len(p)) > c.maxSize {
+		if err := c.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.f.Write(p)
+	c.size += int64(n)
+	return n, err
+}
+
+func (c *CaptureFiles) rotate() error {
+	if c.f != nil {
+		if err := c.f.Close(); err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+	}
+	// names sort in order of creation
+	name := filepath.Join(c.dir, fmt.Sprintf("%s%020d-%06d%s", captureFilePrefix, time.Now().UnixNano(), c.seq%1_000_000, captureFileExt))
+	c.seq++
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("capture: %w", err)
+	}
+	c.f, c.size = f, 0
+	if c.maxFiles <= 0 {
+		return nil
+	}
+	files, err := CaptureFileNames(c.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > c.maxFiles {
+		if err = os.Remove(files[0]); err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+		log.Debug("[txpool] removed old capture file", "file", files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+func (c *CaptureFiles) Close() error {
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f = nil
+	return err
+}
+
+// CaptureFileNames - capture files in dir, oldest first
+func CaptureFileNames(dir string) ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("capture dir: %w", err)
+	}
+	var files []string
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() && strings.HasPrefix(fileInfo.Name(), captureFilePrefix) && strings.HasSuffix(fileInfo.Name(), captureFileExt) {
+			files = append(files, filepath.Join(dir, fileInfo.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// OpenCaptureDir - all capture files of dir as one stream for Replay, oldest first
+func OpenCaptureDir(dir string) (io.ReadCloser, error) {
+	files, err := CaptureFileNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	mr := &multiFileReader{}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			mr.Close()
+			return nil, fmt.Errorf("capture: %w", err)
+		}
+		mr.files = append(mr.files, f)
+	}
+	readers := make([]io.Reader, len(mr.files))
+	for i, f := range mr.files {
+		readers[i] = f
+	}
+	mr.Reader = io.MultiReader(readers...)
+	return mr, nil
+}
+
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+func (r *multiFileReader) Close() error {
+	var firstErr error
+	for _, f := range r.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+)
+
+// chainTrackerDepth - how many recent block hashes are remembered, deeper reorgs can't be checked
+const chainTrackerDepth = 128
+
+// chainTracker - recent canonical blocks, as seen in applied state change batches.
+// Protects OnNewBlock from batches which arrived out of order or were built on top of another chain:
+// StateChange of UNWIND direction carries height and hash of the block chain was unwound to,
+// FORWARD - height and hash of the new block, and hash of its parent if sender sets it.
+// Hashes are optional: checks which need absent hash are skipped
+type chainTracker struct {
+	applied bool                // whether any batch was applied
+	viewID  uint64              // DatabaseViewID of the last applied batch
+	tip     uint64              // height of the last applied block
+	hashes  map[uint64][32]byte // height -> hash, for heights within chainTrackerDepth from tip
+}
+
+func newChainTracker() *chainTracker {
+	return &chainTracker{hashes: map[uint64][32]byte{}}
+}
+
+// check - returns error if the batch is stale and must not be applied, doesn't change the tracker
+func (ct *chainTracker) check(batch *remote.StateChangeBatch) error {
+	if !ct.applied {
+		return nil // nothing seen yet, any batch is fine
+	}
+	if batch.DatabaseViewID < ct.viewID {
+		return fmt.Errorf("batch of view %d is older than applied view %d", batch.DatabaseViewID, ct.viewID)
+	}
+	// replay the batch on top of known part of the chain
+	tip := ct.tip
+	replacedFrom := uint64(math.MaxUint64) // known hashes from this height are replaced by FORWARD changes of the batch
+	changed := false                       // batch brings blocks which are not in the applied chain
+	var last [32]byte                      // hash of the block at tip, once batch moved it
+	moved, hasLast := false, false
+	for _, change := range batch.ChangeBatch {
+		hash, hasHash := h256ToHash(change.BlockHash)
+		known, ok := ct.hashes[change.BlockHeight]
+		if change.Direction != remote.Direction_UNWIND && (!ok || !hasHash || known != hash) {
+			changed = true
+		}
+		ok = ok && hasHash && change.BlockHeight < replacedFrom
+		switch change.Direction {
+		case remote.Direction_UNWIND:
+			if change.BlockHeight > tip {
+				return fmt.Errorf("unwind to %d, above tip %d", change.BlockHeight, tip)
+			}
+			if ok && known != hash {
+				return fmt.Errorf("unwind to unknown block %d %x, known %x", change.BlockHeight, hash, known)
+			}
+		default:
+			if ok && known == hash && change.BlockHeight <= tip {
+				return fmt.Errorf("block %d %x is already applied", change.BlockHeight, hash)
+			}
+			if parentHash, hasParent := h256ToHash(change.ParentHash); hasParent && change.BlockHeight > 0 {
+				parent, ok := ct.hashes[change.BlockHeight-1]
+				ok = ok && change.BlockHeight-1 < replacedFrom
+				if moved && tip == change.BlockHeight-1 {
+					parent, ok = last, hasLast
+				}
+				if ok && parent != parentHash {
+					return fmt.Errorf("block %d %x is child of %x, not of known %x", change.BlockHeight, hash, parentHash, parent)
+				}
+			}
+			if change.BlockHeight < replacedFrom {
+				replacedFrom = change.BlockHeight
+			}
+		}
+		tip, last, moved, hasLast = change.BlockHeight, hash, true, hasHash
+	}
+	if !changed && tip == ct.tip {
+		return fmt.Errorf("batch doesn't change applied chain, tip %d", tip)
+	}
+	return nil
+}
+
+// apply - remembers blocks of the batch, must be called for every applied batch
+func (ct *chainTracker) apply(batch *remote.StateChangeBatch) {
+	ct.applied, ct.viewID = true, batch.DatabaseViewID
+	for _, change := range batch.ChangeBatch {
+		for h := range ct.hashes {
+			if h > change.BlockHeight {
+				delete(ct.hashes, h) // blocks above are unwound or replaced by the new block
+			}
+		}
+		ct.tip = change.BlockHeight
+		if hash, ok := h256ToHash(change.BlockHash); ok {
+			ct.hashes[ct.tip] = hash
+		} else {
+			delete(ct.hashes, ct.tip)
+		}
+	}
+	for h := range ct.hashes {
+		if h+chainTrackerDepth < ct.tip {
+			delete(ct.hashes, h)
+		}
+	}
+}
+
+// h256ToHash - converts optional hash of state change, false if it's not set
+func h256ToHash(h *types.H256) ([32]byte, bool) {
+	if h == nil || h.Hi == nil || h.Lo == nil {
+		return [32]byte{}, false
+	}
+	return gointerfaces.ConvertH256ToHash(h), true
+}
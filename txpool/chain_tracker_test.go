@@ -0,0 +1,132 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainTrackerReorgs(t *testing.T) {
+	fwd := func(height uint64, fork byte) *remote.StateChange {
+		return &remote.StateChange{BlockHeight: height, BlockHash: gointerfaces.ConvertHashToH256([32]byte{fork, byte(height)})}
+	}
+	unwind := func(height uint64, fork byte) *remote.StateChange {
+		sc := fwd(height, fork)
+		sc.Direction = remote.Direction_UNWIND
+		return sc
+	}
+	batch := func(viewID uint64, changes ...*remote.StateChange) *remote.StateChangeBatch {
+		return &remote.StateChangeBatch{DatabaseViewID: viewID, ChangeBatch: changes}
+	}
+	ct := newChainTracker()
+	for _, tc := range []struct {
+		name  string
+		batch *remote.StateChangeBatch
+		stale bool
+	}{
+		{"first batch", batch(1, fwd(10, 'a')), false},
+		{"next block", batch(2, fwd(11, 'a')), false},
+		{"same block again", batch(3, fwd(11, 'a')), true},
+		{"reorg of 1 block", batch(3, unwind(10, 'a'), fwd(11, 'b')), false},
+		{"older view", batch(2, fwd(12, 'a')), true},
+		{"reorg back", batch(4, unwind(10, 'a'), fwd(11, 'a'), fwd(12, 'a')), false},
+		{"unwind to block of other fork", batch(5, unwind(11, 'b'), fwd(12, 'b')), true},
+		{"unwind above tip", batch(5, unwind(13, 'a')), true},
+		{"reorg of 2 blocks", batch(5, unwind(10, 'a'), fwd(11, 'c'), fwd(12, 'c')), false},
+		{"replayed reorg", batch(5, unwind(10, 'a'), fwd(11, 'c'), fwd(12, 'c')), true},
+		{"duplicate block", batch(6, fwd(12, 'c')), true},
+		{"new block without unwind", batch(6, fwd(12, 'd')), false},
+		{"next block of new fork", batch(7, fwd(13, 'd')), false},
+	} {
+		err := ct.check(tc.batch)
+		assert.Equal(t, tc.stale, err != nil, "%s: %v", tc.name, err)
+		if err == nil {
+			ct.apply(tc.batch)
+		}
+	}
+	assert.Equal(t, uint64(13), ct.tip)
+}
+
+func TestChainTrackerParentHash(t *testing.T) {
+	child := func(height uint64, fork, parentFork byte) *remote.StateChange {
+		return &remote.StateChange{BlockHeight: height,
+			BlockHash:  gointerfaces.ConvertHashToH256([32]byte{fork, byte(height)}),
+			ParentHash: gointerfaces.ConvertHashToH256([32]byte{parentFork, byte(height - 1)}),
+		}
+	}
+	unwind := func(height uint64, fork byte) *remote.StateChange {
+		return &remote.StateChange{Direction: remote.Direction_UNWIND, BlockHeight: height, BlockHash: gointerfaces.ConvertHashToH256([32]byte{fork, byte(height)})}
+	}
+	batch := func(viewID uint64, changes ...*remote.StateChange) *remote.StateChangeBatch {
+		return &remote.StateChangeBatch{DatabaseViewID: viewID, ChangeBatch: changes}
+	}
+	ct := newChainTracker()
+	for _, tc := range []struct {
+		name  string
+		batch *remote.StateChangeBatch
+		stale bool
+	}{
+		{"first batch", batch(1, child(10, 'a', 'a'), child(11, 'a', 'a')), false},
+		{"child of unknown parent", batch(2, child(12, 'a', 'x')), true},
+		{"child of tip", batch(2, child(12, 'a', 'a')), false},
+		{"sibling without unwind", batch(3, child(12, 'b', 'a')), false},
+		{"child of replaced block", batch(4, child(13, 'a', 'a')), true},
+		{"gap in batch", batch(4, child(13, 'b', 'b'), child(14, 'b', 'c')), true},
+		{"reorg", batch(4, unwind(11, 'a'), child(12, 'c', 'a'), child(13, 'c', 'c')), false},
+		{"child of unwound block", batch(5, unwind(11, 'a'), child(12, 'd', 'b')), true},
+	} {
+		err := ct.check(tc.batch)
+		assert.Equal(t, tc.stale, err != nil, "%s: %v", tc.name, err)
+		if err == nil {
+			ct.apply(tc.batch)
+		}
+	}
+	assert.Equal(t, uint64(13), ct.tip)
+}
+
+func TestChainTrackerWithoutHashes(t *testing.T) {
+	fwd := func(height uint64) *remote.StateChange {
+		return &remote.StateChange{BlockHeight: height}
+	}
+	batch := func(viewID uint64, changes ...*remote.StateChange) *remote.StateChangeBatch {
+		return &remote.StateChangeBatch{DatabaseViewID: viewID, ChangeBatch: changes}
+	}
+	ct := newChainTracker()
+	for _, tc := range []struct {
+		name  string
+		batch *remote.StateChangeBatch
+		stale bool
+	}{
+		{"first batch", batch(1, fwd(10)), false},
+		{"next block", batch(2, fwd(11)), false},
+		{"older view", batch(1, fwd(12)), true},
+		{"unwind", batch(3, &remote.StateChange{Direction: remote.Direction_UNWIND, BlockHeight: 10}, fwd(11)), false},
+		{"unwind above tip", batch(4, &remote.StateChange{Direction: remote.Direction_UNWIND, BlockHeight: 12}), true},
+		{"block with hash", batch(4, &remote.StateChange{BlockHeight: 12, BlockHash: gointerfaces.ConvertHashToH256([32]byte{12}), ParentHash: gointerfaces.ConvertHashToH256([32]byte{11})}), false},
+	} {
+		err := ct.check(tc.batch)
+		assert.Equal(t, tc.stale, err != nil, "%s: %v", tc.name, err)
+		if err == nil {
+			ct.apply(tc.batch)
+		}
+	}
+	assert.Equal(t, uint64(12), ct.tip)
+}
@@ -18,6 +18,7 @@ package txpool
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -25,10 +26,12 @@ import (
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/direct"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/remotedbserver"
 	"github.com/ledgerwatch/erigon-lib/rlp"
 	"github.com/ledgerwatch/log/v3"
 	"google.golang.org/grpc"
@@ -52,8 +55,14 @@ type Fetch struct {
 	stateChangesParseCtxLock sync.Mutex
 	pooledTxsParseCtx        *TxParseContext
 	pooledTxsParseCtxLock    sync.Mutex
+	inFlight                 *inFlightRequests // requested hashes, to not request same tx from every peer which announced it
+	capture                  *CaptureWriter    // nil - capture is off
 }
 
+// softResponseLimit - target size of POOLED_TRANSACTIONS_66 reply, as recommended by eth protocol spec.
+// Reply can get larger than this by size of one transaction
+const softResponseLimit = 2 * 1024 * 1024
+
 type StateChangesClient interface {
 	StateChanges(ctx context.Context, in *remote.StateChangeRequest, opts ...grpc.CallOption) (remote.KV_StateChangesClient, error)
 }
@@ -71,8 +80,10 @@ func NewFetch(ctx context.Context, sentryClients []direct.SentryClient, pool Poo
 		stateChangesClient:   stateChangesClient,
 		stateChangesParseCtx: NewTxParseContext(chainID), //TODO: change ctx if rules changed
 		pooledTxsParseCtx:    NewTxParseContext(chainID),
+		inFlight:             newInFlightRequests(inFlightTTL),
 	}
 	f.pooledTxsParseCtx.ValidateRLP(f.pool.ValidateSerializedTxn)
+	f.pooledTxsParseCtx.WithBorrow(true) // data of gRPC messages is never reused
 	f.stateChangesParseCtx.ValidateRLP(f.pool.ValidateSerializedTxn)
 
 	return f
@@ -82,6 +93,12 @@ func (f *Fetch) SetWaitGroup(wg *sync.WaitGroup) {
 	f.wg = wg
 }
 
+// SetCapture - records received state change batches, sentry messages and pool composition after every batch,
+// for Replay. Must be called before Connect. To write into rotating files: NewCaptureWriter(OpenCaptureFiles(...))
+func (f *Fetch) SetCapture(c *CaptureWriter) {
+	f.capture = c
+}
+
 func (f *Fetch) threadSafeParsePooledTxn(cb func(*TxParseContext) error) error {
 	f.pooledTxsParseCtxLock.Lock()
 	defer f.pooledTxsParseCtxLock.Unlock()
@@ -107,48 +124,50 @@ func (f *Fetch) ConnectSentries() {
 }
 func (f *Fetch) ConnectCore() {
 	go func() {
-		for {
-			select {
-			case <-f.ctx.Done():
-				return
-			default:
-			}
-			if err := f.handleStateChanges(f.ctx, f.stateChangesClient); err != nil {
-				if grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err) {
-					time.Sleep(3 * time.Second)
-					continue
-				}
-				log.Warn("[txpool.handleStateChanges]", "err", err)
-			}
+		if err := f.ensureCoreVersion(); err != nil {
+			log.Error("[txpool] can't subscribe to state changes of core", "err", err)
+			return
 		}
+		_ = grpcutil.RetryLoop(f.ctx, grpcutil.DefaultBackoff, func(ctx context.Context) error {
+			return f.handleStateChanges(ctx, f.stateChangesClient)
+		}, func(err error) {
+			log.Warn("[txpool.handleStateChanges]", "err", err)
+		})
 	}()
 }
 
-func (f *Fetch) receiveMessageLoop(sentryClient sentry.SentryClient) {
-	for {
-		select {
-		case <-f.ctx.Done():
-			return
-		default:
-		}
-		if _, err := sentryClient.HandShake(f.ctx, &emptypb.Empty{}, grpc.WaitForReady(true)); err != nil {
-			if grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err) {
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			// Report error and wait more
-			log.Warn("[txpool.recvMessage] sentry not ready yet", "err", err)
-			continue
+// ensureCoreVersion - fails fast if core serves incompatible version of KV interface. Checks nothing if
+// stateChangesClient has no Version method
+func (f *Fetch) ensureCoreVersion() error {
+	client, ok := f.stateChangesClient.(gointerfaces.VersionClient)
+	if !ok {
+		return nil
+	}
+	expected := gointerfaces.VersionFromProto(remotedbserver.KvServiceAPIVersion)
+	err := grpcutil.Retry(f.ctx, grpcutil.DefaultBackoff, func(ctx context.Context) error {
+		_, err := gointerfaces.NegotiateVersion(ctx, client, expected, gointerfaces.NewerMinor)
+		if errors.Is(err, gointerfaces.ErrIncompatibleVersion) {
+			return grpcutil.Permanent(err)
 		}
+		return err
+	}, func(err error) {
+		log.Warn("[txpool.ensureCoreVersion]", "err", err)
+	})
+	if err != nil && f.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
 
-		if err := f.receiveMessage(f.ctx, sentryClient); err != nil {
-			if grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err) {
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			log.Warn("[txpool.recvMessage]", "err", err)
+func (f *Fetch) receiveMessageLoop(sentryClient sentry.SentryClient) {
+	_ = grpcutil.RetryLoop(f.ctx, grpcutil.DefaultBackoff, func(ctx context.Context) error {
+		if _, err := sentryClient.HandShake(ctx, &emptypb.Empty{}, grpc.WaitForReady(true)); err != nil {
+			return fmt.Errorf("sentry not ready yet: %w", err)
 		}
-	}
+		return f.receiveMessage(ctx, sentryClient)
+	}, func(err error) {
+		log.Warn("[txpool.recvMessage]", "err", err)
+	})
 }
 
 func (f *Fetch) receiveMessage(ctx context.Context, sentryClient sentry.SentryClient) error {
@@ -182,6 +201,11 @@ func (f *Fetch) receiveMessage(ctx context.Context, sentryClient sentry.SentryCl
 		if req == nil {
 			return nil
 		}
+		if f.capture != nil {
+			if err := f.capture.Message(req); err != nil {
+				log.Warn("[txpool.fetch] capture", "err", err)
+			}
+		}
 		if err := f.handleInboundMessage(streamCtx, req, sentryClient); err != nil {
 			if grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err) {
 				time.Sleep(3 * time.Second)
@@ -237,6 +261,7 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 				unknownHashes = append(unknownHashes, hashbuf[:]...)
 			}
 		}
+		unknownHashes = f.inFlight.filter(unknownHashes, time.Now(), unknownHashes[:0])
 		if len(unknownHashes) > 0 {
 			var encodedRequest []byte
 			var messageId sentry.MessageId
@@ -269,7 +294,8 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 			}
 			_ = requestID
 			var txs [][]byte
-			for i := 0; i < len(hashes); i += 32 {
+			var size int
+			for i := 0; i < len(hashes) && size < softResponseLimit; i += 32 {
 				txn, err := f.pool.GetRlp(tx, hashes[i:i+32])
 				if err != nil {
 					return err
@@ -278,6 +304,7 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 					continue
 				}
 				txs = append(txs, txn)
+				size += len(txn)
 			}
 
 			encodedRequest = EncodePooledTransactions66(txs, requestID, nil)
@@ -334,6 +361,9 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 		if len(txs.txs) == 0 {
 			return nil
 		}
+		for _, txn := range txs.txs {
+			f.inFlight.received(txn.IdHash[:])
+		}
 		f.pool.AddRemoteTxs(ctx, txs)
 	default:
 		defer log.Trace("[txpool] dropped p2p message", "id", req.Id)
@@ -343,31 +373,14 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 }
 
 func (f *Fetch) receivePeerLoop(sentryClient sentry.SentryClient) {
-	for {
-		select {
-		case <-f.ctx.Done():
-			return
-		default:
-		}
-		if _, err := sentryClient.HandShake(f.ctx, &emptypb.Empty{}, grpc.WaitForReady(true)); err != nil {
-			if grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err) {
-				time.Sleep(3 * time.Second)
-				continue
-			}
-			// Report error and wait more
-			log.Warn("[txpool.recvPeers] sentry not ready yet", "err", err)
-			time.Sleep(time.Second)
-			continue
+	_ = grpcutil.RetryLoop(f.ctx, grpcutil.DefaultBackoff, func(ctx context.Context) error {
+		if _, err := sentryClient.HandShake(ctx, &emptypb.Empty{}, grpc.WaitForReady(true)); err != nil {
+			return fmt.Errorf("sentry not ready yet: %w", err)
 		}
-		if err := f.receivePeer(sentryClient); err != nil {
-			if grpcutil.IsRetryLater(err) || grpcutil.IsEndOfStream(err) {
-				time.Sleep(3 * time.Second)
-				continue
-			}
-
-			log.Warn("[txpool.recvPeers]", "err", err)
-		}
-	}
+		return f.receivePeer(sentryClient)
+	}, func(err error) {
+		log.Warn("[txpool.recvPeers]", "err", err)
+	})
 }
 
 func (f *Fetch) receivePeer(sentryClient sentry.SentryClient) error {
@@ -428,42 +441,60 @@ func (f *Fetch) handleStateChanges(ctx context.Context, client StateChangesClien
 			return nil
 		}
 
-		var unwindTxs, minedTxs TxSlots
-		for _, change := range req.ChangeBatch {
-			if change.Direction == remote.Direction_FORWARD {
-				minedTxs.Resize(uint(len(change.Txs)))
-				for i := range change.Txs {
-					minedTxs.txs[i] = &TxSlot{}
-					if err = f.threadSafeParseStateChangeTxn(func(parseContext *TxParseContext) error {
-						_, err := parseContext.ParseTransaction(change.Txs[i], 0, minedTxs.txs[i], minedTxs.senders.At(i), true /* hasEnvelope */)
-						return err
-					}); err != nil {
-						log.Warn("stream.Recv", "err", err)
-						continue
-					}
+		if err := f.onStateChange(ctx, req); err != nil {
+			log.Warn("onNewBlock", "err", err)
+		}
+		if f.wg != nil {
+			f.wg.Done()
+		}
+	}
+}
+
+// onStateChange - parses mined and unwound transactions of the batch and applies it to the pool
+func (f *Fetch) onStateChange(ctx context.Context, req *remote.StateChangeBatch) (err error) {
+	if f.capture != nil {
+		if err := f.capture.StateChange(req); err != nil {
+			log.Warn("[txpool.fetch] capture", "err", err)
+		}
+	}
+	var unwindTxs, minedTxs TxSlots
+	for _, change := range req.ChangeBatch {
+		if change.Direction == remote.Direction_FORWARD {
+			minedTxs.Resize(uint(len(change.Txs)))
+			for i := range change.Txs {
+				minedTxs.txs[i] = &TxSlot{}
+				if err = f.threadSafeParseStateChangeTxn(func(parseContext *TxParseContext) error {
+					_, err := parseContext.ParseTransaction(change.Txs[i], 0, minedTxs.txs[i], minedTxs.senders.At(i), true /* hasEnvelope */)
+					return err
+				}); err != nil {
+					log.Warn("stream.Recv", "err", err)
+					continue
 				}
 			}
-			if change.Direction == remote.Direction_UNWIND {
-				unwindTxs.Resize(uint(len(change.Txs)))
-				for i := range change.Txs {
-					unwindTxs.txs[i] = &TxSlot{}
-					if err = f.threadSafeParseStateChangeTxn(func(parseContext *TxParseContext) error {
-						_, err = parseContext.ParseTransaction(change.Txs[i], 0, unwindTxs.txs[i], unwindTxs.senders.At(i), true /* hasEnvelope */)
-						return err
-					}); err != nil {
-						log.Warn("stream.Recv", "err", err)
-						continue
-					}
+		}
+		if change.Direction == remote.Direction_UNWIND {
+			unwindTxs.Resize(uint(len(change.Txs)))
+			for i := range change.Txs {
+				unwindTxs.txs[i] = &TxSlot{}
+				if err = f.threadSafeParseStateChangeTxn(func(parseContext *TxParseContext) error {
+					_, err = parseContext.ParseTransaction(change.Txs[i], 0, unwindTxs.txs[i], unwindTxs.senders.At(i), true /* hasEnvelope */)
+					return err
+				}); err != nil {
+					log.Warn("stream.Recv", "err", err)
+					continue
 				}
 			}
 		}
-		if err := f.db.View(ctx, func(tx kv.Tx) error {
-			return f.pool.OnNewBlock(ctx, req, unwindTxs, minedTxs, tx)
-		}); err != nil {
-			log.Warn("onNewBlock", "err", err)
-		}
-		if f.wg != nil {
-			f.wg.Done()
+	}
+	if err := f.db.View(ctx, func(tx kv.Tx) error {
+		return f.pool.OnNewBlock(ctx, req, unwindTxs, minedTxs, tx)
+	}); err != nil {
+		return err
+	}
+	if c, ok := f.pool.(interface{ Composition() *PoolComposition }); ok && f.capture != nil {
+		if err := f.capture.Composition(c.Composition()); err != nil {
+			log.Warn("[txpool.fetch] capture", "err", err)
 		}
 	}
+	return nil
 }
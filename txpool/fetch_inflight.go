@@ -0,0 +1,76 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// inFlightTTL - hash may be requested again after this time: asked peer may be slow, or may not have the transaction
+const inFlightTTL = 5 * time.Second
+
+var duplicateFetchesAvoided = metrics.GetOrCreateCounter(`pool_fetch_duplicates_avoided`)
+
+// inFlightRequests - hashes requested by GET_POOLED_TRANSACTIONS_66 and not received yet. When many peers announce
+// same new transaction - it's requested only from first of them
+type inFlightRequests struct {
+	lock        sync.Mutex
+	ttl         time.Duration
+	requests    map[string]time.Time // hash -> when it was requested
+	lastCleanup time.Time
+}
+
+func newInFlightRequests(ttl time.Duration) *inFlightRequests {
+	return &inFlightRequests{ttl: ttl, requests: map[string]time.Time{}}
+}
+
+// filter - leaves only hashes which are not requested yet or which requests expired, and marks them as requested.
+// Result is appended to buf
+func (r *inFlightRequests) filter(hashes Hashes, now time.Time, buf Hashes) Hashes {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if now.Sub(r.lastCleanup) > r.ttl {
+		for hash, requested := range r.requests {
+			if now.Sub(requested) > r.ttl {
+				delete(r.requests, hash)
+			}
+		}
+		r.lastCleanup = now
+	}
+	avoided := 0
+	for i := 0; i < hashes.Len(); i++ {
+		hash := hashes.At(i)
+		if requested, ok := r.requests[string(hash)]; ok && now.Sub(requested) <= r.ttl {
+			avoided++
+			continue
+		}
+		r.requests[string(hash)] = now
+		buf = append(buf, hash...)
+	}
+	duplicateFetchesAvoided.Add(avoided)
+	return buf
+}
+
+// received - transaction arrived, it can be requested again if pool drops it
+func (r *inFlightRequests) received(hash []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.requests, string(hash))
+}
@@ -22,6 +22,7 @@ import (
 	"io"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/direct"
@@ -29,6 +30,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -176,3 +178,89 @@ func TestOnNewBlock(t *testing.T) {
 	assert.Equal(t, 1, len(pool.OnNewBlockCalls()))
 	assert.Equal(t, 3, len(pool.OnNewBlockCalls()[0].MinedTxs.txs))
 }
+
+func TestInFlightRequests(t *testing.T) {
+	r := newInFlightRequests(inFlightTTL)
+	h1, h2 := make([]byte, 32), make([]byte, 32)
+	h1[0], h2[0] = 1, 2
+	now := time.Now()
+
+	assert.Equal(t, 2, r.filter(toHashes(h1[0], h2[0]), now, nil).Len())
+	assert.Equal(t, 0, r.filter(toHashes(h1[0], h2[0]), now.Add(time.Second), nil).Len(), "already requested")
+
+	r.received(h1)
+	res := r.filter(toHashes(h1[0], h2[0]), now.Add(2*time.Second), nil)
+	require.Equal(t, 1, res.Len())
+	assert.Equal(t, h1, []byte(res.At(0)), "received hash may be requested again")
+
+	res = r.filter(toHashes(h1[0], h2[0]), now.Add(inFlightTTL+time.Second), nil)
+	require.Equal(t, 1, res.Len())
+	assert.Equal(t, h2, []byte(res.At(0)), "expired request may be repeated")
+}
+
+func TestPooledTransactionsReplySizeLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMockSentry(ctx)
+	m.SendMessageByIdFunc = func(ctx context.Context, req *sentry.SendMessageByIdRequest) (*sentry.SentPeers, error) {
+		return &sentry.SentPeers{}, nil
+	}
+	sentryClient := direct.NewSentryClientDirect(direct.ETH66, m)
+	bigRlp := make([]byte, 600*1024)
+	pool := &PoolMock{
+		StartedFunc: func() bool { return true },
+		GetRlpFunc:  func(tx kv.Tx, hash []byte) ([]byte, error) { return bigRlp, nil },
+	}
+	fetch := NewFetch(ctx, nil, pool, &remote.KVClientMock{}, nil, memdb.NewTestPoolDB(t), *u256.N1)
+
+	hashes := toHashes(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	request, err := EncodeGetPooledTransactions66(hashes, 42, nil)
+	require.NoError(t, err)
+	err = fetch.handleInboundMessage(ctx, &sentry.InboundMessage{Id: sentry.MessageId_GET_POOLED_TRANSACTIONS_66, Data: request, PeerId: PeerId}, sentryClient)
+	require.NoError(t, err)
+
+	// 4th transaction exceeds the budget, rest are not even looked up
+	assert.Equal(t, 4, len(pool.GetRlpCalls()))
+	calls := m.SendMessageByIdCalls()
+	require.Equal(t, 1, len(calls))
+	reply := calls[0].SendMessageByIdRequest.Data
+	assert.Equal(t, sentry.MessageId_POOLED_TRANSACTIONS_66, reply.Id)
+	assert.Equal(t, EncodePooledTransactions66([][]byte{bigRlp, bigRlp, bigRlp, bigRlp}, 42, nil), reply.Data)
+}
+
+func TestSendPeersStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMockSentry(ctx)
+	peers := toPeerIDs(1, 2)
+	m.SendMessageToAllFunc = func(ctx context.Context, data *sentry.OutboundMessageData) (*sentry.SentPeers, error) {
+		return &sentry.SentPeers{Peers: []*types.H256{peers[0], peers[1]}}, nil
+	}
+	m.SendMessageToRandomPeersFunc = func(ctx context.Context, req *sentry.SendMessageToRandomPeersRequest) (*sentry.SentPeers, error) {
+		return &sentry.SentPeers{Peers: []*types.H256{peers[1]}}, nil
+	}
+	m.SendMessageByIdFunc = func(ctx context.Context, req *sentry.SendMessageByIdRequest) (*sentry.SentPeers, error) {
+		if gointerfaces.ConvertH256ToHash(req.PeerId) == gointerfaces.ConvertH256ToHash(peers[0]) {
+			return nil, fmt.Errorf("peer disconnected")
+		}
+		return &sentry.SentPeers{Peers: []*types.H256{req.PeerId}}, nil
+	}
+	send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH66, m)}, nil)
+	send.AnnouncePooledTxs(toHashes(1, 42, 43))
+	send.BroadcastPooledTxs(testRlps(2))
+	send.PropagatePooledTxsToPeersList(peers, toHashes(1))
+
+	stats := send.GetPeersStats()
+	require.Equal(t, 2, len(stats))
+	assert.Equal(t, gointerfaces.ConvertH256ToHash(peers[0]), stats[0].PeerID)
+	assert.Equal(t, uint64(3), stats[0].AnnouncedHashes)
+	assert.Equal(t, uint64(0), stats[0].BroadcastTxs)
+	assert.Equal(t, uint64(1), stats[0].Failures)
+	assert.Equal(t, gointerfaces.ConvertH256ToHash(peers[1]), stats[1].PeerID)
+	assert.Equal(t, uint64(4), stats[1].AnnouncedHashes)
+	assert.Equal(t, uint64(2), stats[1].BroadcastTxs)
+	assert.Equal(t, uint64(0), stats[1].Failures)
+	assert.True(t, stats[1].Bytes > stats[0].Bytes)
+}
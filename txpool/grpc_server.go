@@ -18,6 +18,7 @@ package txpool
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net"
@@ -43,18 +44,23 @@ import (
 )
 
 // TxPoolAPIVersion
-var TxPoolAPIVersion = &types2.VersionReply{Major: 1, Minor: 0, Patch: 0}
+var TxPoolAPIVersion = &types2.VersionReply{Major: 1, Minor: 7, Patch: 0}
 
 type txPool interface {
-	ValidateSerializedTxn(serializedTxn []byte) error
+	PoolReader
 
 	Best(n uint16, txs *TxsRlp, tx kv.Tx) error
-	GetRlp(tx kv.Tx, hash []byte) ([]byte, error)
+	BestIds() [][32]byte
+	BestByIds(ids [][32]byte, txs *TxsRlp, tx kv.Tx) error
 	AddLocalTxs(ctx context.Context, newTxs TxSlots) ([]DiscardReason, error)
-	deprecatedForEach(_ context.Context, f func(rlp, sender []byte, t SubPoolType), tx kv.Tx)
+	deprecatedForEach(_ context.Context, f func(rlp, sender []byte, t SubPoolType, firstSeen uint64), tx kv.Tx)
 	CountContent() (int, int, int)
-	IdHashKnown(tx kv.Tx, hash []byte) (bool, error)
 	NonceFromAddress(addr [20]byte) (nonce uint64, inPool bool)
+	CountEligible(baseFee uint64) (count int, gas uint64)
+	SetLimits(limits Limits) Limits
+	PreCheck(ctx context.Context, newTxs TxSlots) ([]DiscardReason, error)
+	PagedAll(t SubPoolType, cursor []byte, limit int) (txs []TxInfo, next []byte, total int, err error)
+	DbStats(tx kv.Tx) (DbStats, error)
 }
 
 var _ txpool_proto.TxpoolServer = (*GrpcServer)(nil)   // compile-time interface check
@@ -93,6 +99,21 @@ func (*GrpcDisabled) Status(ctx context.Context, request *txpool_proto.StatusReq
 func (*GrpcDisabled) Nonce(ctx context.Context, request *txpool_proto.NonceRequest) (*txpool_proto.NonceReply, error) {
 	return nil, ErrPoolDisabled
 }
+func (*GrpcDisabled) CountEligible(ctx context.Context, request *txpool_proto.CountEligibleRequest) (*txpool_proto.CountEligibleReply, error) {
+	return nil, ErrPoolDisabled
+}
+func (*GrpcDisabled) SetLimits(ctx context.Context, request *txpool_proto.SetLimitsRequest) (*txpool_proto.SetLimitsReply, error) {
+	return nil, ErrPoolDisabled
+}
+func (*GrpcDisabled) PreCheck(ctx context.Context, request *txpool_proto.AddRequest) (*txpool_proto.AddReply, error) {
+	return nil, ErrPoolDisabled
+}
+func (*GrpcDisabled) PagedAll(ctx context.Context, request *txpool_proto.PagedAllRequest) (*txpool_proto.PagedAllReply, error) {
+	return nil, ErrPoolDisabled
+}
+func (*GrpcDisabled) BestStream(request *txpool_proto.BestStreamRequest, server txpool_proto.Txpool_BestStreamServer) error {
+	return ErrPoolDisabled
+}
 
 type GrpcServer struct {
 	txpool_proto.UnimplementedTxpoolServer
@@ -123,6 +144,18 @@ func convertSubPoolType(t SubPoolType) txpool_proto.AllReply_Type {
 		panic("unknown")
 	}
 }
+func convertProtoSubPoolType(t txpool_proto.AllReply_Type) (SubPoolType, error) {
+	switch t {
+	case txpool_proto.AllReply_PENDING:
+		return PendingSubPool, nil
+	case txpool_proto.AllReply_BASE_FEE:
+		return BaseFeeSubPool, nil
+	case txpool_proto.AllReply_QUEUED:
+		return QueuedSubPool, nil
+	default:
+		return 0, fmt.Errorf("unknown sub-pool type: %d", t)
+	}
+}
 func (s *GrpcServer) All(ctx context.Context, _ *txpool_proto.AllRequest) (*txpool_proto.AllReply, error) {
 	tx, err := s.db.BeginRo(ctx)
 	if err != nil {
@@ -131,16 +164,47 @@ func (s *GrpcServer) All(ctx context.Context, _ *txpool_proto.AllRequest) (*txpo
 	defer tx.Rollback()
 	reply := &txpool_proto.AllReply{}
 	reply.Txs = make([]*txpool_proto.AllReply_Tx, 0, 32)
-	s.txPool.deprecatedForEach(ctx, func(rlp, sender []byte, t SubPoolType) {
+	s.txPool.deprecatedForEach(ctx, func(rlp, sender []byte, t SubPoolType, firstSeen uint64) {
 		reply.Txs = append(reply.Txs, &txpool_proto.AllReply_Tx{
-			Sender: sender,
-			Type:   convertSubPoolType(t),
-			RlpTx:  common.Copy(rlp),
+			Sender:    sender,
+			Type:      convertSubPoolType(t),
+			RlpTx:     common.Copy(rlp),
+			FirstSeen: firstSeen,
 		})
 	}, tx)
 	return reply, nil
 }
 
+// PagedAll - unlike All, reply is bounded by MaxPagedAllLimit transactions and doesn't carry rlp
+func (s *GrpcServer) PagedAll(_ context.Context, in *txpool_proto.PagedAllRequest) (*txpool_proto.PagedAllReply, error) {
+	t, err := convertProtoSubPoolType(in.Type)
+	if err != nil {
+		return nil, err
+	}
+	limit := int(in.Limit)
+	if limit == 0 || limit > MaxPagedAllLimit {
+		limit = MaxPagedAllLimit
+	}
+	txs, next, total, err := s.txPool.PagedAll(t, in.Cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	reply := &txpool_proto.PagedAllReply{Txs: make([]*txpool_proto.TxInfo, len(txs)), NextCursor: next, Total: uint64(total)}
+	for i := range txs {
+		reply.Txs[i] = &txpool_proto.TxInfo{
+			Hash:      gointerfaces.ConvertHashToH256(txs[i].IdHash),
+			Sender:    common.Copy(txs[i].Sender[:]),
+			Nonce:     txs[i].Nonce,
+			Tip:       txs[i].Tip,
+			FeeCap:    txs[i].FeeCap,
+			Gas:       txs[i].Gas,
+			FirstSeen: txs[i].FirstSeen,
+			IsLocal:   txs[i].IsLocal,
+		}
+	}
+	return reply, nil
+}
+
 func (s *GrpcServer) Pending(ctx context.Context, _ *emptypb.Empty) (*txpool_proto.PendingReply, error) {
 	tx, err := s.db.BeginRo(ctx)
 	if err != nil {
@@ -155,19 +219,78 @@ func (s *GrpcServer) Pending(ctx context.Context, _ *emptypb.Empty) (*txpool_pro
 	}
 	for i := range txSlots.Txs {
 		reply.Txs = append(reply.Txs, &txpool_proto.PendingReply_Tx{
-			Sender:  txSlots.Senders.At(i),
-			RlpTx:   txSlots.Txs[i],
-			IsLocal: txSlots.IsLocal[i],
+			Sender:    txSlots.Senders.At(i),
+			RlpTx:     txSlots.Txs[i],
+			IsLocal:   txSlots.IsLocal[i],
+			FirstSeen: txSlots.FirstSeen[i],
 		})
 	}
 	return reply, nil
 }
 
+// BestStream - unlike Pending, reads transactions lazily: their order is fixed when stream starts, next batch is
+// read only after previous one is sent, so client which stopped reading (block is full) doesn't make pool read the rest.
+// Transactions which left pending sub-pool meanwhile are skipped
+func (s *GrpcServer) BestStream(in *txpool_proto.BestStreamRequest, stream txpool_proto.Txpool_BestStreamServer) error {
+	batchSize := int(in.BatchSize)
+	if batchSize == 0 {
+		batchSize = DefaultBestStreamBatch
+	}
+	if batchSize > MaxBestStreamBatch {
+		batchSize = MaxBestStreamBatch
+	}
+	ctx := stream.Context()
+	ids := s.txPool.BestIds()
+	txSlots := TxsRlp{}
+	for len(ids) > 0 {
+		n := batchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+		reply := &txpool_proto.BestStreamReply{}
+		// rlp of flushed transactions points into db, reply is copied out of short read transaction
+		if err := s.db.View(ctx, func(tx kv.Tx) error {
+			if err := s.txPool.BestByIds(ids[:n], &txSlots, tx); err != nil {
+				return err
+			}
+			reply.Txs = make([]*txpool_proto.PendingReply_Tx, len(txSlots.Txs))
+			for i := range txSlots.Txs {
+				reply.Txs[i] = &txpool_proto.PendingReply_Tx{
+					Sender:    common.Copy(txSlots.Senders.At(i)),
+					RlpTx:     common.Copy(txSlots.Txs[i]),
+					IsLocal:   txSlots.IsLocal[i],
+					FirstSeen: txSlots.FirstSeen[i],
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		ids = ids[n:]
+		if len(reply.Txs) == 0 {
+			continue
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *GrpcServer) FindUnknown(ctx context.Context, in *txpool_proto.TxHashes) (*txpool_proto.TxHashes, error) {
 	return nil, fmt.Errorf("unimplemented")
 }
 
 func (s *GrpcServer) Add(ctx context.Context, in *txpool_proto.AddRequest) (*txpool_proto.AddReply, error) {
+	return s.add(ctx, in, s.txPool.AddLocalTxs)
+}
+
+// PreCheck - same checks and reply as Add, but transactions are not added to the pool
+func (s *GrpcServer) PreCheck(ctx context.Context, in *txpool_proto.AddRequest) (*txpool_proto.AddReply, error) {
+	return s.add(ctx, in, s.txPool.PreCheck)
+}
+
+func (s *GrpcServer) add(ctx context.Context, in *txpool_proto.AddRequest, addTxs func(ctx context.Context, newTxs TxSlots) ([]DiscardReason, error)) (*txpool_proto.AddReply, error) {
 	tx, err := s.db.BeginRo(context.Background())
 	if err != nil {
 		return nil, err
@@ -192,13 +315,16 @@ func (s *GrpcServer) Add(ctx context.Context, in *txpool_proto.AddRequest) (*txp
 		slots.txs[j] = &TxSlot{}
 		slots.isLocal[j] = true
 		if _, err := parseCtx.ParseTransaction(in.RlpTxs[i], 0, slots.txs[j], slots.senders.At(j), false /* hasEnvelope */); err != nil {
-			switch err {
-			case ErrAlreadyKnown: // Noop, but need to handle to not count these
+			switch {
+			case errors.Is(err, ErrAlreadyKnown): // Noop, but need to handle to not count these
 				reply.Errors[i] = AlreadyKnown.String()
 				reply.Imported[i] = txpool_proto.ImportResult_ALREADY_EXISTS
-			case ErrRlpTooBig: // Noop, but need to handle to not count these
+			case errors.Is(err, ErrRlpTooBig): // Noop, but need to handle to not count these
 				reply.Errors[i] = RLPTooLong.String()
 				reply.Imported[i] = txpool_proto.ImportResult_INVALID
+			case errors.Is(err, ErrWrongChainID):
+				reply.Errors[i] = WrongChainID.String()
+				reply.Imported[i] = mapDiscardReasonToProto(WrongChainID)
 			default:
 				reply.Errors[i] = err.Error()
 				reply.Imported[i] = txpool_proto.ImportResult_INTERNAL_ERROR
@@ -208,7 +334,7 @@ func (s *GrpcServer) Add(ctx context.Context, in *txpool_proto.AddRequest) (*txp
 		j++
 	}
 
-	discardReasons, err := s.txPool.AddLocalTxs(ctx, slots)
+	discardReasons, err := addTxs(ctx, slots)
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +361,7 @@ func mapDiscardReasonToProto(reason DiscardReason) txpool_proto.ImportResult {
 		return txpool_proto.ImportResult_ALREADY_EXISTS
 	case UnderPriced, ReplaceUnderpriced, FeeTooLow:
 		return txpool_proto.ImportResult_FEE_TOO_LOW
-	case InvalidSender, NegativeValue, OversizedData:
+	case InvalidSender, NegativeValue, OversizedData, GasLimitTooHigh, AltMempoolRejected, WrongChainID, DisallowedTxType:
 		return txpool_proto.ImportResult_INVALID
 	default:
 		return txpool_proto.ImportResult_INTERNAL_ERROR
@@ -275,13 +401,33 @@ func (s *GrpcServer) Transactions(ctx context.Context, in *txpool_proto.Transact
 	return reply, nil
 }
 
-func (s *GrpcServer) Status(_ context.Context, _ *txpool_proto.StatusRequest) (*txpool_proto.StatusReply, error) {
+func (s *GrpcServer) Status(ctx context.Context, _ *txpool_proto.StatusRequest) (*txpool_proto.StatusReply, error) {
+	tx, err := s.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	dbStats, err := s.txPool.DbStats(tx)
+	if err != nil {
+		return nil, err
+	}
+
 	pending, baseFee, queued := s.txPool.CountContent()
-	return &txpool_proto.StatusReply{
-		PendingCount: uint32(pending),
-		QueuedCount:  uint32(queued),
-		BaseFeeCount: uint32(baseFee),
-	}, nil
+	reply := &txpool_proto.StatusReply{
+		PendingCount:        uint32(pending),
+		QueuedCount:         uint32(queued),
+		BaseFeeCount:        uint32(baseFee),
+		DbSize:              dbStats.Size,
+		DbTables:            make([]*txpool_proto.DbTableStats, len(dbStats.Tables)),
+		DbFlushes:           dbStats.Flush.Flushes,
+		DbWrittenBytes:      dbStats.Flush.WrittenBytes,
+		DbWrittenTxBytes:    dbStats.Flush.WrittenTxBytes,
+		DbWrittenTxRlpBytes: dbStats.Flush.WrittenTxRlpBytes,
+	}
+	for i, table := range dbStats.Tables {
+		reply.DbTables[i] = &txpool_proto.DbTableStats{Table: table.Table, Rows: table.Rows, Size: table.Size}
+	}
+	return reply, nil
 }
 
 // returns nonce for address
@@ -294,6 +440,31 @@ func (s *GrpcServer) Nonce(ctx context.Context, in *txpool_proto.NonceRequest) (
 	}, nil
 }
 
+// CountEligible - amount and total gas of pending transactions which can be included into block with given base fee
+func (s *GrpcServer) CountEligible(_ context.Context, in *txpool_proto.CountEligibleRequest) (*txpool_proto.CountEligibleReply, error) {
+	count, gas := s.txPool.CountEligible(in.BaseFee)
+	return &txpool_proto.CountEligibleReply{Count: uint64(count), Gas: gas}, nil
+}
+
+// SetLimits - changes sub-pool limits and price bump, zero fields of request keep current values
+func (s *GrpcServer) SetLimits(_ context.Context, in *txpool_proto.SetLimitsRequest) (*txpool_proto.SetLimitsReply, error) {
+	if in.PendingSubPoolLimit > math.MaxInt32 || in.BaseFeeSubPoolLimit > math.MaxInt32 || in.QueuedSubPoolLimit > math.MaxInt32 {
+		return nil, fmt.Errorf("sub-pool limit is too large")
+	}
+	limits := s.txPool.SetLimits(Limits{
+		PendingSubPoolLimit: int(in.PendingSubPoolLimit),
+		BaseFeeSubPoolLimit: int(in.BaseFeeSubPoolLimit),
+		QueuedSubPoolLimit:  int(in.QueuedSubPoolLimit),
+		PriceBump:           in.PriceBump,
+	})
+	return &txpool_proto.SetLimitsReply{
+		PendingSubPoolLimit: uint64(limits.PendingSubPoolLimit),
+		BaseFeeSubPoolLimit: uint64(limits.BaseFeeSubPoolLimit),
+		QueuedSubPoolLimit:  uint64(limits.QueuedSubPoolLimit),
+		PriceBump:           limits.PriceBump,
+	}, nil
+}
+
 // NewSlotsStreams - it's safe to use this class as non-pointer
 type NewSlotsStreams struct {
 	chans map[uint]txpool_proto.Txpool_OnAddServer
@@ -351,6 +522,7 @@ func StartGrpc(txPoolServer txpool_proto.TxpoolServer, miningServer txpool_proto
 	)
 	streamInterceptors = append(streamInterceptors, grpc_recovery.StreamServerInterceptor())
 	unaryInterceptors = append(unaryInterceptors, grpc_recovery.UnaryServerInterceptor())
+	unaryInterceptors = append(unaryInterceptors, ValidationUnaryServerInterceptor())
 
 	//if metrics.Enabled {
 	//	streamInterceptors = append(streamInterceptors, grpc_prometheus.StreamServerInterceptor)
@@ -0,0 +1,94 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"context"
+
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	types2 "github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Limits of batch requests, larger batches are rejected with INVALID_ARGUMENT
+const (
+	MaxAddRequestTxs = 1024 // transactions in one AddRequest
+	MaxRequestHashes = 4096 // hashes in one TransactionsRequest or TxHashes
+	MaxPagedAllLimit = 4096 // transactions in one PagedAllReply
+)
+
+// Transactions in one BestStreamReply: request without batch size gets default one, larger than max is lowered to max
+const (
+	DefaultBestStreamBatch = 64
+	MaxBestStreamBatch     = 1024
+)
+
+// ValidationUnaryServerInterceptor - rejects malformed requests before they reach handlers: nil hashes and addresses
+// (handlers would panic on them) and too large batches
+func ValidationUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := validateRequest(req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// validateRequest - returns INVALID_ARGUMENT status error, requests of unknown types are not checked
+func validateRequest(req interface{}) error {
+	switch r := req.(type) {
+	case *txpool_proto.AddRequest:
+		if len(r.RlpTxs) > MaxAddRequestTxs {
+			return status.Errorf(codes.InvalidArgument, "too many transactions: %d, max %d", len(r.RlpTxs), MaxAddRequestTxs)
+		}
+		for i, rlp := range r.RlpTxs {
+			if len(rlp) == 0 {
+				return status.Errorf(codes.InvalidArgument, "empty transaction %d", i)
+			}
+		}
+	case *txpool_proto.TransactionsRequest:
+		return validateHashes(r.Hashes)
+	case *txpool_proto.TxHashes:
+		return validateHashes(r.Hashes)
+	case *txpool_proto.NonceRequest:
+		if r.Address == nil || r.Address.Hi == nil {
+			return status.Error(codes.InvalidArgument, "address is not set")
+		}
+	case *txpool_proto.PagedAllRequest:
+		if r.Limit > MaxPagedAllLimit {
+			return status.Errorf(codes.InvalidArgument, "too large limit: %d, max %d", r.Limit, MaxPagedAllLimit)
+		}
+		if len(r.Cursor) != 0 && len(r.Cursor) != pagedAllCursorLen {
+			return status.Errorf(codes.InvalidArgument, "invalid cursor length: %d", len(r.Cursor))
+		}
+	}
+	return nil
+}
+
+func validateHashes(hashes []*types2.H256) error {
+	if len(hashes) > MaxRequestHashes {
+		return status.Errorf(codes.InvalidArgument, "too many hashes: %d, max %d", len(hashes), MaxRequestHashes)
+	}
+	for i, h := range hashes {
+		if h == nil || h.Hi == nil || h.Lo == nil {
+			return status.Errorf(codes.InvalidArgument, "hash %d is not set", i)
+		}
+	}
+	return nil
+}
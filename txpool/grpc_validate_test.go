@@ -0,0 +1,74 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	types2 "github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidationInterceptor(t *testing.T) {
+	interceptor := ValidationUnaryServerInterceptor()
+	var handled int
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		return req, nil
+	}
+	hash := gointerfaces.ConvertHashToH256([32]byte{1})
+	tooManyHashes := make([]*types2.H256, MaxRequestHashes+1)
+	for i := range tooManyHashes {
+		tooManyHashes[i] = hash
+	}
+	for name, tc := range map[string]struct {
+		req   interface{}
+		valid bool
+	}{
+		"add":                {&txpool_proto.AddRequest{RlpTxs: [][]byte{{1}}}, true},
+		"add empty rlp":      {&txpool_proto.AddRequest{RlpTxs: [][]byte{{1}, {}}}, false},
+		"add too many":       {&txpool_proto.AddRequest{RlpTxs: make([][]byte, MaxAddRequestTxs+1)}, false},
+		"transactions":       {&txpool_proto.TransactionsRequest{Hashes: []*types2.H256{hash}}, true},
+		"transactions nil":   {&txpool_proto.TransactionsRequest{Hashes: []*types2.H256{hash, nil}}, false},
+		"transactions no lo": {&txpool_proto.TransactionsRequest{Hashes: []*types2.H256{{Hi: hash.Hi}}}, false},
+		"transactions many":  {&txpool_proto.TransactionsRequest{Hashes: tooManyHashes}, false},
+		"find unknown nil":   {&txpool_proto.TxHashes{Hashes: []*types2.H256{nil}}, false},
+		"nonce":              {&txpool_proto.NonceRequest{Address: gointerfaces.ConvertAddressToH160([20]byte{1})}, true},
+		"nonce no address":   {&txpool_proto.NonceRequest{}, false},
+		"nonce partial":      {&txpool_proto.NonceRequest{Address: &types2.H160{Lo: 1}}, false},
+		"status not checked": {&txpool_proto.StatusRequest{}, true},
+		"paged all":          {&txpool_proto.PagedAllRequest{Cursor: make([]byte, 16), Limit: MaxPagedAllLimit}, true},
+		"paged all limit":    {&txpool_proto.PagedAllRequest{Limit: MaxPagedAllLimit + 1}, false},
+		"paged all cursor":   {&txpool_proto.PagedAllRequest{Cursor: []byte{1}}, false},
+	} {
+		handled = 0
+		_, err := interceptor(context.Background(), tc.req, &grpc.UnaryServerInfo{}, handler)
+		if tc.valid {
+			require.NoError(t, err, name)
+			require.Equal(t, 1, handled, name)
+			continue
+		}
+		require.Equal(t, codes.InvalidArgument, status.Code(err), name)
+		require.Equal(t, 0, handled, name)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import "sync"
+
+const knownHashesShards = 64
+
+// reasons why hash is known to the pool, one hash may have several of them
+const (
+	knownInPool      byte = 1 << iota // in byHash
+	knownUnprocessed                  // in unprocessedRemoteByHash
+	knownDiscarded                    // in discardReasonsLRU
+)
+
+// knownHashes - hashes for which IdHashKnown returns true without db lookup.
+// Mirrors byHash, unprocessedRemoteByHash and discardReasonsLRU: mutated only under pool's lock, together with them,
+// but read without it. Sharded by first byte of hash, every shard has own lock - so checks of hashes announced by
+// many sentry streams don't contend with OnNewBlock and with each other
+type knownHashes struct {
+	shards [knownHashesShards]knownHashesShard
+}
+
+type knownHashesShard struct {
+	lock sync.RWMutex
+	m    map[string]byte // hash => reasons
+}
+
+func newKnownHashes() *knownHashes {
+	k := &knownHashes{}
+	for i := range k.shards {
+		k.shards[i].m = map[string]byte{}
+	}
+	return k
+}
+
+func (k *knownHashes) shard(hash string) *knownHashesShard {
+	if len(hash) == 0 {
+		return &k.shards[0]
+	}
+	return &k.shards[hash[0]%knownHashesShards]
+}
+
+func (k *knownHashes) has(hash string) bool {
+	s := k.shard(hash)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	_, ok := s.m[hash]
+	return ok
+}
+
+func (k *knownHashes) set(hash string, reason byte) {
+	s := k.shard(hash)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.m[hash] |= reason
+}
+
+func (k *knownHashes) clear(hash string, reason byte) {
+	s := k.shard(hash)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if reasons, ok := s.m[hash]; ok {
+		if reasons &^= reason; reasons == 0 {
+			delete(s.m, hash)
+		} else {
+			s.m[hash] = reasons
+		}
+	}
+}
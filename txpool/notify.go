@@ -0,0 +1,90 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+)
+
+// notifyArenaChunk - chunks sent to newPendingTxs are carved from arena of this size (in bytes), they are owned
+// by receivers, so arena is never reset
+const notifyArenaChunk = 1024 * length.Hash
+
+var (
+	notifyBacklogCounter = metrics.GetOrCreateCounter(`pool_notify_backlog`)        // hashes waiting for free space in newPendingTxs
+	notifyDroppedCounter = metrics.GetOrCreateCounter(`pool_notify_dropped_hashes`) // hashes dropped from overflowed backlog
+)
+
+// notifyPromotedLocked - sends p.promoted to newPendingTxs in chunks of at most cfg.PromotedBatchLimit hashes.
+// Never blocks (called under pool's lock): chunks which don't fit into the channel stay in backlog and are sent
+// by next notification or by processRemoteTxs tick
+func (p *TxPool) notifyPromotedLocked() {
+	limit := p.cfg.PromotedBatchLimit
+	if limit <= 0 {
+		limit = p.promoted.Len()
+	}
+	for from := 0; from < p.promoted.Len(); from += limit {
+		to := from + limit
+		if to > p.promoted.Len() {
+			to = p.promoted.Len()
+		}
+		p.notifyBacklog = append(p.notifyBacklog, p.notifyArena.Copy(p.promoted[from*length.Hash:to*length.Hash]))
+	}
+	p.trimNotifyBacklogLocked()
+	p.flushNotifyBacklogLocked()
+}
+
+// trimNotifyBacklogLocked - backlog can't be larger than pending and baseFee sub-pools together: older chunks
+// are about txs which are likely evicted already. Txs of dropped chunks are still synced to new peers
+func (p *TxPool) trimNotifyBacklogLocked() {
+	maxLen := p.cfg.PendingSubPoolLimit + p.cfg.BaseFeeSubPoolLimit
+	total := 0
+	for _, chunk := range p.notifyBacklog {
+		total += chunk.Len()
+	}
+	for total > maxLen && len(p.notifyBacklog) > 0 {
+		dropped := p.notifyBacklog[0].Len()
+		p.notifyBacklog[0] = nil
+		p.notifyBacklog = p.notifyBacklog[1:]
+		total -= dropped
+		notifyDroppedCounter.Add(dropped)
+	}
+}
+
+func (p *TxPool) flushNotifyBacklogLocked() {
+	sent := 0
+	for ; sent < len(p.notifyBacklog); sent++ {
+		select {
+		case p.newPendingTxs <- p.notifyBacklog[sent]:
+			p.notifyBacklog[sent] = nil
+			continue
+		default:
+		}
+		break
+	}
+	if sent == len(p.notifyBacklog) {
+		p.notifyBacklog = p.notifyBacklog[:0]
+	} else {
+		p.notifyBacklog = p.notifyBacklog[sent:]
+	}
+	total := 0
+	for _, chunk := range p.notifyBacklog {
+		total += chunk.Len()
+	}
+	notifyBacklogCounter.Set(uint64(total))
+}
@@ -19,6 +19,7 @@ package txpool
 import (
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/length"
@@ -108,9 +109,7 @@ func ParseGetPooledTransactions66(payload []byte, pos int, hashbuf []byte) (requ
 
 // == Pooled transactions ==
 
-func EncodePooledTransactions66(txsRlp [][]byte, requestId uint64, encodeBuf []byte) []byte {
-	pos := 0
-	txsRlpLen := 0
+func pooledTransactions66Len(txsRlp [][]byte, requestId uint64) (dataLen, txsRlpLen int) {
 	for i := range txsRlp {
 		_, _, isLegacy, _ := rlp.Prefix(txsRlp[i], 0)
 		if isLegacy {
@@ -119,7 +118,12 @@ func EncodePooledTransactions66(txsRlp [][]byte, requestId uint64, encodeBuf []b
 			txsRlpLen += rlp.StringLen(len(txsRlp[i]))
 		}
 	}
-	dataLen := rlp.U64Len(requestId) + rlp.ListPrefixLen(txsRlpLen) + txsRlpLen
+	return rlp.U64Len(requestId) + rlp.ListPrefixLen(txsRlpLen) + txsRlpLen, txsRlpLen
+}
+
+func EncodePooledTransactions66(txsRlp [][]byte, requestId uint64, encodeBuf []byte) []byte {
+	pos := 0
+	dataLen, txsRlpLen := pooledTransactions66Len(txsRlp, requestId)
 
 	encodeBuf = common.EnsureEnoughSize(encodeBuf, rlp.ListPrefixLen(dataLen)+dataLen)
 
@@ -139,6 +143,34 @@ func EncodePooledTransactions66(txsRlp [][]byte, requestId uint64, encodeBuf []b
 	_ = pos
 	return encodeBuf
 }
+
+// WritePooledTransactions66 - same as EncodePooledTransactions66, but streams to w instead of building whole packet in memory
+func WritePooledTransactions66(w io.Writer, txsRlp [][]byte, requestId uint64) error {
+	dataLen, txsRlpLen := pooledTransactions66Len(txsRlp, requestId)
+	lw, err := rlp.NewListWriter(w, dataLen)
+	if err != nil {
+		return err
+	}
+	if err = lw.WriteU64(requestId); err != nil {
+		return err
+	}
+	if err = lw.ListPrefix(txsRlpLen); err != nil {
+		return err
+	}
+	for i := range txsRlp {
+		_, _, isLegacy, _ := rlp.Prefix(txsRlp[i], 0)
+		if isLegacy {
+			err = lw.WriteRaw(txsRlp[i])
+		} else {
+			err = lw.WriteString(txsRlp[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return lw.Close()
+}
+
 func EncodeTransactions(txsRlp [][]byte, encodeBuf []byte) []byte {
 	pos := 0
 	dataLen := 0
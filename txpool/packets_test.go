@@ -17,6 +17,7 @@
 package txpool
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"strconv"
@@ -151,6 +152,10 @@ func TestPooledTransactionsPacket66(t *testing.T) {
 			encodeBuf = EncodePooledTransactions66(tt.txs, tt.requestId, encodeBuf)
 			require.Equal(tt.encoded, fmt.Sprintf("%x", encodeBuf))
 
+			var streamed bytes.Buffer
+			require.NoError(WritePooledTransactions66(&streamed, tt.txs, tt.requestId))
+			require.Equal(tt.encoded, fmt.Sprintf("%x", streamed.Bytes()))
+
 			ctx := NewTxParseContext(*uint256.NewInt(tt.chainID))
 			slots := &TxSlots{}
 			requestId, _, err := ParsePooledTransactions66(encodeBuf, 0, ctx, slots)
@@ -182,6 +187,50 @@ func TestPooledTransactionsPacket66(t *testing.T) {
 	}
 }
 
+func TestPooledTransactions66Borrow(t *testing.T) {
+	tt := ptp66EncodeTests[1]
+	for _, borrow := range []bool{false, true} {
+		encodeBuf := EncodePooledTransactions66(tt.txs, tt.requestId, nil)
+		ctx := NewTxParseContext(*uint256.NewInt(tt.chainID))
+		ctx.WithBorrow(borrow)
+		slots := &TxSlots{}
+		_, _, err := ParsePooledTransactions66(encodeBuf, 0, ctx, slots)
+		require.NoError(t, err)
+		for i := range encodeBuf { // caller reused buffer
+			encodeBuf[i] = 0
+		}
+		require.Equal(t, !borrow, bytes.Equal(tt.txs[0], slots.txs[0].rlp), "borrow=%t", borrow)
+	}
+}
+
+// pooledTransactions66Batch - POOLED_TRANSACTIONS message of n transactions
+func pooledTransactions66Batch(n int) []byte {
+	txs := make([][]byte, n)
+	for i := range txs {
+		txs[i] = ptp66EncodeTests[1].txs[i%2]
+	}
+	return EncodePooledTransactions66(txs, 1, nil)
+}
+
+func BenchmarkParsePooledTransactions66(b *testing.B) {
+	payload := pooledTransactions66Batch(4096)
+	for _, borrow := range []bool{false, true} {
+		b.Run(fmt.Sprintf("borrow=%t", borrow), func(b *testing.B) {
+			ctx := NewTxParseContext(*u256.N1)
+			ctx.WithSender(false)
+			ctx.WithBorrow(borrow)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				slots := &TxSlots{}
+				if _, _, err := ParsePooledTransactions66(payload, 0, ctx, slots); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 var tpEncodeTests = []struct {
 	txs         [][]byte
 	encoded     string
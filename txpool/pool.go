@@ -21,11 +21,13 @@ import (
 	"container/heap"
 	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,6 +39,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/chain"
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/fixedgas"
+	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
@@ -56,6 +59,13 @@ var (
 	propagateToNewPeerTimer = metrics.NewSummary(`pool_propagate_to_new_peer`)
 	propagateNewTxsTimer    = metrics.NewSummary(`pool_propagate_new_txs`)
 	writeToDbBytesCounter   = metrics.GetOrCreateCounter(`pool_write_to_db_bytes`)
+	writeToDbTxRlpBytes     = metrics.GetOrCreateCounter(`pool_write_to_db_tx_rlp_bytes`) // before compression
+	writeToDbTxBytes        = metrics.GetOrCreateCounter(`pool_write_to_db_tx_bytes`)     // after compression
+	pendingEligibleCounter  = metrics.GetOrCreateCounter(`pool_pending_eligible`)
+	pendingEligibleGas      = metrics.GetOrCreateCounter(`pool_pending_eligible_gas`)
+	staleBatchesCounter     = metrics.GetOrCreateCounter(`pool_stale_state_change_batches`)
+	staleReplacedCounter    = metrics.GetOrCreateCounter(`pool_stale_replacements_removed`)
+	highVolumeSlotsCounter  = metrics.GetOrCreateCounter(`pool_high_volume_slots_used`) // txs accepted only thanks to HighVolumeSenders
 )
 
 const ASSERT = false
@@ -75,6 +85,48 @@ type Config struct {
 	AccountSlots  uint64   // Number of executable transaction slots guaranteed per account
 	PriceBump     uint64   // Price bump percentage to replace an already existing transaction
 	TracedSenders []string // List of senders for which tx pool should print out debugging info
+
+	// HighVolumeSenders - sender address (20 bytes) => number of transaction slots, replaces AccountSlots for
+	// known senders which legitimately keep many pending txs (exchanges, bridges). Values below AccountSlots are ignored
+	HighVolumeSenders map[string]uint64
+
+	// MaxTxGasFraction - transactions with gas limit above this fraction of block gas limit are rejected, 0 - no limit.
+	// Transactions which don't fit into block can't be mined and otherwise would occupy pending slots forever
+	MaxTxGasFraction float64
+
+	// LocalMinFeeCap - minimal feeCap of local transactions. Local transactions with feeCap between LocalMinFeeCap and
+	// MinFeeCap are accepted, but kept in queued sub-pool (not announced to peers and not offered to block builder)
+	// while pending block's baseFee is above their feeCap
+	LocalMinFeeCap uint64
+
+	ShanghaiTime *uint64 // Unix time of Shanghai fork (EIP-3860 limits and charges initcode), nil - not scheduled
+
+	CompressTxRlp bool // Store large transactions in db compressed. Db written with it can't be read by versions without it.
+
+	// PromotedBatchLimit - max amount of hashes in one notification about new pending txs, 0 - no limit.
+	// After catch-up hundreds of thousands of txs can be promoted at once, every notification is broadcast
+	// to peers and to OnAdd subscribers as one message
+	PromotedBatchLimit int
+
+	// StrictNonceContinuity - Best offers only the next executable transaction of every sender (nonce equal to
+	// sender's state nonce), even if balance allows more: each block includes at most one transaction per account,
+	// in nonce order. For L2 sequencers which guarantee FIFO inclusion per account
+	StrictNonceContinuity bool
+
+	// DisallowedTxTypes - new transactions of these types (LegacyTxType, AccessListTxType, ...) are rejected by
+	// validation with DisallowedTxType reason, pooled ones are not evicted. Changed at runtime by SetDisallowedTxTypes
+	DisallowedTxTypes []int
+
+	// PriorityContracts - contract address (20 bytes) => priority boost of transactions calling it. Within the same
+	// sub-pool bits transactions with bigger boost are ordered first (offered to block builder before better paying ones)
+	// and evicted last. For sequencers which must include protocol-critical calls (oracle updates) in time
+	PriorityContracts map[string]uint8
+
+	// SystemTxSenders - sender address (20 bytes) of fee-less system transactions of bor-style chains, see
+	// SystemTxSendersFromChain. Their transactions of SystemTxTypes skip MinFeeCap and intrinsic gas checks
+	// and are treated as paying any base fee
+	SystemTxSenders map[string]struct{}
+	SystemTxTypes   []int // nil - LegacyTxType only
 }
 
 var DefaultConfig = Config{
@@ -90,24 +142,63 @@ var DefaultConfig = Config{
 	MinFeeCap:    1,
 	AccountSlots: 16, //TODO: to choose right value (16 to be compat with Geth)
 	PriceBump:    10, // Price bump percentage to replace an already existing transaction
+
+	MaxTxGasFraction:   1,
+	PromotedBatchLimit: 1024,
+}
+
+// SenderStateOverride - allows embedders (for example L2 sequencers) to adjust nonce and balance of sender, as seen by
+// pool, before its transactions are validated and classified into sub-pools: credit fee deposits, account abstraction
+// paymasters, etc. Called under pool's lock, must be fast and must not call pool.
+type SenderStateOverride interface {
+	OverrideSenderState(addr []byte, nonce uint64, balance uint256.Int) (uint64, uint256.Int)
+}
+
+// SenderStateOverrideFunc - adapter to use ordinary function as SenderStateOverride
+type SenderStateOverrideFunc func(addr []byte, nonce uint64, balance uint256.Int) (uint64, uint256.Int)
+
+func (f SenderStateOverrideFunc) OverrideSenderState(addr []byte, nonce uint64, balance uint256.Int) (uint64, uint256.Int) {
+	return f(addr, nonce, balance)
+}
+
+// AltMempool - allows external validation pipelines (for example EIP-4337 bundlers) to handle transactions of specific
+// types or to specific addresses: they get parsed transaction before standard validation and can reject or tag it.
+// Standard validation is still applied to transactions which pass. Called under pool's lock, must be fast and must not call pool.
+type AltMempool interface {
+	// Match - whether transaction belongs to this alt-mempool, only first matching alt-mempool validates it
+	Match(txn *TxSlot, sender []byte) bool
+	// Validate - Success lets the transaction go to standard validation, any other reason rejects it.
+	// tag is attached to the transaction, see TxPool.AltTag
+	Validate(txn *TxSlot, sender []byte, isLocal bool) (reason DiscardReason, tag uint64)
 }
 
 // Pool is interface for the transaction pool
 // This interface exists for the convinience of testing, and not yet because
 // there are multiple implementations
 type Pool interface {
-	ValidateSerializedTxn(serializedTxn []byte) error
-
-	// Handle 3 main events - new remote txs from p2p, new local txs from RPC, new blocks from execution layer
-	AddRemoteTxs(ctx context.Context, newTxs TxSlots)
-	AddLocalTxs(ctx context.Context, newTxs TxSlots) ([]DiscardReason, error)
-	OnNewBlock(ctx context.Context, stateChanges *remote.StateChangeBatch, unwindTxs, minedTxs TxSlots, tx kv.Tx) error
+	PoolReader
+	PoolWriter
+	PoolP2P
+}
 
+// PoolReader - queries which don't change the pool
+type PoolReader interface {
+	ValidateSerializedTxn(serializedTxn []byte) error
 	// IdHashKnown check whether transaction with given Id hash is known to the pool
 	IdHashKnown(tx kv.Tx, hash []byte) (bool, error)
 	Started() bool
 	GetRlp(tx kv.Tx, hash []byte) ([]byte, error)
+}
 
+// PoolWriter - handles 3 main events - new remote txs from p2p, new local txs from RPC, new blocks from execution layer
+type PoolWriter interface {
+	AddRemoteTxs(ctx context.Context, newTxs TxSlots)
+	AddLocalTxs(ctx context.Context, newTxs TxSlots) ([]DiscardReason, error)
+	OnNewBlock(ctx context.Context, stateChanges *remote.StateChangeBatch, unwindTxs, minedTxs TxSlots, tx kv.Tx) error
+}
+
+// PoolP2P - events of p2p network, which are not transactions
+type PoolP2P interface {
 	AddNewGoodPeer(peerID PeerID)
 }
 
@@ -158,6 +249,11 @@ const (
 	InsufficientFunds   DiscardReason = 19
 	NotReplaced         DiscardReason = 20 // There was an existing transaction with the same sender and nonce, not enough price bump to replace
 	DuplicateHash       DiscardReason = 21 // There was an existing transaction with the same hash
+	InitCodeTooLarge    DiscardReason = 22 // EIP-3860 - transaction init code is too large
+	GasLimitTooHigh     DiscardReason = 23 // gas limit of transaction is above Config.MaxTxGasFraction of block gas limit
+	AltMempoolRejected  DiscardReason = 24 // rejected by AltMempool which claimed the transaction
+	WrongChainID        DiscardReason = 25 // transaction is signed for another chain
+	DisallowedTxType    DiscardReason = 26 // type of transaction is in Config.DisallowedTxTypes
 )
 
 func (r DiscardReason) String() string {
@@ -204,6 +300,16 @@ func (r DiscardReason) String() string {
 		return "could not replace existing tx"
 	case DuplicateHash:
 		return "existing tx with same hash"
+	case InitCodeTooLarge:
+		return "initcode too large"
+	case GasLimitTooHigh:
+		return "gas limit too high"
+	case AltMempoolRejected:
+		return "rejected by alt-mempool"
+	case WrongChainID:
+		return "wrong chain id"
+	case DisallowedTxType:
+		return "transaction type not allowed"
 	default:
 		panic(fmt.Sprintf("discard reason: %d", r))
 	}
@@ -221,10 +327,13 @@ type metaTx struct {
 	worstIndex                int
 	currentSubPool            SubPoolType
 	timestamp                 uint64 // when it was added to pool
+	firstSeen                 uint64 // unix time (seconds) when pool saw it first, survives restarts
+	boost                     uint8  // ordering priority within the same sub-pool bits, see Config.PriorityContracts
+	system                    bool   // fee-less system transaction, see Config.SystemTxSenders
 }
 
 func newMetaTx(slot *TxSlot, isLocal bool, timestmap uint64) *metaTx {
-	mt := &metaTx{Tx: slot, worstIndex: -1, bestIndex: -1, timestamp: timestmap}
+	mt := &metaTx{Tx: slot, worstIndex: -1, bestIndex: -1, timestamp: timestmap, firstSeen: uint64(time.Now().Unix())}
 	if isLocal {
 		mt.subPool = IsLocal
 	}
@@ -300,19 +409,26 @@ type TxPool struct {
 
 	byHash            map[string]*metaTx // tx_hash => tx : only not committed to db yet records
 	discardReasonsLRU *simplelru.LRU     // tx_hash => discard_reason : non-persisted
+	known             *knownHashes       // hashes of byHash, unprocessedRemoteByHash and discardReasonsLRU - readable without lock
 	pending           *PendingPool
 	baseFee, queued   *SubPool
 	isLocalLRU        *simplelru.LRU    // tx_hash => is_local : to restore isLocal flag of unwinded transactions
 	newPendingTxs     chan Hashes       // notifications about new txs in Pending sub-pool
+	notifyBacklog     []Hashes          // chunks of promoted hashes which didn't fit into newPendingTxs yet, oldest first
 	deletedTxs        []*metaTx         // list of discarded txs since last db commit
 	all               *BySenderAndNonce // senderID => (sorted map of tx nonce => *metaTx)
 	promoted          Hashes            // pre-allocated temporary buffer to write promoted to pending pool txn hashes
+	notifyArena       *common.Arena     // chunks of notifyBacklog are allocated from it
+	flushStats        FlushStats        // committed flushes since start
 	_chainDB          kv.RoDB           // remote db - use it wisely
 	_stateCache       kvcache.Cache
 	cfg               Config
 
 	recentlyConnectedPeers *recentlyConnectedPeers // all txs will be propagated to this peers eventually, and clear list
 	senders                *sendersBatch
+	chain                  *chainTracker // recent blocks of applied state change batches - to skip stale ones
+	altMempools            []AltMempool
+	noPropagate            map[string]struct{} // tx_hash => nil : txs not announced to peers, see SetNoPropagate
 
 	chainID uint256.Int
 }
@@ -322,7 +438,8 @@ func New(newTxs chan Hashes, coreDB kv.RoDB, cfg Config, cache kvcache.Cache, ch
 	if err != nil {
 		return nil, err
 	}
-	discardHistory, err := simplelru.NewLRU(10_000, nil)
+	known := newKnownHashes()
+	discardHistory, err := simplelru.NewLRU(10_000, func(hash, _ interface{}) { known.clear(hash.(string), knownDiscarded) })
 	if err != nil {
 		return nil, err
 	}
@@ -336,11 +453,28 @@ func New(newTxs chan Hashes, coreDB kv.RoDB, cfg Config, cache kvcache.Cache, ch
 	for _, sender := range cfg.TracedSenders {
 		tracedSenders[sender] = struct{}{}
 	}
+	highVolumeSenders := make(map[string]uint64, len(cfg.HighVolumeSenders))
+	for sender, slots := range cfg.HighVolumeSenders {
+		highVolumeSenders[sender] = slots
+	}
+	cfg.HighVolumeSenders = highVolumeSenders
+	priorityContracts := make(map[string]uint8, len(cfg.PriorityContracts))
+	for contract, boost := range cfg.PriorityContracts {
+		priorityContracts[contract] = boost
+	}
+	cfg.PriorityContracts = priorityContracts
+	systemTxSenders := make(map[string]struct{}, len(cfg.SystemTxSenders))
+	for sender := range cfg.SystemTxSenders {
+		systemTxSenders[sender] = struct{}{}
+	}
+	cfg.SystemTxSenders = systemTxSenders
 	return &TxPool{
 		lock:                    &sync.RWMutex{},
 		byHash:                  map[string]*metaTx{},
 		isLocalLRU:              localsHistory,
+		noPropagate:             map[string]struct{}{},
 		discardReasonsLRU:       discardHistory,
+		known:                   known,
 		all:                     byNonce,
 		recentlyConnectedPeers:  &recentlyConnectedPeers{},
 		pending:                 NewPendingSubPool(PendingSubPool, cfg.PendingSubPoolLimit),
@@ -349,12 +483,14 @@ func New(newTxs chan Hashes, coreDB kv.RoDB, cfg Config, cache kvcache.Cache, ch
 		newPendingTxs:           newTxs,
 		_stateCache:             cache,
 		senders:                 newSendersCache(tracedSenders),
+		chain:                   newChainTracker(),
 		_chainDB:                coreDB,
 		cfg:                     cfg,
 		chainID:                 chainID,
 		unprocessedRemoteTxs:    &TxSlots{},
 		unprocessedRemoteByHash: map[string]int{},
 		promoted:                make(Hashes, 0, 32*1024),
+		notifyArena:             common.NewArena(notifyArenaChunk),
 	}, nil
 }
 
@@ -373,6 +509,13 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	// batches of rapid small reorgs may arrive out of order, applying stale one would undo newer state
+	if err := p.chain.check(stateChanges); err != nil {
+		staleBatchesCounter.Inc()
+		log.Warn("[txpool] skip stale state change batch", "err", err)
+		return nil
+	}
+	p.chain.apply(stateChanges)
 	p.lastSeenBlock.Store(stateChanges.ChangeBatch[len(stateChanges.ChangeBatch)-1].BlockHeight)
 	if !p.started.Load() {
 		if err := p.fromDB(ctx, tx, coreTx); err != nil {
@@ -441,11 +584,20 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 		p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err != nil {
 		return err
 	}
+	if len(unwindTxs.txs) > 0 {
+		if removed := p.removeStaleReplacementsLocked(pendingBaseFee); removed > 0 {
+			staleReplacedCounter.Add(removed)
+			log.Debug("[txpool] removed stale replacements after unwind", "amount", removed)
+		}
+	}
 	p.pending.EnforceWorstInvariants()
 	p.baseFee.EnforceInvariants()
 	p.queued.EnforceInvariants()
-	promote(p.pending, p.baseFee, p.queued, pendingBaseFee, p.discardLocked)
+	promote(p.pending, p.baseFee, p.queued, pendingBaseFee, p.cfg.MinFeeCap, p.discardLocked)
 	p.pending.EnforceBestInvariants()
+	eligible, eligibleGas := p.countEligibleLocked(pendingBaseFee)
+	pendingEligibleCounter.Set(uint64(eligible))
+	pendingEligibleGas.Set(eligibleGas)
 	p.promoted = p.pending.appendAddedHashes(p.promoted[:0])
 	p.promoted = p.baseFee.appendAddedHashes(p.promoted)
 
@@ -453,12 +605,7 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 		log.Info("[txpool] Started")
 	}
 
-	if p.promoted.Len() > 0 {
-		select {
-		case p.newPendingTxs <- common.Copy(p.promoted):
-		default:
-		}
-	}
+	p.notifyPromotedLocked()
 
 	//log.Info("[txpool] new block", "number", p.lastSeenBlock.Load(), "pendngBaseFee", pendingBaseFee, "in", time.Since(t))
 	return nil
@@ -485,6 +632,7 @@ func (p *TxPool) processRemoteTxs(ctx context.Context) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	p.flushNotifyBacklogLocked() // newPendingTxs may have free space since last notification
 	l := len(p.unprocessedRemoteTxs.txs)
 	if l == 0 {
 		return nil
@@ -503,22 +651,18 @@ func (p *TxPool) processRemoteTxs(ctx context.Context) error {
 	p.pending.resetAddedHashes()
 	p.baseFee.resetAddedHashes()
 	if _, err := addTxs(p.lastSeenBlock.Load(), cacheView, p.senders, newTxs,
-		p.pendingBaseFee.Load(), p.blockGasLimit.Load(), p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err != nil {
+		p.pendingBaseFee.Load(), p.blockGasLimit.Load(), p.cfg.MinFeeCap, p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err != nil {
 		return err
 	}
 	p.promoted = p.pending.appendAddedHashes(p.promoted[:0])
 	p.promoted = p.baseFee.appendAddedHashes(p.promoted)
 
-	if p.promoted.Len() > 0 {
-		select {
-		case <-ctx.Done():
-			return nil
-		case p.newPendingTxs <- common.Copy(p.promoted):
-		default:
-		}
-	}
+	p.notifyPromotedLocked()
 
-	p.unprocessedRemoteTxs.Resize(0)
+	for _, txn := range p.unprocessedRemoteTxs.txs {
+		p.known.clear(string(txn.IdHash[:]), knownUnprocessed)
+	}
+	p.unprocessedRemoteTxs.ResetKeepCap()
 	p.unprocessedRemoteByHash = map[string]int{}
 
 	//log.Info("[txpool] on new txs", "amount", len(newPendingTxs.txs), "in", time.Since(t))
@@ -536,7 +680,11 @@ func (p *TxPool) getRlpLocked(tx kv.Tx, hash []byte) (rlpTxn []byte, sender []by
 	if v == nil {
 		return nil, nil, false, nil
 	}
-	return v[20:], v[:20], txn != nil && txn.subPool&IsLocal > 0, nil
+	sender, rlpTxn, _, err = decodePoolTx(v)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return rlpTxn, sender, txn != nil && txn.subPool&IsLocal > 0, nil
 }
 func (p *TxPool) GetRlp(tx kv.Tx, hash []byte) ([]byte, error) {
 	p.lock.RLock()
@@ -575,16 +723,10 @@ func (p *TxPool) AppendAllHashes(buf []byte) []byte {
 	buf = p.AppendRemoteHashes(buf)
 	return buf
 }
+
+// IdHashKnown - doesn't take pool's lock, because called for every hash announced by peers
 func (p *TxPool) IdHashKnown(tx kv.Tx, hash []byte) (bool, error) {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	if _, ok := p.discardReasonsLRU.Get(string(hash)); ok {
-		return true, nil
-	}
-	if _, ok := p.unprocessedRemoteByHash[string(hash)]; ok {
-		return true, nil
-	}
-	if _, ok := p.byHash[string(hash)]; ok {
+	if p.known.has(string(hash)) {
 		return true, nil
 	}
 	return tx.Has(kv.PoolTransaction, hash)
@@ -606,31 +748,301 @@ func (p *TxPool) Best(n uint16, txs *TxsRlp, tx kv.Tx) error {
 	txs.Resize(uint(min(uint64(n), uint64(len(p.pending.best.ms)))))
 
 	best := p.pending.best
-	for i, j := 0, 0; j < int(n) && i < len(best.ms); i++ {
-		if best.ms[i].Tx.gas >= p.blockGasLimit.Load() {
-			// Skip transactions with very large gas limit
+	j := 0
+	for i := 0; j < int(n) && i < len(best.ms); i++ {
+		if p.skipBestLocked(best.ms[i]) {
 			continue
 		}
-		rlpTx, sender, isLocal, err := p.getRlpLocked(tx, best.ms[i].Tx.IdHash[:])
+		ok, err := p.fillBestLocked(best.ms[i], j, txs, tx)
 		if err != nil {
 			return err
 		}
-		if len(rlpTx) == 0 {
+		if ok {
+			j++
+		}
+	}
+	txs.Resize(uint(j)) // skipped transactions must not leave empty entries
+	return nil
+}
+
+// BestIds - id hashes of transactions Best would return now, in the same order. Lets stream them by batches
+// with BestByIds without holding the lock
+func (p *TxPool) BestIds() [][32]byte {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	best := p.pending.best
+	ids := make([][32]byte, 0, len(best.ms))
+	for i := range best.ms {
+		if !p.skipBestLocked(best.ms[i]) {
+			ids = append(ids, best.ms[i].Tx.IdHash)
+		}
+	}
+	return ids
+}
+
+// BestByIds - like Best, but for given transactions, ones which left pending sub-pool since BestIds are skipped
+func (p *TxPool) BestByIds(ids [][32]byte, txs *TxsRlp, tx kv.Tx) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	txs.Resize(uint(len(ids)))
+	j := 0
+	for i := range ids {
+		mt, ok := p.byHash[string(ids[i][:])]
+		if !ok || mt.currentSubPool != PendingSubPool || p.skipBestLocked(mt) {
 			continue
 		}
-		txs.Txs[j] = rlpTx
-		copy(txs.Senders.At(j), sender)
-		txs.IsLocal[j] = isLocal
-		j++
+		ok, err := p.fillBestLocked(mt, j, txs, tx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			j++
+		}
+	}
+	txs.Resize(uint(j))
+	return nil
+}
+
+// BestAtBaseFee - like Best, but pending and base-fee sub-pools are classified against given baseFee instead of
+// base fee of the pending block: for building or simulation of blocks at alternative fee level. Pool is not changed
+func (p *TxPool) BestAtBaseFee(n uint16, baseFee uint64, txs *TxsRlp, tx kv.Tx) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	candidates := make([]*metaTx, 0, len(p.pending.best.ms)+len(p.baseFee.best.ms))
+	for _, ms := range [][]*metaTx{p.pending.best.ms, p.baseFee.best.ms} {
+		for _, mt := range ms {
+			if mt.subPool&BaseFeePoolBits == BaseFeePoolBits && mt.minFeeCap >= baseFee && !p.skipBestLocked(mt) {
+				candidates = append(candidates, mt)
+			}
+		}
 	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].betterAt(candidates[j], baseFee) })
+
+	txs.Resize(uint(min(uint64(n), uint64(len(candidates)))))
+	j := 0
+	for i := 0; j < int(n) && i < len(candidates); i++ {
+		ok, err := p.fillBestLocked(candidates[i], j, txs, tx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			j++
+		}
+	}
+	txs.Resize(uint(j))
 	return nil
 }
 
+// skipBestLocked - pending transactions which are not given for block building
+func (p *TxPool) skipBestLocked(mt *metaTx) bool {
+	if mt.Tx.gas >= p.blockGasLimit.Load() {
+		return true // Skip transactions with very large gas limit
+	}
+	return p.cfg.StrictNonceContinuity && mt.nonceDistance > 0
+}
+
+// fillBestLocked - puts transaction into j-th entry of txs, false if its rlp is not found
+func (p *TxPool) fillBestLocked(mt *metaTx, j int, txs *TxsRlp, tx kv.Tx) (bool, error) {
+	rlpTx, sender, isLocal, err := p.getRlpLocked(tx, mt.Tx.IdHash[:])
+	if err != nil {
+		return false, err
+	}
+	if len(rlpTx) == 0 {
+		return false, nil
+	}
+	txs.Txs[j] = rlpTx
+	copy(txs.Senders.At(j), sender)
+	txs.IsLocal[j] = isLocal
+	txs.FirstSeen[j] = mt.firstSeen
+	return true, nil
+}
+
+// RegisterAltMempool - adds external validation pipeline, alt-mempools are matched in order of registration
+func (p *TxPool) RegisterAltMempool(m AltMempool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.altMempools = append(p.altMempools, m)
+}
+
+// AltTag - tag given to the transaction by alt-mempool which claimed it, ok is false if transaction is unknown
+func (p *TxPool) AltTag(idHash []byte) (tag uint64, ok bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	mt, ok := p.byHash[string(idHash)]
+	if !ok {
+		return 0, false
+	}
+	return mt.Tx.altTag, true
+}
+
+// SetSenderStateOverride - must be called before pool starts, nil - state is used as is (default)
+func (p *TxPool) SetSenderStateOverride(o SenderStateOverride) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.senders.override = o
+}
+
 func (p *TxPool) CountContent() (int, int, int) {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 	return p.pending.Len(), p.baseFee.Len(), p.queued.Len()
 }
+
+// CountEligible - amount and total gas of pending transactions which clear given baseFee (together with all
+// preceding transactions of their senders) and fit into block gas limit. Doesn't touch rlp of transactions.
+// With StrictNonceContinuity only the next transaction of every sender is counted, like in Best
+func (p *TxPool) CountEligible(baseFee uint64) (count int, gas uint64) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.countEligibleLocked(baseFee)
+}
+
+// Limits - parameters of the pool which can be changed at runtime by SetLimits
+type Limits struct {
+	PendingSubPoolLimit int
+	BaseFeeSubPoolLimit int
+	QueuedSubPoolLimit  int
+	PriceBump           uint64
+}
+
+// SetLimits - changes non-zero limits and immediately enforces them: if some sub-pool became smaller than amount of
+// transactions in it - worst ones are discarded. Returns limits in effect after the change
+func (p *TxPool) SetLimits(limits Limits) Limits {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if limits.PendingSubPoolLimit > 0 {
+		p.cfg.PendingSubPoolLimit = limits.PendingSubPoolLimit
+		p.pending.limit = limits.PendingSubPoolLimit
+	}
+	if limits.BaseFeeSubPoolLimit > 0 {
+		p.cfg.BaseFeeSubPoolLimit = limits.BaseFeeSubPoolLimit
+		p.baseFee.limit = limits.BaseFeeSubPoolLimit
+	}
+	if limits.QueuedSubPoolLimit > 0 {
+		p.cfg.QueuedSubPoolLimit = limits.QueuedSubPoolLimit
+		p.queued.limit = limits.QueuedSubPoolLimit
+	}
+	if limits.PriceBump > 0 {
+		p.cfg.PriceBump = limits.PriceBump
+	}
+	promote(p.pending, p.baseFee, p.queued, p.pendingBaseFee.Load(), p.cfg.MinFeeCap, p.discardLocked)
+	p.pending.EnforceBestInvariants()
+	log.Info("[txpool] limits changed", "pending", p.cfg.PendingSubPoolLimit, "baseFee", p.cfg.BaseFeeSubPoolLimit,
+		"queued", p.cfg.QueuedSubPoolLimit, "priceBump", p.cfg.PriceBump)
+	return p.limitsLocked()
+}
+
+// SetDisallowedTxTypes - replaces Config.DisallowedTxTypes, e.g. to stop accepting some type of transactions
+// during incident response. Empty list allows all types again
+func (p *TxPool) SetDisallowedTxTypes(txTypes []int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.cfg.DisallowedTxTypes = append([]int(nil), txTypes...)
+	log.Info("[txpool] disallowed tx types changed", "types", fmt.Sprintf("%v", p.cfg.DisallowedTxTypes))
+}
+
+// SetNoPropagate - marks transactions (also ones not in pool yet) as not announced and not broadcast to peers,
+// they are still kept in pool and offered to block builder. Marks are written into tx and survive restarts,
+// in-memory change is reverted by undo if tx is not committed. noPropagate=false removes the marks
+func (p *TxPool) SetNoPropagate(tx kv.RwTx, undo *kv.UndoLog, hashes Hashes, noPropagate bool) error {
+	for i := 0; i < hashes.Len(); i++ {
+		var err error
+		if noPropagate {
+			err = tx.Put(kv.PoolNoPropagate, hashes.At(i), nil)
+		} else {
+			err = tx.Delete(kv.PoolNoPropagate, hashes.At(i), nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i := 0; i < hashes.Len(); i++ {
+		hash := string(hashes.At(i))
+		if _, was := p.noPropagate[hash]; was == noPropagate {
+			continue
+		}
+		if noPropagate {
+			p.noPropagate[hash] = struct{}{}
+		} else {
+			delete(p.noPropagate, hash)
+		}
+		undo.OnRollback(func() {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			if noPropagate {
+				delete(p.noPropagate, hash)
+			} else {
+				p.noPropagate[hash] = struct{}{}
+			}
+		})
+	}
+	log.Info("[txpool] no-propagate marks changed", "txs", hashes.Len(), "noPropagate", noPropagate, "total", len(p.noPropagate))
+	return nil
+}
+
+func (p *TxPool) IsNoPropagate(idHash []byte) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	_, ok := p.noPropagate[string(idHash)]
+	return ok
+}
+
+// dropNoPropagate - removes hashes marked by SetNoPropagate, in place
+func (p *TxPool) dropNoPropagate(hashes Hashes) Hashes {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if len(p.noPropagate) == 0 {
+		return hashes
+	}
+	j := 0
+	for i := 0; i < hashes.Len(); i++ {
+		if _, ok := p.noPropagate[string(hashes.At(i))]; ok {
+			continue
+		}
+		copy(hashes[j*length.Hash:], hashes.At(i))
+		j++
+	}
+	return hashes[:j*length.Hash]
+}
+
+func (p *TxPool) txTypeDisallowed(txType int) bool {
+	for _, t := range p.cfg.DisallowedTxTypes {
+		if t == txType {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *TxPool) limitsLocked() Limits {
+	return Limits{
+		PendingSubPoolLimit: p.cfg.PendingSubPoolLimit,
+		BaseFeeSubPoolLimit: p.cfg.BaseFeeSubPoolLimit,
+		QueuedSubPoolLimit:  p.cfg.QueuedSubPoolLimit,
+		PriceBump:           p.cfg.PriceBump,
+	}
+}
+
+func (p *TxPool) countEligibleLocked(baseFee uint64) (count int, gas uint64) {
+	blockGasLimit := p.blockGasLimit.Load()
+	for _, mt := range p.pending.best.ms {
+		if mt.minFeeCap < baseFee || mt.Tx.gas >= blockGasLimit {
+			continue
+		}
+		if p.cfg.StrictNonceContinuity && mt.nonceDistance > 0 {
+			continue
+		}
+		count++
+		gas += mt.Tx.gas
+	}
+	return count, gas
+}
+
 func (p *TxPool) AddRemoteTxs(_ context.Context, newTxs TxSlots) {
 	defer addRemoteTxsTimer.UpdateDuration(time.Now())
 	p.lock.Lock()
@@ -640,46 +1052,108 @@ func (p *TxPool) AddRemoteTxs(_ context.Context, newTxs TxSlots) {
 		if ok {
 			continue
 		}
+		p.unprocessedRemoteByHash[string(txn.IdHash[:])] = len(p.unprocessedRemoteTxs.txs)
+		p.known.set(string(txn.IdHash[:]), knownUnprocessed)
 		p.unprocessedRemoteTxs.Append(txn, newTxs.senders.At(i), false)
 	}
 }
 
+func (p *TxPool) isShanghai() bool {
+	return p.cfg.ShanghaiTime != nil && uint64(time.Now().Unix()) >= *p.cfg.ShanghaiTime
+}
+
+// validateAlt - passes transaction to the first alt-mempool which claims it, if any
+func (p *TxPool) validateAlt(txn *TxSlot, isLocal bool) DiscardReason {
+	if len(p.altMempools) == 0 {
+		return Success
+	}
+	sender := p.senders.senderID2Addr[txn.senderID]
+	for _, m := range p.altMempools {
+		if !m.Match(txn, sender) {
+			continue
+		}
+		reason, tag := m.Validate(txn, sender, isLocal)
+		if reason != Success {
+			if txn.logged() {
+				logEvent(EventValidate, txn, "reason", reason, "altMempool", fmt.Sprintf("%T", m))
+			}
+			return reason
+		}
+		txn.altTag = tag
+		return Success
+	}
+	return Success
+}
+
+// maxTxGas - max gas limit of acceptable transaction, 0 - no limit (also until block gas limit is known)
+func (p *TxPool) maxTxGas() uint64 {
+	if p.cfg.MaxTxGasFraction <= 0 {
+		return 0
+	}
+	return uint64(float64(p.blockGasLimit.Load()) * p.cfg.MaxTxGasFraction)
+}
+
 func (p *TxPool) validateTx(txn *TxSlot, isLocal bool, stateCache kvcache.CacheView) DiscardReason {
-	// Drop non-local transactions under our own minimal accepted gas price or tip
-	if !isLocal && txn.feeCap < p.cfg.MinFeeCap {
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: validateTx underpriced idHash=%x local=%t, feeCap=%d, cfg.MinFeeCap=%d", txn.IdHash, isLocal, txn.feeCap, p.cfg.MinFeeCap))
+	if p.txTypeDisallowed(txn.Type()) {
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", DisallowedTxType, "type", txn.Type())
+		}
+		return DisallowedTxType
+	}
+	if reason := p.validateAlt(txn, isLocal); reason != Success {
+		return reason
+	}
+	// Drop transactions under our own minimal accepted gas price or tip, local ones have own (usually lower) limit
+	minFeeCap := p.cfg.MinFeeCap
+	if isLocal {
+		minFeeCap = p.cfg.LocalMinFeeCap
+	}
+	isSystem := p.isSystemTx(txn)
+	if txn.feeCap < minFeeCap && !isSystem {
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", UnderPriced, "local", isLocal, "feeCap", txn.feeCap, "minFeeCap", minFeeCap)
 		}
 		return UnderPriced
 	}
-	gas, reason := CalcIntrinsicGas(uint64(txn.dataLen), uint64(txn.dataNonZeroLen), nil, txn.creation, true, true)
-	if txn.traced {
-		log.Info(fmt.Sprintf("TX TRACING: validateTx intrinsic gas idHash=%x gas=%d", txn.IdHash, gas))
+	isShanghai := p.isShanghai()
+	if isShanghai && txn.creation && txn.dataLen > fixedgas.MaxInitCodeSize {
+		return InitCodeTooLarge
 	}
+	gas, reason := CalcIntrinsicGas(uint64(txn.dataLen), uint64(txn.dataNonZeroLen), nil, txn.creation, true, true, isShanghai)
 	if reason != Success {
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: validateTx intrinsic gas calculated failed idHash=%x reason=%s", txn.IdHash, reason))
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", reason)
 		}
 		return reason
 	}
-	if gas > txn.gas {
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: validateTx intrinsic gas > txn.gas idHash=%x gas=%d, txn.gas=%d", txn.IdHash, gas, txn.gas))
+	if gas > txn.gas && !isSystem {
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", IntrinsicGas, "intrinsicGas", gas, "gas", txn.gas)
 		}
 		return IntrinsicGas
 	}
-	if uint64(p.all.count(txn.senderID)) > p.cfg.AccountSlots {
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: validateTx marked as spamming idHash=%x slots=%d, limit=%d", txn.IdHash, p.all.count(txn.senderID), p.cfg.AccountSlots))
+	if maxGas := p.maxTxGas(); maxGas > 0 && txn.gas > maxGas {
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", GasLimitTooHigh, "gas", txn.gas, "maxGas", maxGas)
+		}
+		return GasLimitTooHigh
+	}
+	if slots := uint64(p.all.count(txn.senderID)); slots > p.cfg.AccountSlots {
+		accountSlots := p.accountSlots(txn.senderID)
+		if slots > accountSlots {
+			if txn.logged() {
+				logEvent(EventValidate, txn, "reason", Spammer, "slots", slots, "accountSlots", accountSlots)
+			}
+			return Spammer
 		}
-		return Spammer
+		highVolumeSlotsCounter.Inc()
 	}
 
 	// check nonce and balance
 	senderNonce, senderBalance, _ := p.senders.info(stateCache, txn.senderID)
 	if senderNonce > txn.nonce {
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: validateTx nonce too low idHash=%x nonce in state=%d, txn.nonce=%d", txn.IdHash, senderNonce, txn.nonce))
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", NonceTooLow, "senderNonce", senderNonce, "nonce", txn.nonce)
 		}
 		return NonceTooLow
 	}
@@ -688,8 +1162,8 @@ func (p *TxPool) validateTx(txn *TxSlot, isLocal bool, stateCache kvcache.CacheV
 	total.Mul(total, uint256.NewInt(txn.tip))
 	total.Add(total, &txn.value)
 	if senderBalance.Cmp(total) < 0 {
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: validateTx insufficient funds idHash=%x balance in state=%d, txn.gas*txn.tip=%d", txn.IdHash, senderBalance, total))
+		if txn.logged() {
+			logEvent(EventValidate, txn, "reason", InsufficientFunds, "balance", senderBalance.String(), "required", total.String())
 		}
 		return InsufficientFunds
 	}
@@ -752,6 +1226,14 @@ func (p *TxPool) validateTxs(txs *TxSlots, stateCache kvcache.CacheView) (reason
 	return reasons, goodTxs, nil
 }
 
+// accountSlots - number of transaction slots of the sender, Config.HighVolumeSenders may have more than AccountSlots
+func (p *TxPool) accountSlots(senderID uint64) uint64 {
+	if slots, ok := p.cfg.HighVolumeSenders[string(p.senders.senderID2Addr[senderID])]; ok && slots > p.cfg.AccountSlots {
+		return slots
+	}
+	return p.cfg.AccountSlots
+}
+
 // punishSpammer by drop half of it's transactions with high nonce
 func (p *TxPool) punishSpammer(spammer uint64) {
 	count := p.all.count(spammer) / 2
@@ -814,7 +1296,7 @@ func (p *TxPool) AddLocalTxs(ctx context.Context, newTransactions TxSlots) ([]Di
 	p.pending.resetAddedHashes()
 	p.baseFee.resetAddedHashes()
 	if addReasons, err := addTxs(p.lastSeenBlock.Load(), cacheView, p.senders, newTxs,
-		p.pendingBaseFee.Load(), p.blockGasLimit.Load(), p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err == nil {
+		p.pendingBaseFee.Load(), p.blockGasLimit.Load(), p.cfg.MinFeeCap, p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err == nil {
 		for i, reason := range addReasons {
 			if reason != NotSet {
 				reasons[i] = reason
@@ -830,16 +1312,54 @@ func (p *TxPool) AddLocalTxs(ctx context.Context, newTransactions TxSlots) ([]Di
 	for i, reason := range reasons {
 		if reason == Success {
 			txn := newTxs.txs[i]
-			if txn.traced {
-				log.Info(fmt.Sprintf("TX TRACING: AddLocalTxs promotes idHash=%x, senderId=%d", txn.IdHash, txn.senderID))
-			}
 			p.promoted = append(p.promoted, txn.IdHash[:]...)
 		}
 	}
-	if p.promoted.Len() > 0 {
-		select {
-		case p.newPendingTxs <- common.Copy(p.promoted):
-		default:
+	p.notifyPromotedLocked()
+	return reasons, nil
+}
+
+// PreCheck - runs validation of AddLocalTxs (fee, nonce, balance, replacement of transaction with same nonce),
+// but doesn't add transactions to the pool. Lets wallets pre-flight transactions, Success means tx would be accepted
+func (p *TxPool) PreCheck(ctx context.Context, newTransactions TxSlots) ([]DiscardReason, error) {
+	coreTx, err := p.coreDB().BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer coreTx.Rollback()
+
+	cacheView, err := p.cache().View(ctx, coreTx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.Started() {
+		return nil, fmt.Errorf("pool not started yet")
+	}
+	if err = newTransactions.Valid(); err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// unknown senders get IDs only for the time of validation
+	defer p.senders.forgetSendersAfter(p.senders.senderID)
+	if err = p.senders.registerNewSenders(&newTransactions); err != nil {
+		return nil, err
+	}
+
+	reasons := make([]DiscardReason, len(newTransactions.txs))
+	for i, txn := range newTransactions.txs {
+		if reasons[i] = p.validateTx(txn, newTransactions.isLocal[i], cacheView); reasons[i] != Success {
+			continue
+		}
+		if _, ok := p.byHash[string(txn.IdHash[:])]; ok {
+			reasons[i] = DuplicateHash
+			continue
+		}
+		if found := p.all.get(txn.senderID, txn.nonce); found != nil && !p.canReplace(found, txn) {
+			reasons[i] = NotReplaced
 		}
 	}
 	return reasons, nil
@@ -857,7 +1377,7 @@ func (p *TxPool) cache() kvcache.Cache {
 	return p._stateCache
 }
 func addTxs(blockNum uint64, cacheView kvcache.CacheView, senders *sendersBatch,
-	newTxs TxSlots, pendingBaseFee, blockGasLimit uint64,
+	newTxs TxSlots, pendingBaseFee, blockGasLimit, minFeeCap uint64,
 	pending *PendingPool, baseFee, queued *SubPool,
 	byNonce *BySenderAndNonce, byHash map[string]*metaTx, add func(*metaTx) DiscardReason, discard func(*metaTx, DiscardReason)) ([]DiscardReason, error) {
 	protocolBaseFee := calcProtocolBaseFee(pendingBaseFee)
@@ -890,8 +1410,8 @@ func addTxs(blockNum uint64, cacheView kvcache.CacheView, senders *sendersBatch,
 			continue
 		}
 		discardReasons[i] = NotSet
-		if txn.traced {
-			log.Info(fmt.Sprintf("TX TRACING: schedule sendersWithChangedState idHash=%x senderId=%d", txn.IdHash, mt.Tx.senderID))
+		if txn.logged() {
+			logEvent(EventAdd, txn, "local", mt.subPool&IsLocal != 0)
 		}
 		sendersWithChangedState[mt.Tx.senderID] = struct{}{}
 	}
@@ -905,7 +1425,7 @@ func addTxs(blockNum uint64, cacheView kvcache.CacheView, senders *sendersBatch,
 			protocolBaseFee, blockGasLimit, pending, baseFee, queued, false, discard)
 	}
 
-	promote(pending, baseFee, queued, pendingBaseFee, discard)
+	promote(pending, baseFee, queued, pendingBaseFee, minFeeCap, discard)
 	pending.EnforceBestInvariants()
 
 	return discardReasons, nil
@@ -986,10 +1506,7 @@ func (p *TxPool) addLocked(mt *metaTx) DiscardReason {
 	// Insert to pending pool, if pool doesn't have txn with same Nonce and bigger Tip
 	found := p.all.get(mt.Tx.senderID, mt.Tx.nonce)
 	if found != nil {
-		tipThreshold := found.Tx.tip * (100 + p.cfg.PriceBump) / 100
-		feecapThreshold := found.Tx.feeCap * (100 + p.cfg.PriceBump) / 100
-		if mt.Tx.tip < tipThreshold || mt.Tx.feeCap < feecapThreshold {
-			// Both tip and feecap need to be larger than previously to replace the transaction
+		if !p.canReplace(found, mt.Tx) {
 			return NotReplaced
 		}
 
@@ -1004,10 +1521,16 @@ func (p *TxPool) addLocked(mt *metaTx) DiscardReason {
 			//already removed
 		}
 
+		if mt.Tx.logged() {
+			logEvent(EventReplace, mt.Tx, "replaced", fmt.Sprintf("%x", found.Tx.IdHash), "nonce", mt.Tx.nonce)
+		}
 		p.discardLocked(found, ReplacedByHigherTip)
 	}
 
+	mt.boost = p.priorityBoost(mt.Tx)
+	mt.system = p.isSystemTx(mt.Tx)
 	p.byHash[string(mt.Tx.IdHash[:])] = mt
+	p.known.set(string(mt.Tx.IdHash[:]), knownInPool)
 
 	if replaced := p.all.replaceOrInsert(mt); replaced != nil {
 		if ASSERT {
@@ -1023,13 +1546,111 @@ func (p *TxPool) addLocked(mt *metaTx) DiscardReason {
 	return NotSet
 }
 
+// priorityBoost - boost of transaction calling one of Config.PriorityContracts, 0 for others
+func (p *TxPool) priorityBoost(txn *TxSlot) uint8 {
+	if txn.creation || len(p.cfg.PriorityContracts) == 0 {
+		return 0
+	}
+	return p.cfg.PriorityContracts[string(txn.to[:])]
+}
+
+// isSystemTx - transaction of one of Config.SystemTxTypes sent by one of Config.SystemTxSenders
+func (p *TxPool) isSystemTx(txn *TxSlot) bool {
+	if len(p.cfg.SystemTxSenders) == 0 {
+		return false
+	}
+	if _, ok := p.cfg.SystemTxSenders[string(p.senders.senderID2Addr[txn.senderID])]; !ok {
+		return false
+	}
+	if len(p.cfg.SystemTxTypes) == 0 {
+		return txn.Type() == LegacyTxType
+	}
+	for _, t := range p.cfg.SystemTxTypes {
+		if t == txn.Type() {
+			return true
+		}
+	}
+	return false
+}
+
+// effectiveTip - tip which block proposer gets from transaction at given base fee
+func effectiveTip(txn *TxSlot, baseFee uint64) uint64 {
+	if txn.feeCap < baseFee {
+		return 0
+	}
+	return min(txn.feeCap-baseFee, txn.tip)
+}
+
+// removeStaleReplacementsLocked - after unwind, transaction returned from unwound block and the pool's transaction
+// which replaced it may both be in sub-pools. Keeps the transaction with the highest effective tip of every sender
+// and nonce, discards others. Returns amount of discarded transactions
+func (p *TxPool) removeStaleReplacementsLocked(pendingBaseFee uint64) int {
+	type senderNonce struct{ senderID, nonce uint64 }
+	best := map[senderNonce]*metaTx{}
+	var stale []*metaTx
+	var duplicates []senderNonce
+	check := func(mt *metaTx) {
+		key := senderNonce{mt.Tx.senderID, mt.Tx.nonce}
+		kept, ok := best[key]
+		if !ok {
+			best[key] = mt
+			return
+		}
+		tip, keptTip := effectiveTip(mt.Tx, pendingBaseFee), effectiveTip(kept.Tx, pendingBaseFee)
+		if tip > keptTip || tip == keptTip && mt.Tx.feeCap > kept.Tx.feeCap {
+			best[key] = mt
+			mt = kept
+		}
+		stale = append(stale, mt)
+		duplicates = append(duplicates, key)
+	}
+	for _, mt := range p.pending.best.ms {
+		check(mt)
+	}
+	for _, mt := range p.baseFee.best.ms {
+		check(mt)
+	}
+	for _, mt := range p.queued.best.ms {
+		check(mt)
+	}
+	for _, mt := range stale {
+		switch mt.currentSubPool {
+		case PendingSubPool:
+			p.pending.Remove(mt)
+		case BaseFeeSubPool:
+			p.baseFee.Remove(mt)
+		case QueuedSubPool:
+			p.queued.Remove(mt)
+		}
+		p.discardLocked(mt, ReplacedByHigherTip)
+	}
+	for _, key := range duplicates {
+		if p.all.get(key.senderID, key.nonce) == nil { // discarded one was indexed, kept one takes its place
+			p.all.replaceOrInsert(best[key])
+		}
+	}
+	return len(stale)
+}
+
+// canReplace - both tip and feecap need to be larger than of found transaction (by PriceBump percent) to replace it
+func (p *TxPool) canReplace(found *metaTx, txn *TxSlot) bool {
+	tipThreshold := found.Tx.tip * (100 + p.cfg.PriceBump) / 100
+	feecapThreshold := found.Tx.feeCap * (100 + p.cfg.PriceBump) / 100
+	return txn.tip >= tipThreshold && txn.feeCap >= feecapThreshold
+}
+
 // dropping transaction from all sub-structures and from db
 // Important: don't call it while iterating by all
 func (p *TxPool) discardLocked(mt *metaTx, reason DiscardReason) {
+	if mt.Tx.logged() {
+		logEvent(EventDiscard, mt.Tx, "reason", reason, "subPool", mt.currentSubPool)
+	}
 	delete(p.byHash, string(mt.Tx.IdHash[:]))
+	p.known.clear(string(mt.Tx.IdHash[:]), knownInPool)
 	p.deletedTxs = append(p.deletedTxs, mt)
 	p.all.delete(mt)
 	p.discardReasonsLRU.Add(string(mt.Tx.IdHash[:]), reason)
+	p.known.set(string(mt.Tx.IdHash[:]), knownDiscarded)
 }
 
 func (p *TxPool) NonceFromAddress(addr [20]byte) (nonce uint64, inPool bool) {
@@ -1069,9 +1690,6 @@ func removeMined(byNonce *BySenderAndNonce, minedTxs []*TxSlot, pending *Pending
 			if mt.Tx.nonce > nonce {
 				return false
 			}
-			if mt.Tx.traced {
-				log.Info(fmt.Sprintf("TX TRACING: removeMined idHash=%x senderId=%d, currentSubPool=%s", mt.Tx.IdHash, mt.Tx.senderID, mt.currentSubPool))
-			}
 			toDel = append(toDel, mt)
 			// del from sub-pool
 			switch mt.currentSubPool {
@@ -1107,13 +1725,7 @@ func onSenderStateChange(senderID uint64, senderNonce uint64, senderBalance uint
 	minTip := uint64(math.MaxUint64)
 	var toDel []*metaTx // can't delete items while iterate them
 	byNonce.ascend(senderID, func(mt *metaTx) bool {
-		if mt.Tx.traced {
-			log.Info(fmt.Sprintf("TX TRACING: onSenderStateChange loop iteration idHash=%x senderID=%d, senderNonce=%d, txn.nonce=%d, currentSubPool=%s", mt.Tx.IdHash, senderID, senderNonce, mt.Tx.nonce, mt.currentSubPool))
-		}
 		if senderNonce > mt.Tx.nonce {
-			if mt.Tx.traced {
-				log.Info(fmt.Sprintf("TX TRACING: removing due to low nonce for idHash=%x senderID=%d, senderNonce=%d, txn.nonce=%d, currentSubPool=%s", mt.Tx.IdHash, senderID, senderNonce, mt.Tx.nonce, mt.currentSubPool))
-			}
 			// del from sub-pool
 			switch mt.currentSubPool {
 			case PendingSubPool:
@@ -1128,7 +1740,11 @@ func onSenderStateChange(senderID uint64, senderNonce uint64, senderBalance uint
 			toDel = append(toDel, mt)
 			return true
 		}
-		minFeeCap = min(minFeeCap, mt.Tx.feeCap)
+		feeCap := mt.Tx.feeCap
+		if mt.system {
+			feeCap = math.MaxUint64 // fee-less system transaction is included at any base fee
+		}
+		minFeeCap = min(minFeeCap, feeCap)
 		mt.minFeeCap = minFeeCap
 		minTip = min(minTip, mt.Tx.tip)
 		mt.minTip = minTip
@@ -1186,8 +1802,8 @@ func onSenderStateChange(senderID uint64, senderNonce uint64, senderBalance uint
 			mt.subPool |= NotTooMuchGas
 		}
 
-		if mt.Tx.traced {
-			log.Info(fmt.Sprintf("TX TRACING: onSenderStateChange loop iteration idHash=%x senderId=%d subPool=%b", mt.Tx.IdHash, mt.Tx.senderID, mt.subPool))
+		if mt.Tx.logged() {
+			logEvent(EventValidate, mt.Tx, "subPool", fmt.Sprintf("%b", mt.subPool), "senderNonce", senderNonce, "nonce", mt.Tx.nonce, "currentSubPool", mt.currentSubPool)
 		}
 
 		// 5. Local transaction. Set to 1 if transaction is local.
@@ -1210,15 +1826,21 @@ func onSenderStateChange(senderID uint64, senderNonce uint64, senderBalance uint
 	}
 }
 
+// belowFeeFloor - local transaction under MinFeeCap, which can't be included into pending block: it waits in queued sub pool
+// until baseFee drops, instead of base fee sub pool, so it's not announced to peers. Remote ones never pass validation
+func belowFeeFloor(mt *metaTx, pendingBaseFee, minFeeCap uint64) bool {
+	return mt.minFeeCap < minFeeCap && mt.minFeeCap < pendingBaseFee
+}
+
 // promote reasserts invariants of the subpool and returns the list of transactions that ended up
 // being promoted to the pending or basefee pool, for re-broadcasting
-func promote(pending *PendingPool, baseFee, queued *SubPool, pendingBaseFee uint64, discard func(*metaTx, DiscardReason)) {
+func promote(pending *PendingPool, baseFee, queued *SubPool, pendingBaseFee, minFeeCap uint64, discard func(*metaTx, DiscardReason)) {
 	// Demote worst transactions that do not qualify for pending sub pool anymore, to other sub pools, or discard
 	for worst := pending.Worst(); pending.Len() > 0 && (worst.subPool < BaseFeePoolBits || worst.minFeeCap < pendingBaseFee); worst = pending.Worst() {
-		if worst.subPool >= BaseFeePoolBits {
-			baseFee.Add(pending.PopWorst())
+		if worst.subPool >= BaseFeePoolBits && !belowFeeFloor(worst, pendingBaseFee, minFeeCap) {
+			baseFee.Add(moved(pending.PopWorst(), PendingSubPool, BaseFeeSubPool))
 		} else if worst.subPool >= QueuedPoolBits {
-			queued.Add(pending.PopWorst())
+			queued.Add(moved(pending.PopWorst(), PendingSubPool, QueuedSubPool))
 		} else {
 			discard(pending.PopWorst(), FeeTooLow)
 		}
@@ -1226,26 +1848,32 @@ func promote(pending *PendingPool, baseFee, queued *SubPool, pendingBaseFee uint
 
 	// Promote best transactions from base fee pool to pending pool while they qualify
 	for best := baseFee.Best(); baseFee.Len() > 0 && best.subPool >= BaseFeePoolBits && best.minFeeCap >= pendingBaseFee; best = baseFee.Best() {
-		pending.Add(baseFee.PopBest())
+		pending.Add(moved(baseFee.PopBest(), BaseFeeSubPool, PendingSubPool))
 	}
 
 	// Demote worst transactions that do not qualify for base fee pool anymore, to queued sub pool, or discard
 	for worst := baseFee.Worst(); baseFee.Len() > 0 && worst.subPool < BaseFeePoolBits; worst = baseFee.Worst() {
 		if worst.subPool >= QueuedPoolBits {
-			queued.Add(baseFee.PopWorst())
+			queued.Add(moved(baseFee.PopWorst(), BaseFeeSubPool, QueuedSubPool))
 		} else {
 			discard(baseFee.PopWorst(), FeeTooLow)
 		}
 	}
 
 	// Promote best transactions from the queued pool to either pending or base fee pool, while they qualify
+	var belowFloor []*metaTx
 	for best := queued.Best(); queued.Len() > 0 && best.subPool >= BaseFeePoolBits; best = queued.Best() {
 		if best.minFeeCap >= pendingBaseFee {
-			pending.Add(queued.PopBest())
+			pending.Add(moved(queued.PopBest(), QueuedSubPool, PendingSubPool))
+		} else if belowFeeFloor(best, pendingBaseFee, minFeeCap) {
+			belowFloor = append(belowFloor, queued.PopBest()) // stays in queued, put back below
 		} else {
-			baseFee.Add(queued.PopBest())
+			baseFee.Add(moved(queued.PopBest(), QueuedSubPool, BaseFeeSubPool))
 		}
 	}
+	for _, mt := range belowFloor {
+		queued.Add(mt)
+	}
 
 	// Discard worst transactions from the queued sub pool if they do not qualify
 	for worst := queued.Worst(); queued.Len() > 0 && worst.subPool < QueuedPoolBits; worst = queued.Worst() {
@@ -1284,6 +1912,7 @@ func MainLoop(ctx context.Context, db kv.RwDB, coreDB kv.RoDB, p *TxPool, newTxs
 	defer commitEvery.Stop()
 	logEvery := time.NewTicker(p.cfg.LogEvery)
 	defer logEvery.Stop()
+	promotedBatchLimit := p.cfg.PromotedBatchLimit
 
 	for {
 		select {
@@ -1318,7 +1947,7 @@ func MainLoop(ctx context.Context, db kv.RwDB, coreDB kv.RoDB, p *TxPool, newTxs
 			}
 		case h := <-newTxs:
 			go func() {
-				for i := 0; i < 16; i++ { // drain more events from channel, then merge and dedup them
+				for i := 0; i < 16 && (promotedBatchLimit <= 0 || h.Len() < promotedBatchLimit); i++ { // drain more events from channel, then merge and dedup them
 					select {
 					case a := <-newTxs:
 						h = append(h, a...)
@@ -1355,6 +1984,9 @@ func MainLoop(ctx context.Context, db kv.RwDB, coreDB kv.RoDB, p *TxPool, newTxs
 
 						// Empty rlp can happen if a transaction we want to broadcase has just been mined, for example
 						slotsRlp = append(slotsRlp, slotRlp)
+						if p.IsNoPropagate(hash) {
+							continue // local subscribers get it, peers don't
+						}
 						if p.IsLocal(hash) {
 							localTxHashes = append(localTxHashes, hash...)
 							localTxRlps = append(localTxRlps, slotRlp)
@@ -1389,7 +2021,7 @@ func MainLoop(ctx context.Context, db kv.RwDB, coreDB kv.RoDB, p *TxPool, newTxs
 			}
 			t := time.Now()
 			var hashes Hashes
-			hashes = p.AppendAllHashes(hashes[:0])
+			hashes = p.dropNoPropagate(p.AppendAllHashes(hashes[:0]))
 			go send.PropagatePooledTxsToPeersList(newPeers, hashes)
 			propagateToNewPeerTimer.UpdateDuration(t)
 		}
@@ -1401,8 +2033,8 @@ func (p *TxPool) flush(db kv.RwDB) (written uint64, err error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 	//it's important that write db tx is done inside lock, to make last writes visible for all read operations
-	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
-		err = p.flushLocked(tx)
+	if err := kv.UpdateWithUndo(context.Background(), db, func(tx kv.RwTx, undo *kv.UndoLog) error {
+		err = p.flushLocked(tx, undo)
 		if err != nil {
 			return err
 		}
@@ -1414,9 +2046,69 @@ func (p *TxPool) flush(db kv.RwDB) (written uint64, err error) {
 	}); err != nil {
 		return 0, err
 	}
+	p.flushStats.Flushes++
+	p.flushStats.WrittenBytes += written
 	return written, nil
 }
-func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
+
+// FlushStats - what flushes of in-memory changes wrote to pool.db. Write amplification is
+// WrittenBytes (dirty pages) relative to WrittenTxBytes (new PoolTransaction values)
+type FlushStats struct {
+	Flushes           uint64
+	WrittenBytes      uint64
+	WrittenTxBytes    uint64
+	WrittenTxRlpBytes uint64 // rlp of written transactions, before compression
+}
+
+// DbTableStats - amount of records and size in bytes of pool.db table
+type DbTableStats struct {
+	Table string
+	Rows  uint64
+	Size  uint64
+}
+
+type DbStats struct {
+	Tables []DbTableStats
+	Size   uint64 // sum of sizes of Tables
+	Flush  FlushStats
+}
+
+// dbStatsTables - pool.db tables reported by DbStats
+var dbStatsTables = []string{kv.PoolTransaction, kv.RecentLocalTransaction, kv.PoolInfo}
+
+// DbStats - size of pool.db tables and write amplification of flushes since start, for monitoring
+func (p *TxPool) DbStats(tx kv.Tx) (DbStats, error) {
+	var stats DbStats
+	for _, table := range dbStatsTables {
+		c, err := tx.Cursor(table)
+		if err != nil {
+			return stats, err
+		}
+		rows, err := c.Count()
+		c.Close()
+		if err != nil {
+			return stats, fmt.Errorf("counting %s: %w", table, err)
+		}
+		size, err := tx.BucketSize(table)
+		if err != nil {
+			return stats, fmt.Errorf("size of %s: %w", table, err)
+		}
+		stats.Tables = append(stats.Tables, DbTableStats{Table: table, Rows: rows, Size: size})
+		stats.Size += size
+	}
+	p.lock.RLock()
+	stats.Flush = p.flushStats
+	p.lock.RUnlock()
+	return stats, nil
+}
+
+// flushLocked - in-memory changes are registered in undo, to be reverted if tx is not committed
+func (p *TxPool) flushLocked(tx kv.RwTx, undo *kv.UndoLog) (err error) {
+	if undo != nil {
+		deletedTxs := append([]*metaTx(nil), p.deletedTxs...)
+		flushStats := p.flushStats
+		undo.OnRollback(func() { p.deletedTxs, p.flushStats = deletedTxs, flushStats })
+	}
 	for i, mt := range p.deletedTxs {
 		id := mt.Tx.senderID
 		idHash := mt.Tx.IdHash[:]
@@ -1425,6 +2117,10 @@ func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
 			if ok {
 				delete(p.senders.senderID2Addr, id)
 				delete(p.senders.senderIDs, string(addr))
+				undo.OnRollback(func() {
+					p.senders.senderID2Addr[id] = addr
+					p.senders.senderIDs[string(addr)] = id
+				})
 			}
 		}
 		//fmt.Printf("del:%d,%d,%d\n", mt.Tx.senderID, mt.Tx.nonce, mt.Tx.tip)
@@ -1457,14 +2153,15 @@ func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
 		if metaTx.Tx.rlp == nil {
 			continue
 		}
-		v = common.EnsureEnoughSize(v, 20+len(metaTx.Tx.rlp))
+		v = v[:0]
 		for addr, id := range p.senders.senderIDs { // no inverted index - tradeoff flush speed for memory usage
 			if id == metaTx.Tx.senderID {
-				copy(v[:20], addr)
+				v = append(v, addr...)
 				break
 			}
 		}
-		copy(v[20:], metaTx.Tx.rlp)
+		v = appendPoolTxFirstSeen(v, metaTx.firstSeen)
+		v = appendPoolTxRlp(v, metaTx.Tx.rlp, p.cfg.CompressTxRlp)
 
 		has, err := tx.Has(kv.PoolTransaction, []byte(txHash))
 		if err != nil {
@@ -1474,10 +2171,28 @@ func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
 			if err := tx.Put(kv.PoolTransaction, []byte(txHash), v); err != nil {
 				return err
 			}
+			writeToDbTxRlpBytes.Add(len(metaTx.Tx.rlp))
+			writeToDbTxBytes.Add(len(v))
+			p.flushStats.WrittenTxRlpBytes += uint64(len(metaTx.Tx.rlp))
+			p.flushStats.WrittenTxBytes += uint64(len(v))
 		}
+		slot, rlp := metaTx.Tx, metaTx.Tx.rlp
+		undo.OnRollback(func() { slot.rlp = rlp })
 		metaTx.Tx.rlp = nil
 	}
 
+	// remote txs received since last processRemoteTxs would be lost on shutdown, fromDB gives them back
+	if err := tx.ClearBucket(kv.PoolUnprocessedRemote); err != nil {
+		return err
+	}
+	for i, txn := range p.unprocessedRemoteTxs.txs {
+		binary.BigEndian.PutUint64(encID, uint64(i))
+		v = appendPoolTxRlp(append(v[:0], p.unprocessedRemoteTxs.senders.At(i)...), txn.rlp, p.cfg.CompressTxRlp)
+		if err := tx.Append(kv.PoolUnprocessedRemote, encID, v); err != nil {
+			return err
+		}
+	}
+
 	binary.BigEndian.PutUint64(encID, p.pendingBaseFee.Load())
 	if err := tx.Put(kv.PoolInfo, PoolPendingBaseFeeKey, encID); err != nil {
 		return err
@@ -1488,7 +2203,7 @@ func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
 
 	// clean - in-memory data structure as later as possible - because if during this Tx will happen error,
 	// DB will stay consitant but some in-memory structures may be alread cleaned, and retry will not work
-	// failed write transaction must not create side-effects
+	// failed write transaction must not create side-effects - undo restores them if commit fails
 	p.deletedTxs = p.deletedTxs[:0]
 	return nil
 }
@@ -1517,13 +2232,21 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 	txs := TxSlots{}
 	parseCtx := NewTxParseContext(p.chainID)
 	parseCtx.WithSender(false)
+	parseCtx.WithBorrow(true) // rlp is not used after cursor moves, see below
 
 	i := 0
+	firstSeen := map[string]uint64{}
 	if err := tx.ForEach(kv.PoolTransaction, nil, func(k, v []byte) error {
-		addr, txRlp := v[:20], v[20:]
+		addr, txRlp, txFirstSeen, err := decodePoolTx(v)
+		if err != nil {
+			return err
+		}
+		if txFirstSeen > 0 {
+			firstSeen[string(k)] = txFirstSeen
+		}
 		txn := &TxSlot{}
 
-		_, err := parseCtx.ParseTransaction(txRlp, 0, txn, nil, false /* hasEnvelope */)
+		_, err = parseCtx.ParseTransaction(txRlp, 0, txn, nil, false /* hasEnvelope */)
 		if err != nil {
 			return fmt.Errorf("err: %w, rlp: %x", err, txRlp)
 		}
@@ -1562,13 +2285,54 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 		return err
 	}
 	if _, err := addTxs(p.lastSeenBlock.Load(), cacheView, p.senders, txs,
-		pendingBaseFee, math.MaxUint64 /* blockGasLimit */, p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err != nil {
+		pendingBaseFee, math.MaxUint64 /* blockGasLimit */, p.cfg.MinFeeCap, p.pending, p.baseFee, p.queued, p.all, p.byHash, p.addLocked, p.discardLocked); err != nil {
+		return err
+	}
+	for idHash, ts := range firstSeen {
+		if mt, ok := p.byHash[idHash]; ok {
+			mt.firstSeen = ts
+		}
+	}
+	if err := p.unprocessedRemoteFromDB(tx); err != nil {
+		return err
+	}
+	if err := tx.ForEach(kv.PoolNoPropagate, nil, func(k, _ []byte) error {
+		p.noPropagate[string(k)] = struct{}{}
+		return nil
+	}); err != nil {
 		return err
 	}
 	p.pendingBaseFee.Store(pendingBaseFee)
 
 	return nil
 }
+
+// unprocessedRemoteFromDB - remote txs which were not processed before shutdown, they go to the next processRemoteTxs
+func (p *TxPool) unprocessedRemoteFromDB(tx kv.Tx) error {
+	parseCtx := NewTxParseContext(p.chainID)
+	parseCtx.WithSender(false) // sender is stored
+	return tx.ForEach(kv.PoolUnprocessedRemote, nil, func(k, v []byte) error {
+		addr, txRlp, _, err := decodePoolTx(v)
+		if err != nil {
+			return err
+		}
+		txn := &TxSlot{}
+		if _, err = parseCtx.ParseTransaction(txRlp, 0, txn, nil, false /* hasEnvelope */); err != nil {
+			return fmt.Errorf("err: %w, rlp: %x", err, txRlp)
+		}
+		if _, ok := p.byHash[string(txn.IdHash[:])]; ok {
+			return nil
+		}
+		if _, ok := p.unprocessedRemoteByHash[string(txn.IdHash[:])]; ok {
+			return nil
+		}
+		p.unprocessedRemoteByHash[string(txn.IdHash[:])] = len(p.unprocessedRemoteTxs.txs)
+		p.known.set(string(txn.IdHash[:]), knownUnprocessed)
+		p.unprocessedRemoteTxs.Append(txn, addr, false)
+		return nil
+	})
+}
+
 func LastSeenBlock(tx kv.Getter) (uint64, error) {
 	v, err := tx.GetOne(kv.PoolInfo, PoolLastSeenBlockKey)
 	if err != nil {
@@ -1602,6 +2366,21 @@ func ChainConfig(tx kv.Getter) (*chain.Config, error) {
 	}
 	return &config, nil
 }
+// SystemTxSendersFromChain - Config.SystemTxSenders of bor-style chain, nil for other chains
+func SystemTxSendersFromChain(cc *chain.Config) (map[string]struct{}, error) {
+	if cc == nil || cc.Bor == nil {
+		return nil, nil
+	}
+	senders := make(map[string]struct{}, len(cc.Bor.SystemTxSenders))
+	for _, s := range cc.Bor.SystemTxSenders {
+		addr, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+		if err != nil || len(addr) != 20 {
+			return nil, fmt.Errorf("invalid system tx sender %q in chain config", s)
+		}
+		senders[string(addr)] = struct{}{}
+	}
+	return senders, nil
+}
 func PutChainConfig(tx kv.Putter, cc *chain.Config, buf []byte) error {
 	wr := bytes.NewBuffer(buf)
 	if err := json.NewEncoder(wr).Encode(cc); err != nil {
@@ -1676,7 +2455,7 @@ func (p *TxPool) logStats() {
 }
 
 //Deprecated need switch to streaming-like
-func (p *TxPool) deprecatedForEach(_ context.Context, f func(rlp, sender []byte, t SubPoolType), tx kv.Tx) {
+func (p *TxPool) deprecatedForEach(_ context.Context, f func(rlp, sender []byte, t SubPoolType, firstSeen uint64), tx kv.Tx) {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 	p.all.ascendAll(func(mt *metaTx) bool {
@@ -1692,17 +2471,98 @@ func (p *TxPool) deprecatedForEach(_ context.Context, f func(rlp, sender []byte,
 				log.Warn("[txpool] foreach: tx not found in db")
 				return true
 			}
-			slotRlp = v[20:]
+			if _, slotRlp, _, err = decodePoolTx(v); err != nil {
+				log.Warn("[txpool] foreach: decode tx from db", "err", err)
+				return true
+			}
 		}
 		if sender, found := p.senders.senderID2Addr[slot.senderID]; found {
-			f(slotRlp, sender, mt.currentSubPool)
+			f(slotRlp, sender, mt.currentSubPool, mt.firstSeen)
 		}
 		return true
 	})
 }
 
+// TxInfo - transaction of the pool without rlp, see PagedAll
+type TxInfo struct {
+	IdHash    [32]byte
+	Sender    [20]byte
+	Nonce     uint64
+	Tip       uint64
+	FeeCap    uint64
+	Gas       uint64
+	FirstSeen uint64
+	IsLocal   bool
+}
+
+// pagedAllCursorLen - cursor of PagedAll is senderID and nonce of the first transaction of the next page
+const pagedAllCursorLen = 16
+
+// PagedAll - at most limit transactions of sub-pool t, starting from cursor (empty for the first page), and cursor
+// of the next page (nil after the last one) together with current size of the sub-pool. Transactions are ordered by
+// sender and nonce, not by priority - so pages don't overlap when the pool changes between calls.
+// Cursor is meaningful only for the same TxPool instance
+func (p *TxPool) PagedAll(t SubPoolType, cursor []byte, limit int) (txs []TxInfo, next []byte, total int, err error) {
+	if limit <= 0 {
+		return nil, nil, 0, fmt.Errorf("limit must be positive: %d", limit)
+	}
+	var fromSenderID, fromNonce uint64
+	switch len(cursor) {
+	case 0:
+	case pagedAllCursorLen:
+		fromSenderID, fromNonce = binary.BigEndian.Uint64(cursor), binary.BigEndian.Uint64(cursor[8:])
+	default:
+		return nil, nil, 0, fmt.Errorf("invalid cursor length: %d", len(cursor))
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	switch t {
+	case PendingSubPool:
+		total = p.pending.Len()
+	case BaseFeeSubPool:
+		total = p.baseFee.Len()
+	case QueuedSubPool:
+		total = p.queued.Len()
+	default:
+		return nil, nil, 0, fmt.Errorf("unknown sub-pool: %d", t)
+	}
+	if total < limit {
+		txs = make([]TxInfo, 0, total)
+	} else {
+		txs = make([]TxInfo, 0, limit)
+	}
+	p.all.ascendFrom(fromSenderID, fromNonce, func(mt *metaTx) bool {
+		if mt.currentSubPool != t {
+			return true
+		}
+		if len(txs) == limit {
+			next = make([]byte, pagedAllCursorLen)
+			binary.BigEndian.PutUint64(next, mt.Tx.senderID)
+			binary.BigEndian.PutUint64(next[8:], mt.Tx.nonce)
+			return false
+		}
+		sender, found := p.senders.senderID2Addr[mt.Tx.senderID]
+		if !found {
+			return true
+		}
+		info := TxInfo{
+			IdHash:    mt.Tx.IdHash,
+			Nonce:     mt.Tx.nonce,
+			Tip:       mt.Tx.tip,
+			FeeCap:    mt.Tx.feeCap,
+			Gas:       mt.Tx.gas,
+			FirstSeen: mt.firstSeen,
+			IsLocal:   mt.subPool&IsLocal != 0,
+		}
+		copy(info.Sender[:], sender)
+		txs = append(txs, info)
+		return true
+	})
+	return txs, next, total, nil
+}
+
 // CalcIntrinsicGas computes the 'intrinsic gas' for a message with the given data.
-func CalcIntrinsicGas(dataLen, dataNonZeroLen uint64, accessList AccessList, isContractCreation bool, isHomestead, isEIP2028 bool) (uint64, DiscardReason) {
+func CalcIntrinsicGas(dataLen, dataNonZeroLen uint64, accessList AccessList, isContractCreation bool, isHomestead, isEIP2028, isEIP3860 bool) (uint64, DiscardReason) {
 	// Set the starting gas for the raw transaction
 	var gas uint64
 	if isContractCreation && isHomestead {
@@ -1729,6 +2589,14 @@ func CalcIntrinsicGas(dataLen, dataNonZeroLen uint64, accessList AccessList, isC
 			return 0, GasUintOverflow
 		}
 		gas += z * fixedgas.TxDataZeroGas
+
+		if isContractCreation && isEIP3860 {
+			initcodeGas := fixedgas.CalcInitcodeGas(dataLen)
+			if math.MaxUint64-gas < initcodeGas {
+				return 0, GasUintOverflow
+			}
+			gas += initcodeGas
+		}
 	}
 	if accessList != nil {
 		gas += uint64(len(accessList)) * fixedgas.TxAccessListAddressGas
@@ -1780,6 +2648,7 @@ type sendersBatch struct {
 	senderIDs     map[string]uint64
 	senderID2Addr map[uint64][]byte
 	tracedSenders map[string]struct{}
+	override      SenderStateOverride
 }
 
 func newSendersCache(tracedSenders map[string]struct{}) *sendersBatch {
@@ -1800,7 +2669,7 @@ func (sc *sendersBatch) getOrCreateID(addr []byte) (uint64, bool) {
 		sc.senderIDs[string(copyAddr)] = id
 		sc.senderID2Addr[id] = copyAddr
 		if traced {
-			log.Info(fmt.Sprintf("TX TRACING: allocated senderID %d to sender %x", id, addr))
+			log.Info("[txpool] allocated senderID", "senderID", id, "sender", fmt.Sprintf("%x", addr))
 		}
 	}
 	return id, traced
@@ -1815,12 +2684,13 @@ func (sc *sendersBatch) info(cacheView kvcache.CacheView, id uint64) (nonce uint
 		return 0, emptySender.balance, err
 	}
 	if len(encoded) == 0 {
-		return emptySender.nonce, emptySender.balance, nil
-	}
-	nonce, balance, err = DecodeSender(encoded)
-	if err != nil {
+		nonce, balance = emptySender.nonce, emptySender.balance
+	} else if nonce, balance, err = DecodeSender(encoded); err != nil {
 		return 0, emptySender.balance, err
 	}
+	if sc.override != nil {
+		nonce, balance = sc.override.OverrideSenderState(addr, nonce, balance)
+	}
 	return nonce, balance, nil
 }
 
@@ -1830,6 +2700,14 @@ func (sc *sendersBatch) registerNewSenders(newTxs *TxSlots) (err error) {
 	}
 	return nil
 }
+// forgetSendersAfter - removes senders registered after given senderID, to undo registerNewSenders
+func (sc *sendersBatch) forgetSendersAfter(senderID uint64) {
+	for id := senderID + 1; id <= sc.senderID; id++ {
+		delete(sc.senderIDs, string(sc.senderID2Addr[id]))
+		delete(sc.senderID2Addr, id)
+	}
+	sc.senderID = senderID
+}
 func (sc *sendersBatch) onNewBlock(stateChanges *remote.StateChangeBatch, unwindTxs, minedTxs TxSlots) error {
 	for _, diff := range stateChanges.ChangeBatch {
 		for _, change := range diff.Changes { // merge state changes
@@ -1894,6 +2772,15 @@ func (b *BySenderAndNonce) ascend(senderID uint64, f func(*metaTx) bool) {
 		return f(mt)
 	})
 }
+// ascendFrom - all transactions starting from (senderID, nonce), ordered by sender and nonce
+func (b *BySenderAndNonce) ascendFrom(senderID, txNonce uint64, f func(*metaTx) bool) {
+	s := b.search
+	s.metaTx.Tx.senderID = senderID
+	s.metaTx.Tx.nonce = txNonce
+	b.tree.AscendGreaterOrEqual(s, func(i btree.Item) bool {
+		return f(i.(sortByNonce).metaTx)
+	})
+}
 func (b *BySenderAndNonce) descend(senderID uint64, f func(*metaTx) bool) {
 	s := b.search
 	s.metaTx.Tx.senderID = senderID
@@ -1932,7 +2819,11 @@ func (b *BySenderAndNonce) has(mt *metaTx) bool {
 	found := b.tree.Get(sortByNonce{mt})
 	return found != nil
 }
+// delete - removes mt, but not another transaction of the same sender and nonce (e.g. the one which replaced mt)
 func (b *BySenderAndNonce) delete(mt *metaTx) {
+	if b.get(mt.Tx.senderID, mt.Tx.nonce) != mt {
+		return
+	}
 	if b.tree.Delete(sortByNonce{mt}) != nil {
 		senderID := mt.Tx.senderID
 		count := b.senderIDTxnCount[senderID]
@@ -2040,9 +2931,6 @@ func (p *PendingPool) Add(i *metaTx) {
 	if p.adding {
 		p.added = append(p.added, i.Tx.IdHash[:]...)
 	}
-	if i.Tx.traced {
-		log.Info(fmt.Sprintf("TX TRACING: moved to subpool %s, IdHash=%x, sender=%d", p.t, i.Tx.IdHash, i.Tx.senderID))
-	}
 	i.currentSubPool = p.t
 	heap.Push(p.worst, i)
 	p.best.UnsafeAdd(i)
@@ -2110,9 +2998,6 @@ func (p *SubPool) Add(i *metaTx) {
 	if p.adding {
 		p.added = append(p.added, i.Tx.IdHash[:]...)
 	}
-	if i.Tx.traced {
-		log.Info(fmt.Sprintf("TX TRACING: moved to subpool %s, IdHash=%x, sender=%d", p.t, i.Tx.IdHash, i.Tx.senderID))
-	}
 	i.currentSubPool = p.t
 	heap.Push(p.best, i)
 	heap.Push(p.worst, i)
@@ -2155,18 +3040,14 @@ func (mt *metaTx) better(than *metaTx, pendingBaseFee uint64) bool {
 	if subPool != thanSubPool {
 		return subPool > thanSubPool
 	}
+	if mt.boost != than.boost {
+		return mt.boost > than.boost
+	}
 
 	switch mt.currentSubPool {
 	case PendingSubPool:
-		var effectiveTip, thanEffectiveTip uint64
-		if pendingBaseFee <= mt.minFeeCap {
-			effectiveTip = min(mt.minFeeCap-pendingBaseFee, mt.minTip)
-		}
-		if pendingBaseFee <= than.minFeeCap {
-			thanEffectiveTip = min(than.minFeeCap-pendingBaseFee, than.minTip)
-		}
-		if effectiveTip != thanEffectiveTip {
-			return effectiveTip > thanEffectiveTip
+		if tip, thanTip := mt.effectiveMinTip(pendingBaseFee), than.effectiveMinTip(pendingBaseFee); tip != thanTip {
+			return tip > thanTip
 		}
 	case BaseFeeSubPool:
 		if mt.minFeeCap != than.minFeeCap {
@@ -2183,6 +3064,28 @@ func (mt *metaTx) better(than *metaTx, pendingBaseFee uint64) bool {
 	return mt.timestamp < than.timestamp
 }
 
+// effectiveMinTip - tip at given base fee, guaranteed by this transaction and all previous ones of the sender
+func (mt *metaTx) effectiveMinTip(baseFee uint64) uint64 {
+	if baseFee > mt.minFeeCap {
+		return 0
+	}
+	return min(mt.minFeeCap-baseFee, mt.minTip)
+}
+
+// betterAt - order of pending sub-pool as if both transactions were pending at given base fee
+func (mt *metaTx) betterAt(than *metaTx, baseFee uint64) bool {
+	if mt.subPool != than.subPool {
+		return mt.subPool > than.subPool
+	}
+	if mt.boost != than.boost {
+		return mt.boost > than.boost
+	}
+	if tip, thanTip := mt.effectiveMinTip(baseFee), than.effectiveMinTip(baseFee); tip != thanTip {
+		return tip > thanTip
+	}
+	return mt.timestamp < than.timestamp
+}
+
 func (mt *metaTx) worse(than *metaTx, pendingBaseFee uint64) bool {
 	subPool := mt.subPool
 	thanSubPool := than.subPool
@@ -2195,6 +3098,9 @@ func (mt *metaTx) worse(than *metaTx, pendingBaseFee uint64) bool {
 	if subPool != thanSubPool {
 		return subPool < thanSubPool
 	}
+	if mt.boost != than.boost {
+		return mt.boost < than.boost
+	}
 
 	switch mt.currentSubPool {
 	case PendingSubPool:
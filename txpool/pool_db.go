@@ -0,0 +1,83 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Values of kv.PoolTransaction table: sender_address(20 bytes) + tx_rlp, or sender_address + compressedTxRlpFlag + zstd(tx_rlp).
+// Flag is taken from range of RLP string prefixes: tx_rlp starts either from type byte (< 0x80) or from list prefix (>= 0xc0),
+// so uncompressed rows written by old versions are readable as is and don't need migration.
+const compressedTxRlpFlag byte = 0xbf
+
+// firstSeenFlag - optional firstSeenFlag + unix_seconds(8 bytes) between sender_address and tx_rlp, from the same range.
+// Rows without it were written by old versions, time of their load is used instead.
+const firstSeenFlag byte = 0xbe
+
+// compressTxRlpMinLen - small transactions are mostly signature and addresses, compression doesn't pay off for them
+const compressTxRlpMinLen = 256
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
+
+// appendPoolTxFirstSeen - appends time when pool saw the transaction first to value of kv.PoolTransaction (buf already has sender)
+func appendPoolTxFirstSeen(buf []byte, firstSeen uint64) []byte {
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], firstSeen)
+	return append(append(buf, firstSeenFlag), enc[:]...)
+}
+
+// appendPoolTxRlp - appends rlp to value of kv.PoolTransaction (buf already has sender), compresses it if it makes value smaller
+func appendPoolTxRlp(buf, txRlp []byte, compress bool) []byte {
+	if compress && len(txRlp) >= compressTxRlpMinLen {
+		prefixLen := len(buf)
+		buf = zstdEncoder.EncodeAll(txRlp, append(buf, compressedTxRlpFlag))
+		if len(buf)-prefixLen < len(txRlp) {
+			return buf
+		}
+		buf = buf[:prefixLen]
+	}
+	return append(buf, txRlp...)
+}
+
+// decodePoolTx - splits value of kv.PoolTransaction. Returned rlp points into v if it wasn't compressed.
+// firstSeen is 0 for rows written by old versions
+func decodePoolTx(v []byte) (sender, txRlp []byte, firstSeen uint64, err error) {
+	if len(v) < 21 {
+		return nil, nil, 0, fmt.Errorf("%w: pool tx value is too short: %d", ErrParseTxn, len(v))
+	}
+	sender, txRlp = v[:20], v[20:]
+	if txRlp[0] == firstSeenFlag {
+		if len(txRlp) < 10 {
+			return nil, nil, 0, fmt.Errorf("%w: pool tx value is too short: %d", ErrParseTxn, len(v))
+		}
+		firstSeen, txRlp = binary.BigEndian.Uint64(txRlp[1:9]), txRlp[9:]
+	}
+	if txRlp[0] != compressedTxRlpFlag {
+		return sender, txRlp, firstSeen, nil
+	}
+	if txRlp, err = zstdDecoder.DecodeAll(txRlp[1:], nil); err != nil {
+		return nil, nil, 0, fmt.Errorf("decompress pool tx of sender %x: %w", sender, err)
+	}
+	return sender, txRlp, firstSeen, nil
+}
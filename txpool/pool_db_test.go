@@ -0,0 +1,85 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// abiLikeRlp - transaction with ABI-encoded calldata: 32-bytes words with small values, typical for large transactions
+func abiLikeRlp(words int) []byte {
+	data := make([]byte, 4+32*words)
+	copy(data, []byte{0xa9, 0x05, 0x9c, 0xbb})
+	for i := 0; i < words; i++ {
+		binary.BigEndian.PutUint64(data[4+32*i+24:], uint64(i*1000+7))
+	}
+	return append([]byte{0xf9, byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+func TestPoolTxRlpCompression(t *testing.T) {
+	require := require.New(t)
+	sender := bytes.Repeat([]byte{0x11}, 20)
+	for _, txRlp := range [][]byte{decodeHex(txParseMainnetTests[0].payloadStr), abiLikeRlp(64)} {
+		v := appendPoolTxRlp(append([]byte{}, sender...), txRlp, true)
+		if len(txRlp) >= compressTxRlpMinLen {
+			require.Equal(compressedTxRlpFlag, v[20])
+			require.Less(len(v), 20+len(txRlp))
+		} else {
+			require.Equal(append(append([]byte{}, sender...), txRlp...), v)
+		}
+		gotSender, gotRlp, firstSeen, err := decodePoolTx(v)
+		require.NoError(err)
+		require.Equal(sender, gotSender)
+		require.Equal(txRlp, gotRlp)
+		require.Zero(firstSeen)
+
+		// rows written without compression (also by old versions) are read as is
+		v = appendPoolTxRlp(append([]byte{}, sender...), txRlp, false)
+		_, gotRlp, _, err = decodePoolTx(v)
+		require.NoError(err)
+		require.Equal(txRlp, gotRlp)
+
+		for _, compress := range []bool{false, true} {
+			v = appendPoolTxFirstSeen(append([]byte{}, sender...), 1_650_000_000)
+			v = appendPoolTxRlp(v, txRlp, compress)
+			gotSender, gotRlp, firstSeen, err = decodePoolTx(v)
+			require.NoError(err)
+			require.Equal(sender, gotSender)
+			require.Equal(txRlp, gotRlp)
+			require.Equal(uint64(1_650_000_000), firstSeen)
+		}
+	}
+	_, _, _, err := decodePoolTx(sender)
+	require.ErrorIs(err, ErrParseTxn)
+	_, _, _, err = decodePoolTx(appendPoolTxFirstSeen(append([]byte{}, sender...), 1)[:25])
+	require.ErrorIs(err, ErrParseTxn)
+}
+
+func BenchmarkPoolTxRlpCompression(b *testing.B) {
+	sender := bytes.Repeat([]byte{0x11}, 20)
+	txRlp := abiLikeRlp(64)
+	var v []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v = appendPoolTxRlp(append(v[:0], sender...), txRlp, true)
+	}
+	b.ReportMetric(float64(len(v))/float64(len(sender)+len(txRlp)), "written/raw")
+}
@@ -531,7 +531,7 @@ func FuzzOnNewBlocks(f *testing.F) {
 		check(p2pReceived, TxSlots{}, "p2pmsg1")
 		checkNotify(p2pReceived, TxSlots{}, "p2pmsg1")
 
-		err = pool.flushLocked(tx) // we don't test eviction here, because dedicated test exists
+		err = pool.flushLocked(tx, nil) // we don't test eviction here, because dedicated test exists
 		require.NoError(err)
 		check(p2pReceived, TxSlots{}, "after_flush")
 		checkNotify(p2pReceived, TxSlots{}, "after_flush")
@@ -541,8 +541,9 @@ func FuzzOnNewBlocks(f *testing.F) {
 		p2.senders = pool.senders // senders are not persisted
 		err = coreDB.View(ctx, func(coreTx kv.Tx) error { return p2.fromDB(ctx, tx, coreTx) })
 		require.NoError(err)
-		for _, txn := range p2.byHash {
+		for hash, txn := range p2.byHash {
 			assert.Nil(txn.Tx.rlp)
+			assert.Equal(pool.byHash[hash].firstSeen, txn.firstSeen)
 		}
 
 		check(txs2, TxSlots{}, "fromDB")
@@ -0,0 +1,104 @@
+/*
+   Copyright 2021 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/log/v3"
+	"go.uber.org/atomic"
+)
+
+// PoolEvent - kind of decision made by pool about transaction
+type PoolEvent uint8
+
+const (
+	EventValidate PoolEvent = iota // checked against sender state, fields: reason (if rejected) or subPool bits
+	EventAdd                       // added to pool or to sub-pool
+	EventReplace                   // replaced other transaction with same sender and nonce
+	EventPromote                   // moved to better sub-pool
+	EventDemote                    // moved to worse sub-pool
+	EventDiscard                   // removed from pool, fields: reason
+)
+
+func (e PoolEvent) String() string {
+	switch e {
+	case EventValidate:
+		return "validate"
+	case EventAdd:
+		return "add"
+	case EventReplace:
+		return "replace"
+	case EventPromote:
+		return "promote"
+	case EventDemote:
+		return "demote"
+	case EventDiscard:
+		return "discard"
+	default:
+		return fmt.Sprintf("unknown event: %d", e)
+	}
+}
+
+// PoolLogger - receives decisions of pool about transactions, with key-value context (same as log/v3).
+// Called under pool's lock, must be fast and must not call pool.
+type PoolLogger interface {
+	Event(event PoolEvent, idHash []byte, senderID uint64, traced bool, ctx ...interface{})
+}
+
+// DefaultPoolLogger - logs events of traced senders (Config.TracedSenders) at Info level, others - at Debug level
+type DefaultPoolLogger struct{}
+
+func (DefaultPoolLogger) Event(event PoolEvent, idHash []byte, senderID uint64, traced bool, ctx ...interface{}) {
+	ctx = append([]interface{}{"idHash", fmt.Sprintf("%x", idHash), "senderID", senderID}, ctx...)
+	if traced {
+		log.Info("[txpool] "+event.String(), ctx...)
+		return
+	}
+	log.Debug("[txpool] "+event.String(), ctx...)
+}
+
+var (
+	poolLogger   PoolLogger = DefaultPoolLogger{}
+	logAllEvents            = atomic.NewBool(false)
+)
+
+// SetPoolLogger - replaces logger of pool decisions, must be called before pools start. If all is false, only events of
+// traced senders are reported - building of events context is not free, and pool makes several decisions per transaction on each block.
+func SetPoolLogger(l PoolLogger, all bool) {
+	poolLogger = l
+	logAllEvents.Store(all)
+}
+
+// logged - whether events about this transaction must be reported
+func (tx *TxSlot) logged() bool { return tx.traced || logAllEvents.Load() }
+
+func logEvent(event PoolEvent, tx *TxSlot, ctx ...interface{}) {
+	poolLogger.Event(event, tx.IdHash[:], tx.senderID, tx.traced, ctx...)
+}
+
+// moved - reports move of transaction between sub-pools, sub-pools are ordered from best (pending) to worst (queued)
+func moved(mt *metaTx, from, to SubPoolType) *metaTx {
+	if mt.Tx.logged() {
+		event := EventDemote
+		if to < from {
+			event = EventPromote
+		}
+		logEvent(event, mt.Tx, "from", from, "subPool", to)
+	}
+	return mt
+}
@@ -17,17 +17,24 @@
 package txpool
 
 import (
+	"bytes"
 	"container/heap"
 	"context"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"math/rand"
 	"testing"
 
 	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/chain"
 	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/fixedgas"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
+	"github.com/ledgerwatch/erigon-lib/direct"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/kvcache"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
@@ -207,6 +214,194 @@ func TestNonceFromAddress(t *testing.T) {
 	}
 }
 
+func TestSenderStateOverride(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+
+	cfg := DefaultConfig
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	var addr [20]byte
+	addr[0] = 1
+	// sender has no balance in state, but has fee deposit on L2
+	pool.SetSenderStateOverride(SenderStateOverrideFunc(func(a []byte, nonce uint64, balance uint256.Int) (uint64, uint256.Int) {
+		if bytes.Equal(a, addr[:]) {
+			balance.Add(&balance, uint256.NewInt(common.Ether))
+		}
+		return nonce, balance
+	}))
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	var txSlots TxSlots
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0}
+	txSlot.IdHash[0] = 1
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	pending, _, _ := pool.CountContent()
+	assert.Equal(1, pending)
+}
+
+type recordingPoolLogger []PoolEvent
+
+func (r *recordingPoolLogger) Event(event PoolEvent, _ []byte, _ uint64, _ bool, _ ...interface{}) {
+	*r = append(*r, event)
+}
+
+func TestPoolLogger(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	var events recordingPoolLogger
+	SetPoolLogger(&events, true)
+	defer SetPoolLogger(DefaultPoolLogger{}, false)
+
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(1 * common.Ether)))
+	EncodeSender(0, *uint256.NewInt(1 * common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	for i, tip := range []uint64{300000, 400000} {
+		var txSlots TxSlots
+		txSlot := &TxSlot{tip: tip, feeCap: tip, gas: 100000, nonce: 0}
+		txSlot.IdHash[0] = byte(i + 1)
+		txSlots.Append(txSlot, addr[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots)
+		assert.NoError(err)
+		for _, reason := range reasons {
+			assert.Equal(Success, reason, reason.String())
+		}
+	}
+	count := map[PoolEvent]int{}
+	for _, e := range events {
+		count[e]++
+	}
+	assert.Equal(1, count[EventReplace])
+	assert.Equal(1, count[EventDiscard])
+	assert.Equal(2, count[EventAdd])
+	assert.Equal(2, count[EventPromote]) // from queued to pending
+	assert.Equal(0, count[EventDemote])
+}
+
+func TestCountEligible(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+
+	cfg := DefaultConfig
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(2, *uint256.NewInt(1 * common.Ether)))
+	EncodeSender(2, *uint256.NewInt(1 * common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	var txSlots TxSlots
+	txSlot1 := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 2}
+	txSlot1.IdHash[0] = 1
+	txSlot2 := &TxSlot{tip: 250000, feeCap: 250000, gas: 50000, nonce: 3}
+	txSlot2.IdHash[0] = 2
+	txSlot3 := &TxSlot{tip: 400000, feeCap: 400000, gas: 30000, nonce: 4}
+	txSlot3.IdHash[0] = 3
+	txSlots.Append(txSlot1, addr[:], true)
+	txSlots.Append(txSlot2, addr[:], true)
+	txSlots.Append(txSlot3, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+
+	count, gas := pool.CountEligible(200000)
+	assert.Equal(3, count)
+	assert.Equal(uint64(180000), gas)
+	// third transaction clears base fee, but can't be included after the second one
+	count, gas = pool.CountEligible(260000)
+	assert.Equal(1, count)
+	assert.Equal(uint64(100000), gas)
+	count, _ = pool.CountEligible(400000)
+	assert.Equal(0, count)
+}
 func TestReplaceWithHigherFee(t *testing.T) {
 	assert, require := assert.New(t), require.New(t)
 	ch := make(chan Hashes, 100)
@@ -449,3 +644,1354 @@ func TestReverseNonces(t *testing.T) {
 
 	}
 }
+
+func TestCalcIntrinsicGasEIP3860(t *testing.T) {
+	dataLen, nonZero := uint64(65), uint64(1) // 3 words
+	before, reason := CalcIntrinsicGas(dataLen, nonZero, nil, true, true, true, false)
+	require.Equal(t, Success, reason)
+	require.Equal(t, fixedgas.TxGasContractCreation+nonZero*fixedgas.TxDataNonZeroGasEIP2028+(dataLen-nonZero)*fixedgas.TxDataZeroGas, before)
+
+	after, reason := CalcIntrinsicGas(dataLen, nonZero, nil, true, true, true, true)
+	require.Equal(t, Success, reason)
+	require.Equal(t, before+3*fixedgas.InitCodeWordGas, after)
+
+	call, _ := CalcIntrinsicGas(dataLen, nonZero, nil, false, true, true, true) // not charged for calls
+	require.Equal(t, before-fixedgas.TxGasContractCreation+fixedgas.TxGas, call)
+}
+
+func TestFlushRollback(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+	tx.Rollback() // flush opens own write tx
+
+	var txSlots TxSlots
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0, rlp: []byte{0xc1, 0x01}}
+	txSlot.IdHash[0] = 1
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	// tx of the sender without other txs was deleted since last flush
+	var addr2 [20]byte
+	addr2[0] = 2
+	pool.senders.senderIDs[string(addr2[:])] = 999
+	pool.senders.senderID2Addr[999] = addr2[:]
+	deleted := &metaTx{Tx: &TxSlot{senderID: 999}}
+	deleted.Tx.IdHash[0] = 2
+	pool.deletedTxs = append(pool.deletedTxs, deleted)
+
+	// failed flush leaves in-memory structures as they were
+	errCommit := fmt.Errorf("commit failed")
+	err = kv.UpdateWithUndo(ctx, db, func(tx kv.RwTx, undo *kv.UndoLog) error {
+		require.NoError(pool.flushLocked(tx, undo))
+		return errCommit
+	})
+	require.ErrorIs(err, errCommit)
+	assert.Equal([]*metaTx{deleted}, pool.deletedTxs)
+	assert.Equal(uint64(999), pool.senders.senderIDs[string(addr2[:])])
+	assert.Equal(addr2[:], pool.senders.senderID2Addr[999])
+	assert.Equal([]byte{0xc1, 0x01}, pool.byHash[string(txSlot.IdHash[:])].Tx.rlp)
+	assert.Equal(FlushStats{}, pool.flushStats)
+
+	// successful flush cleans them
+	written, err := pool.flush(db)
+	require.NoError(err)
+	assert.Equal(0, len(pool.deletedTxs))
+	assert.NotContains(pool.senders.senderID2Addr, uint64(999))
+	assert.Nil(pool.byHash[string(txSlot.IdHash[:])].Tx.rlp)
+
+	require.NoError(db.View(ctx, func(tx kv.Tx) error {
+		stats, err := pool.DbStats(tx)
+		require.NoError(err)
+		assert.Equal(FlushStats{Flushes: 1, WrittenBytes: written, WrittenTxBytes: stats.Flush.WrittenTxBytes, WrittenTxRlpBytes: 2}, stats.Flush)
+		assert.NotZero(stats.Flush.WrittenTxBytes)
+		require.Equal(3, len(stats.Tables))
+		assert.Equal(DbTableStats{Table: kv.PoolTransaction, Rows: 1, Size: stats.Tables[0].Size}, stats.Tables[0])
+		assert.Equal(uint64(1), stats.Tables[1].Rows)
+		assert.Equal(kv.PoolInfo, stats.Tables[2].Table)
+		assert.Equal(stats.Tables[0].Size+stats.Tables[1].Size+stats.Tables[2].Size, stats.Size)
+		return nil
+	}))
+}
+
+func TestLocalMinFeeCap(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	cfg := DefaultConfig
+	cfg.MinFeeCap, cfg.LocalMinFeeCap = 300000, 100000
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	// under LocalMinFeeCap - rejected
+	var txSlots TxSlots
+	txSlot := &TxSlot{tip: 50000, feeCap: 50000, gas: 100000, nonce: 0}
+	txSlot.IdHash[0] = 1
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{UnderPriced}, reasons)
+
+	// between LocalMinFeeCap and MinFeeCap - accepted, but waits in queued while baseFee is higher
+	txSlots = TxSlots{}
+	txSlot = &TxSlot{tip: 150000, feeCap: 150000, gas: 100000, nonce: 0}
+	txSlot.IdHash[0] = 2
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err = pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{Success}, reasons)
+	mt := pool.byHash[string(txSlot.IdHash[:])]
+	require.NotNil(mt)
+	assert.Equal(QueuedSubPool, mt.currentSubPool)
+	assert.Equal(0, pool.baseFee.Len())
+
+	// baseFee dropped - can be included
+	h2 := gointerfaces.ConvertHashToH256([32]byte{2})
+	change = &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 100000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 1, BlockHash: h2},
+		},
+	}
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+	assert.Equal(PendingSubPool, mt.currentSubPool)
+
+	// baseFee raised again - back to queued, not to base fee sub pool
+	h3 := gointerfaces.ConvertHashToH256([32]byte{3})
+	change = &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 2, BlockHash: h3},
+		},
+	}
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+	assert.Equal(QueuedSubPool, mt.currentSubPool)
+	assert.Equal(0, pool.baseFee.Len())
+}
+
+func TestSetLimits(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	// nonce gap - all go to queued
+	var txSlots TxSlots
+	for i := 0; i < 3; i++ {
+		txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: uint64(5 + i)}
+		txSlot.IdHash[0] = byte(1 + i)
+		txSlots.Append(txSlot, addr[:], true)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	assert.Equal(3, pool.queued.Len())
+
+	limits := pool.SetLimits(Limits{QueuedSubPoolLimit: 1, PriceBump: 20})
+	assert.Equal(Limits{PendingSubPoolLimit: 10_000, BaseFeeSubPoolLimit: 10_000, QueuedSubPoolLimit: 1, PriceBump: 20}, limits)
+	assert.Equal(1, pool.queued.Len())
+	assert.Equal(uint64(20), pool.cfg.PriceBump)
+
+	// zero fields keep current values
+	assert.Equal(limits, pool.SetLimits(Limits{}))
+}
+
+func TestPagedAll(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	addrs := [][20]byte{{1}, {2}, {3}}
+	for _, addr := range addrs {
+		v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+		EncodeSender(0, *uint256.NewInt(common.Ether), v)
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(addr),
+			Data:    v,
+		})
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	// 5 pending txs of first sender, 2 queued (nonce gap) of second, 1 base fee of third
+	var txSlots TxSlots
+	add := func(addr [20]byte, nonce, feeCap uint64) {
+		txSlot := &TxSlot{tip: feeCap, feeCap: feeCap, gas: 21000, nonce: nonce}
+		txSlot.IdHash[0], txSlot.IdHash[1] = addr[0], byte(nonce)
+		txSlots.Append(txSlot, addr[:], true)
+	}
+	for i := uint64(0); i < 5; i++ {
+		add(addrs[0], i, 300000)
+	}
+	add(addrs[1], 5, 300000)
+	add(addrs[1], 6, 300000)
+	add(addrs[2], 0, 100000)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+
+	var pages int
+	var cursor []byte
+	var nonces []uint64
+	for {
+		txs, next, total, err := pool.PagedAll(PendingSubPool, cursor, 2)
+		require.NoError(err)
+		assert.Equal(5, total)
+		for _, info := range txs {
+			assert.Equal(addrs[0], info.Sender)
+			assert.True(info.IsLocal)
+			assert.Equal(uint64(21000), info.Gas)
+			nonces = append(nonces, info.Nonce)
+		}
+		pages++
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(3, pages)
+	assert.Equal([]uint64{0, 1, 2, 3, 4}, nonces)
+
+	txs, next, total, err := pool.PagedAll(QueuedSubPool, nil, 10)
+	require.NoError(err)
+	assert.Nil(next)
+	assert.Equal(2, total)
+	require.Len(txs, 2)
+	assert.Equal(addrs[1], txs[0].Sender)
+	assert.Equal(uint64(6), txs[1].Nonce)
+
+	txs, _, total, err = pool.PagedAll(BaseFeeSubPool, nil, 10)
+	require.NoError(err)
+	assert.Equal(1, total)
+	require.Len(txs, 1)
+	assert.Equal(uint64(100000), txs[0].FeeCap)
+
+	_, _, _, err = pool.PagedAll(PendingSubPool, []byte{1}, 10)
+	assert.Error(err)
+	_, _, _, err = pool.PagedAll(PendingSubPool, nil, 0)
+	assert.Error(err)
+}
+
+func TestStaleReplacementAfterUnwind(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	slots := func(fee uint64, hash byte) TxSlots {
+		var txSlots TxSlots
+		txSlot := &TxSlot{tip: fee, feeCap: fee, gas: 21000, nonce: 0}
+		txSlot.IdHash[0] = hash
+		txSlots.Append(txSlot, addr[:], true)
+		return txSlots
+	}
+	// replacing transaction is in the pool, then reorg returns replaced one from unwound block
+	reasons, err := pool.AddLocalTxs(ctx, slots(500000, 2))
+	assert.NoError(err)
+	assert.Equal(Success, reasons[0], reasons[0].String())
+	change.ChangeBatch[0].BlockHeight = 1
+	change.ChangeBatch[0].BlockHash = gointerfaces.ConvertHashToH256([32]byte{1})
+	err = pool.OnNewBlock(ctx, change, slots(300000, 1), TxSlots{}, tx)
+	assert.NoError(err)
+	assert.Equal(1, pool.pending.Len())
+	assert.Equal(byte(2), pool.pending.best.ms[0].Tx.IdHash[0])
+	nonce, inPool := pool.NonceFromAddress(addr)
+	assert.True(inPool)
+	assert.Equal(uint64(0), nonce)
+
+	// replacing transaction is still indexed, so transaction without enough price bump is not added next to it
+	reasons, err = pool.AddLocalTxs(ctx, slots(400000, 3))
+	assert.NoError(err)
+	assert.Equal(NotReplaced, reasons[0], reasons[0].String())
+	assert.Equal(1, pool.pending.Len())
+
+	// duplicate which stays in sub-pool is discarded, the one with higher effective tip is kept
+	dup := slots(600000, 4).txs[0]
+	dup.senderID = pool.pending.best.ms[0].Tx.senderID
+	mt := newMetaTx(dup, true, 1)
+	pool.byHash[string(dup.IdHash[:])] = mt
+	pool.queued.Add(mt)
+	pool.lock.Lock()
+	removed := pool.removeStaleReplacementsLocked(200000)
+	pool.lock.Unlock()
+	assert.Equal(1, removed)
+	assert.Equal(0, pool.pending.Len())
+	assert.Equal(1, pool.queued.Len())
+	assert.Equal(mt, pool.all.get(dup.senderID, 0))
+	_, ok := pool.byHash[string([]byte{2})+string(make([]byte, 31))]
+	assert.False(ok)
+}
+
+func TestPreCheck(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(2, *uint256.NewInt(common.Ether)))
+	EncodeSender(2, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	var txSlots TxSlots
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 2}
+	txSlot.IdHash[0] = 1
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{Success}, reasons)
+	pending, senderID := pool.pending.Len(), pool.senders.senderID
+
+	var addr2 [20]byte
+	addr2[0] = 2 // unknown sender, no balance
+	var checkSlots TxSlots
+	for i, txSlot := range []*TxSlot{
+		{tip: 300000, feeCap: 300000, gas: 100000, nonce: 3},                            // fits
+		{tip: 300000, feeCap: 300000, gas: 100000, nonce: 1},                            // nonce is already used
+		{tip: 300001, feeCap: 300001, gas: 100000, nonce: 2},                            // doesn't pay enough to replace
+		{tip: 300000, feeCap: 300000, gas: 100000, nonce: 2},                            // already in pool
+		{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0, value: *uint256.NewInt(1)}, // sender of addr2 has no balance
+	} {
+		if i == 3 {
+			txSlot.IdHash[0] = 1
+		} else {
+			txSlot.IdHash[0] = byte(10 + i)
+		}
+		if i == 4 {
+			checkSlots.Append(txSlot, addr2[:], true)
+			continue
+		}
+		checkSlots.Append(txSlot, addr[:], true)
+	}
+	reasons, err = pool.PreCheck(ctx, checkSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{Success, NonceTooLow, NotReplaced, DuplicateHash, InsufficientFunds}, reasons)
+
+	// pool is not changed
+	assert.Equal(pending, pool.pending.Len())
+	assert.Equal(0, pool.baseFee.Len()+pool.queued.Len())
+	assert.Equal(senderID, pool.senders.senderID)
+	_, ok := pool.senders.senderIDs[string(addr2[:])]
+	assert.False(ok)
+	assert.Nil(pool.byHash[string(checkSlots.txs[0].IdHash[:])])
+}
+
+func TestPromotedBatchLimit(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 1)
+	coreDB := memdb.NewTestDB(t)
+	cfg := DefaultConfig
+	cfg.PromotedBatchLimit = 2
+	cfg.PendingSubPoolLimit, cfg.BaseFeeSubPoolLimit = 4, 0
+	pool, err := New(ch, coreDB, cfg, kvcache.New(kvcache.DefaultCoherentConfig), *u256.N1)
+	require.NoError(err)
+
+	hashes := make(Hashes, 5*32)
+	for i := 0; i < hashes.Len(); i++ {
+		hashes.At(i)[0] = byte(i + 1)
+	}
+	pool.promoted = append(pool.promoted[:0], hashes...)
+	pool.notifyPromotedLocked() // chunks [1 2] [3 4] [5], backlog is limited to 4 hashes - oldest chunk is dropped
+	assert.Equal(hashes[2*32:4*32], <-ch)
+	assert.Equal(1, len(pool.notifyBacklog))
+
+	pool.flushNotifyBacklogLocked()
+	assert.Equal(hashes[4*32:], <-ch)
+	assert.Equal(0, len(pool.notifyBacklog))
+
+	pool.promoted = append(pool.promoted[:0], hashes[:3*32]...)
+	pool.notifyPromotedLocked()
+	pool.flushNotifyBacklogLocked() // channel is full, nothing changes
+	assert.Equal(hashes[:2*32], <-ch)
+	pool.flushNotifyBacklogLocked()
+	assert.Equal(hashes[2*32:3*32], <-ch)
+}
+
+func TestHighVolumeSenders(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	var addr, highVolumeAddr [20]byte
+	addr[0], highVolumeAddr[0] = 1, 2
+	cfg := DefaultConfig
+	cfg.AccountSlots = 2
+	cfg.HighVolumeSenders = map[string]uint64{string(highVolumeAddr[:]): 5}
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	for _, a := range [][20]byte{addr, highVolumeAddr} {
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(a),
+			Data:    v,
+		})
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	add := func(sender [20]byte, nonce uint64) DiscardReason {
+		var txSlots TxSlots
+		txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: nonce}
+		txSlot.IdHash[0], txSlot.IdHash[1] = sender[0], byte(nonce)
+		txSlots.Append(txSlot, sender[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots)
+		require.NoError(err)
+		return reasons[0]
+	}
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		assert.Equal(Success, add(addr, nonce))
+	}
+	assert.Equal(Spammer, add(addr, 3))
+	for nonce := uint64(0); nonce < 6; nonce++ {
+		assert.Equal(Success, add(highVolumeAddr, nonce))
+	}
+	assert.Equal(Spammer, add(highVolumeAddr, 6))
+}
+
+func TestStrictNonceContinuity(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	var addr1, addr2 [20]byte
+	addr1[0], addr2[0] = 1, 2
+	cfg := DefaultConfig
+	cfg.StrictNonceContinuity = true
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	v := make([]byte, EncodeSenderLengthForStorage(2, *uint256.NewInt(common.Ether)))
+	EncodeSender(2, *uint256.NewInt(common.Ether), v)
+	for _, a := range [][20]byte{addr1, addr2} {
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(a),
+			Data:    v,
+		})
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	var txSlots TxSlots
+	for _, s := range []struct {
+		sender [20]byte
+		nonce  uint64
+	}{{addr1, 2}, {addr1, 3}, {addr1, 4}, {addr2, 2}} {
+		txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: s.nonce, rlp: []byte{s.sender[0], byte(s.nonce)}}
+		txSlot.IdHash[0], txSlot.IdHash[1] = s.sender[0], byte(s.nonce)
+		txSlots.Append(txSlot, s.sender[:], true)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	require.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	pending, _, _ := pool.CountContent()
+	assert.Equal(4, pending)
+
+	// only next transaction of every sender is offered
+	var best TxsRlp
+	require.NoError(pool.Best(10, &best, tx))
+	require.Len(best.Txs, 2)
+	assert.ElementsMatch([][]byte{{1, 2}, {2, 2}}, best.Txs)
+	count, _ := pool.CountEligible(0)
+	assert.Equal(2, count)
+
+	pool.cfg.StrictNonceContinuity = false
+	require.NoError(pool.Best(10, &best, tx))
+	assert.Len(best.Txs, 4)
+}
+
+func TestBestStream(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	require.NoError(err)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{}), Changes: []*remote.AccountChange{
+			{Action: remote.Action_UPSERT, Address: gointerfaces.ConvertAddressToH160(addr), Data: v},
+		}}},
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	require.NoError(pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx))
+
+	var txSlots TxSlots
+	for nonce := uint64(0); nonce < 5; nonce++ {
+		txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: nonce, rlp: []byte{addr[0], byte(nonce)}}
+		txSlot.IdHash[0], txSlot.IdHash[1] = addr[0], byte(nonce)
+		txSlots.Append(txSlot, addr[:], true)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	require.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	var best TxsRlp
+	require.NoError(pool.Best(10, &best, tx))
+	require.Len(best.Txs, 5)
+	require.NoError(tx.Commit())
+
+	// unknown transaction is skipped
+	ids := append(pool.BestIds(), [32]byte{0xff})
+	var byIds TxsRlp
+	require.NoError(db.View(ctx, func(tx kv.Tx) error { return pool.BestByIds(ids, &byIds, tx) }))
+	assert.Equal(best.Txs, byIds.Txs)
+
+	client := direct.NewTxPoolClientDirect(NewGrpcServer(ctx, pool, db, *u256.N1))
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.BestStream(streamCtx, &txpool_proto.BestStreamRequest{BatchSize: 2})
+	require.NoError(err)
+	var streamed [][]byte
+	for {
+		reply, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(err)
+		assert.LessOrEqual(len(reply.Txs), 2)
+		for _, txn := range reply.Txs {
+			assert.Equal(addr[:], txn.Sender)
+			assert.True(txn.IsLocal)
+			streamed = append(streamed, txn.RlpTx)
+		}
+	}
+	assert.Equal(best.Txs, streamed)
+}
+
+func TestIdHashKnown(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	known := func(b byte) bool {
+		var hash [32]byte
+		hash[0] = b
+		ok, err := pool.IdHashKnown(tx, hash[:])
+		require.NoError(err)
+		return ok
+	}
+	var txSlots TxSlots
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0}
+	txSlot.IdHash[0] = 1
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{Success}, reasons)
+	assert.True(known(1))
+	assert.False(known(2))
+
+	// replaced tx is known as discarded
+	txSlots = TxSlots{}
+	txSlot = &TxSlot{tip: 400000, feeCap: 400000, gas: 100000, nonce: 0}
+	txSlot.IdHash[0] = 2
+	txSlots.Append(txSlot, addr[:], false)
+	pool.AddRemoteTxs(ctx, txSlots)
+	assert.True(known(2))
+	require.NoError(pool.processRemoteTxs(ctx))
+	assert.True(known(1))
+	assert.True(known(2))
+	assert.Equal(1, len(pool.byHash))
+
+	// forgotten discard reason
+	pool.discardReasonsLRU.RemoveOldest()
+	assert.False(known(1))
+	assert.True(known(2))
+}
+
+func TestMaxTxGasFraction(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	cfg := DefaultConfig
+	cfg.MaxTxGasFraction = 0.5
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	var txSlots TxSlots
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 600000, nonce: 0}
+	txSlot.IdHash[0] = 1
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{GasLimitTooHigh}, reasons)
+
+	txSlots = TxSlots{}
+	txSlot = &TxSlot{tip: 300000, feeCap: 300000, gas: 500000, nonce: 0}
+	txSlot.IdHash[0] = 2
+	txSlots.Append(txSlot, addr[:], true)
+	reasons, err = pool.AddLocalTxs(ctx, txSlots)
+	assert.NoError(err)
+	assert.Equal([]DiscardReason{Success}, reasons)
+	assert.Equal(1, pool.pending.Len())
+}
+
+func TestPriorityContracts(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	var addr1, addr2, oracle, other [20]byte
+	addr1[0], addr2[0], oracle[0], other[0] = 1, 2, 0xa, 0xb
+	cfg := DefaultConfig
+	cfg.PriorityContracts = map[string]uint8{string(oracle[:]): 1}
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	require.NoError(err)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	v := make([]byte, EncodeSenderLengthForStorage(2, *uint256.NewInt(common.Ether)))
+	EncodeSender(2, *uint256.NewInt(common.Ether), v)
+	for _, a := range [][20]byte{addr1, addr2} {
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(a),
+			Data:    v,
+		})
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	require.NoError(pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx))
+
+	// oracle update pays less, but goes first
+	var txSlots TxSlots
+	for _, s := range []struct {
+		sender, to  [20]byte
+		tip, feeCap uint64
+	}{{addr1, other, 300000, 400000}, {addr2, oracle, 250000, 300000}} {
+		txSlot := &TxSlot{tip: s.tip, feeCap: s.feeCap, gas: 100000, nonce: 2, to: s.to, rlp: []byte{s.sender[0]}}
+		txSlot.IdHash[0] = s.sender[0]
+		txSlots.Append(txSlot, s.sender[:], false)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	require.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	var best TxsRlp
+	require.NoError(pool.Best(10, &best, tx))
+	assert.Equal([][]byte{{addr2[0]}, {addr1[0]}}, best.Txs)
+}
+
+func TestSystemTxs(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	var pool *TxPool
+	var err error
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	var addr1, addr2 [20]byte
+	addr1[0], addr2[0] = 1, 2
+	cfg := DefaultConfig
+	cfg.MinFeeCap = 1
+	cfg.SystemTxSenders, err = SystemTxSendersFromChain(&chain.Config{Bor: &chain.BorConfig{SystemTxSenders: []string{"0x" + hex.EncodeToString(addr1[:])}}})
+	require.NoError(err)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err = New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	require.NoError(err)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	v := make([]byte, EncodeSenderLengthForStorage(2, *uint256.NewInt(common.Ether)))
+	EncodeSender(2, *uint256.NewInt(common.Ether), v)
+	for _, a := range [][20]byte{addr1, addr2} {
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(a),
+			Data:    v,
+		})
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	require.NoError(pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx))
+
+	// fee-less legacy transaction of system sender is accepted, the same from other sender or of other type is not
+	var txSlots TxSlots
+	for i, s := range []struct {
+		sender [20]byte
+		txType int
+	}{{addr1, LegacyTxType}, {addr2, LegacyTxType}, {addr1, DynamicFeeTxType}} {
+		txSlot := &TxSlot{nonce: 2 + uint64(i/2), txType: byte(s.txType), rlp: []byte{byte(i)}}
+		txSlot.IdHash[0] = byte(i + 1)
+		txSlots.Append(txSlot, s.sender[:], false)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	require.NoError(err)
+	assert.Equal([]DiscardReason{Success, UnderPriced, UnderPriced}, reasons)
+	pending, baseFee, queued := pool.CountContent()
+	assert.Equal(1, pending)
+	assert.Equal(0, baseFee)
+	assert.Equal(0, queued)
+
+	_, err = SystemTxSendersFromChain(&chain.Config{Bor: &chain.BorConfig{SystemTxSenders: []string{"0x01"}}})
+	assert.Error(err)
+	senders, err := SystemTxSendersFromChain(&chain.Config{})
+	assert.NoError(err)
+	assert.Nil(senders)
+}
+
+func TestBestAtBaseFee(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	var addr1, addr2, addr3 [20]byte
+	addr1[0], addr2[0], addr3[0] = 1, 2, 3
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	require.NoError(err)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	v := make([]byte, EncodeSenderLengthForStorage(2, *uint256.NewInt(common.Ether)))
+	EncodeSender(2, *uint256.NewInt(common.Ether), v)
+	for _, a := range [][20]byte{addr1, addr2, addr3} {
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(a),
+			Data:    v,
+		})
+	}
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	require.NoError(pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx))
+
+	var txSlots TxSlots
+	for _, s := range []struct {
+		sender      [20]byte
+		tip, feeCap uint64
+	}{{addr1, 10000, 150000}, {addr2, 50000, 300000}, {addr3, 100000, 220000}} {
+		txSlot := &TxSlot{tip: s.tip, feeCap: s.feeCap, gas: 100000, nonce: 2, rlp: []byte{s.sender[0]}}
+		txSlot.IdHash[0] = s.sender[0]
+		txSlots.Append(txSlot, s.sender[:], false)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots)
+	require.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(Success, reason, reason.String())
+	}
+	pending, baseFee, _ := pool.CountContent()
+	assert.Equal(2, pending)
+	assert.Equal(1, baseFee)
+
+	for _, c := range []struct {
+		baseFee  uint64
+		expected [][]byte
+	}{
+		{100000, [][]byte{{addr3[0]}, {addr2[0]}, {addr1[0]}}}, // cheaper block: base-fee sub-pool becomes pending
+		{200000, [][]byte{{addr2[0]}, {addr3[0]}}},
+		{250000, [][]byte{{addr2[0]}}},
+	} {
+		var best TxsRlp
+		require.NoError(pool.BestAtBaseFee(10, c.baseFee, &best, tx))
+		assert.Equal(c.expected, best.Txs, "base fee %d", c.baseFee)
+	}
+	var best TxsRlp
+	require.NoError(pool.BestAtBaseFee(1, 100000, &best, tx))
+	assert.Equal([][]byte{{addr3[0]}}, best.Txs)
+
+	// pool state is not changed
+	require.NoError(pool.Best(10, &best, tx))
+	assert.Equal([][]byte{{addr2[0]}, {addr3[0]}}, best.Txs)
+	pending, baseFee, _ = pool.CountContent()
+	assert.Equal(2, pending)
+	assert.Equal(1, baseFee)
+}
+
+func TestDisallowedTxTypes(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	cfg := DefaultConfig
+	cfg.DisallowedTxTypes = []int{LegacyTxType}
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	add := func(txSlot *TxSlot) DiscardReason {
+		var txSlots TxSlots
+		txSlots.Append(txSlot, addr[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots)
+		require.NoError(err)
+		return reasons[0]
+	}
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0, txType: byte(LegacyTxType)}
+	txSlot.IdHash[0] = 1
+	assert.Equal(DisallowedTxType, add(txSlot))
+	txSlot = &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0, txType: byte(DynamicFeeTxType)}
+	txSlot.IdHash[0] = 2
+	assert.Equal(Success, add(txSlot))
+
+	// toggled at runtime
+	pool.SetDisallowedTxTypes([]int{DynamicFeeTxType})
+	txSlot = &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 1, txType: byte(DynamicFeeTxType)}
+	txSlot.IdHash[0] = 3
+	assert.Equal(DisallowedTxType, add(txSlot))
+	txSlot = &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 1, txType: byte(LegacyTxType)}
+	txSlot.IdHash[0] = 4
+	assert.Equal(Success, add(txSlot))
+	assert.Equal(2, pool.pending.Len())
+}
+
+// testAltMempool - claims transactions to given address, rejects ones with zero nonce, tags others with their nonce
+type testAltMempool struct{ to [20]byte }
+
+func (m *testAltMempool) Match(txn *TxSlot, sender []byte) bool {
+	to, ok := txn.To()
+	return ok && to == m.to
+}
+
+func (m *testAltMempool) Validate(txn *TxSlot, sender []byte, isLocal bool) (DiscardReason, uint64) {
+	if txn.nonce == 0 {
+		return AltMempoolRejected, 0
+	}
+	return Success, txn.nonce
+}
+
+func TestAltMempool(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	entryPoint := [20]byte{0xee}
+	pool.RegisterAltMempool(&testAltMempool{to: entryPoint})
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	h1 := gointerfaces.ConvertHashToH256([32]byte{})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: h1},
+		},
+	}
+	var addr [20]byte
+	addr[0] = 1
+	v := make([]byte, EncodeSenderLengthForStorage(0, *uint256.NewInt(common.Ether)))
+	EncodeSender(0, *uint256.NewInt(common.Ether), v)
+	change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+		Action:  remote.Action_UPSERT,
+		Address: gointerfaces.ConvertAddressToH160(addr),
+		Data:    v,
+	})
+	tx, err := db.BeginRw(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+
+	add := func(txSlot *TxSlot) DiscardReason {
+		var txSlots TxSlots
+		txSlots.Append(txSlot, addr[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots)
+		require.NoError(err)
+		return reasons[0]
+	}
+	// claimed and vetoed
+	txSlot := &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0, to: entryPoint}
+	txSlot.IdHash[0] = 1
+	assert.Equal(AltMempoolRejected, add(txSlot))
+
+	// not claimed - standard validation only
+	txSlot = &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 0, to: [20]byte{0x01}}
+	txSlot.IdHash[0] = 2
+	assert.Equal(Success, add(txSlot))
+	tag, ok := pool.AltTag(txSlot.IdHash[:])
+	assert.True(ok)
+	assert.Zero(tag)
+
+	// claimed and tagged
+	txSlot = &TxSlot{tip: 300000, feeCap: 300000, gas: 100000, nonce: 1, to: entryPoint}
+	txSlot.IdHash[0] = 3
+	assert.Equal(Success, add(txSlot))
+	tag, ok = pool.AltTag(txSlot.IdHash[:])
+	assert.True(ok)
+	assert.Equal(uint64(1), tag)
+}
+
+func TestUnprocessedRemoteTxsSurviveRestart(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	require.True(pool != nil)
+	ctx := context.Background()
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	change := &remote.StateChangeBatch{
+		DatabaseViewID:      txID,
+		PendingBlockBaseFee: 200000,
+		BlockGasLimit:       1000000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 0, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	tx, err := db.BeginRo(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = pool.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+	tx.Rollback() // flush opens own write tx
+
+	parseCtx := NewTxParseContext(*u256.N1)
+	var txSlots TxSlots
+	for i := 0; i < 2; i++ {
+		txSlot, sender := &TxSlot{}, make([]byte, 20)
+		_, err = parseCtx.ParseTransaction(decodeHex(txParseMainnetTests[i].payloadStr), 0, txSlot, sender, false /* hasEnvelope */)
+		require.NoError(err)
+		txSlots.Append(txSlot, sender, false)
+	}
+	pool.AddRemoteTxs(ctx, txSlots) // node stops before processRemoteTxs
+	_, err = pool.flush(db)
+	require.NoError(err)
+
+	p2, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	assert.NoError(err)
+	tx, err = db.BeginRo(ctx)
+	require.NoError(err)
+	defer tx.Rollback()
+	err = p2.OnNewBlock(ctx, change, TxSlots{}, TxSlots{}, tx)
+	assert.NoError(err)
+	require.Equal(2, len(p2.unprocessedRemoteTxs.txs))
+	for i := range txSlots.txs {
+		assert.Equal(txSlots.txs[i].IdHash, p2.unprocessedRemoteTxs.txs[i].IdHash)
+		assert.Equal(txSlots.senders.At(i), p2.unprocessedRemoteTxs.senders.At(i))
+		assert.Equal(txSlots.txs[i].rlp, p2.unprocessedRemoteTxs.txs[i].rlp)
+	}
+	require.NoError(p2.processRemoteTxs(ctx))
+	assert.Equal(0, len(p2.unprocessedRemoteTxs.txs))
+}
+
+func TestNoPropagate(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ch := make(chan Hashes, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
+	pool, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	require.NoError(err)
+	ctx := context.Background()
+
+	var h1, h2, h3 [32]byte
+	h1[0], h2[0], h3[0] = 1, 2, 3
+	var marked Hashes
+	marked = append(append(marked, h1[:]...), h2[:]...)
+
+	// failed write doesn't mark
+	errCommit := fmt.Errorf("commit failed")
+	err = kv.UpdateWithUndo(ctx, db, func(tx kv.RwTx, undo *kv.UndoLog) error {
+		require.NoError(pool.SetNoPropagate(tx, undo, marked, true))
+		assert.True(pool.IsNoPropagate(h1[:]))
+		return errCommit
+	})
+	require.ErrorIs(err, errCommit)
+	assert.False(pool.IsNoPropagate(h1[:]))
+
+	require.NoError(kv.UpdateWithUndo(ctx, db, func(tx kv.RwTx, undo *kv.UndoLog) error {
+		return pool.SetNoPropagate(tx, undo, marked, true)
+	}))
+	assert.True(pool.IsNoPropagate(h1[:]))
+	assert.True(pool.IsNoPropagate(h2[:]))
+	assert.False(pool.IsNoPropagate(h3[:]))
+	all := append(append(append(Hashes{}, h3[:]...), h1[:]...), h2[:]...)
+	assert.Equal(Hashes(h3[:]), pool.dropNoPropagate(all))
+
+	require.NoError(kv.UpdateWithUndo(ctx, db, func(tx kv.RwTx, undo *kv.UndoLog) error {
+		return pool.SetNoPropagate(tx, undo, Hashes(h2[:]), false)
+	}))
+	assert.False(pool.IsNoPropagate(h2[:]))
+
+	// marks survive restart
+	p2, err := New(ch, coreDB, DefaultConfig, sendersCache, *u256.N1)
+	require.NoError(err)
+	require.NoError(db.View(ctx, func(tx kv.Tx) error {
+		return coreDB.View(ctx, func(coreTx kv.Tx) error { return p2.fromDB(ctx, tx, coreTx) })
+	}))
+	assert.True(p2.IsNoPropagate(h1[:]))
+	assert.False(p2.IsNoPropagate(h2[:]))
+}
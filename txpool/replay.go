@@ -0,0 +1,229 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/kvcache"
+	"google.golang.org/protobuf/proto"
+)
+
+// PoolComposition - sorted hashes of transactions in every sub-pool
+type PoolComposition struct {
+	Pending, BaseFee, Queued Hashes
+}
+
+func (c *PoolComposition) subPools() []*Hashes { return []*Hashes{&c.Pending, &c.BaseFee, &c.Queued} }
+
+// Encode - for every sub-pool: amount of hashes (4 bytes, big-endian), then hashes
+func (c *PoolComposition) Encode() []byte {
+	var buf []byte
+	var l [4]byte
+	for _, h := range c.subPools() {
+		binary.BigEndian.PutUint32(l[:], uint32(h.Len()))
+		buf = append(buf, l[:]...)
+		buf = append(buf, *h...)
+	}
+	return buf
+}
+
+func (c *PoolComposition) Decode(data []byte) error {
+	for _, h := range c.subPools() {
+		if len(data) < 4 {
+			return fmt.Errorf("pool composition: unexpected end of data")
+		}
+		size := int(binary.BigEndian.Uint32(data)) * length.Hash
+		data = data[4:]
+		if len(data) < size {
+			return fmt.Errorf("pool composition: unexpected end of data")
+		}
+		*h = append(Hashes{}, data[:size]...)
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		return fmt.Errorf("pool composition: %d extra bytes", len(data))
+	}
+	return nil
+}
+
+// Composition - hashes of transactions in every sub-pool, sorted
+func (p *TxPool) Composition() *PoolComposition {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	c := &PoolComposition{}
+	for _, mt := range p.pending.best.ms {
+		c.Pending = append(c.Pending, mt.Tx.IdHash[:]...)
+	}
+	for _, mt := range p.baseFee.best.ms {
+		c.BaseFee = append(c.BaseFee, mt.Tx.IdHash[:]...)
+	}
+	for _, mt := range p.queued.best.ms {
+		c.Queued = append(c.Queued, mt.Tx.IdHash[:]...)
+	}
+	for _, h := range c.subPools() {
+		sort.Sort(*h)
+	}
+	return c
+}
+
+// Divergence - difference between sub-pool of replayed pool and recorded baseline
+type Divergence struct {
+	Record     int // index of CaptureComposition record in capture file
+	SubPool    SubPoolType
+	Missing    Hashes // in baseline, but not in replayed pool
+	Unexpected Hashes // in replayed pool, but not in baseline
+}
+
+type ReplayReport struct {
+	StateChanges, Messages, Compositions int // replayed records
+	Skipped                              int // messages which need peers: announcements and requests
+	From, To                             time.Time
+	Divergences                          []Divergence
+}
+
+// Replay - feeds state change batches and p2p transactions from capture file into fresh TxPool (db must be empty,
+// coreDB should have state of the chain at the beginning of capture: senders which are not touched by
+// captured batches are read from it) and compares sub-pools with every recorded composition.
+// Remote transactions are processed right before every state change batch, while live pool processes them
+// every cfg.ProcessRemoteTxsEvery - so transactions which arrived shortly before a block may diverge
+func Replay(ctx context.Context, capture io.Reader, cfg Config, chainID uint256.Int, coreDB kv.RoDB, db kv.RwDB) (*ReplayReport, error) {
+	// nobody listens for notifications, newPendingTxs is nil
+	pool, err := New(nil, coreDB, cfg, kvcache.New(kvcache.DefaultCoherentConfig), chainID)
+	if err != nil {
+		return nil, err
+	}
+	f := NewFetch(ctx, nil, pool, nil, coreDB, db, chainID)
+	processRemote := func() error {
+		if !pool.Started() {
+			return nil
+		}
+		return pool.processRemoteTxs(ctx)
+	}
+
+	report := &ReplayReport{}
+	r := bufio.NewReader(capture)
+	var head [captureHeadLen]byte
+	for record := 0; ; record++ {
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		default:
+		}
+		if _, err = io.ReadFull(r, head[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return report, nil
+			}
+			return report, fmt.Errorf("replay: record %d: %w", record, err)
+		}
+		at := time.Unix(0, int64(binary.BigEndian.Uint64(head[1:])))
+		if report.From.IsZero() {
+			report.From = at
+		}
+		report.To = at
+		size := binary.BigEndian.Uint32(head[9:])
+		if size > captureMaxRecord {
+			return report, fmt.Errorf("replay: record %d: too large %d", record, size)
+		}
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return report, fmt.Errorf("replay: record %d: %w", record, err)
+		}
+		switch head[0] {
+		case CaptureStateChange:
+			batch := &remote.StateChangeBatch{}
+			if err = proto.Unmarshal(payload, batch); err != nil {
+				return report, fmt.Errorf("replay: record %d: %w", record, err)
+			}
+			if err = processRemote(); err != nil {
+				return report, fmt.Errorf("replay: record %d: %w", record, err)
+			}
+			if err = f.onStateChange(ctx, batch); err != nil {
+				return report, fmt.Errorf("replay: record %d: %w", record, err)
+			}
+			report.StateChanges++
+		case CaptureMessage:
+			msg := &sentry.InboundMessage{}
+			if err = proto.Unmarshal(payload, msg); err != nil {
+				return report, fmt.Errorf("replay: record %d: %w", record, err)
+			}
+			if !isReplayableMessage(msg.Id) {
+				report.Skipped++
+				continue
+			}
+			// malformed messages are recorded as they came, live pool skipped them too
+			_ = f.handleInboundMessage(ctx, msg, nil)
+			report.Messages++
+		case CaptureComposition:
+			baseline := &PoolComposition{}
+			if err = baseline.Decode(payload); err != nil {
+				return report, fmt.Errorf("replay: record %d: %w", record, err)
+			}
+			report.Divergences = append(report.Divergences, diffComposition(record, baseline, pool.Composition())...)
+			report.Compositions++
+		default:
+			return report, fmt.Errorf("replay: record %d: unknown kind %d", record, head[0])
+		}
+	}
+}
+
+func diffComposition(record int, baseline, actual *PoolComposition) []Divergence {
+	var res []Divergence
+	expected, got := baseline.subPools(), actual.subPools()
+	for i, t := range []SubPoolType{PendingSubPool, BaseFeeSubPool, QueuedSubPool} {
+		d := Divergence{Record: record, SubPool: t}
+		d.Missing, d.Unexpected = diffSortedHashes(*expected[i], *got[i])
+		if len(d.Missing) > 0 || len(d.Unexpected) > 0 {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// diffSortedHashes - hashes which are only in a, and which are only in b
+func diffSortedHashes(a, b Hashes) (onlyA, onlyB Hashes) {
+	i, j := 0, 0
+	for i < a.Len() && j < b.Len() {
+		switch c := bytes.Compare(a.At(i), b.At(j)); {
+		case c < 0:
+			onlyA = append(onlyA, a.At(i)...)
+			i++
+		case c > 0:
+			onlyB = append(onlyB, b.At(j)...)
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	onlyA = append(onlyA, a[i*length.Hash:]...)
+	onlyB = append(onlyB, b[j*length.Hash:]...)
+	return onlyA, onlyB
+}
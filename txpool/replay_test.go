@@ -0,0 +1,129 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/u256"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/kvcache"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ctx := context.Background()
+	coreDB := memdb.NewTestDB(t)
+	var txID uint64
+	_ = coreDB.View(ctx, func(tx kv.Tx) error {
+		txID = tx.ViewID()
+		return nil
+	})
+	balance := uint256.NewInt(0).Mul(uint256.NewInt(common.Ether), uint256.NewInt(100))
+	v := make([]byte, EncodeSenderLengthForStorage(0, *balance))
+	EncodeSender(0, *balance, v)
+	batch := func(height uint64) *remote.StateChangeBatch {
+		return &remote.StateChangeBatch{
+			DatabaseViewID:      txID,
+			PendingBlockBaseFee: 1,
+			BlockGasLimit:       30_000_000,
+			ChangeBatch: []*remote.StateChange{
+				{BlockHeight: height, BlockHash: gointerfaces.ConvertHashToH256([32]byte{byte(height)})},
+			},
+		}
+	}
+	first := batch(1)
+	var txs [][]byte
+	for _, tt := range []parseTxTest{txParseMainnetTests[0], txParseMainnetTests[3]} { // legacy, valid for any chain
+		txs = append(txs, decodeHex(tt.payloadStr))
+		var addr [20]byte
+		copy(addr[:], decodeHex(tt.senderStr))
+		first.ChangeBatch[0].Changes = append(first.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(addr),
+			Data:    v,
+		})
+	}
+	msg := &sentry.InboundMessage{Id: sentry.MessageId_TRANSACTIONS_66, Data: EncodeTransactions(txs, nil)}
+
+	// record capture on "live" pool
+	var buf bytes.Buffer
+	capture := NewCaptureWriter(&buf)
+	live, err := New(nil, coreDB, DefaultConfig, kvcache.New(kvcache.DefaultCoherentConfig), *u256.N1)
+	require.NoError(err)
+	fetch := NewFetch(ctx, nil, live, nil, coreDB, memdb.NewTestPoolDB(t), *u256.N1)
+	fetch.SetCapture(capture)
+	require.NoError(fetch.onStateChange(ctx, first))
+	require.NoError(capture.Message(msg))
+	require.NoError(fetch.handleInboundMessage(ctx, msg, nil))
+	require.NoError(live.processRemoteTxs(ctx))
+	require.NoError(fetch.onStateChange(ctx, batch(2)))
+	comp := live.Composition()
+	require.Equal(2, comp.Pending.Len())
+
+	report, err := Replay(ctx, bytes.NewReader(buf.Bytes()), DefaultConfig, *u256.N1, coreDB, memdb.NewTestPoolDB(t))
+	require.NoError(err)
+	assert.Equal(2, report.StateChanges)
+	assert.Equal(1, report.Messages)
+	assert.Equal(2, report.Compositions)
+	assert.Empty(report.Divergences)
+
+	// baseline has unknown pending tx instead of one of replayed
+	extra := Hashes(make([]byte, 32))
+	require.NoError(capture.Composition(&PoolComposition{Pending: append(extra, comp.Pending.At(1)...)}))
+	report, err = Replay(ctx, bytes.NewReader(buf.Bytes()), DefaultConfig, *u256.N1, coreDB, memdb.NewTestPoolDB(t))
+	require.NoError(err)
+	require.Len(report.Divergences, 1)
+	d := report.Divergences[0]
+	assert.Equal(5, d.Record)
+	assert.Equal(PendingSubPool, d.SubPool)
+	assert.Equal(extra, d.Missing)
+	assert.Equal(Hashes(comp.Pending.At(0)), d.Unexpected)
+}
+
+func TestCaptureFiles(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	files, err := OpenCaptureFiles(dir, 1, 3) // every record goes into own file
+	require.NoError(err)
+	capture := NewCaptureWriter(files)
+	for i := 0; i < 10; i++ {
+		require.NoError(capture.Message(&sentry.InboundMessage{Id: sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_66, Data: toHashes(byte(i))}))
+	}
+	require.NoError(files.Close())
+	names, err := CaptureFileNames(dir)
+	require.NoError(err)
+	require.Len(names, 3)
+
+	r, err := OpenCaptureDir(dir)
+	require.NoError(err)
+	defer r.Close()
+	report, err := Replay(context.Background(), r, DefaultConfig, *u256.N1, memdb.NewTestDB(t), memdb.NewTestPoolDB(t))
+	require.NoError(err)
+	require.Equal(3, report.Skipped)
+	require.False(report.To.Before(report.From))
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/direct"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
 	"github.com/ledgerwatch/log/v3"
 	"google.golang.org/grpc"
 )
@@ -36,16 +37,18 @@ type SentryClient interface {
 type Send struct {
 	ctx           context.Context
 	sentryClients []direct.SentryClient // sentry clients that will be used for accessing the network
-	pool          Pool
+	pool          PoolReader
+	stats         *sendStats
 
 	wg *sync.WaitGroup
 }
 
-func NewSend(ctx context.Context, sentryClients []direct.SentryClient, pool Pool) *Send {
+func NewSend(ctx context.Context, sentryClients []direct.SentryClient, pool PoolReader) *Send {
 	return &Send{
 		ctx:           ctx,
 		pool:          pool,
 		sentryClients: sentryClients,
+		stats:         newSendStats(),
 	}
 }
 
@@ -77,7 +80,7 @@ func (f *Send) BroadcastPooledTxs(rlps [][]byte) (txSentTo []int) {
 		if i == l-1 || size >= p2pTxPacketLimit {
 			txsData := EncodeTransactions(rlps[prev:i+1], nil)
 			var txs66 *sentry.SendMessageToRandomPeersRequest
-			for _, sentryClient := range f.sentryClients {
+			for sentryIdx, sentryClient := range f.sentryClients {
 				if !sentryClient.Ready() {
 					continue
 				}
@@ -94,9 +97,11 @@ func (f *Send) BroadcastPooledTxs(rlps [][]byte) (txSentTo []int) {
 					}
 					peers, err := sentryClient.SendMessageToRandomPeers(f.ctx, txs66)
 					if err != nil {
+						f.stats.failed(sentryIdx, txs66.Data.Id, nil)
 						log.Debug("[txpool.send] BroadcastPooledTxs", "err", err)
 					}
 					if peers != nil {
+						f.stats.sent(sentryIdx, txs66.Data.Id, 0, i+1-prev, len(txsData), peers.Peers)
 						for j := prev; j <= i; j++ {
 							txSentTo[j] = len(peers.Peers)
 						}
@@ -126,7 +131,7 @@ func (f *Send) AnnouncePooledTxs(hashes Hashes) (hashSentTo []int) {
 
 		hashesData := EncodeHashes(pending, nil)
 		var hashes66 *sentry.OutboundMessageData
-		for _, sentryClient := range f.sentryClients {
+		for sentryIdx, sentryClient := range f.sentryClients {
 			if !sentryClient.Ready() {
 				continue
 			}
@@ -140,9 +145,11 @@ func (f *Send) AnnouncePooledTxs(hashes Hashes) (hashSentTo []int) {
 				}
 				peers, err := sentryClient.SendMessageToAll(f.ctx, hashes66, &grpc.EmptyCallOption{})
 				if err != nil {
+					f.stats.failed(sentryIdx, hashes66.Id, nil)
 					log.Debug("[txpool.send] AnnouncePooledTxs", "err", err)
 				}
 				if peers != nil {
+					f.stats.sent(sentryIdx, hashes66.Id, pending.Len(), 0, len(hashesData), peers.Peers)
 					for j, l := prev, pending.Len(); j < prev+l; j++ {
 						hashSentTo[j] = len(peers.Peers)
 					}
@@ -172,7 +179,7 @@ func (f *Send) PropagatePooledTxsToPeersList(peers []PeerID, txs []byte) {
 		}
 
 		data := EncodeHashes(pending, nil)
-		for _, sentryClient := range f.sentryClients {
+		for sentryIdx, sentryClient := range f.sentryClients {
 			if !sentryClient.Ready() {
 				continue
 			}
@@ -188,8 +195,11 @@ func (f *Send) PropagatePooledTxsToPeersList(peers []PeerID, txs []byte) {
 						},
 					}
 					if _, err := sentryClient.SendMessageById(f.ctx, req66, &grpc.EmptyCallOption{}); err != nil {
+						f.stats.failed(sentryIdx, req66.Data.Id, peer)
 						log.Debug("[txpool.send] PropagatePooledTxsToPeersList", "err", err)
+						continue
 					}
+					f.stats.sent(sentryIdx, req66.Data.Id, pending.Len(), 0, len(data), []*types.H256{peer})
 				}
 			}
 		}
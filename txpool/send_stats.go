@@ -0,0 +1,128 @@
+/*
+   Copyright 2022 Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+)
+
+// maxPeersStats - how many peers are tracked by sendStats, peer which didn't receive anything for longest time is evicted
+const maxPeersStats = 1024
+
+// PeerSendStats - what Send delivered to the peer. Messages to random or to all peers are accounted
+// to peers reported by sentry, failures - only for messages addressed to the peer
+type PeerSendStats struct {
+	PeerID          [32]byte
+	AnnouncedHashes uint64
+	BroadcastTxs    uint64
+	Bytes           uint64
+	Failures        uint64
+	LastSent        time.Time
+}
+
+// sendStats - counters of outbound traffic: per sentry and message type - as metrics, per peer - in memory.
+// Counters are multiplied by amount of peers message was delivered to, so per-peer stats sum up to the metrics
+type sendStats struct {
+	lock  sync.Mutex
+	peers map[[32]byte]*PeerSendStats
+}
+
+func newSendStats() *sendStats {
+	return &sendStats{peers: map[[32]byte]*PeerSendStats{}}
+}
+
+func sendCounter(name string, sentryIdx int, msg sentry.MessageId) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`%s{sentry="%d",msg="%s"}`, name, sentryIdx, msg.String()))
+}
+
+// sent - message with given amount of hashes or transactions was delivered to peers
+func (s *sendStats) sent(sentryIdx int, msg sentry.MessageId, hashes, txs, size int, peers []*types.H256) {
+	if len(peers) == 0 {
+		return
+	}
+	if hashes > 0 {
+		sendCounter(`pool_send_announced_hashes`, sentryIdx, msg).Add(hashes * len(peers))
+	}
+	if txs > 0 {
+		sendCounter(`pool_send_broadcast_txs`, sentryIdx, msg).Add(txs * len(peers))
+	}
+	sendCounter(`pool_send_bytes`, sentryIdx, msg).Add(size * len(peers))
+
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, peer := range peers {
+		if peer == nil {
+			continue
+		}
+		ps := s.peerLocked(gointerfaces.ConvertH256ToHash(peer))
+		ps.AnnouncedHashes += uint64(hashes)
+		ps.BroadcastTxs += uint64(txs)
+		ps.Bytes += uint64(size)
+		ps.LastSent = now
+	}
+}
+
+// failed - sentry returned error, peer is nil for messages which were not addressed to concrete peer
+func (s *sendStats) failed(sentryIdx int, msg sentry.MessageId, peer *types.H256) {
+	sendCounter(`pool_send_failures`, sentryIdx, msg).Inc()
+	if peer == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.peerLocked(gointerfaces.ConvertH256ToHash(peer)).Failures++
+}
+
+func (s *sendStats) peerLocked(peerID [32]byte) *PeerSendStats {
+	if ps, ok := s.peers[peerID]; ok {
+		return ps
+	}
+	if len(s.peers) >= maxPeersStats {
+		var oldest *PeerSendStats
+		for _, ps := range s.peers {
+			if oldest == nil || ps.LastSent.Before(oldest.LastSent) {
+				oldest = ps
+			}
+		}
+		delete(s.peers, oldest.PeerID)
+	}
+	ps := &PeerSendStats{PeerID: peerID}
+	s.peers[peerID] = ps
+	return ps
+}
+
+// GetPeersStats - copy of per-peer counters, sorted by peer id
+func (f *Send) GetPeersStats() []PeerSendStats {
+	f.stats.lock.Lock()
+	defer f.stats.lock.Unlock()
+	res := make([]PeerSendStats, 0, len(f.stats.peers))
+	for _, ps := range f.stats.peers {
+		res = append(res, *ps)
+	}
+	sort.Slice(res, func(i, j int) bool { return bytes.Compare(res[i].PeerID[:], res[j].PeerID[:]) < 0 })
+	return res
+}
@@ -106,6 +106,12 @@ func AllComponents(ctx context.Context, cfg txpool.Config, cache kvcache.Cache,
 		return nil, nil, nil, nil, nil, err
 	}
 
+	if cfg.SystemTxSenders == nil {
+		if cfg.SystemTxSenders, err = txpool.SystemTxSendersFromChain(chainConfig); err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+	}
+
 	chainID, _ := uint256.FromBig(chainConfig.ChainID)
 	txPool, err := txpool.New(newTxs, chainDB, cfg, cache, *chainID)
 	if err != nil {
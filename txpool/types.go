@@ -27,6 +27,7 @@ import (
 	"sort"
 
 	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
@@ -51,6 +52,7 @@ type TxParseContext struct {
 	sighash          [32]byte
 	sig              [65]byte
 	withSender       bool
+	borrow           bool // slot.rlp references payload instead of copy, see WithBorrow
 	isProtected      bool
 	validateHash     func([]byte) error
 	validateRlp      func([]byte) error
@@ -88,6 +90,9 @@ type TxSlot struct {
 	senderID       uint64      // SenderID - require external mapping to it's address
 	traced         bool        // Whether transaction needs to be traced throughout transcation pool code and generate debug printing
 	creation       bool        // Set to true if "To" field of the transation is not set
+	to             [20]byte    // "To" field of the transaction, zero if not set
+	txType         byte        // Type of the transaction, LegacyTxType for legacy ones
+	altTag         uint64      // Tag given by AltMempool which claimed the transaction, see TxPool.AltTag
 	dataLen        int         // Length of transaction's data (for calculation of intrinsic gas)
 	dataNonZeroLen int
 	alAddrCount    int // Number of addresses in the access list
@@ -108,6 +113,9 @@ const (
 
 var ErrParseTxn = fmt.Errorf("%w transaction", rlp.ErrParse)
 
+// ErrWrongChainID - transaction is signed for another chain, it's also ErrParseTxn
+var ErrWrongChainID = fmt.Errorf("%w: invalid chainID", ErrParseTxn)
+
 var ErrRejected = errors.New("rejected")
 var ErrAlreadyKnown = errors.New("already known")
 var ErrRlpTooBig = errors.New("txn rlp too big")
@@ -116,9 +124,22 @@ func (ctx *TxParseContext) ValidateHash(f func(hash []byte) error)  { ctx.valida
 func (ctx *TxParseContext) ValidateRLP(f func(txnRlp []byte) error) { ctx.validateHash = f }
 func (ctx *TxParseContext) WithSender(v bool)                       { ctx.withSender = v }
 
+// WithBorrow - zero-copy mode: TxSlot.rlp of parsed slots references payload instead of owning a copy of it.
+// Caller must guarantee that payload is not modified or reused while slot.rlp is in use: data of gRPC message is never
+// reused after Recv, but value of db cursor is valid only until cursor moves. Borrowed slots retain whole payload in memory.
+func (ctx *TxParseContext) WithBorrow(v bool) { ctx.borrow = v }
+
 // ParseTransaction extracts all the information from the transactions's payload (RLP) necessary to build TxSlot
-// it also performs syntactic validation of the transactions
+// it also performs syntactic validation of the transactions. Slot owns copy of transaction's rlp, unless WithBorrow
 func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlot, sender []byte, hasEnvelope bool) (p int, err error) {
+	p, err = ctx.parseTransaction(payload, pos, slot, sender, hasEnvelope)
+	if err == nil && !ctx.borrow {
+		slot.rlp = common.Copy(slot.rlp)
+	}
+	return p, err
+}
+
+func (ctx *TxParseContext) parseTransaction(payload []byte, pos int, slot *TxSlot, sender []byte, hasEnvelope bool) (p int, err error) {
 	if len(payload) == 0 {
 		return 0, fmt.Errorf("%w: empty rlp", ErrParseTxn)
 	}
@@ -175,6 +196,7 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 	} else {
 		slot.rlp = payload[pos : dataPos+dataLen]
 	}
+	slot.txType = byte(txType)
 
 	if ctx.validateRlp != nil {
 		if err := ctx.validateRlp(slot.rlp); err != nil {
@@ -227,8 +249,9 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 	if dataLen != 0 && dataLen != 20 {
 		return 0, fmt.Errorf("%w: unexpected length of to field: %d", ErrParseTxn, dataLen)
 	}
-	// Only note if To field is empty or not
 	slot.creation = dataLen == 0
+	slot.to = [20]byte{}
+	copy(slot.to[:], payload[dataPos:dataPos+dataLen])
 	p = dataPos + dataLen
 	// Next follows value
 	p, err = rlp.U256(payload, p, &slot.value)
@@ -325,7 +348,7 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 			ctx.chainId.Sub(&ctx.v, u256.N35)
 			ctx.chainId.Rsh(&ctx.chainId, 1)
 			if ctx.chainId.Cmp(&ctx.cfg.chainID) != 0 {
-				return 0, fmt.Errorf("%w: %s, %d (expected %d)", ErrParseTxn, "invalid chainID", ctx.chainId.Uint64(), ctx.cfg.chainID.Uint64())
+				return 0, fmt.Errorf("%w: %d (expected %d)", ErrWrongChainID, ctx.chainId.Uint64(), ctx.cfg.chainID.Uint64())
 			}
 
 			chainIdBits = ctx.chainId.BitLen()
@@ -355,7 +378,7 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 		ctx.chainId.Set(&ctx.cfg.chainID)
 	}
 	if ctx.chainId.Cmp(&ctx.cfg.chainID) != 0 {
-		return 0, fmt.Errorf("%w: %s, %d (expected %d)", ErrParseTxn, "invalid chainID", ctx.chainId.Uint64(), ctx.cfg.chainID.Uint64())
+		return 0, fmt.Errorf("%w: %d (expected %d)", ErrWrongChainID, ctx.chainId.Uint64(), ctx.cfg.chainID.Uint64())
 	}
 
 	// Next follows R of the signature
@@ -526,32 +549,60 @@ func (s TxSlots) Valid() error {
 
 var zeroAddr = make([]byte, 20)
 
-// Resize internal arrays to len=targetSize, shrinks if need. It rely on `append` algorithm to realloc
-func (s *TxSlots) Resize(targetSize uint) {
-	for uint(len(s.txs)) < targetSize {
-		s.txs = append(s.txs, nil)
+// Grow - ensures capacity for n more slots, so next Resize/Append don't realloc. Capacity of all columns
+// (txs, senders, isLocal) grows together and at least doubles - amortized O(1) per slot
+func (s *TxSlots) Grow(n int) { s.reserve(len(s.txs) + n) }
+
+func (s *TxSlots) reserve(need int) {
+	if need <= cap(s.txs) && need*length.Addr <= cap(s.senders) && need <= cap(s.isLocal) {
+		return
 	}
-	for uint(s.senders.Len()) < targetSize {
-		s.senders = append(s.senders, addressesGrowth...)
+	newCap := 2 * cap(s.txs)
+	if newCap < need {
+		newCap = need
 	}
-	for uint(len(s.isLocal)) < targetSize {
-		s.isLocal = append(s.isLocal, false)
+	if newCap > cap(s.txs) {
+		txs := make([]*TxSlot, len(s.txs), newCap)
+		copy(txs, s.txs)
+		s.txs = txs
 	}
-	//todo: set nil to overflow txs
-	oldLen := uint(len(s.txs))
-	s.txs = s.txs[:targetSize]
-	for i := oldLen; i < targetSize; i++ {
+	if newCap*length.Addr > cap(s.senders) {
+		senders := make(Addresses, len(s.senders), newCap*length.Addr)
+		copy(senders, s.senders)
+		s.senders = senders
+	}
+	if newCap > cap(s.isLocal) {
+		isLocal := make([]bool, len(s.isLocal), newCap)
+		copy(isLocal, s.isLocal)
+		s.isLocal = isLocal
+	}
+}
+
+// Resize internal arrays to len=targetSize, shrinks if need. New slots are zeroed, dropped are released
+func (s *TxSlots) Resize(targetSize uint) {
+	n := int(targetSize)
+	s.reserve(n)
+	for i := n; i < len(s.txs); i++ {
 		s.txs[i] = nil
 	}
-	s.senders = s.senders[:length.Addr*targetSize]
-	for i := oldLen; i < targetSize; i++ {
-		copy(s.senders.At(int(i)), zeroAddr)
+	oldTxs, oldSenders, oldIsLocal := len(s.txs), s.senders.Len(), len(s.isLocal)
+	s.txs = s.txs[:n]
+	s.senders = s.senders[:length.Addr*n]
+	s.isLocal = s.isLocal[:n]
+	for i := oldTxs; i < n; i++ {
+		s.txs[i] = nil
+	}
+	for i := oldSenders; i < n; i++ {
+		copy(s.senders.At(i), zeroAddr)
 	}
-	s.isLocal = s.isLocal[:targetSize]
-	for i := oldLen; i < targetSize; i++ {
+	for i := oldIsLocal; i < n; i++ {
 		s.isLocal[i] = false
 	}
 }
+
+// ResetKeepCap - makes len=0, but keeps allocated arrays for reuse by next message
+func (s *TxSlots) ResetKeepCap() { s.Resize(0) }
+
 func (s *TxSlots) Append(slot *TxSlot, sender []byte, isLocal bool) {
 	n := len(s.txs)
 	s.Resize(uint(len(s.txs) + 1))
@@ -561,9 +612,10 @@ func (s *TxSlots) Append(slot *TxSlot, sender []byte, isLocal bool) {
 }
 
 type TxsRlp struct {
-	Txs     [][]byte
-	Senders Addresses
-	IsLocal []bool
+	Txs       [][]byte
+	Senders   Addresses
+	IsLocal   []bool
+	FirstSeen []uint64 // unix time (seconds) when pool saw the transaction first
 }
 
 // Resize internal arrays to len=targetSize, shrinks if need. It rely on `append` algorithm to realloc
@@ -577,10 +629,14 @@ func (s *TxsRlp) Resize(targetSize uint) {
 	for uint(len(s.IsLocal)) < targetSize {
 		s.IsLocal = append(s.IsLocal, false)
 	}
+	for uint(len(s.FirstSeen)) < targetSize {
+		s.FirstSeen = append(s.FirstSeen, 0)
+	}
 	//todo: set nil to overflow txs
 	s.Txs = s.Txs[:targetSize]
 	s.Senders = s.Senders[:length.Addr*targetSize]
 	s.IsLocal = s.IsLocal[:targetSize]
+	s.FirstSeen = s.FirstSeen[:targetSize]
 }
 
 var addressesGrowth = make([]byte, length.Addr)
@@ -669,6 +725,15 @@ func bytesToUint64(buf []byte) (x uint64) {
 }
 
 //nolint
+// Type - type of the transaction, LegacyTxType, AccessListTxType, etc.
+func (tx *TxSlot) Type() int { return int(tx.txType) }
+
+// To - destination address of the transaction, ok is false for contract creation
+func (tx *TxSlot) To() (to [20]byte, ok bool) { return tx.to, !tx.creation }
+
+// Rlp - rlp of the transaction, available during validation only: pool drops it after writing to db
+func (tx *TxSlot) Rlp() []byte { return tx.rlp }
+
 func (tx *TxSlot) printDebug(prefix string) {
 	fmt.Printf("%s: senderID=%d,nonce=%d,tip=%d,v=%d\n", prefix, tx.senderID, tx.nonce, tx.tip, tx.value.Uint64())
 	//fmt.Printf("%s: senderID=%d,nonce=%d,tip=%d,hash=%x\n", prefix, tx.senderID, tx.nonce, tx.tip, tx.IdHash)
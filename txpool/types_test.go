@@ -133,11 +133,26 @@ func TestParseTransactionRLP(t *testing.T) {
 						}
 					}
 					require.Equal(tt.nonce, tx.nonce)
+					if payload[0] >= 0xc0 {
+						require.Equal(LegacyTxType, tx.Type())
+					} else {
+						require.Equal(int(tx.rlp[0]), tx.Type()) // rlp of typed transaction starts from type
+					}
 				})
 			}
 		})
 	}
 }
+
+func TestParseWrongChainID(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	// EIP-155 transaction of chain 123
+	_, err := ctx.ParseTransaction(decodeHex(txParseCalaverasTests[0].payloadStr), 0, tx, txSender[:], false /* hasEnvelope */)
+	require.ErrorIs(t, err, ErrWrongChainID)
+	require.ErrorIs(t, err, ErrParseTxn)
+}
+
 func TestTxSlotsGrowth(t *testing.T) {
 	assert := assert.New(t)
 	s := &TxSlots{}
@@ -161,6 +176,47 @@ func TestTxSlotsGrowth(t *testing.T) {
 	assert.Equal(2, s.senders.Len())
 }
 
+func TestTxSlotsGrow(t *testing.T) {
+	s := &TxSlots{}
+	s.Grow(100)
+	txs, senders := cap(s.txs), cap(s.senders)
+	require.GreaterOrEqual(t, txs, 100)
+	for i := 0; i < 100; i++ {
+		s.Append(&TxSlot{}, zeroAddr, true)
+	}
+	require.Equal(t, txs, cap(s.txs)) // no realloc
+	require.Equal(t, senders, cap(s.senders))
+	require.NoError(t, s.Valid())
+
+	s.ResetKeepCap()
+	require.Equal(t, 0, len(s.txs))
+	require.Equal(t, txs, cap(s.txs))
+	require.Nil(t, s.txs[:1][0]) // dropped slots are released
+	s.Resize(1)
+	require.False(t, s.isLocal[0])
+}
+
+func BenchmarkTxSlotsResize(b *testing.B) {
+	b.ReportAllocs()
+	s := &TxSlots{}
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 4096; j++ { // like parsing of 4k txs message
+			s.Resize(uint(j + 1))
+		}
+		s.ResetKeepCap()
+	}
+}
+
+func BenchmarkTxSlotsResizeNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := &TxSlots{}
+		for j := 0; j < 4096; j++ {
+			s.Resize(uint(j + 1))
+		}
+	}
+}
+
 func TestDedupHashes(t *testing.T) {
 	assert := assert.New(t)
 	h := toHashes(2, 6, 2, 5, 2, 4)